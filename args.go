@@ -55,7 +55,9 @@ func (a *Args) Append(vs ...interface{}) *Args {
 		v := v
 		switch v := v.(type) {
 		case *Args:
-			a.underlying = append(a.underlying, v.underlying...)
+			if v != nil {
+				a.underlying = append(a.underlying, v.underlying...)
+			}
 		default:
 			a.underlying = append(a.underlying, v)
 		}
@@ -119,10 +121,27 @@ func (a *Args) Visit(str func(string), out func(DynArg), flag func(KargoValuePro
 }
 
 func (a *Args) Collect(get func(string) (string, error)) ([]string, error) {
+	return a.collect(get, false)
+}
+
+// collect resolves the args into strings.
+//
+// If shell is true, the args are rendered as words of a shell script.
+// That is, every value is quoted with ShellQuote except ShellRaw,
+// and secrets are rendered as references to their environment variables
+// instead of their values.
+func (a *Args) collect(get func(string) (string, error), shell bool) ([]string, error) {
 	if a == nil {
 		return nil, nil
 	}
 
+	quote := func(s string) string {
+		if shell {
+			return ShellQuote(s)
+		}
+		return s
+	}
+
 	var (
 		prev   string
 		args   []string
@@ -130,7 +149,7 @@ func (a *Args) Collect(get func(string) (string, error)) ([]string, error) {
 	)
 
 	a.Visit(func(s string) {
-		args = append(args, s)
+		args = append(args, quote(s))
 		prev = s
 	}, func(a DynArg) {
 		v, err := get(a.FromOutput)
@@ -139,18 +158,33 @@ func (a *Args) Collect(get func(string) (string, error)) ([]string, error) {
 			return
 		}
 		if a.Value != "" {
-			args = append(args, a.Value)
+			args = append(args, quote(a.Value))
 		} else {
-			args = append(args, a.Prefix+v)
+			args = append(args, quote(a.Prefix+v))
 		}
 		prev = a.FromOutput
 	}, func(fvp KargoValueProvider) {
+		switch v := fvp.(type) {
+		case ShellRaw:
+			args = append(args, string(v))
+			prev = string(v)
+			return
+		case Secret:
+			if shell {
+				args = append(args, `"$`+v.Env+`"`)
+				prev = redacted
+				return
+			}
+		}
 		v, err := fvp.KargoValue(get)
 		if err != nil {
 			errors = append(errors, fmt.Errorf("after %s: %w", prev, err))
 			return
 		}
-		args = append(args, v)
+		args = append(args, quote(v))
+		if _, ok := fvp.(Secret); ok {
+			v = redacted
+		}
 		prev = v
 	})
 
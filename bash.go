@@ -12,10 +12,60 @@ func NewBashScript(args *Args) *BashScript {
 	}
 }
 
+// KargoValue returns the script.
+//
+// Every value in the script is quoted with ShellQuote, except ShellRaw.
+// Secrets in the script are rendered as references to their environment variables,
+// so that the values never appear in the script.
 func (b *BashScript) KargoValue(get GetValue) (string, error) {
-	script, err := b.Script.Collect(get)
+	script, err := b.Script.collect(get, true)
 	if err != nil {
 		return "", err
 	}
 	return strings.Join(script, " "), nil
 }
+
+func (b *BashScript) String() string {
+	return b.Script.String()
+}
+
+// newCmd returns the command to run name with args.
+//
+// If args contain secrets, it returns the command to run it via a bash script
+// instead, so that the secrets are passed via environment variables
+// rather than appearing in the command-line.
+func newCmd(name string, args *Args) Cmd {
+	if len(args.Secrets()) > 0 {
+		return newBashCmd(NewArgs(name, args))
+	}
+
+	return Cmd{
+		Name: name,
+		Args: args,
+	}
+}
+
+// newIgnoreErrorCmd returns the command to run the command with bash,
+// ignoring its failure with `|| true` so that the subsequent commands are run regardless.
+// Unlike Cmd.AllowFailure, this doesn't rely on the runner.
+func newIgnoreErrorCmd(name string, args *Args) Cmd {
+	return newBashCmd(NewArgs(name, args, ShellRaw("||"), ShellRaw("true")))
+}
+
+// newBashCmd returns the command to run the script with bash.
+//
+// xtrace is enabled to print the commands as they are run,
+// unless the script contains secrets.
+// That's because xtrace prints the commands after expanding the
+// environment variables that contain the secrets.
+func newBashCmd(script *Args) Cmd {
+	flags := "-vxc"
+	if len(script.Secrets()) > 0 {
+		flags = "-vc"
+	}
+
+	return Cmd{
+		Name: "bash",
+		Args: NewArgs(flags, NewBashScript(script)),
+	}
+}
@@ -32,6 +32,54 @@ type Config struct {
 	Kustomize *Kustomize `yaml:"kustomize"`
 	Helm      *Helm      `yaml:"helm"`
 	ArgoCD    *ArgoCD    `yaml:"argocd"`
+	// Outputs are the values that the other components of a Graph
+	// refer to as <name>.<output name>, like cluster.k8s_endpoint.
+	Outputs []Output `yaml:"outputs" kargo:""`
+	// Namespace is the Kubernetes namespace to deploy the app to,
+	// which is created on apply if it doesn't exist.
+	// It's the default of argocd.namespace, and isn't supported by compose.
+	Namespace string `yaml:"namespace" kargo:""`
+	// Preview configures how the preview environments of the app are derived.
+	// See Generator.PreviewCmds.
+	Preview *Preview `yaml:"preview" kargo:""`
+}
+
+// Preview configures how the image tag of a preview environment is set to each backend.
+// The defaults work without configuring it.
+type Preview struct {
+	// Images is the list of glob patterns of the kustomize images whose tags are overridden.
+	// All the images are overridden if empty.
+	Images []string `yaml:"images" kargo:""`
+	// HelmImageTagValues is the list of the chart values set to the image tag. Defaults to image.tag.
+	HelmImageTagValues []string `yaml:"helmImageTagValues" kargo:""`
+	// ImageTagEnv is the environment variable set to the image tag for compose and kompose,
+	// which the compose file refers to like ${IMAGE_TAG}. Defaults to IMAGE_TAG.
+	ImageTagEnv string `yaml:"imageTagEnv" kargo:""`
+}
+
+// Output is a value produced by deploying a Config.
+// It's either the static Value, the value of ValueFrom,
+// or the value captured via Kubectl or File after the deployment.
+type Output struct {
+	Name      string `yaml:"name"`
+	Value     string `yaml:"value"`
+	ValueFrom string `yaml:"valueFrom"`
+	// Kubectl, if set, captures the value from the resource in the cluster.
+	Kubectl *KubectlOutput `yaml:"kubectl"`
+	// File, if set, captures the value from the file,
+	// like the pull request info written to Generator.PullRequestOutputFile.
+	File string `yaml:"file"`
+	// JSONPath selects the value in the resource or the file, like {.status.loadBalancer.ingress[0].ip}.
+	JSONPath string `yaml:"jsonPath"`
+	// Regexp captures the first submatch of the regular expression in the resource or the file.
+	Regexp string `yaml:"regexp"`
+}
+
+// KubectlOutput is the resource to capture an Output from, via kubectl-get.
+type KubectlOutput struct {
+	// Resource is the resource type and name, like svc/myapp.
+	Resource  string `yaml:"resource"`
+	Namespace string `yaml:"namespace"`
 }
 
 type Env struct {
@@ -54,6 +102,11 @@ func (e Env) KargoValue(get GetValue) (string, error) {
 
 type Compose struct {
 	EnableVals bool `yaml:"enableVals" kargo:""`
+	// ProjectName is the compose project name.
+	// Defaults to the name of the directory of the compose file.
+	ProjectName string `yaml:"projectName" compose:"project-name"`
+	// RemoveVolumes makes destroy remove the named volumes of the project too.
+	RemoveVolumes bool `yaml:"removeVolumes" kargo:""`
 }
 
 type Kompose struct {
@@ -80,12 +133,60 @@ type Kustomize struct {
 	Strategy string          `yaml:"strategy" kargo:""`
 	Images   KustomizeImages `yaml:"images" argocd-app:"kustomize-image"`
 	Git      KustomizeGit    `yaml:"git" kargo:""`
+	// Promotion, if set, promotes the images and the Helm chart versions
+	// deployed to another environment, instead of setting Images.
+	// It requires Git.Repo, as the changes are made to the kustomization in the repo.
+	// The Helm chart versions are promoted only within the helmCharts of the kustomization,
+	// and so Promotion can't be used along with Config.Helm.
+	Promotion *Promotion `yaml:"promotion" kargo:""`
+}
+
+// Promotion reads the images and the Helm chart versions from the source environment
+// and sets them to the kustomization at Kustomize.Git.Path via `<tools command> promote`.
+type Promotion struct {
+	// FromPath is the path to the kustomization of the source environment in Kustomize.Git.Repo.
+	// It's read from the base branch, so that only merged changes are promoted.
+	FromPath string `yaml:"fromPath" kargo:""`
+	// FromArgoCDApp is the name of the ArgoCD application of the source environment.
+	// Either FromPath or FromArgoCDApp must be set.
+	FromArgoCDApp string `yaml:"fromArgoCDApp" kargo:""`
+	// Policy decides which images and charts are allowed to be promoted.
+	Policy PromotionPolicy `yaml:"policy" kargo:""`
+}
+
+type PromotionPolicy struct {
+	// Images is the list of glob patterns of the image names to promote, like ghcr.io/myorg/*.
+	// All the images are promoted if empty.
+	Images []string `yaml:"images" kargo:""`
+	// TagPattern is the regular expression that the image tags must match.
+	TagPattern string `yaml:"tagPattern" kargo:""`
+	// RequireDigest promotes only the images pinned by digest.
+	RequireDigest bool `yaml:"requireDigest" kargo:""`
+	// Charts is the list of glob patterns of the Helm chart names to promote.
+	// All the charts are promoted if empty.
+	Charts []string `yaml:"charts" kargo:""`
 }
 
 type KustomizeGit struct {
 	Repo   string `yaml:"repo" kargo:""`
 	Branch string `yaml:"branch" kargo:""`
 	Path   string `yaml:"path" kargo:""`
+	// Mode is either "pullrequest" or "direct". Defaults to "pullrequest".
+	// "direct" commits and pushes the changes straight to Branch, without a pull request.
+	Mode GitOpsMode `yaml:"mode" kargo:""`
+	// AllowProtectedBranch allows the direct mode to push to main and master.
+	AllowProtectedBranch bool `yaml:"allowProtectedBranch" kargo:""`
+	// PushRetries is the maximum number of times the direct mode rebases and retries
+	// the push when it's rejected as non-fast-forward. Defaults to 3.
+	PushRetries int `yaml:"pushRetries" kargo:""`
+}
+
+func (g KustomizeGit) pushOptions() gitPushOptions {
+	return gitPushOptions{
+		mode:                 g.Mode,
+		allowProtectedBranch: g.AllowProtectedBranch,
+		retries:              g.PushRetries,
+	}
 }
 
 type KustomizeImages []KustomizeImage
@@ -159,6 +260,14 @@ type ArgoCD struct {
 	Path     string `yaml:"path" kargo:""`
 	PathFrom string `yaml:"pathFrom" kargo:""`
 
+	// Sources is the list of sources of the ArgoCD application.
+	// When set, kargo creates a multi-source application
+	// from the manifest generated by Generator.ArgoCDApplication,
+	// and Repo, RepoFrom, Path and PathFrom must be empty.
+	// The manifest is rendered when argocd-app-create runs,
+	// so the *From fields like Sources[].RepoFrom refer to the outputs of the preceding commands.
+	Sources []ArgoCDSource `yaml:"sources" kargo:""`
+
 	Upload []Upload `yaml:"upload" kargo:""`
 
 	DirRecurse bool `yaml:"dirRecurse" argocd-app:"directory-recurse,paramless"`
@@ -182,6 +291,11 @@ type ArgoCD struct {
 
 	// Project is the ArgoCD project to be used for the deployment.
 	Project string `yaml:"project" argocd-app:"project"`
+	// ProjectSpec is the desired state of the ArgoCD project.
+	// When set, kargo reconciles the project to match it on apply,
+	// and shows the differences between the current and the desired state on plan.
+	// When unset, kargo creates the project with the ArgoCD defaults.
+	ProjectSpec *ArgoCDProjectSpec `yaml:"projectSpec" kargo:""`
 	// Push is set to true if the user wants kargo to automatically
 	// - git-clone the repo
 	// - git-add the files in the config.Path
@@ -189,6 +303,14 @@ type ArgoCD struct {
 	// - git-push
 	// so that it triggers the deployment.
 	Push bool `yaml:"push" kargo:""`
+	// PushMode is either "pullrequest" or "direct". Defaults to "pullrequest".
+	// "direct" commits and pushes the manifests straight to Branch, without a pull request.
+	PushMode GitOpsMode `yaml:"pushMode" kargo:""`
+	// AllowProtectedBranch allows the direct push mode to push to main and master.
+	AllowProtectedBranch bool `yaml:"allowProtectedBranch" kargo:""`
+	// PushRetries is the maximum number of times the direct push mode rebases and retries
+	// the push when it's rejected as non-fast-forward. Defaults to 3.
+	PushRetries int `yaml:"pushRetries" kargo:""`
 
 	// DestName is the name of the K8s cluster where the deployment is to be done.
 	DestName string `yaml:"name" kargo:""`
@@ -201,13 +323,162 @@ type ArgoCD struct {
 	DestServer string `yaml:"destServer" kargo:""`
 	// DestServerFrom is the key to be used to get the target Kubernetes API endpoint from the environment.
 	DestServerFrom string `yaml:"destServerFrom" kargo:""`
+	// Delete configures how destroy deletes the application.
+	Delete ArgoCDDelete `yaml:"delete" kargo:""`
 	// ConfigManagementPlugin is the config management plugin to be used.
 	ConfigManagementPlugin string `yaml:"configManagementPlugin" argocd-app:"config-management-plugin"`
+	// CMP instructs kargo to install the config management plugin
+	// that is used to deploy kompose apps via ArgoCD.
+	// This is effective only when you use kompose along with argocd,
+	// and ConfigManagementPlugin is not set.
+	CMP *ArgoCDCMP `yaml:"cmp" kargo:""`
+}
+
+// argoCDDestNamespace returns the namespace of the ArgoCD application,
+// and whether ArgoCD needs to create it because it's Config.Namespace.
+func (c *Config) argoCDDestNamespace() (string, bool) {
+	if c.ArgoCD.DestNamespace != "" {
+		return c.ArgoCD.DestNamespace, false
+	}
+	return c.Namespace, c.Namespace != ""
+}
+
+func (a *ArgoCD) pushOptions() gitPushOptions {
+	return gitPushOptions{
+		mode:                 a.PushMode,
+		allowProtectedBranch: a.AllowProtectedBranch,
+		retries:              a.PushRetries,
+	}
+}
+
+// ArgoCDDelete is the options of argocd-app-delete run on destroy.
+type ArgoCDDelete struct {
+	// Orphan leaves the resources of the application in the cluster,
+	// by deleting the application with --cascade=false.
+	Orphan bool `yaml:"orphan" kargo:""`
+	// PropagationPolicy is either foreground or background.
+	// Defaults to foreground, which deletes the application after its resources.
+	PropagationPolicy string `yaml:"propagationPolicy" kargo:""`
+}
+
+// ArgoCDCMP is the configuration for the config management plugin
+// that kargo generates for kompose.
+type ArgoCDCMP struct {
+	// Namespace is the namespace where argocd-repo-server runs.
+	// Defaults to argocd.
+	Namespace string `yaml:"namespace" kargo:""`
+	// RepoServerDeployment is the name of the argocd-repo-server deployment.
+	// Defaults to argocd-repo-server.
+	RepoServerDeployment string `yaml:"repoServerDeployment" kargo:""`
+	// Image is the container image of the plugin sidecar.
+	// It's required, as kargo doesn't build one for you.
+	// Pin it to a tag or a digest, so that argocd-repo-server isn't changed behind your back.
+	Image string `yaml:"image" kargo:""`
+	// Git is set when you want kargo to git-commit the plugin manifests
+	// and create a pull request, instead of directly applying them.
+	Git *CMPGit `yaml:"git" kargo:""`
+}
+
+// CMPGit is the git repository and the path in it
+// to which the plugin manifests are pushed.
+type CMPGit struct {
+	Repo   string `yaml:"repo" kargo:""`
+	Branch string `yaml:"branch" kargo:""`
+	Path   string `yaml:"path" kargo:""`
+}
+
+// ArgoCDProjectSpec is the desired state of an ArgoCD project.
+type ArgoCDProjectSpec struct {
+	Description string `yaml:"description" kargo:""`
+	// SourceRepos is the list of repository URLs that applications in the project can use.
+	SourceRepos []string `yaml:"sourceRepos" kargo:""`
+	// Destinations is the list of clusters and namespaces that applications in the project can deploy to.
+	Destinations []ArgoCDProjectDestination `yaml:"destinations" kargo:""`
+	// ClusterResourceWhitelist is the list of cluster-scoped resources that applications in the project can deploy.
+	ClusterResourceWhitelist []ArgoCDProjectGroupKind `yaml:"clusterResourceWhitelist" kargo:""`
+	// Roles is the list of project roles.
+	Roles []ArgoCDProjectRole `yaml:"roles" kargo:""`
+}
+
+type ArgoCDProjectDestination struct {
+	// Server is the Kubernetes API endpoint of the cluster.
+	Server string `yaml:"server" kargo:""`
+	// Name is the name of the cluster.
+	// Either Server or Name is required.
+	Name      string `yaml:"name" kargo:""`
+	Namespace string `yaml:"namespace" kargo:""`
+}
+
+type ArgoCDProjectGroupKind struct {
+	Group string `yaml:"group" kargo:""`
+	Kind  string `yaml:"kind" kargo:""`
+}
+
+type ArgoCDProjectRole struct {
+	Name        string `yaml:"name" kargo:""`
+	Description string `yaml:"description" kargo:""`
+	// Policies is the list of permissions granted to the role.
+	Policies []ArgoCDProjectPolicy `yaml:"policies" kargo:""`
+	// Groups is the list of OIDC groups bound to the role.
+	Groups []string `yaml:"groups" kargo:""`
+}
+
+// ArgoCDProjectPolicy is a permission granted to a project role.
+// It translates to the casbin policy:
+//
+//	p, proj:<project>:<role>, <resource>, <action>, <project>/<object>, <permission>
+type ArgoCDProjectPolicy struct {
+	// Resource defaults to applications.
+	Resource string `yaml:"resource" kargo:""`
+	// Action is e.g. get, create, update, delete, sync, or override.
+	Action string `yaml:"action" kargo:""`
+	// Object defaults to *, which means all the applications in the project.
+	Object string `yaml:"object" kargo:""`
+	// Permission is either allow or deny. Defaults to allow.
+	Permission string `yaml:"permission" kargo:""`
+}
+
+// ArgoCDSource is one of the sources of a multi-source ArgoCD application.
+type ArgoCDSource struct {
+	// Repo is the URL of the git repository or the Helm chart repository.
+	Repo string `yaml:"repo" kargo:""`
+	// RepoFrom is the key to be used to get the repository URL from the environment.
+	RepoFrom string `yaml:"repoFrom" kargo:""`
+	// Path is the directory in the git repository.
+	Path string `yaml:"path" kargo:""`
+	// Chart is the name of the Helm chart in the Helm chart repository.
+	Chart string `yaml:"chart" kargo:""`
+	// TargetRevision is the git revision or the Helm chart version.
+	TargetRevision string `yaml:"targetRevision" kargo:""`
+	// Ref is the name of this source, so that the other sources
+	// can refer to files in this source like `$values/envs/prod/values.yaml`.
+	Ref string `yaml:"ref" kargo:""`
+	// ValuesFiles is the list of Helm values files.
+	// Each item is either a path relative to this source,
+	// or a `$<ref>/<path>` to refer to a file in another source.
+	ValuesFiles []string `yaml:"valuesFiles" kargo:""`
 }
 
+// Upload is a local directory to sync to the git repository on gitops.
 type Upload struct {
-	Local  string `yaml:"local" kargo:""`
+	// Local is the local directory to upload.
+	Local string `yaml:"local" kargo:""`
+	// Remote is the directory in the git repository to upload to.
+	// Defaults to the root of the repository.
 	Remote string `yaml:"remote" kargo:""`
+	// Mode is either "copy" or "mirror". Defaults to "copy".
+	// "copy" copies the local files over the remote ones, leaving the other remote files as-is.
+	// "mirror" makes the remote directory an exact copy of the local one,
+	// removing the remote files that don't exist locally.
+	Mode string `yaml:"mode" kargo:""`
+	// Include is the list of glob patterns of the files to upload, relative to Local.
+	// All the files are uploaded if empty.
+	// A pattern without a slash, like *.yaml, matches the file name at any depth,
+	// and ** matches any number of directories, like charts/**/values.yaml.
+	Include []string `yaml:"include" kargo:""`
+	// Exclude is the list of glob patterns of the files not to upload.
+	// Excluded remote files are kept as-is even in the mirror mode.
+	Exclude []string `yaml:"exclude" kargo:""`
 }
 
 type GetValue func(key string) (string, error)
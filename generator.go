@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+
+	"github.com/mumoshu/kargo/tools"
 )
 
 // Generator generates commands and config files
@@ -41,15 +43,86 @@ type Generator struct {
 
 	// PullRequestOutputFile is the path to the file to write the pull request info to.
 	PullRequestOutputFile string
+
+	// PreviewStateFile is the path to the file to record the deployed preview environments in,
+	// which PreviewGCCmds reads to find the environments of the closed pull requests.
+	// The environments aren't recorded if empty.
+	PreviewStateFile string
+
+	// StablePullRequestHead makes gitops use the stable head branch
+	// named <ToolName>/<name> instead of <ToolName>-<timestamp>.
+	// The head branch is force-pushed on every deployment,
+	// and the open pull request from it is updated instead of creating another one.
+	// <ToolName>_PULLREQUEST_HEAD takes precedence over this.
+	StablePullRequestHead bool
+
+	// GitBackend is the backend to run git operations for gitops.
+	//
+	// Either "shell" or "go-git". Defaults to "shell", which runs the git binary.
+	// "go-git" runs the git operations via `kargo tools git`,
+	// which is implemented in pure Go and doesn't require the git binary.
+	// ToolsCommand needs to be set to use "go-git".
+	GitBackend string
+
+	// ShallowClone makes gitops clone the latest commit of the target branch only.
+	ShallowClone bool
+
+	// SparseCheckout makes gitops check out only the paths it modifies,
+	// that is, the path of the git config and the remote paths of the uploads.
+	// Blobs outside the paths are never downloaded.
+	SparseCheckout bool
+
+	// ReuseClone makes gitops keep the clone of each repository in TempDir,
+	// and add the worktree of each deployment from it instead of re-cloning.
+	// The clone is fetched under a file lock, which requires flock(1).
+	//
+	// ShallowClone, SparseCheckout and ReuseClone require the "shell" GitBackend.
+	ReuseClone bool
+
+	// ProtectedNames is the list of glob patterns of the app names and the namespaces,
+	// like prod-* and kube-system, that Destroy refuses to remove unless ConfirmDestroy is set.
+	ProtectedNames []string
+
+	// ConfirmDestroy is the name of the protected app that the user confirmed to destroy,
+	// like the one typed in at the confirmation prompt.
+	ConfirmDestroy string
+
+	// WorktreeID makes the gitops worktree of each deployment unique,
+	// so that concurrent deployments never touch each other's worktree.
+	// The worktree is created at <TempDir>/kargo-gitops/<name>-<WorktreeID>,
+	// and removed after the deployment whether it succeeds or not.
+	// So is the file kustomize builds into, <TempDir>/kustomize-built-<name>-<WorktreeID>.yaml.
+	//
+	// A random ID is generated for each deployment if empty.
+	// Set it to e.g. the CI job ID to make the path predictable.
+	WorktreeID string
 }
 
+// envArgoCDPassword is the environment variable to pass
+// the ArgoCD password to the argocd-login script.
+const envArgoCDPassword = "KARGO_ARGOCD_PASSWORD"
+
 type Target int
 
 const (
 	Plan = iota
 	Apply
+	// Destroy removes the deployment.
+	Destroy
+	// PlanDestroy shows what Destroy would remove.
+	PlanDestroy
 )
 
+// planning reports whether the target only shows the changes without making them.
+func (t Target) planning() bool {
+	return t == Plan || t == PlanDestroy
+}
+
+// destroying reports whether the target is to remove the deployment.
+func (t Target) destroying() bool {
+	return t == Destroy || t == PlanDestroy
+}
+
 type Cmd struct {
 	ID   string
 	Name string
@@ -59,7 +132,24 @@ type Cmd struct {
 	// That is, the command will be run with the environment variables
 	// specified in AddEnv in addition to the environment variables provided
 	// by the current process(os.Environ).
+	// It doesn't include the secrets like the git and pull request tokens, which are in SecretEnv.
+	// Runners must run the command with Env instead, which is the only way to get all of them.
 	AddEnv map[string]string
+	// SecretEnv is a map of environment variables to add to the command,
+	// whose values are secrets.
+	// Use Env to get the resolved values along with AddEnv.
+	SecretEnv map[string]Secret
+	// AllowFailure is set to true if the runner should continue
+	// running the subsequent commands even if this command fails.
+	AllowFailure bool
+	// Finally is set to true if the runner should run this command
+	// even if any of the preceding commands failed,
+	// like removing the temporary files created by them.
+	// Runners must honor it, or the temporary files are left behind on failures.
+	Finally bool
+	// Outputs are the values captured from the command after it succeeds.
+	// The runner captures them via CaptureOutputs, and they require ID.
+	Outputs []CmdOutput
 }
 
 func (c Cmd) ToArgs() *Args {
@@ -71,11 +161,35 @@ func (c *Cmd) String() string {
 }
 
 func (g *Generator) ExecCmds(c *Config, t Target) ([]Cmd, error) {
+	var (
+		cmds []Cmd
+		err  error
+	)
+	if t.destroying() {
+		return g.destroyCmds(c, t)
+	}
+
+	// The helm config takes precedence over the kustomize config,
+	// which would silently skip the promotion.
+	if c.Helm != nil && c.Kustomize != nil && c.Kustomize.Promotion != nil {
+		return nil, errors.New("kustomize.promotion is not supported with helm: only the kustomizations and the helm charts in them can be promoted")
+	}
+
 	if c.ArgoCD != nil {
-		return g.cmdsArgoCD(c, t)
+		cmds, err = g.cmdsArgoCD(c, t)
+	} else {
+		cmds, err = g.cmds(c, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	outputs, err := g.outputCmds(c, t)
+	if err != nil {
+		return nil, err
 	}
 
-	return g.cmds(c, t)
+	return append(cmds, outputs...), nil
 }
 
 func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
@@ -109,8 +223,11 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 	}
 
 	var (
-		remotePath *Args
-		cmds       []Cmd
+		remotePath        *Args
+		pluginName        string
+		sourceRepoAddArgs []*Args
+		multiSource       = len(c.ArgoCD.Sources) > 0
+		cmds              []Cmd
 	)
 
 	if c.ArgoCD.Path != "" {
@@ -119,48 +236,7 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 		remotePath = remotePath.AppendValueFromOutput(c.ArgoCD.PathFrom)
 	}
 
-	{
-		if c.ArgoCD.Server != "" {
-			args = args.AppendStrings("--server", c.ArgoCD.Server)
-
-			loginArgs = loginArgs.AppendStrings(c.ArgoCD.Server)
-		} else if c.ArgoCD.ServerFrom != "" {
-			args = args.AppendStrings("--server")
-			args = args.AppendValueFromOutput(c.ArgoCD.ServerFrom)
-
-			loginArgs = loginArgs.AppendValueFromOutput(c.ArgoCD.ServerFrom)
-		}
-	}
-
-	{
-		if c.ArgoCD.Username != "" {
-			loginArgs = loginArgs.AppendStrings("--username", c.ArgoCD.Username)
-		} else if c.ArgoCD.UsernameFrom != "" {
-			loginArgs = loginArgs.AppendStrings("--username")
-			loginArgs = loginArgs.AppendValueFromOutput(c.ArgoCD.UsernameFrom)
-		}
-	}
-
-	{
-		if c.ArgoCD.Password != "" {
-			loginArgs = loginArgs.AppendStrings("--password", c.ArgoCD.Password)
-		} else if c.ArgoCD.PasswordFrom != "" {
-			loginArgs = loginArgs.AppendStrings("--password")
-			loginArgs = loginArgs.AppendValueFromOutput(c.ArgoCD.PasswordFrom)
-		}
-	}
-
-	{
-		if c.ArgoCD.Insecure {
-			args = args.AppendStrings("--insecure")
-
-			loginArgs = loginArgs.AppendStrings("--insecure")
-		} else if c.ArgoCD.InsecureFrom != "" {
-			args = args.AppendValueIfOutput("--insecure", c.ArgoCD.InsecureFrom)
-
-			loginArgs = loginArgs.AppendValueIfOutput("--insecure", c.ArgoCD.InsecureFrom)
-		}
-	}
+	args, loginArgs = argoCDConnArgs(c.ArgoCD)
 
 	appArgs = appArgs.CopyFrom(args)
 	{
@@ -185,7 +261,6 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 			return nil, errors.New("unable to generate argocd commands: specify argocd.DestName or argocd.DestNameFrom in your config")
 		}
 
-		var pluginName string
 		if c.ArgoCD.ConfigManagementPlugin != "" {
 			pluginName = c.ArgoCD.ConfigManagementPlugin
 		} else if c.Kompose != nil {
@@ -195,31 +270,36 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 			appArgs = appArgs.AppendStrings("--config-management-plugin=" + pluginName)
 		}
 
-		// TODO Remote path is required for ArgoCD App with Repo
-		appArgs = appArgs.AppendStrings("--path")
-		if remotePath == nil {
-			return nil, errors.New("unable to generate argocd commands: specify argocd.Path or argocd.PathFrom in your config")
-		}
-		appArgs = appArgs.Append(remotePath)
+		if !multiSource {
+			// TODO Remote path is required for ArgoCD App with Repo
+			appArgs = appArgs.AppendStrings("--path")
+			if remotePath == nil {
+				return nil, errors.New("unable to generate argocd commands: specify argocd.Path or argocd.PathFrom in your config")
+			}
+			appArgs = appArgs.Append(remotePath)
 
-		if c.ArgoCD.Repo != "" {
-			appArgs = appArgs.AppendStrings("--repo", c.ArgoCD.Repo)
+			if c.ArgoCD.Repo != "" {
+				appArgs = appArgs.AppendStrings("--repo", c.ArgoCD.Repo)
 
-			repoAddArgs = repoAddArgs.AppendStrings(c.ArgoCD.Repo)
-		} else if c.ArgoCD.RepoFrom != "" {
-			appArgs = appArgs.AppendStrings("--repo")
-			appArgs = appArgs.AppendValueFromOutput(c.ArgoCD.RepoFrom)
+				repoAddArgs = repoAddArgs.AppendStrings(c.ArgoCD.Repo)
+			} else if c.ArgoCD.RepoFrom != "" {
+				appArgs = appArgs.AppendStrings("--repo")
+				appArgs = appArgs.AppendValueFromOutput(c.ArgoCD.RepoFrom)
 
-			repoAddArgs = repoAddArgs.AppendStrings("--repo")
-			repoAddArgs = repoAddArgs.AppendValueFromOutput(c.ArgoCD.RepoFrom)
-		} else {
-			return nil, errors.New("unable to generate argocd commands: specify argocd.repo or argocd.repoFrom in your config")
+				repoAddArgs = repoAddArgs.AppendStrings("--repo")
+				repoAddArgs = repoAddArgs.AppendValueFromOutput(c.ArgoCD.RepoFrom)
+			} else {
+				return nil, errors.New("unable to generate argocd commands: specify argocd.repo or argocd.repoFrom in your config")
+			}
 		}
 
-		destNamespace := c.ArgoCD.DestNamespace
+		destNamespace, createNamespace := c.argoCDDestNamespace()
 		if destNamespace != "" {
 			appArgs = appArgs.AppendStrings("--dest-namespace", destNamespace)
 		}
+		if createNamespace {
+			appArgs = appArgs.AppendStrings("--sync-option", "CreateNamespace=true")
+		}
 
 		destServer := c.ArgoCD.DestServer
 		if destServer != "" {
@@ -243,6 +323,14 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 		return nil, fmt.Errorf("invalid argocd.Project value: %s", proj)
 	}
 
+	var projManifest []byte
+	if c.ArgoCD.ProjectSpec != nil {
+		projManifest, err = g.ArgoCDProjectManifest(c, proj)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if c.ArgoCD.RepoSSHPrivateKeyPath != "" {
 		repoAddArgs = repoAddArgs.AppendStrings("--ssh-private-key-path", c.ArgoCD.RepoSSHPrivateKeyPath)
 	} else if c.ArgoCD.RepoSSHPrivateKeyPathFrom != "" {
@@ -250,6 +338,44 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 		repoAddArgs = repoAddArgs.AppendValueFromOutput(c.ArgoCD.RepoSSHPrivateKeyPathFrom)
 	}
 
+	if multiSource {
+		// Repos and paths are in the application manifest
+		// that is passed to argocd-app-create.
+		// We only need to register the repos to ArgoCD.
+		seen := map[string]bool{}
+		for i, s := range c.ArgoCD.Sources {
+			var sourceArgs *Args
+			if s.Repo != "" {
+				if seen[s.Repo] {
+					continue
+				}
+				seen[s.Repo] = true
+				sourceArgs = sourceArgs.AppendStrings(s.Repo)
+			} else if s.RepoFrom != "" {
+				sourceArgs = sourceArgs.AppendValueFromOutput(s.RepoFrom)
+			} else {
+				return nil, fmt.Errorf("unable to generate argocd commands: specify argocd.sources[%d].repo or argocd.sources[%d].repoFrom in your config", i, i)
+			}
+			if s.Chart != "" {
+				name := s.Ref
+				if name == "" {
+					name = s.Chart
+				}
+				sourceArgs = sourceArgs.AppendStrings("--type", "helm", "--name", name)
+			} else {
+				// repoAddArgs contains only the ssh private key flags here
+				sourceArgs = sourceArgs.Append(repoAddArgs)
+			}
+			sourceRepoAddArgs = append(sourceRepoAddArgs, sourceArgs)
+		}
+
+		// The manifest is rendered when argocd-app-create runs,
+		// so the *From fields are only validated here.
+		if _, err := argoCDApplication(c, unresolvedValue); err != nil {
+			return nil, err
+		}
+	}
+
 	if args.Len() == 0 {
 		return nil, errors.New("unable to generate argocd commands: specify argocd connection-related fields in your config")
 	} else if appArgs.Len() == 0 {
@@ -261,83 +387,177 @@ func (g *Generator) cmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
 		push = true
 	}
 
+	if push && multiSource {
+		return nil, errors.New("unable to generate argocd commands: argocd.push and argocd.upload are not supported with argocd.sources")
+	}
+
 	if push {
-		g, err := g.gitOps(t, c.Name, c.ArgoCD.Repo, c.ArgoCD.Branch, g.prHeadFromEnv(), c.ArgoCD.Path, c.ArgoCD.Upload, nil, true, g.prOptsFromEnv())
+		prOpts, err := g.prOpts(c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+		}
+		g, err := g.gitOps(t, c.Name, c.ArgoCD.Repo, c.ArgoCD.Branch, g.prHeadFromEnv(), c.ArgoCD.Path, c.ArgoCD.Upload, nil, true, c.ArgoCD.pushOptions(), prOpts)
 		if err != nil {
 			return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
 		}
 		cmds = append(cmds, g...)
 	}
 
-	// create or update the config manangement plugin configmap
-	// with the generated ConfigManagementPlugin data.
-	// and if not yet done so, patch the argocd repo server with the updated configmap
-	// or restart the argocd repo server
-	// OR
-	// git-commit/push the cmp config file or the configmap containing it to a repo
-	// so that some automation redeploys argocd-repo-server with it...
-	// kargo cmp --namespace $argons $argo_repo_server_deploy apply/diff --name $plugin_name --type kompose_vals
+	// Create or update the config manangement plugin configmap
+	// and patch the argocd repo server with it,
+	// or git-commit/push the manifests to a repo
+	// so that some automation redeploys argocd-repo-server with it.
+	if c.ArgoCD.CMP != nil && c.ArgoCD.ConfigManagementPlugin == "" && c.Kompose != nil {
+		cmp, err := g.cmdsCMP(c, t, pluginName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate cmp commands: %w", err)
+		}
+		cmds = append(cmds, cmp...)
+	}
+
+	login := newCmd("argocd", NewArgs("login", loginArgs))
+
 	if t == Plan {
 		// TODO
 		// - Add some command to diff argocd-app-create changes
-		// - kargo cmp --namespace $argons $argo_repo_server_deploy diff --name $plugin_name --type kompose_vals
+		if projManifest != nil {
+			if len(g.ToolsCommand) == 0 {
+				return nil, errors.New("ToolsCommand is required to diff argocd.projectSpec")
+			}
+
+			var diffArgs *Args
+			diffArgs = diffArgs.AppendStrings(g.ToolsCommand[1:]...)
+			diffArgs = diffArgs.AppendStrings(tools.CommandArgoCDProjDiff,
+				"--"+tools.FlagArgoCDProjDiffName, proj,
+				"--"+tools.FlagArgoCDProjDiffDesiredEnv, envArgoCDProject,
+				"--",
+			)
+			diffArgs = diffArgs.Append(args)
+
+			cmds = append(cmds, login, Cmd{
+				Name:   g.ToolsCommand[0],
+				Args:   diffArgs,
+				AddEnv: map[string]string{envArgoCDProject: string(projManifest)},
+			})
+		}
 		return append([]Cmd{}, cmds...), nil
 	}
 
-	var script *Args
-
-	script = script.Append("argocd", "login")
-	script = script.Append(loginArgs)
-	script = script.Append(";")
-	script = script.Append("argocd", "proj", "create", proj)
-	script = script.Append(args)
-	script = script.Append(";")
-	script = script.Append("aws", "eks", "update-kubeconfig")
-	script = script.Append(awsEKSUpdateKubeconfigArgs)
-	script = script.Append(";")
-	script = script.Append("argocd", "cluster", "add")
-	script = script.Append(clusterAddArgs)
-	script = script.Append(";")
-	script = script.Append("argocd", "repo", "add")
-	script = script.Append(repoAddArgs)
-	script = script.Append(";")
-	script = script.Append("argocd", "app", "create")
-	script = script.Append(appArgs)
-	script = script.Append(";")
-	script = script.Append("argocd", "app", "set")
-	script = script.Append(appArgs)
+	cmds = append(cmds, login)
 
-	if g.TailLogs {
-		script = script.Append(";")
-		script = script.Append("argocd", "app", "logs", c.Name, "--follow", "--tail=-1")
+	if projManifest != nil {
+		if len(g.ToolsCommand) == 0 {
+			return nil, errors.New("ToolsCommand is required to apply argocd.projectSpec")
+		}
+
+		// Upserting the project merged with the current one reconciles the source repos,
+		// destinations, cluster resource whitelist and roles at once,
+		// leaving the rest of the project as is.
+		var applyArgs *Args
+		applyArgs = applyArgs.AppendStrings(g.ToolsCommand[1:]...)
+		applyArgs = applyArgs.AppendStrings(tools.CommandArgoCDProjApply,
+			"--"+tools.FlagArgoCDProjApplyName, proj,
+			"--"+tools.FlagArgoCDProjApplyDesiredEnv, envArgoCDProject,
+			"--",
+		)
+		applyArgs = applyArgs.Append(args)
+
+		cmds = append(cmds, Cmd{
+			Name:   g.ToolsCommand[0],
+			Args:   applyArgs,
+			AddEnv: map[string]string{envArgoCDProject: string(projManifest)},
+		})
+	} else {
+		// This fails when the project already exists with a different spec,
+		// which is fine because we don't manage the spec here.
+		cmds = append(cmds, newIgnoreErrorCmd("argocd", NewArgs("proj", "create", proj, args)))
 	}
 
 	cmds = append(cmds, Cmd{
-		Name: "bash",
-		Args: NewArgs("-vxc", NewBashScript(script)),
+		Name: "aws",
+		Args: NewArgs("eks", "update-kubeconfig", awsEKSUpdateKubeconfigArgs),
 	})
 
+	// argocd-cluster-add and argocd-repo-add fail when the cluster or the repo
+	// is already registered with different settings, which is fine.
+	cmds = append(cmds, newIgnoreErrorCmd("argocd", NewArgs("cluster", "add", clusterAddArgs)))
+
+	if multiSource {
+		for _, repoAddArgs := range sourceRepoAddArgs {
+			cmds = append(cmds, newIgnoreErrorCmd("argocd", NewArgs("repo", "add", repoAddArgs)))
+		}
+		// argocd-app-create does not support multi-source applications via flags,
+		// so we pass the whole application manifest via stdin.
+		cmds = append(cmds, newBashCmd(NewArgs("argocd", "app", "create", "--upsert", "--file", "/dev/stdin", args, ShellRaw("<<<"), argoCDApplicationManifest{c: c})))
+	} else {
+		cmds = append(cmds, newIgnoreErrorCmd("argocd", NewArgs("repo", "add", repoAddArgs)))
+		// argocd-app-create fails when the app already exists,
+		// in which case the subsequent argocd-app-set updates it.
+		cmds = append(cmds, newIgnoreErrorCmd("argocd", NewArgs("app", "create", appArgs)))
+		cmds = append(cmds, Cmd{
+			Name: "argocd",
+			Args: NewArgs("app", "set", appArgs),
+		})
+	}
+
+	if g.TailLogs {
+		cmds = append(cmds, Cmd{
+			Name: "argocd",
+			Args: NewArgs("app", "logs", c.Name, "--follow", "--tail=-1"),
+		})
+	}
+
 	return cmds, nil
 }
 
-func (g *Generator) runWithinDir(dir string, cmds []Cmd) Cmd {
-	script := g.scriptWithinDir(dir, cmds)
+// argoCDConnArgs returns the args to connect to the ArgoCD server,
+// and the args for argocd-login.
+func argoCDConnArgs(a *ArgoCD) (args *Args, loginArgs *Args) {
+	{
+		if a.Server != "" {
+			args = args.AppendStrings("--server", a.Server)
 
-	runScript := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(script))}
+			loginArgs = loginArgs.AppendStrings(a.Server)
+		} else if a.ServerFrom != "" {
+			args = args.AppendStrings("--server")
+			args = args.AppendValueFromOutput(a.ServerFrom)
 
-	return runScript
-}
+			loginArgs = loginArgs.AppendValueFromOutput(a.ServerFrom)
+		}
+	}
 
-func (g *Generator) scriptWithinDir(dir string, cmds []Cmd) *Args {
-	var script *Args
-	script = script.Append("cd", dir, ";")
-	for i, cmd := range cmds {
-		script = script.Append(cmd.Name, cmd.Args)
-		if i < len(cmds)-1 {
-			script = script.Append("&&")
+	{
+		if a.Username != "" {
+			loginArgs = loginArgs.AppendStrings("--username", a.Username)
+		} else if a.UsernameFrom != "" {
+			loginArgs = loginArgs.AppendStrings("--username")
+			loginArgs = loginArgs.AppendValueFromOutput(a.UsernameFrom)
 		}
 	}
-	return script
+
+	{
+		if a.Password != "" {
+			loginArgs = loginArgs.AppendStrings("--password")
+			loginArgs = loginArgs.AppendSecret(envArgoCDPassword, a.Password)
+		} else if a.PasswordFrom != "" {
+			loginArgs = loginArgs.AppendStrings("--password")
+			loginArgs = loginArgs.AppendSecretFromOutput(envArgoCDPassword, a.PasswordFrom)
+		}
+	}
+
+	{
+		if a.Insecure {
+			args = args.AppendStrings("--insecure")
+
+			loginArgs = loginArgs.AppendStrings("--insecure")
+		} else if a.InsecureFrom != "" {
+			args = args.AppendValueIfOutput("--insecure", a.InsecureFrom)
+
+			loginArgs = loginArgs.AppendValueIfOutput("--insecure", a.InsecureFrom)
+		}
+	}
+
+	return args, loginArgs
 }
 
 func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
@@ -347,17 +567,16 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 	)
 
 	if c.Compose != nil {
+		if c.Namespace != "" {
+			return nil, errors.New("namespace is not supported with compose")
+		}
+
 		args, err = AppendArgs(args, c.Compose, FieldTagCompose)
 		if err != nil {
 			return nil, err
 		}
 
-		dir := c.Path
-		file := "docker-compose.yml"
-		if strings.HasSuffix(dir, ".yml") {
-			file = filepath.Base(dir)
-			dir = filepath.Dir(dir)
-		}
+		dir, file := composeFile(c)
 
 		composeArgs := NewArgs("compose", "-f", file, args)
 
@@ -375,7 +594,7 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 				return []Cmd{
 					{
 						Name: "vals",
-						Args: NewArgs("exec", "--stream-yaml", file, "--", "docker", "compose", "-f", "-", upArgs),
+						Args: NewArgs("exec", "--stream-yaml", file, "--", "docker", "compose", "-f", "-", args, upArgs),
 						Dir:  dir,
 					},
 				}, nil
@@ -432,9 +651,15 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 
 		// Note that helm-diff-upgrate flags are superset of helm-upgrade flags
 		helmUpgradeArgs := NewArgs("upgrade", "--install", c.Name, chart, args)
+		if c.Namespace != "" {
+			helmUpgradeArgs = helmUpgradeArgs.AppendStrings("--namespace", c.Namespace)
+		}
 
 		switch t {
 		case Apply:
+			if c.Namespace != "" {
+				helmUpgradeArgs = helmUpgradeArgs.AppendStrings("--create-namespace")
+			}
 			helmUpgrade := Cmd{
 				Name: "helm",
 				Args: helmUpgradeArgs,
@@ -460,24 +685,51 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 			}
 		}
 
-		if args.Len() == 0 {
-			return nil, fmt.Errorf("unable to generate kustomize commands: specify kubernetes.kustomize.images fields in your config")
-		}
+		var kustomizeEdit Cmd
+		if c.Kustomize.Promotion != nil {
+			if args.Len() > 0 {
+				return nil, fmt.Errorf("unable to generate kustomize commands: kustomize.images and kustomize.promotion are mutually exclusive")
+			}
 
-		kustomizeEdit := Cmd{
-			Name: "kustomize",
-			Args: NewArgs("edit", "set", "image", args),
-			Dir:  c.Path,
-		}
+			kustomizeEdit, err = g.promoteCmd(c.Kustomize)
+			if err != nil {
+				return nil, fmt.Errorf("unable to generate kustomize commands: %w", err)
+			}
+		} else {
+			if args.Len() == 0 {
+				return nil, fmt.Errorf("unable to generate kustomize commands: specify kubernetes.kustomize.images fields in your config")
+			}
 
-		if g.TempDir == "" {
-			return nil, fmt.Errorf("TempDir is required to run kustomize")
+			kustomizeEdit = Cmd{
+				Name: "kustomize",
+				Args: NewArgs("edit", "set", "image", args),
+				Dir:  c.Path,
+			}
 		}
 
-		tmpFile := filepath.Join(g.TempDir, "kustomize-built.yaml")
+		kustomizeEdits := []Cmd{kustomizeEdit}
+
+		tmpFile, err := g.kustomizeBuiltFile(c)
+		if err != nil {
+			return nil, err
+		}
 
 		kustomizeBuildArgs := NewArgs("build", "--output="+tmpFile)
 
+		var createNamespaceOverlay, removeNamespaceOverlay []Cmd
+		if c.Namespace != "" {
+			if c.Kustomize.Strategy == KustomizeStrategySetImageAndCreatePR {
+				return nil, fmt.Errorf("unable to generate kustomize commands: namespace is not supported with kustomize.strategy=%s", KustomizeStrategySetImageAndCreatePR)
+			}
+
+			overlay, err := g.kustomizeNamespaceOverlay(c)
+			if err != nil {
+				return nil, err
+			}
+			createNamespaceOverlay, removeNamespaceOverlay = kustomizeNamespaceOverlayCmds(c, overlay)
+			kustomizeBuildArgs = kustomizeBuildArgs.AppendStrings(overlay)
+		}
+
 		kustomizeBuild := Cmd{
 			Name: "kustomize",
 			Args: kustomizeBuildArgs,
@@ -499,24 +751,36 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 			if c.Kustomize.Git.Repo == "" {
 				return nil, fmt.Errorf("kustomize.git.repo is required for kustomize.strategy=%s", KustomizeStrategySetImageAndCreatePR)
 			}
-			setImageAndCreatePR, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), c.Kustomize.Git.Path, nil, []Cmd{kustomizeEdit}, t == Apply, g.prOptsFromEnv())
+			prOpts, err := g.prOpts(c)
+			if err != nil {
+				return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+			}
+			setImageAndCreatePR, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), c.Kustomize.Git.Path, nil, kustomizeEdits, t == Apply, c.Kustomize.Git.pushOptions(), prOpts)
 			if err != nil {
 				return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
 			}
 			return setImageAndCreatePR, nil
 		} else if c.Kustomize.Strategy == KustomizeStrategyBuildAndKubectlApply || c.Kustomize.Strategy == "" {
-			var cmds []Cmd
+			cmds := append(append([]Cmd{}, kustomizeEdits...), createNamespaceOverlay...)
 			switch t {
 			case Apply:
-				cmds = []Cmd{kustomizeEdit, kustomizeBuild, kubectlApply}
+				cmds = append(cmds, kustomizeBuild)
+				cmds = append(cmds, createNamespaceCmds(c)...)
+				cmds = append(cmds, kubectlApply)
 			case Plan:
-				cmds = []Cmd{kustomizeEdit, kustomizeBuild, kubectlDiff}
+				cmds = append(cmds, kustomizeBuild, kubectlDiff)
 			default:
 				return nil, fmt.Errorf("unsupported target: %v", t)
 			}
+			cmds = append(cmds, removeBuiltFileCmd(tmpFile))
+			cmds = append(cmds, removeNamespaceOverlay...)
 
 			if c.Kustomize.Git.Repo != "" {
-				setImageAndDiffOrApply, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), c.Kustomize.Git.Path, nil, cmds, t == Apply, g.prOptsFromEnv())
+				prOpts, err := g.prOpts(c)
+				if err != nil {
+					return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+				}
+				setImageAndDiffOrApply, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), c.Kustomize.Git.Path, nil, cmds, t == Apply, c.Kustomize.Git.pushOptions(), prOpts)
 				if err != nil {
 					return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
 				}
@@ -533,47 +797,45 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 			return nil, err
 		}
 
-		dir := c.Path
-		file := "docker-compose.yml"
-		if strings.HasSuffix(dir, ".yml") {
-			file = filepath.Base(dir)
-			dir = filepath.Dir(dir)
-		}
+		dir, file := composeFile(c)
 
-		komposeConvertArgs := func(f, out string) []string {
-			komposeConvertArgs := []string{"convert"}
+		komposeConvertArgs := func(f, out string) *Args {
+			komposeConvertArgs := NewArgs("convert")
 			if out != "" {
-				komposeConvertArgs = append(komposeConvertArgs, "--output="+out)
+				komposeConvertArgs = komposeConvertArgs.AppendStrings("--output=" + out)
 			} else {
-				komposeConvertArgs = append(komposeConvertArgs, "--stdout")
+				komposeConvertArgs = komposeConvertArgs.AppendStrings("--stdout")
 			}
 			if c.Path != "" {
-				komposeConvertArgs = append(komposeConvertArgs, "-f", f)
+				komposeConvertArgs = komposeConvertArgs.AppendStrings("-f", f)
 			}
-			komposeConvertArgs = append(komposeConvertArgs, args.MustCollect(g.GetValue)...)
+			komposeConvertArgs = komposeConvertArgs.Append(args)
 			return komposeConvertArgs
 		}
 
-		kubectlArgs := func(f string) []string {
-			return []string{"--server-side", "-f", f}
+		kubectlArgs := func(f string) *Args {
+			args := NewArgs("--server-side", "-f", f)
+			if c.Namespace != "" {
+				args = args.AppendStrings("--namespace", c.Namespace)
+			}
+			return args
 		}
 
-		tailArgs := func() string {
+		tailArgs := func() *Args {
 			if g.TailLogs {
-				return " && stern -l kompose.io.service!="
+				return NewArgs(ShellRaw("&&"), "stern", "-l", "kompose.io.service!=")
 			}
-			return ""
+			return nil
 		}
 
+		// kompose-convert and kubectl are connected with a pipe,
+		// which requires a shell script.
 		switch t {
 		case Apply:
 			if c.Kompose.EnableVals {
-				script := append([]string{"kompose"}, komposeConvertArgs(
-					"-",
-					"",
-				)...)
-				script = append(script, "|", "kubectl", "apply")
-				script = append(script, kubectlArgs("-")...)
+				script := NewArgs("kompose", komposeConvertArgs("-", ""))
+				script = script.Append(ShellRaw("|"), "kubectl", "apply", kubectlArgs("-"))
+				script = script.Append(tailArgs())
 				args := NewArgs(
 					"exec",
 					"--stream-yaml",
@@ -581,44 +843,33 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 					"--",
 					"bash",
 					"-c",
-					strings.Join(script, " ")+tailArgs(),
+					NewBashScript(script),
 				)
-				return []Cmd{
-					{
-						Name: "vals",
-						Args: args,
-						Dir:  dir,
-					},
-				}, nil
+				return append(createNamespaceCmds(c), Cmd{
+					Name: "vals",
+					Args: args,
+					Dir:  dir,
+				}), nil
 			}
 
-			script := append([]string{"kompose"}, komposeConvertArgs(
-				file,
-				"",
-			)...)
-			script = append(script, "|", "kubectl", "apply")
-			script = append(script, kubectlArgs("-")...)
+			script := NewArgs("kompose", komposeConvertArgs(file, ""))
+			script = script.Append(ShellRaw("|"), "kubectl", "apply", kubectlArgs("-"))
+			script = script.Append(tailArgs())
 			args := NewArgs(
 				"-c",
-				strings.Join(script, " ")+tailArgs(),
+				NewBashScript(script),
 			)
-			return []Cmd{
-				{
-					Name: "bash",
-					Args: args,
-					Dir:  dir,
-				},
-			}, nil
+			return append(createNamespaceCmds(c), Cmd{
+				Name: "bash",
+				Args: args,
+				Dir:  dir,
+			}), nil
 		case Plan:
-			script := append([]string{"kompose"}, komposeConvertArgs(
-				file,
-				"",
-			)...)
-			script = append(script, "|", "kubectl", "diff")
-			script = append(script, kubectlArgs("-")...)
+			script := NewArgs("kompose", komposeConvertArgs(file, ""))
+			script = script.Append(ShellRaw("|"), "kubectl", "diff", kubectlArgs("-"))
 			args := NewArgs(
 				"-c",
-				strings.Join(script, " "),
+				NewBashScript(script),
 			)
 			return []Cmd{
 				{
@@ -635,6 +886,9 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 		}
 
 		kubectlArgs := []string{"-f", path, "--server-side=true"}
+		if c.Namespace != "" {
+			kubectlArgs = append(kubectlArgs, "--namespace", c.Namespace)
+		}
 
 		kubectlDiff := Cmd{
 			Name: "kubectl",
@@ -648,7 +902,7 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 
 		switch t {
 		case Apply:
-			return []Cmd{kubectlApply}, nil
+			return append(createNamespaceCmds(c), kubectlApply), nil
 		case Plan:
 			return []Cmd{kubectlDiff}, nil
 		}
@@ -656,3 +910,88 @@ func (g *Generator) cmds(c *Config, t Target) ([]Cmd, error) {
 
 	return nil, nil
 }
+
+// composeFile returns the directory and the name of the compose file at Config.Path,
+// which is either the directory containing docker-compose.yml or the path to the .yml file.
+func composeFile(c *Config) (string, string) {
+	dir := c.Path
+	file := "docker-compose.yml"
+	if strings.HasSuffix(dir, ".yml") {
+		file = filepath.Base(dir)
+		dir = filepath.Dir(dir)
+	}
+	return dir, file
+}
+
+// kustomizeBuiltFile returns the path to the file to build the kustomization of the config into.
+// Like the gitops worktree, it's unique to each deployment per WorktreeID,
+// so that concurrent deployments never overwrite each other's manifests.
+func (g *Generator) kustomizeBuiltFile(c *Config) (string, error) {
+	if g.TempDir == "" {
+		return "", fmt.Errorf("TempDir is required to run kustomize")
+	}
+
+	id, err := g.worktreeID()
+	if err != nil {
+		return "", fmt.Errorf("unable to generate kustomize commands: %w", err)
+	}
+
+	return filepath.Join(g.TempDir, "kustomize-built-"+c.Name+"-"+id+".yaml"), nil
+}
+
+// kustomizeNamespaceOverlay returns the path to the temporary kustomization
+// that sets Config.Namespace on the kustomization of the config.
+// Like the built file, it's unique to each deployment per WorktreeID.
+func (g *Generator) kustomizeNamespaceOverlay(c *Config) (string, error) {
+	if g.TempDir == "" {
+		return "", fmt.Errorf("TempDir is required to run kustomize")
+	}
+
+	id, err := g.worktreeID()
+	if err != nil {
+		return "", fmt.Errorf("unable to generate kustomize commands: %w", err)
+	}
+
+	return filepath.Join(g.TempDir, "kustomize-namespace-"+c.Name+"-"+id), nil
+}
+
+// kustomizeNamespaceOverlayCmds returns the commands to create and to remove the temporary kustomization
+// at overlay, which refers to the kustomization of the config as its resource.
+// The namespace is set on it instead of the kustomization of the app,
+// so that it's never written to the caller's checkout nor pushed from the gitops worktree.
+//
+// The command to create it runs in the directory of the kustomization, which the gitops worktree overrides,
+// and so the kustomization is referred to via $OLDPWD.
+func kustomizeNamespaceOverlayCmds(c *Config, overlay string) ([]Cmd, []Cmd) {
+	create := newBashCmd(NewArgs(
+		"rm", "-rf", overlay, ShellRaw("&&"),
+		"mkdir", "-p", overlay, ShellRaw("&&"),
+		"cd", overlay, ShellRaw("&&"),
+		"kustomize", "create", "--resources", ShellRaw(`"$OLDPWD"`), ShellRaw("&&"),
+		"kustomize", "edit", "set", "namespace", c.Namespace,
+	))
+	create.Dir = c.Path
+
+	remove := Cmd{Name: "rm", Args: NewArgs("-rf", overlay), Finally: true}
+
+	return []Cmd{create}, []Cmd{remove}
+}
+
+// removeBuiltFileCmd returns the command to remove the file built by kustomize,
+// which is run whether the preceding commands succeed or not.
+func removeBuiltFileCmd(f string) Cmd {
+	return Cmd{Name: "rm", Args: NewArgs("-f", f), Finally: true}
+}
+
+// createNamespaceCmds returns the command to create Config.Namespace before kubectl-apply, if it's set.
+// The namespace is applied rather than created, so that the command succeeds when it already exists.
+func createNamespaceCmds(c *Config) []Cmd {
+	if c.Namespace == "" {
+		return nil
+	}
+
+	return []Cmd{newBashCmd(NewArgs(
+		"kubectl", "create", "namespace", c.Namespace, "--dry-run=client", "-o", "yaml",
+		ShellRaw("|"), "kubectl", "apply", "-f", "-",
+	))}
+}
@@ -0,0 +1,350 @@
+package kargo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ArgoCDApplication is the declarative representation of the
+// ArgoCD Application that kargo creates for the Config.
+type ArgoCDApplication struct {
+	APIVersion string                    `yaml:"apiVersion"`
+	Kind       string                    `yaml:"kind"`
+	Metadata   ArgoCDApplicationMetadata `yaml:"metadata"`
+	Spec       ArgoCDApplicationSpec     `yaml:"spec"`
+}
+
+type ArgoCDApplicationMetadata struct {
+	Name string `yaml:"name"`
+}
+
+type ArgoCDApplicationSpec struct {
+	Project     string                       `yaml:"project"`
+	Destination ArgoCDApplicationDestination `yaml:"destination"`
+	// Source is set for single-source applications.
+	Source *ArgoCDApplicationSource `yaml:"source,omitempty"`
+	// Sources is set for multi-source applications.
+	Sources    []ArgoCDApplicationSource    `yaml:"sources,omitempty"`
+	SyncPolicy *ArgoCDApplicationSyncPolicy `yaml:"syncPolicy,omitempty"`
+}
+
+type ArgoCDApplicationSyncPolicy struct {
+	SyncOptions []string `yaml:"syncOptions,omitempty"`
+}
+
+type ArgoCDApplicationDestination struct {
+	Name      string `yaml:"name,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+	Server    string `yaml:"server,omitempty"`
+}
+
+type ArgoCDApplicationSource struct {
+	RepoURL        string                            `yaml:"repoURL"`
+	Path           string                            `yaml:"path,omitempty"`
+	Chart          string                            `yaml:"chart,omitempty"`
+	TargetRevision string                            `yaml:"targetRevision,omitempty"`
+	Ref            string                            `yaml:"ref,omitempty"`
+	Helm           *ArgoCDApplicationSourceHelm      `yaml:"helm,omitempty"`
+	Kustomize      *ArgoCDApplicationSourceKustomize `yaml:"kustomize,omitempty"`
+	Plugin         *ArgoCDApplicationSourcePlugin    `yaml:"plugin,omitempty"`
+	Directory      *ArgoCDApplicationSourceDirectory `yaml:"directory,omitempty"`
+}
+
+type ArgoCDApplicationSourceHelm struct {
+	ValueFiles []string                         `yaml:"valueFiles,omitempty"`
+	Parameters []ArgoCDApplicationHelmParameter `yaml:"parameters,omitempty"`
+}
+
+type ArgoCDApplicationHelmParameter struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type ArgoCDApplicationSourceKustomize struct {
+	Images []string `yaml:"images,omitempty"`
+}
+
+type ArgoCDApplicationSourcePlugin struct {
+	Name string                       `yaml:"name"`
+	Env  []ArgoCDApplicationPluginEnv `yaml:"env,omitempty"`
+}
+
+type ArgoCDApplicationPluginEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type ArgoCDApplicationSourceDirectory struct {
+	Recurse bool `yaml:"recurse,omitempty"`
+}
+
+// ArgoCDApplication returns the ArgoCD Application that corresponds to
+// the argocd-app-create command kargo generates for the Config.
+//
+// Unlike ExecCmds, this resolves all the *From fields with GetValue
+// at the time of the call.
+//
+// ExecCmds creates multi-source applications, which argocd-app-create
+// does not support via its flags, from the same application rendered when the command runs.
+// You can also use it to get the declarative output of the application,
+// so that you can git-commit it for your GitOps automation to deploy.
+func (g *Generator) ArgoCDApplication(c *Config) (*ArgoCDApplication, error) {
+	get := g.GetValue
+	if get == nil {
+		get = func(key string) (string, error) {
+			return "", fmt.Errorf("unable to get %s: GetValue is not set", key)
+		}
+	}
+
+	return argoCDApplication(c, get)
+}
+
+func argoCDApplication(c *Config, get GetValue) (*ArgoCDApplication, error) {
+	if c.ArgoCD == nil {
+		return nil, errors.New("argocd is required to generate argocd application")
+	}
+
+	valueOrFrom := func(field, v, from string) (string, error) {
+		if v != "" || from == "" {
+			return v, nil
+		}
+		v, err := get(from)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", field, err)
+		}
+		return v, nil
+	}
+
+	proj := c.ArgoCD.Project
+	if proj == "" {
+		proj = c.Name
+	}
+
+	app := &ArgoCDApplication{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata: ArgoCDApplicationMetadata{
+			Name: c.Name,
+		},
+		Spec: ArgoCDApplicationSpec{
+			Project: proj,
+		},
+	}
+
+	var err error
+
+	dest := &app.Spec.Destination
+	var createNamespace bool
+	dest.Namespace, createNamespace = c.argoCDDestNamespace()
+	if createNamespace {
+		app.Spec.SyncPolicy = &ArgoCDApplicationSyncPolicy{SyncOptions: []string{"CreateNamespace=true"}}
+	}
+	if dest.Name, err = valueOrFrom("argocd.destNameFrom", c.ArgoCD.DestName, c.ArgoCD.DestNameFrom); err != nil {
+		return nil, err
+	}
+	if dest.Server, err = valueOrFrom("argocd.destServerFrom", c.ArgoCD.DestServer, c.ArgoCD.DestServerFrom); err != nil {
+		return nil, err
+	}
+	if dest.Name == "" && dest.Server == "" {
+		return nil, errors.New("unable to generate argocd application: specify argocd.DestName or argocd.DestServer in your config")
+	}
+
+	if len(c.ArgoCD.Sources) > 0 {
+		if c.ArgoCD.Repo != "" || c.ArgoCD.RepoFrom != "" || c.ArgoCD.Path != "" || c.ArgoCD.PathFrom != "" {
+			return nil, errors.New("unable to generate argocd application: argocd.sources cannot be used along with argocd.repo and argocd.path")
+		}
+
+		if c.Kompose != nil {
+			return nil, errors.New("unable to generate argocd application: kompose is not supported with argocd.sources")
+		}
+
+		// The sources have no helm parameters, and the values files are per source.
+		if c.Helm != nil && (len(c.Helm.Set) > 0 || len(c.Helm.ValuesFiles) > 0) {
+			return nil, errors.New("unable to generate argocd application: helm.set and helm.valuesFiles are not supported with argocd.sources, use argocd.sources[].valuesFiles instead")
+		}
+
+		// The charts are per source too.
+		if c.Helm != nil && (c.Helm.Repo != "" || c.Helm.Chart != "" || c.Helm.Version != "") {
+			return nil, errors.New("unable to generate argocd application: helm.repo, helm.chart and helm.version are not supported with argocd.sources, use argocd.sources[].repo, chart and targetRevision instead")
+		}
+
+		if c.Kustomize != nil && len(c.Kustomize.Images) > 0 {
+			return nil, errors.New("unable to generate argocd application: kustomize.images is not supported with argocd.sources")
+		}
+
+		if c.ArgoCD.DirRecurse {
+			return nil, errors.New("unable to generate argocd application: argocd.dirRecurse is not supported with argocd.sources")
+		}
+
+		if c.ArgoCD.ConfigManagementPlugin != "" || len(c.Env) > 0 {
+			return nil, errors.New("unable to generate argocd application: argocd.configManagementPlugin and env are not supported with argocd.sources")
+		}
+
+		refs := map[string]bool{}
+		for i, s := range c.ArgoCD.Sources {
+			if s.Ref == "" {
+				continue
+			}
+			if refs[s.Ref] {
+				return nil, fmt.Errorf("argocd.sources[%d]: duplicate ref %q", i, s.Ref)
+			}
+			refs[s.Ref] = true
+		}
+
+		for i, s := range c.ArgoCD.Sources {
+			src := ArgoCDApplicationSource{
+				Path:           s.Path,
+				Chart:          s.Chart,
+				TargetRevision: s.TargetRevision,
+				Ref:            s.Ref,
+			}
+
+			if src.RepoURL, err = valueOrFrom(fmt.Sprintf("argocd.sources[%d].repoFrom", i), s.Repo, s.RepoFrom); err != nil {
+				return nil, err
+			}
+			if src.RepoURL == "" {
+				return nil, fmt.Errorf("argocd.sources[%d]: either repo or repoFrom is required", i)
+			}
+
+			for _, f := range s.ValuesFiles {
+				if strings.HasPrefix(f, "$") {
+					ref := strings.SplitN(strings.TrimPrefix(f, "$"), "/", 2)[0]
+					if !refs[ref] {
+						return nil, fmt.Errorf("argocd.sources[%d]: values file %q refers to undefined ref %q", i, f, ref)
+					}
+				}
+			}
+
+			if len(s.ValuesFiles) > 0 {
+				src.Helm = &ArgoCDApplicationSourceHelm{
+					ValueFiles: s.ValuesFiles,
+				}
+			}
+
+			app.Spec.Sources = append(app.Spec.Sources, src)
+		}
+
+		return app, nil
+	}
+
+	src := &ArgoCDApplicationSource{}
+
+	if src.RepoURL, err = valueOrFrom("argocd.repoFrom", c.ArgoCD.Repo, c.ArgoCD.RepoFrom); err != nil {
+		return nil, err
+	}
+	if src.RepoURL == "" {
+		return nil, errors.New("unable to generate argocd application: specify argocd.repo or argocd.repoFrom in your config")
+	}
+	if src.Path, err = valueOrFrom("argocd.pathFrom", c.ArgoCD.Path, c.ArgoCD.PathFrom); err != nil {
+		return nil, err
+	}
+
+	if c.ArgoCD.DirRecurse {
+		src.Directory = &ArgoCDApplicationSourceDirectory{Recurse: true}
+	}
+
+	if c.Helm != nil {
+		src.Chart = c.Helm.Chart
+		src.TargetRevision = c.Helm.Version
+
+		helm := &ArgoCDApplicationSourceHelm{
+			ValueFiles: c.Helm.ValuesFiles,
+		}
+		for _, s := range c.Helm.Set {
+			v, err := valueOrFrom("helm.set."+s.Name, s.Value, s.ValueFrom)
+			if err != nil {
+				return nil, err
+			}
+			helm.Parameters = append(helm.Parameters, ArgoCDApplicationHelmParameter{Name: s.Name, Value: v})
+		}
+		if len(helm.ValueFiles) > 0 || len(helm.Parameters) > 0 {
+			src.Helm = helm
+		}
+	} else if c.Kustomize != nil && len(c.Kustomize.Images) > 0 {
+		images, err := c.Kustomize.Images.KargoAppendArgs(nil, FieldTagKustomize)
+		if err != nil {
+			return nil, err
+		}
+		collected, err := images.Collect(get)
+		if err != nil {
+			return nil, fmt.Errorf("kustomize.images: %w", err)
+		}
+		src.Kustomize = &ArgoCDApplicationSourceKustomize{Images: collected}
+	}
+
+	var pluginName string
+	if c.ArgoCD.ConfigManagementPlugin != "" {
+		pluginName = c.ArgoCD.ConfigManagementPlugin
+	} else if c.Kompose != nil {
+		pluginName = "kargo"
+	}
+	if pluginName != "" {
+		plugin := &ArgoCDApplicationSourcePlugin{Name: pluginName}
+		for _, e := range c.Env {
+			v, err := valueOrFrom("env."+e.Name, e.Value, e.ValueFrom)
+			if err != nil {
+				return nil, err
+			}
+			plugin.Env = append(plugin.Env, ArgoCDApplicationPluginEnv{Name: e.Name, Value: v})
+		}
+		src.Plugin = plugin
+	}
+
+	app.Spec.Source = src
+
+	return app, nil
+}
+
+// ArgoCDApplicationManifest returns the YAML manifest of the ArgoCD Application
+// returned by ArgoCDApplication.
+func (g *Generator) ArgoCDApplicationManifest(c *Config) ([]byte, error) {
+	app, err := g.ArgoCDApplication(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalArgoCDApplication(app)
+}
+
+func marshalArgoCDApplication(app *ArgoCDApplication) ([]byte, error) {
+	out, err := yaml.Marshal(app)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling argocd application: %w", err)
+	}
+
+	return out, nil
+}
+
+// argoCDApplicationManifest is the YAML manifest of the ArgoCD Application
+// rendered when the command runs, so that the *From fields
+// refer to the outputs of the preceding commands like DynArg.
+type argoCDApplicationManifest struct {
+	c *Config
+}
+
+func (m argoCDApplicationManifest) KargoValue(get GetValue) (string, error) {
+	app, err := argoCDApplication(m.c, get)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := marshalArgoCDApplication(app)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+func (m argoCDApplicationManifest) String() string {
+	return "$(argocd application manifest of " + m.c.Name + ")"
+}
+
+// unresolvedValue stands in for the *From fields
+// to validate the ArgoCD Application before the commands run.
+func unresolvedValue(key string) (string, error) {
+	return "$(get " + key + ")", nil
+}
@@ -0,0 +1,235 @@
+package kargo_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_ArgoCD_MultiSource(t *testing.T) {
+	g := &kargo.Generator{
+		GetValue: func(key string) (string, error) {
+			return strings.ToUpper(key), nil
+		},
+	}
+
+	c := &kargo.Config{
+		Name: "test",
+		ArgoCD: &kargo.ArgoCD{
+			Server:   "https://localhost:8080",
+			Project:  "testproj",
+			DestName: "myekscluster",
+			Sources: []kargo.ArgoCDSource{
+				{
+					Repo:           "https://charts.example.com",
+					Chart:          "mychart",
+					TargetRevision: "1.2.3",
+					ValuesFiles:    []string{"$values/envs/prod/values.yaml"},
+				},
+				{
+					Repo:           "https://github.com/myorg/values.git",
+					TargetRevision: "main",
+					Ref:            "values",
+				},
+			},
+		},
+	}
+
+	t.Run("manifest", func(t *testing.T) {
+		manifest, err := g.ArgoCDApplicationManifest(c)
+		require.NoError(t, err)
+		require.Equal(t, `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+    name: test
+spec:
+    project: testproj
+    destination:
+        name: myekscluster
+    sources:
+        - repoURL: https://charts.example.com
+          chart: mychart
+          targetRevision: 1.2.3
+          helm:
+            valueFiles:
+                - $values/envs/prod/values.yaml
+        - repoURL: https://github.com/myorg/values.git
+          targetRevision: main
+          ref: values
+`, string(manifest))
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		c := *c
+		argocd := *c.ArgoCD
+		argocd.DestName = ""
+		argocd.DestNameFrom = "cluster.name"
+		c.ArgoCD = &argocd
+
+		cmds, err := g.ExecCmds(&c, kargo.Apply)
+		require.NoError(t, err)
+
+		// The manifest is rendered with the outputs available when the command runs.
+		manifest, err := g.ArgoCDApplicationManifest(&c)
+		require.NoError(t, err)
+		require.Contains(t, string(manifest), "name: CLUSTER.NAME")
+
+		var got []cmd
+		for _, c := range cmds {
+			got = append(got, cmd{Name: c.Name, Args: c.Args.MustCollect(g.GetValue), Dir: c.Dir})
+		}
+		require.Equal(t, []cmd{
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd proj create testproj --server https://localhost:8080 || true"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "CLUSTER.NAME", "--alias", "CLUSTER.NAME"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add CLUSTER.NAME || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add https://charts.example.com --type helm --name mychart || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add https://github.com/myorg/values.git || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create --upsert --file /dev/stdin --server https://localhost:8080 <<< " + kargo.ShellQuote(string(manifest))}},
+		}, got)
+	})
+
+	t.Run("undefined ref", func(t *testing.T) {
+		c := *c
+		argocd := *c.ArgoCD
+		argocd.Sources = []kargo.ArgoCDSource{
+			{
+				Repo:        "https://charts.example.com",
+				Chart:       "mychart",
+				ValuesFiles: []string{"$vals/values.yaml"},
+			},
+		}
+		c.ArgoCD = &argocd
+
+		_, err := g.ExecCmds(&c, kargo.Apply)
+		require.EqualError(t, err, `argocd.sources[0]: values file "$vals/values.yaml" refers to undefined ref "vals"`)
+	})
+
+	t.Run("unresolvable destNameFrom", func(t *testing.T) {
+		c := *c
+		argocd := *c.ArgoCD
+		argocd.DestName = ""
+		argocd.DestNameFrom = "cluster.name"
+		c.ArgoCD = &argocd
+
+		g := &kargo.Generator{
+			GetValue: func(key string) (string, error) {
+				return "", errors.New("not found")
+			},
+		}
+
+		_, err := g.ArgoCDApplication(&c)
+		require.EqualError(t, err, "argocd.destNameFrom: not found")
+	})
+
+	t.Run("sources with helm values", func(t *testing.T) {
+		c := *c
+		c.Helm = &kargo.Helm{Set: []kargo.Set{{Name: "replicas", Value: "2"}}}
+
+		_, err := g.ArgoCDApplication(&c)
+		require.EqualError(t, err, "unable to generate argocd application: helm.set and helm.valuesFiles are not supported with argocd.sources, use argocd.sources[].valuesFiles instead")
+	})
+
+	t.Run("sources with ignored fields", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			modify func(c *kargo.Config)
+			err    string
+		}{
+			{
+				name:   "helm chart",
+				modify: func(c *kargo.Config) { c.Helm = &kargo.Helm{Chart: "mychart", Version: "1.2.3"} },
+				err:    "unable to generate argocd application: helm.repo, helm.chart and helm.version are not supported with argocd.sources, use argocd.sources[].repo, chart and targetRevision instead",
+			},
+			{
+				name: "kustomize images",
+				modify: func(c *kargo.Config) {
+					c.Kustomize = &kargo.Kustomize{Images: kargo.KustomizeImages{{Name: "myapp", NewTag: "v1"}}}
+				},
+				err: "unable to generate argocd application: kustomize.images is not supported with argocd.sources",
+			},
+			{
+				name:   "dir recurse",
+				modify: func(c *kargo.Config) { c.ArgoCD.DirRecurse = true },
+				err:    "unable to generate argocd application: argocd.dirRecurse is not supported with argocd.sources",
+			},
+			{
+				name:   "env",
+				modify: func(c *kargo.Config) { c.Env = []kargo.Env{{Name: "FOO", Value: "bar"}} },
+				err:    "unable to generate argocd application: argocd.configManagementPlugin and env are not supported with argocd.sources",
+			},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				c := *c
+				argocd := *c.ArgoCD
+				c.ArgoCD = &argocd
+				tc.modify(&c)
+
+				_, err := g.ExecCmds(&c, kargo.Apply)
+				require.EqualError(t, err, tc.err)
+			})
+		}
+	})
+
+	t.Run("sources with repo", func(t *testing.T) {
+		c := *c
+		argocd := *c.ArgoCD
+		argocd.Repo = "https://github.com/myorg/app.git"
+		c.ArgoCD = &argocd
+
+		_, err := g.ArgoCDApplication(&c)
+		require.EqualError(t, err, "unable to generate argocd application: argocd.sources cannot be used along with argocd.repo and argocd.path")
+	})
+}
+
+func TestGenerate_ArgoCDApplication_SingleSource(t *testing.T) {
+	g := &kargo.Generator{
+		GetValue: func(key string) (string, error) {
+			return strings.ToUpper(key), nil
+		},
+	}
+
+	c := &kargo.Config{
+		Name: "test",
+		Helm: &kargo.Helm{
+			Chart:   "mychart",
+			Version: "1.2.3",
+			Set: []kargo.Set{
+				{Name: "foo", Value: "bar"},
+			},
+		},
+		ArgoCD: &kargo.ArgoCD{
+			Repo:           "https://charts.example.com",
+			DestServerFrom: "cluster.endpoint",
+			DestNamespace:  "default",
+		},
+	}
+
+	app, err := g.ArgoCDApplication(c)
+	require.NoError(t, err)
+	require.Equal(t, &kargo.ArgoCDApplication{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata:   kargo.ArgoCDApplicationMetadata{Name: "test"},
+		Spec: kargo.ArgoCDApplicationSpec{
+			Project: "test",
+			Destination: kargo.ArgoCDApplicationDestination{
+				Namespace: "default",
+				Server:    "CLUSTER.ENDPOINT",
+			},
+			Source: &kargo.ArgoCDApplicationSource{
+				RepoURL:        "https://charts.example.com",
+				Chart:          "mychart",
+				TargetRevision: "1.2.3",
+				Helm: &kargo.ArgoCDApplicationSourceHelm{
+					Parameters: []kargo.ArgoCDApplicationHelmParameter{
+						{Name: "foo", Value: "bar"},
+					},
+				},
+			},
+		},
+	}, app)
+}
@@ -58,14 +58,13 @@ func TestGenerate_ArgoCD_Kompose(t *testing.T) {
 			c.ArgoCD.Project = "testproj"
 			c.ArgoCD.Server = "https://localhost:8080"
 		}, []cmd{
-			{
-				Name: "bash",
-				Args: []string{
-					"-vxc",
-					"argocd login https://localhost:8080 ; argocd proj create testproj --server https://localhost:8080 ; aws eks update-kubeconfig --name myekscluster --alias myekscluster ; argocd cluster add myekscluster ; argocd repo add exmaple.com/myrepo ; argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo ; argocd app set test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo",
-				},
-				Dir: "",
-			},
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd proj create testproj --server https://localhost:8080 || true"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "myekscluster", "--alias", "myekscluster"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add myekscluster || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add exmaple.com/myrepo || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo || true"}},
+			{Name: "argocd", Args: []string{"app", "set", "test", "--directory-recurse", "--project", "testproj", "--server", "https://localhost:8080", "--dest-name", "myekscluster", "--config-management-plugin=kargo", "--path", "to/where/push/manifests", "--repo", "exmaple.com/myrepo"}},
 		})
 	})
 
@@ -75,14 +74,14 @@ func TestGenerate_ArgoCD_Kompose(t *testing.T) {
 			c.ArgoCD.Project = "testproj"
 			c.ArgoCD.Server = "https://localhost:8080"
 		}, []cmd{
-			{
-				Name: "bash",
-				Args: []string{
-					"-vxc",
-					"argocd login https://localhost:8080 ; argocd proj create testproj --server https://localhost:8080 ; aws eks update-kubeconfig --name myekscluster --alias myekscluster ; argocd cluster add myekscluster ; argocd repo add exmaple.com/myrepo ; argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo ; argocd app set test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo ; argocd app logs test --follow --tail=-1",
-				},
-				Dir: "",
-			},
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd proj create testproj --server https://localhost:8080 || true"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "myekscluster", "--alias", "myekscluster"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add myekscluster || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add exmaple.com/myrepo || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo || true"}},
+			{Name: "argocd", Args: []string{"app", "set", "test", "--directory-recurse", "--project", "testproj", "--server", "https://localhost:8080", "--dest-name", "myekscluster", "--config-management-plugin=kargo", "--path", "to/where/push/manifests", "--repo", "exmaple.com/myrepo"}},
+			{Name: "argocd", Args: []string{"app", "logs", "test", "--follow", "--tail=-1"}},
 		})
 	})
 
@@ -100,18 +99,82 @@ func TestGenerate_ArgoCD_Kompose(t *testing.T) {
 			c.Kompose.EnableVals = true
 			c.ArgoCD.Project = "testproj"
 			c.ArgoCD.Server = "https://localhost:8080"
+		}, []cmd{
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd proj create testproj --server https://localhost:8080 || true"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "myekscluster", "--alias", "myekscluster"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add myekscluster || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add exmaple.com/myrepo || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo || true"}},
+			{Name: "argocd", Args: []string{"app", "set", "test", "--directory-recurse", "--project", "testproj", "--server", "https://localhost:8080", "--dest-name", "myekscluster", "--config-management-plugin=kargo", "--path", "to/where/push/manifests", "--repo", "exmaple.com/myrepo"}},
+		})
+	})
+
+	t.Run("apply with cmp", func(t *testing.T) {
+		run(t, kargo.Apply, func(g *kargo.Generator, c *kargo.Config) {
+			g.TailLogs = false
+			g.ToolsCommand = []string{"kargo", "tools"}
+			c.Kompose.EnableVals = true
+			c.ArgoCD.Project = "testproj"
+			c.ArgoCD.Server = "https://localhost:8080"
+			c.Path = "testdata/compose/compose.yml"
+			c.ArgoCD.CMP = &kargo.ArgoCDCMP{
+				Namespace: "myargocd",
+				Image:     "example.com/cmp:v1",
+			}
+		}, []cmd{
+			{
+				Name: "kargo",
+				Args: []string{
+					"tools", "cmp", "--action", "apply", "--name", "kargo", "--type", "kompose_vals", "--image", "example.com/cmp:v1", "--file", "compose.yml", "--namespace", "myargocd", "--",
+				},
+			},
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd proj create testproj --server https://localhost:8080 || true"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "myekscluster", "--alias", "myekscluster"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add myekscluster || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add exmaple.com/myrepo || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo || true"}},
+			{Name: "argocd", Args: []string{"app", "set", "test", "--directory-recurse", "--project", "testproj", "--server", "https://localhost:8080", "--dest-name", "myekscluster", "--config-management-plugin=kargo", "--path", "to/where/push/manifests", "--repo", "exmaple.com/myrepo"}},
+		})
+	})
+
+	t.Run("plan with cmp", func(t *testing.T) {
+		run(t, kargo.Plan, func(g *kargo.Generator, c *kargo.Config) {
+			g.ToolsCommand = []string{"kargo", "tools"}
+			c.ArgoCD.Server = "https://localhost:8080"
+			c.ArgoCD.CMP = &kargo.ArgoCDCMP{
+				Image: "example.com/cmp:v1",
+			}
 		}, []cmd{
 			{
-				Name: "bash",
+				Name: "kargo",
 				Args: []string{
-					"-vxc",
-					"argocd login https://localhost:8080 ; argocd proj create testproj --server https://localhost:8080 ; aws eks update-kubeconfig --name myekscluster --alias myekscluster ; argocd cluster add myekscluster ; argocd repo add exmaple.com/myrepo ; argocd app create test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo ; argocd app set test --directory-recurse --project testproj --server https://localhost:8080 --dest-name myekscluster --config-management-plugin=kargo --path to/where/push/manifests --repo exmaple.com/myrepo",
+					"tools", "cmp", "--action", "diff", "--name", "kargo", "--type", "kompose", "--image", "example.com/cmp:v1", "--file", "docker-compose.yml", "--",
 				},
-				Dir: "",
 			},
 		})
 	})
 
+	t.Run("cmp without image", func(t *testing.T) {
+		g := &kargo.Generator{ToolsCommand: []string{"kargo", "tools"}}
+		c := &kargo.Config{
+			Name:    "test",
+			Path:    "testdata/compose",
+			Kompose: &kargo.Kompose{},
+			ArgoCD: &kargo.ArgoCD{
+				Repo:     "exmaple.com/myrepo",
+				DestName: "myekscluster",
+				Path:     "to/where/push/manifests",
+				Server:   "https://localhost:8080",
+				CMP:      &kargo.ArgoCDCMP{},
+			},
+		}
+
+		_, err := g.ExecCmds(c, kargo.Plan)
+		require.EqualError(t, err, "unable to generate cmp commands: argocd.cmp.image is required to run the config management plugin sidecar")
+	})
+
 	t.Run("plan with vals", func(t *testing.T) {
 		run(t, kargo.Plan, func(g *kargo.Generator, c *kargo.Config) {
 			g.TailLogs = false
@@ -0,0 +1,141 @@
+package kargo
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envArgoCDProject is the environment variable to pass
+// the ArgoCD project manifest to argocd-proj-diff and argocd-proj-apply.
+const envArgoCDProject = "KARGO_ARGOCD_PROJECT"
+
+// ArgoCDProject is the declarative representation of the
+// ArgoCD AppProject that kargo reconciles for ArgoCD.ProjectSpec.
+type ArgoCDProject struct {
+	APIVersion string                `yaml:"apiVersion"`
+	Kind       string                `yaml:"kind"`
+	Metadata   ArgoCDProjectMetadata `yaml:"metadata"`
+	Spec       ArgoCDAppProjectSpec  `yaml:"spec"`
+}
+
+type ArgoCDProjectMetadata struct {
+	Name string `yaml:"name"`
+}
+
+type ArgoCDAppProjectSpec struct {
+	Description              string                        `yaml:"description,omitempty"`
+	SourceRepos              []string                      `yaml:"sourceRepos,omitempty"`
+	Destinations             []ArgoCDAppProjectDestination `yaml:"destinations,omitempty"`
+	ClusterResourceWhitelist []ArgoCDAppProjectGroupKind   `yaml:"clusterResourceWhitelist,omitempty"`
+	Roles                    []ArgoCDAppProjectRole        `yaml:"roles,omitempty"`
+}
+
+type ArgoCDAppProjectDestination struct {
+	Server    string `yaml:"server,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type ArgoCDAppProjectGroupKind struct {
+	Group string `yaml:"group"`
+	Kind  string `yaml:"kind"`
+}
+
+type ArgoCDAppProjectRole struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description,omitempty"`
+	Policies    []string `yaml:"policies,omitempty"`
+	Groups      []string `yaml:"groups,omitempty"`
+}
+
+// ArgoCDProject returns the ArgoCD AppProject named proj
+// that corresponds to ArgoCD.ProjectSpec.
+func (g *Generator) ArgoCDProject(c *Config, proj string) (*ArgoCDProject, error) {
+	if c.ArgoCD == nil || c.ArgoCD.ProjectSpec == nil {
+		return nil, errors.New("argocd.projectSpec is required to generate argocd project")
+	}
+
+	spec := c.ArgoCD.ProjectSpec
+
+	p := &ArgoCDProject{
+		APIVersion: "argoproj.io/v1alpha1",
+		Kind:       "AppProject",
+		Metadata: ArgoCDProjectMetadata{
+			Name: proj,
+		},
+		Spec: ArgoCDAppProjectSpec{
+			Description: spec.Description,
+			SourceRepos: spec.SourceRepos,
+		},
+	}
+
+	for i, d := range spec.Destinations {
+		if d.Server == "" && d.Name == "" {
+			return nil, fmt.Errorf("argocd.projectSpec.destinations[%d]: either server or name is required", i)
+		}
+		p.Spec.Destinations = append(p.Spec.Destinations, ArgoCDAppProjectDestination(d))
+	}
+
+	for _, gk := range spec.ClusterResourceWhitelist {
+		p.Spec.ClusterResourceWhitelist = append(p.Spec.ClusterResourceWhitelist, ArgoCDAppProjectGroupKind(gk))
+	}
+
+	for i, r := range spec.Roles {
+		if r.Name == "" {
+			return nil, fmt.Errorf("argocd.projectSpec.roles[%d]: name is required", i)
+		}
+
+		role := ArgoCDAppProjectRole{
+			Name:        r.Name,
+			Description: r.Description,
+			Groups:      r.Groups,
+		}
+
+		for j, pol := range r.Policies {
+			if pol.Action == "" {
+				return nil, fmt.Errorf("argocd.projectSpec.roles[%d].policies[%d]: action is required", i, j)
+			}
+
+			resource := pol.Resource
+			if resource == "" {
+				resource = "applications"
+			}
+
+			object := pol.Object
+			if object == "" {
+				object = "*"
+			}
+
+			permission := pol.Permission
+			if permission == "" {
+				permission = "allow"
+			} else if permission != "allow" && permission != "deny" {
+				return nil, fmt.Errorf("argocd.projectSpec.roles[%d].policies[%d]: permission must be either allow or deny, but got %s", i, j, permission)
+			}
+
+			role.Policies = append(role.Policies, fmt.Sprintf("p, proj:%s:%s, %s, %s, %s/%s, %s", proj, r.Name, resource, pol.Action, proj, object, permission))
+		}
+
+		p.Spec.Roles = append(p.Spec.Roles, role)
+	}
+
+	return p, nil
+}
+
+// ArgoCDProjectManifest returns the YAML manifest of the ArgoCD AppProject
+// returned by ArgoCDProject.
+func (g *Generator) ArgoCDProjectManifest(c *Config, proj string) ([]byte, error) {
+	p, err := g.ArgoCDProject(c, proj)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling argocd project: %w", err)
+	}
+
+	return out, nil
+}
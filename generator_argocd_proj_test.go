@@ -0,0 +1,120 @@
+package kargo_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_ArgoCD_ProjectSpec(t *testing.T) {
+	g := &kargo.Generator{
+		GetValue: func(key string) (string, error) {
+			return strings.ToUpper(key), nil
+		},
+		ToolsCommand: []string{"kargo", "tools"},
+	}
+
+	c := &kargo.Config{
+		Name: "test",
+		ArgoCD: &kargo.ArgoCD{
+			Repo:     "https://github.com/myorg/app.git",
+			Path:     "deploy",
+			Server:   "https://localhost:8080",
+			Project:  "testproj",
+			DestName: "myekscluster",
+			ProjectSpec: &kargo.ArgoCDProjectSpec{
+				SourceRepos: []string{"https://github.com/myorg/app.git"},
+				Destinations: []kargo.ArgoCDProjectDestination{
+					{Name: "myekscluster", Namespace: "default"},
+				},
+				ClusterResourceWhitelist: []kargo.ArgoCDProjectGroupKind{
+					{Group: "", Kind: "Namespace"},
+				},
+				Roles: []kargo.ArgoCDProjectRole{
+					{
+						Name: "ci",
+						Policies: []kargo.ArgoCDProjectPolicy{
+							{Action: "sync"},
+							{Action: "delete", Permission: "deny"},
+						},
+						Groups: []string{"myorg:ci"},
+					},
+				},
+			},
+		},
+	}
+
+	manifest, err := g.ArgoCDProjectManifest(c, "testproj")
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: argoproj.io/v1alpha1
+kind: AppProject
+metadata:
+    name: testproj
+spec:
+    sourceRepos:
+        - https://github.com/myorg/app.git
+    destinations:
+        - name: myekscluster
+          namespace: default
+    clusterResourceWhitelist:
+        - group: ""
+          kind: Namespace
+    roles:
+        - name: ci
+          policies:
+            - p, proj:testproj:ci, applications, sync, testproj/*, allow
+            - p, proj:testproj:ci, applications, delete, testproj/*, deny
+          groups:
+            - myorg:ci
+`, string(manifest))
+
+	collect := func(cmds []kargo.Cmd) []cmd {
+		var got []cmd
+		for _, c := range cmds {
+			got = append(got, cmd{Name: c.Name, Args: c.Args.MustCollect(g.GetValue), Dir: c.Dir})
+		}
+		return got
+	}
+
+	t.Run("plan", func(t *testing.T) {
+		cmds, err := g.ExecCmds(c, kargo.Plan)
+		require.NoError(t, err)
+		require.Equal(t, []cmd{
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "kargo", Args: []string{"tools", "argocd-proj-diff", "--name", "testproj", "--desired-env", "KARGO_ARGOCD_PROJECT", "--", "--server", "https://localhost:8080"}},
+		}, collect(cmds))
+		require.Equal(t, map[string]string{"KARGO_ARGOCD_PROJECT": string(manifest)}, cmds[1].AddEnv)
+	})
+
+	t.Run("apply", func(t *testing.T) {
+		cmds, err := g.ExecCmds(c, kargo.Apply)
+		require.NoError(t, err)
+		appArgs := []string{"test", "--directory-recurse", "--project", "testproj", "--server", "https://localhost:8080", "--dest-name", "myekscluster", "--path", "deploy", "--repo", "https://github.com/myorg/app.git"}
+		require.Equal(t, []cmd{
+			{Name: "argocd", Args: []string{"login", "https://localhost:8080"}},
+			{Name: "kargo", Args: []string{"tools", "argocd-proj-apply", "--name", "testproj", "--desired-env", "KARGO_ARGOCD_PROJECT", "--", "--server", "https://localhost:8080"}},
+			{Name: "aws", Args: []string{"eks", "update-kubeconfig", "--name", "myekscluster", "--alias", "myekscluster"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd cluster add myekscluster || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd repo add https://github.com/myorg/app.git || true"}},
+			{Name: "bash", Args: []string{"-vxc", "argocd app create " + strings.Join(appArgs, " ") + " || true"}},
+			{Name: "argocd", Args: append([]string{"app", "set"}, appArgs...)},
+		}, collect(cmds))
+		require.Equal(t, map[string]string{"KARGO_ARGOCD_PROJECT": string(manifest)}, cmds[1].AddEnv)
+	})
+
+	t.Run("invalid permission", func(t *testing.T) {
+		spec := *c.ArgoCD.ProjectSpec
+		spec.Roles = []kargo.ArgoCDProjectRole{
+			{Name: "ci", Policies: []kargo.ArgoCDProjectPolicy{{Action: "get", Permission: "maybe"}}},
+		}
+		argocd := *c.ArgoCD
+		argocd.ProjectSpec = &spec
+		c := *c
+		c.ArgoCD = &argocd
+
+		_, err := g.ExecCmds(&c, kargo.Apply)
+		require.EqualError(t, err, "argocd.projectSpec.roles[0].policies[0]: permission must be either allow or deny, but got maybe")
+	})
+}
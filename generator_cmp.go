@@ -0,0 +1,86 @@
+package kargo
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+// cmdsCMP generates the commands to install the config management plugin
+// named pluginName, which is used by ArgoCD to deploy the kompose app.
+//
+// By default, it runs `kargo tools cmp diff` on plan and `kargo tools cmp apply` on apply.
+// If ArgoCD.CMP.Git is set, it instead generates the plugin manifests into the git repository
+// and creates a pull request, so that your GitOps automation redeploys argocd-repo-server.
+func (g *Generator) cmdsCMP(c *Config, t Target, pluginName string) ([]Cmd, error) {
+	if len(g.ToolsCommand) == 0 {
+		return nil, errors.New("ToolsCommand is required to run kargo tools")
+	}
+
+	cmp := c.ArgoCD.CMP
+
+	if cmp.Image == "" {
+		return nil, errors.New("argocd.cmp.image is required to run the config management plugin sidecar")
+	}
+
+	// The plugin converts the compose file with the same args as kompose-convert run by kargo.
+	_, file := composeFile(c)
+	komposeArgs, err := AppendArgs(nil, c.Kompose, FieldTagKustomize)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := tools.CMPTypeKompose
+	if c.Kompose.EnableVals {
+		typ = tools.CMPTypeKomposeVals
+	}
+
+	cmpArgs := func(action string) *Args {
+		var args *Args
+		args = args.AppendStrings(g.ToolsCommand[1:]...)
+		args = args.AppendStrings(tools.CommandCMP,
+			"--"+tools.FlagCMPAction, action,
+			"--"+tools.FlagCMPName, pluginName,
+			"--"+tools.FlagCMPType, typ,
+			"--"+tools.FlagCMPImage, cmp.Image,
+			"--"+tools.FlagCMPFile, file,
+		)
+		if cmp.Namespace != "" {
+			args = args.AppendStrings("--"+tools.FlagCMPNamespace, cmp.Namespace)
+		}
+		if cmp.RepoServerDeployment != "" {
+			args = args.AppendStrings("--"+tools.FlagCMPDeployment, cmp.RepoServerDeployment)
+		}
+		return args
+	}
+
+	if cmp.Git != nil {
+		if cmp.Git.Repo == "" {
+			return nil, fmt.Errorf("argocd.cmp.git.repo is required to push the config management plugin")
+		}
+
+		generate := Cmd{
+			Name: g.ToolsCommand[0],
+			Args: cmpArgs(tools.CMPActionGenerate).AppendStrings("--"+tools.FlagCMPOutputDir, ".", "--").Append(komposeArgs),
+		}
+
+		prOpts, err := g.prOpts(c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+		}
+		return g.gitOps(t, c.Name+"-cmp", cmp.Git.Repo, cmp.Git.Branch, "", cmp.Git.Path, nil, []Cmd{generate}, t == Apply, gitPushOptions{}, prOpts)
+	}
+
+	action := tools.CMPActionDiff
+	if t == Apply {
+		action = tools.CMPActionApply
+	}
+
+	return []Cmd{
+		{
+			Name: g.ToolsCommand[0],
+			Args: cmpArgs(action).AppendStrings("--").Append(komposeArgs),
+		},
+	}, nil
+}
@@ -0,0 +1,97 @@
+package kargo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+// DefaultCommitMessage is the message of gitops commits
+// when no commit message template is given.
+const DefaultCommitMessage = "automated commit"
+
+// commitMessage is the message of a gitops commit.
+//
+// It's rendered from the template at runtime,
+// because the image tags and digests may come from outputs of other commands.
+type commitMessage struct {
+	template string
+	data     tools.PullRequestTemplateData
+	images   []*Args
+	trailers []string
+}
+
+var _ KargoValueProvider = &commitMessage{}
+
+// newCommitMessage returns the commit message for the deployment of the app name.
+// The template is rendered with the same data as the pull request templates,
+// except DiffStat, which isn't available before the commit.
+// The trailers, including Co-authored-by, are appended to the message.
+func newCommitMessage(name, head, base string, prOpts PullRequestOptions) (*commitMessage, error) {
+	m := &commitMessage{
+		template: prOpts.CommitMessageTemplate,
+		data: tools.PullRequestTemplateData{
+			Name:         name,
+			ChartVersion: prOpts.ChartVersion,
+			Head:         head,
+			Base:         base,
+		},
+	}
+
+	for _, img := range prOpts.Images {
+		a, err := KustomizeImages{img}.KargoAppendArgs(nil, "")
+		if err != nil {
+			return nil, fmt.Errorf("unable to render images for the commit message: %w", err)
+		}
+		m.images = append(m.images, a)
+	}
+
+	for _, t := range prOpts.CommitTrailers {
+		if k, v, ok := strings.Cut(t, ":"); !ok || strings.TrimSpace(k) == "" || strings.ContainsAny(k, " \t") || strings.TrimSpace(v) == "" {
+			return nil, fmt.Errorf("commit trailer must be in the form of Key: value, but got %q", t)
+		}
+		m.trailers = append(m.trailers, t)
+	}
+
+	for _, a := range prOpts.CoAuthors {
+		m.trailers = append(m.trailers, "Co-authored-by: "+a)
+	}
+
+	return m, nil
+}
+
+func (m *commitMessage) KargoValue(get GetValue) (string, error) {
+	msg := DefaultCommitMessage
+
+	if m.template != "" {
+		data := m.data
+		for _, img := range m.images {
+			s, err := NewJoin(img).KargoValue(get)
+			if err != nil {
+				return "", err
+			}
+			data.Images = append(data.Images, s)
+		}
+
+		var err error
+		msg, err = tools.RenderPullRequestTemplate("commit message template", m.template, data)
+		if err != nil {
+			return "", err
+		}
+		msg = strings.TrimSpace(msg)
+	}
+
+	if len(m.trailers) > 0 {
+		msg += "\n\n" + strings.Join(m.trailers, "\n")
+	}
+
+	return msg, nil
+}
+
+func (m *commitMessage) String() string {
+	if m.template == "" {
+		return DefaultCommitMessage
+	}
+	return m.template
+}
@@ -124,6 +124,33 @@ func TestGenerate_Compose(t *testing.T) {
 		})
 	})
 
+	t.Run("apply with vals and project name", func(t *testing.T) {
+		run(t, kargo.Apply, func(g *kargo.Generator, c *kargo.Config) {
+			g.TailLogs = false
+			c.Compose.EnableVals = true
+			c.Compose.ProjectName = "myproject"
+		}, []cmd{
+			{
+				Name: "vals",
+				Args: []string{
+					"exec",
+					"--stream-yaml",
+					"docker-compose.yml",
+					"--",
+					"docker",
+					"compose",
+					"-f",
+					"-",
+					"--project-name",
+					"myproject",
+					"up",
+					"-d",
+				},
+				Dir: "testdata/compose",
+			},
+		})
+	})
+
 	t.Run("plan with vals", func(t *testing.T) {
 		run(t, kargo.Plan, func(g *kargo.Generator, c *kargo.Config) {
 			g.TailLogs = false
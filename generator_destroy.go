@@ -0,0 +1,318 @@
+package kargo
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// destroyCmds returns the commands to remove the deployment of the config on Destroy,
+// or to show what would be removed on PlanDestroy.
+//
+// The ArgoCD application is deleted along with its resources unless argocd.delete.orphan is set,
+// and the manifests pushed or uploaded to the repo are removed via a pull request.
+// The ArgoCD project and the config management plugin are kept, as they may be shared with the other apps.
+func (g *Generator) destroyCmds(c *Config, t Target) ([]Cmd, error) {
+	if err := g.confirmDestroy(c, t); err != nil {
+		return nil, err
+	}
+
+	return g.deleteCmds(c, t)
+}
+
+// deleteCmds is destroyCmds without the confirmation,
+// which is used to tear down preview environments too.
+func (g *Generator) deleteCmds(c *Config, t Target) ([]Cmd, error) {
+	if c.ArgoCD != nil {
+		return g.destroyCmdsArgoCD(c, t)
+	}
+
+	var (
+		args *Args
+		err  error
+	)
+
+	if c.Compose != nil {
+		if c.Namespace != "" {
+			return nil, errors.New("namespace is not supported with compose")
+		}
+
+		args, err = AppendArgs(args, c.Compose, FieldTagCompose)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, file := composeFile(c)
+
+		// docker-compose-ps lists the containers to be removed.
+		opArgs := NewArgs("ps", "--all")
+		if t == Destroy {
+			opArgs = NewArgs("down", "--remove-orphans")
+			if c.Compose.RemoveVolumes {
+				opArgs = opArgs.AppendStrings("--volumes")
+			}
+		}
+
+		if c.Compose.EnableVals {
+			return []Cmd{
+				{
+					Name: "vals",
+					Args: NewArgs("exec", "--stream-yaml", file, "--", "docker", "compose", "-f", "-", args, opArgs),
+					Dir:  dir,
+				},
+			}, nil
+		}
+
+		return []Cmd{
+			{
+				Name: "docker",
+				Args: NewArgs("compose", "-f", file, args, opArgs),
+				Dir:  dir,
+			},
+		}, nil
+	}
+
+	var namespaceArgs []string
+	if c.Namespace != "" {
+		namespaceArgs = []string{"--namespace", c.Namespace}
+	}
+
+	// kubectlDelete deletes the resources in the manifest,
+	// or shows what would be deleted on PlanDestroy.
+	kubectlDelete := func(f string) *Args {
+		args := NewArgs("delete", "-f", f, "--ignore-not-found", namespaceArgs)
+		if t == PlanDestroy {
+			args = args.AppendStrings("--dry-run=server")
+		}
+		return args
+	}
+
+	switch {
+	case c.Helm != nil:
+		// helm-get-manifest shows the resources to be uninstalled.
+		helmArgs := NewArgs("get", "manifest", c.Name, namespaceArgs)
+		if t == Destroy {
+			helmArgs = NewArgs("uninstall", c.Name, namespaceArgs)
+		}
+		return []Cmd{{Name: "helm", Args: helmArgs}}, nil
+	case c.Kustomize != nil:
+		return g.destroyCmdsKustomize(c, t, kubectlDelete)
+	case c.Kompose != nil:
+		args, err = AppendArgs(args, c.Kompose, FieldTagKustomize)
+		if err != nil {
+			return nil, err
+		}
+
+		dir, file := composeFile(c)
+
+		komposeConvertArgs := func(f string) *Args {
+			komposeConvertArgs := NewArgs("convert", "--stdout")
+			if c.Path != "" {
+				komposeConvertArgs = komposeConvertArgs.AppendStrings("-f", f)
+			}
+			return komposeConvertArgs.Append(args)
+		}
+
+		// kompose-convert and kubectl are connected with a pipe,
+		// which requires a shell script.
+		if c.Kompose.EnableVals {
+			script := NewArgs("kompose", komposeConvertArgs("-"), ShellRaw("|"), "kubectl", kubectlDelete("-"))
+			return []Cmd{
+				{
+					Name: "vals",
+					Args: NewArgs("exec", "--stream-yaml", file, "--", "bash", "-c", NewBashScript(script)),
+					Dir:  dir,
+				},
+			}, nil
+		}
+
+		script := NewArgs("kompose", komposeConvertArgs(file), ShellRaw("|"), "kubectl", kubectlDelete("-"))
+		return []Cmd{
+			{
+				Name: "bash",
+				Args: NewArgs("-c", NewBashScript(script)),
+				Dir:  dir,
+			},
+		}, nil
+	}
+
+	p := "."
+	if c.Path != "" {
+		p = c.Path
+	}
+
+	return []Cmd{{Name: "kubectl", Args: kubectlDelete(p)}}, nil
+}
+
+// destroyCmdsKustomize returns the commands to delete the resources built by kustomize.
+// The images aren't set before the build, because the resources are deleted by their names,
+// and the tags may refer to outputs that aren't available anymore.
+//
+// In the SetImageAndCreatePullRequest strategy, the kustomization at kustomize.git.path
+// is removed via a pull request instead, so that the gitops automation deletes the resources.
+func (g *Generator) destroyCmdsKustomize(c *Config, t Target, kubectlDelete func(string) *Args) ([]Cmd, error) {
+	if c.Kustomize.Strategy == KustomizeStrategySetImageAndCreatePR {
+		if c.Kustomize.Git.Repo == "" {
+			return nil, fmt.Errorf("kustomize.git.repo is required for kustomize.strategy=%s", KustomizeStrategySetImageAndCreatePR)
+		}
+
+		rm, err := removePathCmd(c.Kustomize.Git.Path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate kustomize commands: kustomize.git.path: %w", err)
+		}
+
+		prOpts, err := g.prOpts(c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+		}
+		cmds, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), "", nil, []Cmd{rm}, t == Destroy, c.Kustomize.Git.pushOptions(), prOpts)
+		if err != nil {
+			return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
+		}
+		return cmds, nil
+	} else if c.Kustomize.Strategy != KustomizeStrategyBuildAndKubectlApply && c.Kustomize.Strategy != "" {
+		return nil, fmt.Errorf("unsupported kustomize strategy: %s", c.Kustomize.Strategy)
+	}
+
+	tmpFile, err := g.kustomizeBuiltFile(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []Cmd
+	if c.Namespace != "" {
+		cmds = append(cmds, Cmd{
+			Name: "kustomize",
+			Args: NewArgs("edit", "set", "namespace", c.Namespace),
+			Dir:  c.Path,
+		})
+	}
+	cmds = append(cmds,
+		Cmd{Name: "kustomize", Args: NewArgs("build", "--output="+tmpFile)},
+		Cmd{Name: "kubectl", Args: kubectlDelete(tmpFile)},
+		removeBuiltFileCmd(tmpFile),
+	)
+
+	if c.Kustomize.Git.Repo != "" {
+		// The kustomization is read from the repo, and never pushed back.
+		prOpts, err := g.prOpts(c)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+		}
+		cmds, err := g.gitOps(t, c.Name, c.Kustomize.Git.Repo, c.Kustomize.Git.Branch, g.prHeadFromEnv(), c.Kustomize.Git.Path, nil, cmds, false, c.Kustomize.Git.pushOptions(), prOpts)
+		if err != nil {
+			return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
+		}
+		return cmds, nil
+	}
+
+	return cmds, nil
+}
+
+func (g *Generator) destroyCmdsArgoCD(c *Config, t Target) ([]Cmd, error) {
+	args, loginArgs := argoCDConnArgs(c.ArgoCD)
+	if args.Len() == 0 {
+		return nil, errors.New("unable to generate argocd commands: specify argocd connection-related fields in your config")
+	}
+
+	cmds := []Cmd{newCmd("argocd", NewArgs("login", loginArgs))}
+
+	if t == PlanDestroy {
+		// argocd-app-resources lists the resources to be deleted along with the application.
+		cmds = append(cmds, Cmd{
+			Name: "argocd",
+			Args: NewArgs("app", "resources", c.Name, args),
+		})
+	} else {
+		deleteArgs := NewArgs("app", "delete", c.Name, "--yes", "--cascade="+fmt.Sprint(!c.ArgoCD.Delete.Orphan))
+		switch c.ArgoCD.Delete.PropagationPolicy {
+		case "":
+		case "foreground", "background":
+			deleteArgs = deleteArgs.AppendStrings("--propagation-policy", c.ArgoCD.Delete.PropagationPolicy)
+		default:
+			return nil, fmt.Errorf("unsupported argocd.delete.propagationPolicy: %q", c.ArgoCD.Delete.PropagationPolicy)
+		}
+		cmds = append(cmds, Cmd{
+			Name: "argocd",
+			Args: NewArgs(deleteArgs, args),
+		})
+	}
+
+	if !c.ArgoCD.Push && len(c.ArgoCD.Upload) == 0 {
+		return cmds, nil
+	}
+
+	// Remove the uploaded manifests from the repo,
+	// so that the application isn't recreated from them by e.g. an ApplicationSet.
+	remotes := []string{c.ArgoCD.Path}
+	if len(c.ArgoCD.Upload) > 0 {
+		remotes = nil
+		for _, u := range c.ArgoCD.Upload {
+			remotes = append(remotes, u.Remote)
+		}
+	}
+
+	var rms []Cmd
+	for _, r := range remotes {
+		rm, err := removePathCmd(r)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate argocd commands: %w", err)
+		}
+		rms = append(rms, rm)
+	}
+
+	prOpts, err := g.prOpts(c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+	}
+	removal, err := g.gitOps(t, c.Name, c.ArgoCD.Repo, c.ArgoCD.Branch, g.prHeadFromEnv(), "", nil, rms, true, c.ArgoCD.pushOptions(), prOpts)
+	if err != nil {
+		return nil, fmt.Errorf("uanble to generate gitops commands: %w", err)
+	}
+
+	return append(cmds, removal...), nil
+}
+
+// removePathCmd returns the command to remove the path relative to the root of the gitops worktree.
+// It never removes the whole worktree.
+func removePathCmd(p string) (Cmd, error) {
+	clean := strings.Trim(path.Clean("/"+p), "/")
+	if clean == "" {
+		return Cmd{}, fmt.Errorf("refusing to remove the root of the repository: %q", p)
+	}
+
+	return Cmd{Name: "rm", Args: NewArgs("-rf", clean)}, nil
+}
+
+// confirmDestroy fails if the app or the namespace to destroy is protected by ProtectedNames,
+// unless ConfirmDestroy is the name of the app.
+// PlanDestroy is allowed, so that the user can review the plan before confirming.
+func (g *Generator) confirmDestroy(c *Config, t Target) error {
+	if t != Destroy || g.ConfirmDestroy == c.Name {
+		return nil
+	}
+
+	names := []string{c.Name, c.Namespace}
+	if c.ArgoCD != nil {
+		ns, _ := c.argoCDDestNamespace()
+		names = append(names, ns)
+	}
+
+	for _, n := range names {
+		if n == "" {
+			continue
+		}
+		for _, p := range g.ProtectedNames {
+			ok, err := path.Match(p, n)
+			if err != nil {
+				return fmt.Errorf("invalid protected name pattern %q: %w", p, err)
+			}
+			if ok {
+				return fmt.Errorf("refusing to destroy %s: %q is protected by %q, set ConfirmDestroy to %q to proceed", c.Name, n, p, c.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+package kargo_test
+
+import (
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_Destroy(t *testing.T) {
+	testcases := []struct {
+		name        string
+		config      kargo.Config
+		destroy     [][]string
+		planDestroy [][]string
+	}{
+		{
+			name:   "helm",
+			config: kargo.Config{Name: "myapp", Path: "charts/myapp", Namespace: "myns", Helm: &kargo.Helm{}},
+			destroy: [][]string{
+				{"helm", "uninstall", "myapp", "--namespace", "myns"},
+			},
+			planDestroy: [][]string{
+				{"helm", "get", "manifest", "myapp", "--namespace", "myns"},
+			},
+		},
+		{
+			name:   "kustomize",
+			config: kargo.Config{Name: "myapp", Path: "deploy", Kustomize: &kargo.Kustomize{Images: kargo.KustomizeImages{{Name: "myapp", NewTagFrom: "tag"}}}},
+			destroy: [][]string{
+				{"kustomize", "build", "--output=/tmp/kustomize-built-myapp-test.yaml"},
+				{"kubectl", "delete", "-f", "/tmp/kustomize-built-myapp-test.yaml", "--ignore-not-found"},
+				{"rm", "-f", "/tmp/kustomize-built-myapp-test.yaml"},
+			},
+			planDestroy: [][]string{
+				{"kustomize", "build", "--output=/tmp/kustomize-built-myapp-test.yaml"},
+				{"kubectl", "delete", "-f", "/tmp/kustomize-built-myapp-test.yaml", "--ignore-not-found", "--dry-run=server"},
+				{"rm", "-f", "/tmp/kustomize-built-myapp-test.yaml"},
+			},
+		},
+		{
+			name:   "kompose",
+			config: kargo.Config{Name: "myapp", Path: "testdata/compose", Kompose: &kargo.Kompose{}},
+			destroy: [][]string{
+				{"bash", "-c", "kompose convert --stdout -f docker-compose.yml | kubectl delete -f - --ignore-not-found"},
+			},
+			planDestroy: [][]string{
+				{"bash", "-c", "kompose convert --stdout -f docker-compose.yml | kubectl delete -f - --ignore-not-found --dry-run=server"},
+			},
+		},
+		{
+			name:   "compose",
+			config: kargo.Config{Name: "myapp", Path: "testdata/compose", Compose: &kargo.Compose{RemoveVolumes: true}},
+			destroy: [][]string{
+				{"docker", "compose", "-f", "docker-compose.yml", "down", "--remove-orphans", "--volumes"},
+			},
+			planDestroy: [][]string{
+				{"docker", "compose", "-f", "docker-compose.yml", "ps", "--all"},
+			},
+		},
+		{
+			name:   "kubectl",
+			config: kargo.Config{Name: "myapp", Path: "manifests", Namespace: "myns"},
+			destroy: [][]string{
+				{"kubectl", "delete", "-f", "manifests", "--ignore-not-found", "--namespace", "myns"},
+			},
+			planDestroy: [][]string{
+				{"kubectl", "delete", "-f", "manifests", "--ignore-not-found", "--namespace", "myns", "--dry-run=server"},
+			},
+		},
+		{
+			name: "argocd",
+			config: kargo.Config{
+				Name: "myapp",
+				ArgoCD: &kargo.ArgoCD{
+					Repo:     "https://github.com/myorg/myapp",
+					Path:     "deploy",
+					Server:   "argocd.example.com",
+					Username: "admin",
+					Delete:   kargo.ArgoCDDelete{PropagationPolicy: "background"},
+				},
+			},
+			destroy: [][]string{
+				{"argocd", "login", "argocd.example.com", "--username", "admin"},
+				{"argocd", "app", "delete", "myapp", "--yes", "--cascade=true", "--propagation-policy", "background", "--server", "argocd.example.com"},
+			},
+			planDestroy: [][]string{
+				{"argocd", "login", "argocd.example.com", "--username", "admin"},
+				{"argocd", "app", "resources", "myapp", "--server", "argocd.example.com"},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := &kargo.Generator{TempDir: "/tmp", WorktreeID: "test"}
+
+			cmds, err := g.ExecCmds(&tc.config, kargo.Destroy)
+			require.NoError(t, err)
+			require.Equal(t, tc.destroy, collectCmds(t, cmds))
+
+			cmds, err = g.ExecCmds(&tc.config, kargo.PlanDestroy)
+			require.NoError(t, err)
+			require.Equal(t, tc.planDestroy, collectCmds(t, cmds))
+		})
+	}
+}
+
+func TestGenerate_DestroyErrors(t *testing.T) {
+	g := &kargo.Generator{}
+
+	_, err := g.ExecCmds(&kargo.Config{Name: "myapp", Namespace: "myns", Compose: &kargo.Compose{}}, kargo.Destroy)
+	require.EqualError(t, err, "namespace is not supported with compose")
+
+	_, err = g.ExecCmds(&kargo.Config{
+		Name:   "myapp",
+		ArgoCD: &kargo.ArgoCD{Server: "argocd.example.com", Delete: kargo.ArgoCDDelete{PropagationPolicy: "orphan"}},
+	}, kargo.Destroy)
+	require.EqualError(t, err, `unsupported argocd.delete.propagationPolicy: "orphan"`)
+
+	_, err = g.ExecCmds(&kargo.Config{
+		Name:   "myapp",
+		ArgoCD: &kargo.ArgoCD{Server: "argocd.example.com", Repo: "https://github.com/myorg/myapp", Path: "/", Push: true},
+	}, kargo.Destroy)
+	require.EqualError(t, err, `unable to generate argocd commands: refusing to remove the root of the repository: "/"`)
+}
+
+func TestGenerate_DestroyProtectedNames(t *testing.T) {
+	g := &kargo.Generator{ProtectedNames: []string{"prod-*", "kube-system"}}
+
+	_, err := g.ExecCmds(&kargo.Config{Name: "prod-api", Helm: &kargo.Helm{}}, kargo.Destroy)
+	require.EqualError(t, err, `refusing to destroy prod-api: "prod-api" is protected by "prod-*", set ConfirmDestroy to "prod-api" to proceed`)
+
+	_, err = g.ExecCmds(&kargo.Config{Name: "dns", Namespace: "kube-system", Helm: &kargo.Helm{}}, kargo.Destroy)
+	require.EqualError(t, err, `refusing to destroy dns: "kube-system" is protected by "kube-system", set ConfirmDestroy to "dns" to proceed`)
+
+	// The plan is shown without the confirmation.
+	_, err = g.ExecCmds(&kargo.Config{Name: "prod-api", Helm: &kargo.Helm{}}, kargo.PlanDestroy)
+	require.NoError(t, err)
+
+	g.ConfirmDestroy = "prod-api"
+	cmds, err := g.ExecCmds(&kargo.Config{Name: "prod-api", Helm: &kargo.Helm{}}, kargo.Destroy)
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"helm", "uninstall", "prod-api"}}, collectCmds(t, cmds))
+
+	// The confirmation is for the app, not for every protected one.
+	_, err = g.ExecCmds(&kargo.Config{Name: "prod-web", Helm: &kargo.Helm{}}, kargo.Destroy)
+	require.Error(t, err)
+
+	_, err = (&kargo.Generator{ProtectedNames: []string{"["}}).ExecCmds(&kargo.Config{Name: "myapp", Helm: &kargo.Helm{}}, kargo.Destroy)
+	require.ErrorContains(t, err, `invalid protected name pattern "["`)
+}
+
+func TestGenerate_DestroyGitOps(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "mytoken")
+
+	g := &kargo.Generator{
+		TempDir:      "/tmp",
+		ToolsCommand: []string{"kargo", "tools"},
+		ToolName:     "kargo",
+		WorktreeID:   "test",
+	}
+
+	c := &kargo.Config{
+		Name: "myapp",
+		ArgoCD: &kargo.ArgoCD{
+			Repo:     "https://github.com/myorg/gitops",
+			Path:     "deploy",
+			Server:   "argocd.example.com",
+			Upload:   []kargo.Upload{{Local: "manifests", Remote: "apps/myapp/"}},
+			Delete:   kargo.ArgoCDDelete{Orphan: true},
+			Username: "admin",
+		},
+	}
+
+	cmds, err := g.ExecCmds(c, kargo.Destroy)
+	require.NoError(t, err)
+
+	got := collectCmds(t, cmds)
+	require.Equal(t, []string{"argocd", "app", "delete", "myapp", "--yes", "--cascade=false", "--server", "argocd.example.com"}, got[1])
+	// The uploaded manifests are removed from the root of the worktree.
+	require.Contains(t, cmds, kargo.Cmd{Name: "rm", Args: kargo.NewArgs("-rf", "apps/myapp"), Dir: "/tmp/kargo-gitops/myapp-test"})
+	pr := got[len(got)-2]
+	require.Equal(t, []string{"kargo", "tools", "create-pullrequest", "--dir", "/tmp/kargo-gitops/myapp-test", "--title", "Destroy myapp", "--body", "Destroy myapp"}, pr[:9])
+	require.NotContains(t, pr, "--dry-run")
+
+	cmds, err = g.ExecCmds(c, kargo.PlanDestroy)
+	require.NoError(t, err)
+	got = collectCmds(t, cmds)
+	require.Contains(t, got[len(got)-2], "--dry-run")
+
+	k := &kargo.Config{
+		Name: "myapp",
+		Kustomize: &kargo.Kustomize{
+			Strategy: kargo.KustomizeStrategySetImageAndCreatePR,
+			Git:      kargo.KustomizeGit{Repo: "https://github.com/myorg/gitops", Path: "overlays/dev"},
+		},
+	}
+	cmds, err = g.ExecCmds(k, kargo.Destroy)
+	require.NoError(t, err)
+	got = collectCmds(t, cmds)
+	require.Contains(t, got, []string{"rm", "-rf", "overlays/dev"})
+	require.Contains(t, got[len(got)-2], "Destroy myapp")
+}
@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +26,84 @@ type PullRequestOptions struct {
 
 	// GitUserEmail is the email of the user to use for git commits.
 	GitUserEmail string
+
+	// CommitMessageTemplate is the Go template to render the commit message,
+	// like "chore(deploy): {{ .Name }} {{ join .Images \", \" }}".
+	// It gets the same data as TitleTemplate and BodyTemplate, except DiffStat.
+	// The message defaults to "automated commit".
+	CommitMessageTemplate string
+
+	// CommitTrailers are the trailers like "Signed-off-by: kargo <kargo@example.com>"
+	// to append to the commit message.
+	CommitTrailers []string
+
+	// CoAuthors are the co-authors like "Jane Doe <jane@example.com>"
+	// to credit with Co-authored-by trailers.
+	CoAuthors []string
+
+	// GitSigningFormat is either "ssh" or "gpg".
+	// Commits are signed with GitSigningKey if set.
+	GitSigningFormat string
+
+	// GitSigningKey is the key to sign commits with.
+	// See tools.GitSigning for the accepted values.
+	GitSigningKey string
+
+	// Labels is the list of labels to add to the pull request.
+	Labels []string
+
+	// Reviewers and TeamReviewers are the GitHub users and team slugs
+	// to request reviews from.
+	Reviewers     []string
+	TeamReviewers []string
+
+	// Draft makes the pull request a draft.
+	Draft bool
+
+	// Milestone is either the number or the title of the milestone.
+	Milestone string
+
+	// TitleTemplate and BodyTemplate are Go templates to render the title and the body
+	// of the pull request. They default to "Deploy <name>".
+	// See tools.PullRequestTemplateData for the available data.
+	TitleTemplate string
+	BodyTemplate  string
+
+	// Merge is either "auto" or "wait".
+	// "auto" enables GitHub auto-merge on the pull request.
+	// "wait" waits for the required checks to pass and merges the pull request.
+	Merge string
+
+	// MergeMethod is one of "merge", "squash" and "rebase".
+	MergeMethod string
+
+	// MergeTimeout is the duration to wait for the pull request to become mergeable,
+	// like "30m". Used only when Merge is "wait".
+	MergeTimeout string
+
+	// Provider is one of "github", "gitlab" and "gitea".
+	// It's detected from the host of the repository if empty.
+	Provider string
+
+	// APIURL is the base URL of the provider's API,
+	// for self-hosted instances that serve the API at a non-default URL.
+	APIURL string
+
+	// GitHubAppID, GitHubAppInstallationID and GitHubAppPrivateKey make kargo
+	// authenticate as the GitHub App when GITHUB_TOKEN is not set.
+	// The installation token is fetched by the commands when they run,
+	// via `<tools command> github-app-token` for git and by create-pullrequest itself,
+	// which are given the private key in KARGO_GITHUB_APP_PRIVATE_KEY.
+	// The installation is looked up from the repository if GitHubAppInstallationID is zero.
+	// GitHubAppPrivateKey is the PEM-encoded private key of the app.
+	GitHubAppID             int64
+	GitHubAppInstallationID int64
+	GitHubAppPrivateKey     string
+
+	// Images and ChartVersion are the images and the chart version being deployed,
+	// that are available to TitleTemplate and BodyTemplate.
+	Images       KustomizeImages
+	ChartVersion string
 }
 
 // gitOps generates a series of commands to:
@@ -35,8 +115,11 @@ type PullRequestOptions struct {
 // - and git-push the changes.
 // The commands are generated in such a way that they can be
 // used to plan or apply the deployment in a gitops environment.
-func (g *Generator) gitOps(t Target, name, repo, branch, head, path string, copies []Upload, fileModCmds []Cmd, doPR bool, prOpts PullRequestOptions) ([]Cmd, error) {
-	if t == Apply && len(g.ToolsCommand) == 0 {
+//
+// In GitOpsModeDirect, the changes are pushed straight to the branch
+// instead of a head branch, and no pull request is created.
+func (g *Generator) gitOps(t Target, name, repo, branch, head, path string, copies []Upload, fileModCmds []Cmd, doPR bool, push gitPushOptions, prOpts PullRequestOptions) ([]Cmd, error) {
+	if !t.planning() && len(g.ToolsCommand) == 0 {
 		return nil, errors.New("ToolsCommand is required to run kargo tools")
 	}
 
@@ -44,195 +127,579 @@ func (g *Generator) gitOps(t Target, name, repo, branch, head, path string, copi
 		return nil, errors.New("TempDir is required to use GitOps support")
 	}
 
-	repo, err := normalizeRepo(repo, os.Getenv("GITHUB_TOKEN"))
+	goGit := g.GitBackend == tools.GitBackendGoGit
+	switch g.GitBackend {
+	case "", tools.GitBackendShell:
+	case tools.GitBackendGoGit:
+		if len(g.ToolsCommand) == 0 {
+			return nil, errors.New("ToolsCommand is required to use the go-git backend")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported git backend: %s", g.GitBackend)
+	}
+
+	ref, err := normalizeRepo(repo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to normalize repo: %w", err)
 	}
 
+	provider := prOpts.Provider
+	if provider == "" {
+		provider = tools.DetectPullRequestProvider(ref.Host)
+	}
+
+	providerTokenEnv, ok := providerTokenEnvs[provider]
+	if !ok {
+		return nil, fmt.Errorf("unable to generate gitops commands: unsupported pull request provider: %s", provider)
+	}
+
+	providerToken := os.Getenv(providerTokenEnv)
+
+	// The installation token of the GitHub App expires in an hour,
+	// so it's fetched by the commands when they run, rather than now.
+	gitHubApp := providerToken == "" && provider == tools.ProviderGitHub && prOpts.GitHubAppID != 0
+	if gitHubApp {
+		if len(g.ToolsCommand) == 0 {
+			return nil, errors.New("unable to generate gitops commands: ToolsCommand is required to authenticate as the GitHub App")
+		}
+		if prOpts.GitHubAppPrivateKey == "" {
+			return nil, errors.New("unable to generate gitops commands: the private key of the GitHub App is required")
+		}
+	} else if providerToken == "" {
+		return nil, fmt.Errorf("unable to generate gitops commands: %s is required", providerTokenEnv)
+	}
+
+	// The token is passed to git via the credential helper
+	// instead of being embedded into the clone URL,
+	// so that it never appears in the script and the remote URL.
+	gitSecretEnv := map[string]Secret{
+		envGitToken: {Value: providerToken},
+	}
+	credentialArgs := gitCredentialArgs(ref)
+
+	// gitHubAppArgs are the flags for the tools commands to get the installation token.
+	var gitHubAppArgs []string
+	if gitHubApp {
+		gitHubAppArgs = []string{
+			"--" + tools.FlagGitHubAppID, strconv.FormatInt(prOpts.GitHubAppID, 10),
+			"--" + tools.FlagGitHubAppPrivateKeyEnv, envGitHubAppPrivateKey,
+		}
+		if prOpts.GitHubAppInstallationID != 0 {
+			gitHubAppArgs = append(gitHubAppArgs, "--"+tools.FlagGitHubAppInstallationID, strconv.FormatInt(prOpts.GitHubAppInstallationID, 10))
+		}
+		if prOpts.APIURL != "" {
+			gitHubAppArgs = append(gitHubAppArgs, "--"+tools.FlagGitHubAppAPIURL, prOpts.APIURL)
+		}
+
+		gitSecretEnv = map[string]Secret{
+			envGitHubAppPrivateKey: {Value: prOpts.GitHubAppPrivateKey},
+		}
+		credentialArgs = g.gitHubAppCredentialArgs(ref, repo, gitHubAppArgs)
+	}
+
 	const (
 		remoteName = "origin"
 	)
 
 	var cmds []Cmd
 
-	localRepoDir := filepath.Join(g.TempDir, "kargo-gitops", name)
-
-	var script *Args
-
-	gitCloneArgs := NewArgs("clone", repo, localRepoDir)
-	script = script.Append("git", gitCloneArgs, ";")
-	// gitClone := Cmd{Name: "git", Args: gitCloneArgs}
+	worktreeID, err := g.worktreeID()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+	}
+	localRepoDir := filepath.Join(g.TempDir, "kargo-gitops", name+"-"+worktreeID)
 
 	formatDateTime := func(t time.Time) string {
 		return t.Format("20060102150405")
 	}
 	datetime := formatDateTime(time.Now())
 
-	if head == "" {
-		if g.ToolName == "" {
-			return nil, errors.New("ToolName is required to use GitOps support")
-		}
-		head = g.ToolName + "-" + datetime
-	}
+	// stableHead is true when the head branch is reused across deployments.
+	// It is recreated from the base branch and force-pushed every time,
+	// so that the pull request always contains the latest changes only.
+	var stableHead bool
 
 	baseBranch := "main"
 	if branch != "" {
 		baseBranch = branch
 	}
-	script = script.Append("(", "cd", localRepoDir, "&&", "git", "fetch", remoteName, "&&", "git", "stash", "&&", "git", "checkout", "-b", head, remoteName+"/"+baseBranch, "&&", "git", "rebase", remoteName+"/"+baseBranch, ")")
 
-	runGitCheckoutScript := Cmd{
-		Name: "bash",
-		Args: NewArgs("-vxc", NewBashScript(script)),
+	if err := push.validate(baseBranch, goGit); err != nil {
+		return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
 	}
 
-	var (
-		copyLocal  *Args
-		copyRemote *Args
-	)
+	direct := push.mode == GitOpsModeDirect
 
-	var fileCopies []Cmd
+	cloneOpts := gitCloneOptions{
+		branch:  baseBranch,
+		shallow: g.ShallowClone,
+	}
+	if g.ReuseClone {
+		cloneOpts.cacheDir = gitCacheDir(g.TempDir, ref.Host, ref.FullName())
+	}
+	if g.SparseCheckout {
+		cloneOpts.sparsePaths = sparseCheckoutPaths(path, copies)
+	}
+	if goGit && (g.ShallowClone || g.SparseCheckout || g.ReuseClone) {
+		return nil, errors.New("unable to generate gitops commands: ShallowClone, SparseCheckout and ReuseClone require the shell git backend")
+	}
 
-	for _, u := range copies {
-		if p := u.Local; p != "" {
-			copyLocal = copyLocal.AppendStrings(p)
+	gitClone := gitCloneCmds(repo, credentialArgs, localRepoDir, remoteName, cloneOpts)
+	for i := range gitClone {
+		if gitClone[i].Name != "rm" {
+			gitClone[i].SecretEnv = gitSecretEnv
 		}
+	}
 
-		if p := u.Remote; p != "" {
-			copyRemote = copyRemote.AppendStrings(p)
+	if direct {
+		head = baseBranch
+	} else if head == "" {
+		if g.ToolName == "" {
+			return nil, errors.New("ToolName is required to use GitOps support")
 		}
+		if g.StablePullRequestHead {
+			head = g.ToolName + "/" + name
+			stableHead = true
+		} else {
+			head = g.ToolName + "-" + datetime
+		}
+	}
 
-		cpArgs := NewArgs("cp", "-r", NewJoin(NewArgs(copyLocal, string(os.PathSeparator), "*")), NewJoin(NewArgs(localRepoDir, string(os.PathSeparator), copyRemote)))
-		cp := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(cpArgs))}
+	gitCheckout := Cmd{
+		Name: "git",
+		Args: NewArgs("checkout", "-b", head, remoteName+"/"+baseBranch),
+		Dir:  localRepoDir,
+	}
 
-		fileCopies = append(fileCopies, cp)
+	// pushRef is the refspec to push the head branch.
+	pushRef := head
+	switch {
+	case g.ReuseClone:
+		// The worktrees of the cached clone share the branches,
+		// and a branch can be checked out by one worktree at a time.
+		// So we commit on the detached HEAD and push it to the head branch.
+		gitCheckout.Args = NewArgs("checkout", "--detach", remoteName+"/"+baseBranch)
+		pushRef = "HEAD:refs/heads/" + head
+	case direct:
+		// The base branch is checked out by the clone already,
+		// so we reset it instead of creating it.
+		gitCheckout.Args = NewArgs("checkout", "-B", head, remoteName+"/"+baseBranch)
 	}
 
-	fileModScript := g.scriptWithinDir(filepath.Join(localRepoDir, path), fileModCmds)
+	var fileCopies []Cmd
+	for _, u := range copies {
+		c, err := g.uploadCmd(u, localRepoDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+		}
+		fileCopies = append(fileCopies, c)
+	}
 
-	runFileModScript := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(fileModScript))}
+	var fileMods []Cmd
+	for _, c := range fileModCmds {
+		c.Dir = filepath.Join(localRepoDir, path)
+		if c.SecretEnv == nil && len(cloneOpts.sparsePaths) > 0 {
+			// The sparse clone fetches the files outside of the sparse paths on demand,
+			// e.g. when promote reads the kustomization of another environment.
+			c.SecretEnv = gitSecretEnv
+		}
+		fileMods = append(fileMods, c)
+	}
 
-	var gitAddArgs *Args
-	gitAddArgs = gitAddArgs.Append("cd", localRepoDir, ";")
-	gitAddArgs = gitAddArgs.Append("git", "add", ".")
-	runGitAddScript := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(gitAddArgs))}
+	gitAdd := Cmd{Name: "git", Args: NewArgs("add", "."), Dir: localRepoDir}
 
-	var gitCommitArgs *Args
-	gitCommitArgs = gitCommitArgs.Append(
-		"cd", localRepoDir, ";",
-	)
+	var gitConfigs []Cmd
 	if prOpts.GitUserName != "" {
-		gitCommitArgs = gitCommitArgs.Append(
-			"git", "config", "user.name", prOpts.GitUserName, ";",
-		)
+		gitConfigs = append(gitConfigs, Cmd{
+			Name: "git",
+			Args: NewArgs("config", "user.name", prOpts.GitUserName),
+			Dir:  localRepoDir,
+		})
 	}
 	if prOpts.GitUserEmail != "" {
-		gitCommitArgs = gitCommitArgs.Append(
-			"git", "config", "user.email", prOpts.GitUserEmail, ";",
-		)
+		gitConfigs = append(gitConfigs, Cmd{
+			Name: "git",
+			Args: NewArgs("config", "user.email", prOpts.GitUserEmail),
+			Dir:  localRepoDir,
+		})
 	} else {
 		// We need to set user.email to an empty string anyway to avoid:
 		//   fatal: unable to auto-detect email address (got 'mylinuxuser@myhostname.(none)')
-		gitCommitArgs = gitCommitArgs.Append(
-			"(", "git", "config", "user.email", "||", "git", "config", "user.email", "''", ")", ";",
-		)
+		gitConfigEmail := newBashCmd(NewArgs("git", "config", "user.email", ShellRaw("||"), "git", "config", "user.email", ""))
+		gitConfigEmail.Dir = localRepoDir
+		gitConfigs = append(gitConfigs, gitConfigEmail)
 	}
-	gitCommitArgs = gitCommitArgs.Append(
-		"git", "commit", "-m", "'automated commit'",
-	)
-	gitCommit := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(gitCommitArgs))}
 
-	var gitPushArgs *Args
-	gitPushArgs = gitPushArgs.Append(
-		"cd", localRepoDir, ";",
-	)
-	gitPushArgs = gitPushArgs.Append(
-		"git", "push", remoteName, head,
-	)
-	gitPush := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(gitPushArgs))}
-
-	// var gitDiffArgs *Args
-	// gitDiffArgs = gitDiffArgs.Append(
-	// 	"cd", localRepoDir, ";",
-	// )
-	// gitDiffArgs = gitDiffArgs.Append(
-	// 	"git", "diff",
-	// )
-	// gitDiff := Cmd{Name: "bash", Args: NewArgs("-vxc", NewBashScript(gitDiffArgs))}
-
-	cmds = append(cmds, runGitCheckoutScript)
+	// The file modifications may result in no changes,
+	// e.g. when the image tag is already up to date.
+	// We skip the commit and the push in that case, so that
+	// re-running the deployment is a no-op.
+	// create-pullrequest detects it too, and reports it as no changes.
+	commitMsg, err := newCommitMessage(name, head, baseBranch, prOpts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+	}
+
+	signing := tools.GitSigning{Format: prOpts.GitSigningFormat, Key: prOpts.GitSigningKey}
+	if err := signing.Validate(); err != nil {
+		return nil, fmt.Errorf("unable to generate gitops commands: %w", err)
+	}
+
+	gitCommitArgs := NewArgs("git", signing.ShellArgs(), "commit", "-m", commitMsg)
+	if signing.Enabled() {
+		gitCommitArgs = gitCommitArgs.AppendStrings("--gpg-sign")
+	}
+	gitCommit := newBashCmd(NewArgs("git", "diff", "--cached", "--quiet", ShellRaw("||"), gitCommitArgs))
+	gitCommit.Dir = localRepoDir
+
+	pushArgs := NewArgs("git", "push")
+	if stableHead {
+		pushArgs = pushArgs.AppendStrings("--force")
+	}
+	pushArgs = pushArgs.AppendStrings(remoteName, pushRef)
+
+	gitPush := newBashCmd(NewArgs(cloneOpts.lockArgs(), "git", "diff", "--quiet", remoteName+"/"+baseBranch, "HEAD", ShellRaw("||"), pushArgs))
+	gitPush.Dir = localRepoDir
+	gitPush.SecretEnv = gitSecretEnv
+
+	if goGit {
+		clone := g.gitToolCmd(tools.GitActionClone, localRepoDir, append([]string{"--" + tools.FlagGitRepo, repo}, gitHubAppArgs...)...)
+		clone.SecretEnv = gitSecretEnv
+		gitClone = []Cmd{{Name: "rm", Args: NewArgs("-rf", localRepoDir)}, clone}
+		gitCheckout = g.gitToolCmd(tools.GitActionCheckout, localRepoDir, "--"+tools.FlagGitBranch, head, "--"+tools.FlagGitStartPoint, remoteName+"/"+baseBranch)
+		gitAdd = g.gitToolCmd(tools.GitActionAdd, localRepoDir)
+		// The author is given to the commit directly,
+		// so there's no need to git-config user.name and user.email.
+		gitConfigs = nil
+		var commitArgs []string
+		if prOpts.GitUserName != "" {
+			commitArgs = append(commitArgs, "--"+tools.FlagGitUserName, prOpts.GitUserName)
+		}
+		if prOpts.GitUserEmail != "" {
+			commitArgs = append(commitArgs, "--"+tools.FlagGitUserEmail, prOpts.GitUserEmail)
+		}
+		if signing.Enabled() {
+			commitArgs = append(commitArgs, "--"+tools.FlagGitSigningFormat, signing.Format, "--"+tools.FlagGitSigningKey, signing.Key)
+		}
+		gitCommit = g.gitToolCmd(tools.GitActionCommit, localRepoDir)
+		gitCommit.Args = gitCommit.Args.Append("--"+tools.FlagGitMessage, commitMsg).AppendStrings(commitArgs...)
+		pushArgs := []string{"--" + tools.FlagGitRemote, remoteName, "--" + tools.FlagGitBranch, head, "--" + tools.FlagGitStartPoint, remoteName + "/" + baseBranch}
+		if stableHead {
+			pushArgs = append(pushArgs, "--"+tools.FlagGitForce, "true")
+		}
+		if gitHubApp {
+			pushArgs = append(pushArgs, "--"+tools.FlagGitRepo, repo)
+			pushArgs = append(pushArgs, gitHubAppArgs...)
+		}
+		gitPush = g.gitToolCmd(tools.GitActionPush, localRepoDir, pushArgs...)
+		gitPush.SecretEnv = gitSecretEnv
+	}
+
+	gitCleanup := gitCleanupCmd(localRepoDir, cloneOpts)
+
+	cmds = append(cmds, gitClone...)
+	cmds = append(cmds, gitCheckout)
 	cmds = append(cmds, fileCopies...)
-	cmds = append(cmds, runFileModScript)
-	cmds = append(cmds, runGitAddScript)
+	cmds = append(cmds, fileMods...)
+	cmds = append(cmds, gitAdd)
+	cmds = append(cmds, gitConfigs...)
 	cmds = append(cmds, gitCommit)
 
-	tokenEnv := "KARGO_TOOLS_GITHUB_TOKEN"
+	if direct {
+		if os.Getenv("KANVAS_DRY_RUN") == "true" || t.planning() || !doPR {
+			// Show what would be pushed.
+			cmds = append(cmds, Cmd{
+				Name: "git",
+				Args: NewArgs("diff", "--stat", remoteName+"/"+baseBranch, "HEAD"),
+				Dir:  localRepoDir,
+			})
+		} else {
+			gitPushDirect := directPushCmd(remoteName, baseBranch, push.retries, signing, cloneOpts.lockArgs())
+			gitPushDirect.Dir = localRepoDir
+			gitPushDirect.SecretEnv = gitSecretEnv
+			cmds = append(cmds, gitPushDirect)
+		}
+		cmds = append(cmds, gitCleanup)
+		return cmds, nil
+	}
+
+	tokenEnv := "KARGO_TOOLS_" + strings.ToUpper(provider) + "_TOKEN"
 	var toolArgs []string
+	verb := "Deploy"
+	if t.destroying() {
+		verb = "Destroy"
+	}
+
 	toolArgs = append(toolArgs, g.ToolsCommand[1:]...)
 	toolArgs = append(toolArgs, tools.CommandCreatePullRequest,
 		"--"+tools.FlagCreatePullRequestDir, localRepoDir,
-		"--"+tools.FlagCreatePullRequestTitle, "Deploy "+name,
-		"--"+tools.FlagCreatePullRequestBody, "Deploy "+name,
+		"--"+tools.FlagCreatePullRequestTitle, verb+" "+name,
+		"--"+tools.FlagCreatePullRequestBody, verb+" "+name,
 		"--"+tools.FlagCreatePullRequestHead, head,
 		"--"+tools.FlagCreatePullRequestBase, baseBranch,
 		"--"+tools.FlagCreatePullRequestTokenEnv, tokenEnv,
 	)
 
+	if provider != tools.ProviderGitHub {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestProvider, provider)
+	}
+
+	if goGit {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestGitBackend, g.GitBackend)
+	}
+
+	prSecretEnv := map[string]Secret{tokenEnv: {Value: providerToken}}
+	if gitHubApp {
+		// The API URL is in gitHubAppArgs.
+		toolArgs = append(toolArgs, gitHubAppArgs...)
+		prSecretEnv = gitSecretEnv
+	} else if prOpts.APIURL != "" {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestAPIURL, prOpts.APIURL)
+	}
+
 	if len(prOpts.AssigneeIDs) > 0 {
 		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestAssigneeIDs, strings.Join(prOpts.AssigneeIDs, ","))
 	}
 
+	if len(prOpts.Labels) > 0 {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestLabels, strings.Join(prOpts.Labels, ","))
+	}
+
+	if len(prOpts.Reviewers) > 0 {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestReviewers, strings.Join(prOpts.Reviewers, ","))
+	}
+
+	if len(prOpts.TeamReviewers) > 0 {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestTeamReviewers, strings.Join(prOpts.TeamReviewers, ","))
+	}
+
+	if prOpts.Draft {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestDraft, "true")
+	}
+
+	if prOpts.Milestone != "" {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestMilestone, prOpts.Milestone)
+	}
+
+	if prOpts.Merge != "" {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestMerge, prOpts.Merge)
+	}
+
+	if prOpts.MergeMethod != "" {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestMergeMethod, prOpts.MergeMethod)
+	}
+
+	if prOpts.MergeTimeout != "" {
+		if _, err := time.ParseDuration(prOpts.MergeTimeout); err != nil {
+			return nil, fmt.Errorf("invalid pull request merge timeout: %w", err)
+		}
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestMergeTimeout, prOpts.MergeTimeout)
+	}
+
+	templateArgs, err := prTemplateArgs(name, prOpts)
+	if err != nil {
+		return nil, err
+	}
+
 	if prOpts.OutputFile != "" {
 		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestOutputFile, prOpts.OutputFile)
 	}
 
-	if os.Getenv("KANVAS_DRY_RUN") == "true" || t == Plan || !doPR {
+	if stableHead {
+		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestUpdate, "true")
+	}
+
+	dryRun := os.Getenv("KANVAS_DRY_RUN") == "true" || t.planning() || !doPR
+	if dryRun {
 		toolArgs = append(toolArgs, "--"+tools.FlagCreatePullRequestDryRun, "true")
 	} else {
 		cmds = append(cmds, gitPush)
 	}
 
-	githubToken := os.Getenv("GITHUB_TOKEN")
-	if githubToken == "" {
-		return nil, fmt.Errorf("unable to generate gitops commands: %s is required", "GITHUB_TOKEN")
-	}
 	kargoToolsCreatePullRequest := Cmd{
-		Name:   g.ToolsCommand[0],
-		Args:   NewArgs(toolArgs),
-		AddEnv: map[string]string{tokenEnv: githubToken},
+		Name:      g.ToolsCommand[0],
+		Args:      NewArgs(toolArgs, templateArgs),
+		SecretEnv: prSecretEnv,
+	}
+	if prOpts.OutputFile != "" && !dryRun {
+		// The subsequent commands can refer to the pull request as pullrequest.number and pullrequest.url.
+		// They are left unset when no pull request is created because there are no changes.
+		// The dry run creates no pull request, and so captures nothing.
+		kargoToolsCreatePullRequest.ID = pullRequestCmdID
+		kargoToolsCreatePullRequest.Outputs = []CmdOutput{
+			{Name: "number", File: prOpts.OutputFile, JSONPath: "{.number}", Optional: true},
+			{Name: "url", File: prOpts.OutputFile, JSONPath: "{.htmlURL}", Optional: true},
+		}
 	}
 	cmds = append(cmds, kargoToolsCreatePullRequest)
+	cmds = append(cmds, gitCleanup)
 
 	return cmds, nil
 }
 
-func validateRepo(repo string) error {
-	http := strings.HasPrefix(repo, "http://")
-	https := strings.HasPrefix(repo, "https://")
-	git := !http && !https && strings.Contains(repo, ":")
+// prTemplateArgs returns the create-pullrequest flags to render
+// the title and the body of the pull request from the templates.
+// The images are given to the tool as a comma-separated list,
+// whose tags and digests may be resolved from outputs at runtime.
+func prTemplateArgs(name string, prOpts PullRequestOptions) (*Args, error) {
+	if prOpts.TitleTemplate == "" && prOpts.BodyTemplate == "" {
+		return nil, nil
+	}
+
+	var args *Args
+
+	if prOpts.TitleTemplate != "" {
+		args = args.AppendStrings("--"+tools.FlagCreatePullRequestTitleTemplate, prOpts.TitleTemplate)
+	}
 
-	if !http && !https && !git {
-		return fmt.Errorf("either http(s):// or host:owner/repo.git format is required for repo, but got %s", repo)
+	if prOpts.BodyTemplate != "" {
+		args = args.AppendStrings("--"+tools.FlagCreatePullRequestBodyTemplate, prOpts.BodyTemplate)
 	}
 
-	return nil
+	args = args.AppendStrings("--"+tools.FlagCreatePullRequestName, name)
+
+	if len(prOpts.Images) > 0 {
+		var images *Args
+		for i, img := range prOpts.Images {
+			if i > 0 {
+				images = images.AppendStrings(",")
+			}
+
+			a, err := KustomizeImages{img}.KargoAppendArgs(nil, "")
+			if err != nil {
+				return nil, fmt.Errorf("unable to render images for pull request templates: %w", err)
+			}
+			images = images.Append(a)
+		}
+		args = args.Append("--"+tools.FlagCreatePullRequestImages, NewJoin(images))
+	}
+
+	if prOpts.ChartVersion != "" {
+		args = args.AppendStrings("--"+tools.FlagCreatePullRequestChartVersion, prOpts.ChartVersion)
+	}
+
+	return args, nil
 }
 
-func normalizeRepo(repo, token string) (string, error) {
-	https := strings.HasPrefix(repo, "https://")
+// uploadCmd returns the command to sync the local directory of the upload
+// to the remote directory in the worktree at dir.
+// The command lists the added, changed and removed files,
+// which shows what's going to be uploaded on plan.
+func (g *Generator) uploadCmd(u Upload, dir string) (Cmd, error) {
+	if len(g.ToolsCommand) == 0 {
+		return Cmd{}, errors.New("ToolsCommand is required to upload files")
+	}
+
+	if u.Local == "" {
+		return Cmd{}, errors.New("upload.local is required")
+	}
 
-	if err := validateRepo(repo); err != nil {
-		return "", err
+	switch u.Mode {
+	case "", tools.SyncModeCopy, tools.SyncModeMirror:
+	default:
+		return Cmd{}, fmt.Errorf("unsupported upload.mode: %q", u.Mode)
 	}
 
-	if token == "" {
-		return repo, nil
+	// The remote path never points outside of the worktree.
+	clean := path.Clean("/" + u.Remote)
+
+	// Mirroring onto the root of the worktree would remove all the other files in the repo.
+	if u.Mode == tools.SyncModeMirror && clean == "/" {
+		return Cmd{}, fmt.Errorf("refusing to mirror onto the root of the repository: upload.remote %q", u.Remote)
 	}
 
-	if https && token != "" {
-		// Use GITHUB_TOKEN if available for git clone
-		repo = strings.Replace(repo, "https://", fmt.Sprintf("https://kargo:%s@", token), 1)
+	remote := filepath.Join(dir, filepath.FromSlash(clean))
+
+	var args []string
+	args = append(args, g.ToolsCommand[1:]...)
+	args = append(args, tools.CommandSyncFiles,
+		"--"+tools.FlagSyncFilesSrc, u.Local,
+		"--"+tools.FlagSyncFilesDst, remote,
+	)
+	if u.Mode != "" {
+		args = append(args, "--"+tools.FlagSyncFilesMode, u.Mode)
+	}
+	if len(u.Include) > 0 {
+		args = append(args, "--"+tools.FlagSyncFilesInclude, strings.Join(u.Include, ","))
 	}
+	if len(u.Exclude) > 0 {
+		args = append(args, "--"+tools.FlagSyncFilesExclude, strings.Join(u.Exclude, ","))
+	}
+
+	return Cmd{
+		Name: g.ToolsCommand[0],
+		Args: NewArgs(args),
+	}, nil
+}
+
+// gitToolCmd returns the command to run the git action
+// via `kargo tools git` with the go-git backend.
+func (g *Generator) gitToolCmd(action, dir string, args ...string) Cmd {
+	var toolArgs []string
+	toolArgs = append(toolArgs, g.ToolsCommand[1:]...)
+	toolArgs = append(toolArgs, tools.CommandGit,
+		"--"+tools.FlagGitBackend, tools.GitBackendGoGit,
+		"--"+tools.FlagGitAction, action,
+		"--"+tools.FlagGitDir, dir,
+		"--"+tools.FlagGitTokenEnv, envGitToken,
+	)
+	toolArgs = append(toolArgs, args...)
+
+	return Cmd{
+		Name: g.ToolsCommand[0],
+		Args: NewArgs(toolArgs),
+	}
+}
+
+// providerTokenEnvs maps each pull request provider to
+// the environment variable that contains the token for it.
+var providerTokenEnvs = map[string]string{
+	tools.ProviderGitHub: "GITHUB_TOKEN",
+	tools.ProviderGitLab: "GITLAB_TOKEN",
+	tools.ProviderGitea:  "GITEA_TOKEN",
+}
+
+// normalizeRepo parses and validates the remote URL of the repository.
+func normalizeRepo(repo string) (*tools.Repository, error) {
+	return tools.ParseRepository(repo)
+}
+
+// envGitToken is the environment variable to pass
+// the git token to the git credential helper.
+const envGitToken = "KARGO_GIT_TOKEN"
+
+// envGitHubAppPrivateKey is the environment variable to pass
+// the private key of the GitHub App to the tools commands.
+const envGitHubAppPrivateKey = "KARGO_GITHUB_APP_PRIVATE_KEY"
+
+// gitCredentialArgs returns the git-clone flags to configure the cloned repository
+// to read the token from envGitToken on fetch and push.
+// The helper is a shell function that is evaluated by git at runtime,
+// so the token is never written to the repository config nor shown in the command.
+//
+// It returns nil for non-https repositories, which are accessed via ssh,
+// or must not be given the token in plain text.
+func gitCredentialArgs(repo *tools.Repository) *Args {
+	if repo.Scheme != "https" {
+		return nil
+	}
+
+	return NewArgs("--config", `credential.helper=!f() { echo username=kargo; echo "password=$`+envGitToken+`"; }; f`)
+}
+
+// gitHubAppCredentialArgs returns the git-clone flags to configure the cloned repository
+// to get the installation token of the GitHub App via `<tools command> github-app-token`
+// on every fetch and push, so that the token never expires while the commands run.
+// The private key is read from envGitHubAppPrivateKey by the tools command.
+func (g *Generator) gitHubAppCredentialArgs(ref *tools.Repository, repo string, gitHubAppArgs []string) *Args {
+	if ref.Scheme != "https" {
+		return nil
+	}
+
+	var tokenCmd []string
+	tokenCmd = append(tokenCmd, g.ToolsCommand...)
+	tokenCmd = append(tokenCmd, tools.CommandGitHubAppToken, "--"+tools.FlagGitHubAppTokenRepo, repo)
+	tokenCmd = append(tokenCmd, gitHubAppArgs...)
 
-	return repo, nil
+	return NewArgs("--config", `credential.helper=!f() { echo username=x-access-token; echo "password=$(`+ShellJoin(tokenCmd)+`)"; }; f`)
 }
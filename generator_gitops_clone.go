@@ -0,0 +1,191 @@
+package kargo
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitCloneOptions is how gitOps gets the worktree of the repository.
+type gitCloneOptions struct {
+	// branch is the branch to clone.
+	branch string
+	// shallow clones the latest commit of the branch only.
+	shallow bool
+	// sparsePaths are the paths to check out.
+	// All the paths are checked out if empty.
+	sparsePaths []string
+	// cacheDir is the clone shared by all the runs, from which the worktree is added.
+	// The worktree is cloned from scratch if empty.
+	cacheDir string
+}
+
+// newWorktreeID returns a random ID to make the gitops worktree of each run unique.
+func newWorktreeID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generating worktree ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// worktreeID returns WorktreeID, or a random ID if it's empty.
+func (g *Generator) worktreeID() (string, error) {
+	if g.WorktreeID != "" {
+		return g.WorktreeID, nil
+	}
+	return newWorktreeID()
+}
+
+// gitCacheDir returns the directory of the clone of the repository
+// shared by ReuseClone across runs and apps.
+func gitCacheDir(tempDir, host, fullName string) string {
+	return filepath.Join(tempDir, "kargo-gitops", "cache", host, filepath.FromSlash(fullName))
+}
+
+// sparseCheckoutPaths returns the paths to check out for the path to modify
+// and the remote paths of the uploads.
+// It returns nil if any of them is the root of the repository,
+// as the whole repository needs to be checked out in that case.
+func sparseCheckoutPaths(p string, copies []Upload) []string {
+	all := []string{p}
+	for _, u := range copies {
+		all = append(all, u.Remote)
+	}
+
+	var paths []string
+	for _, p := range all {
+		p = strings.Trim(path.Clean("/"+p), "/")
+		if p == "" {
+			return nil
+		}
+		paths = append(paths, p)
+	}
+
+	return paths
+}
+
+// lockCmd returns the shell statement to hold the lock on dir until the script exits,
+// so that concurrent runs never update the refs of the shared clone at the same time.
+func lockCmd(dir string) *Args {
+	return NewArgs(
+		"mkdir", "-p", filepath.Dir(dir), ShellRaw("&&"),
+		ShellRaw("exec"), ShellRaw("9>"), dir+".lock", ShellRaw("&&"),
+		"flock", "9", ShellRaw("||"), ShellRaw("exit 1;"),
+	)
+}
+
+// lockArgs returns the shell statement to lock the cached clone,
+// which needs to precede the scripts that update its refs, like fetches and pushes.
+// It returns nil if there's no cached clone.
+func (o gitCloneOptions) lockArgs() *Args {
+	if o.cacheDir == "" {
+		return nil
+	}
+	return lockCmd(o.cacheDir)
+}
+
+// gitCloneCmds returns the commands to get the worktree of the repo at dir.
+//
+// dir is cloned from scratch unless opts.cacheDir is set.
+// Otherwise, the cached clone is cloned or fetched under the file lock,
+// and dir is added as a worktree of it with the detached HEAD,
+// so that each run gets its own worktree without cloning the whole repository.
+//
+// A shallow clone fetches the latest commit of the branch only,
+// and a sparse checkout writes opts.sparsePaths only into the worktree,
+// which makes gitops fast on large monorepos.
+func gitCloneCmds(repo string, credentialArgs *Args, dir, remote string, opts gitCloneOptions) []Cmd {
+	sparse := len(opts.sparsePaths) > 0
+
+	cloneArgs := NewArgs("clone", credentialArgs)
+	if opts.cacheDir != "" {
+		// Only the worktrees are checked out.
+		cloneArgs = cloneArgs.AppendStrings("--no-checkout")
+	}
+	if opts.shallow {
+		cloneArgs = cloneArgs.AppendStrings("--depth", "1")
+	}
+	if opts.shallow || sparse {
+		cloneArgs = cloneArgs.AppendStrings("--single-branch", "--branch", opts.branch)
+	}
+	if sparse {
+		// Blobs outside the sparse paths are never downloaded.
+		cloneArgs = cloneArgs.AppendStrings("--filter=blob:none")
+		if opts.cacheDir == "" {
+			cloneArgs = cloneArgs.AppendStrings("--sparse")
+		}
+	}
+
+	var cmds []Cmd
+
+	if cache := opts.cacheDir; cache != "" {
+		cloneArgs = cloneArgs.AppendStrings(repo, cache)
+
+		fetchArgs := NewArgs("git", "-C", cache, "fetch")
+		if opts.shallow {
+			fetchArgs = fetchArgs.AppendStrings("--depth", "1")
+		}
+		fetchArgs = fetchArgs.AppendStrings(remote, "+refs/heads/"+opts.branch+":refs/remotes/"+remote+"/"+opts.branch)
+
+		// The worktree is added with --no-checkout, so that the sparse checkout,
+		// which is configured per worktree, takes effect on the checkout.
+		// The worktrees of runs that were killed before the cleanup are pruned.
+		add := NewArgs(
+			lockCmd(cache),
+			ShellRaw("if"), ShellRaw("["), ShellRaw("-d"), cache+"/.git", ShellRaw("];"), ShellRaw("then"),
+			fetchArgs, ShellRaw(";"),
+			ShellRaw("else"),
+			"rm", "-rf", cache, ShellRaw("&&"), "git", cloneArgs, ShellRaw(";"),
+			ShellRaw("fi"), ShellRaw("&&"),
+			"git", "-C", cache, "worktree", "prune", ShellRaw("&&"),
+			"rm", "-rf", dir, ShellRaw("&&"),
+			"git", "-C", cache, "worktree", "add", "--no-checkout", "--detach", dir, remote+"/"+opts.branch,
+		)
+		cmds = append(cmds, newBashCmd(add))
+	} else {
+		cloneArgs = cloneArgs.AppendStrings(repo, dir)
+
+		// We start from a fresh clone so that a stale worktree
+		// left by a previous run never affects the result.
+		cmds = append(cmds,
+			Cmd{Name: "rm", Args: NewArgs("-rf", dir)},
+			Cmd{Name: "git", Args: cloneArgs},
+		)
+	}
+
+	if sparse {
+		// Non-cone patterns are used, so that the uploads can be files as well as directories.
+		var patterns []string
+		for _, p := range opts.sparsePaths {
+			patterns = append(patterns, "/"+p)
+		}
+		cmds = append(cmds, Cmd{
+			Name: "git",
+			Args: NewArgs("sparse-checkout", "set", "--no-cone", patterns),
+			Dir:  dir,
+		})
+	}
+
+	return cmds
+}
+
+// gitCleanupCmd returns the command to remove the worktree at dir,
+// which is run whether the gitops commands succeed or not.
+func gitCleanupCmd(dir string, opts gitCloneOptions) Cmd {
+	if cache := opts.cacheDir; cache != "" {
+		remove := NewArgs(
+			lockCmd(cache),
+			"git", "-C", cache, "worktree", "remove", "--force", dir, ShellRaw("||"), "rm", "-rf", dir, ShellRaw(";"),
+			"git", "-C", cache, "worktree", "prune",
+		)
+		c := newBashCmd(remove)
+		c.Finally = true
+		return c
+	}
+
+	return Cmd{Name: "rm", Args: NewArgs("-rf", dir), Finally: true}
+}
@@ -0,0 +1,311 @@
+package kargo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSparseCheckoutPaths(t *testing.T) {
+	require.Equal(t, []string{"deploy/myapp"}, sparseCheckoutPaths("deploy/myapp/", nil))
+	require.Equal(t, []string{"deploy/myapp", "charts", "manifests/app.yaml"}, sparseCheckoutPaths("./deploy/myapp", []Upload{
+		{Local: "charts", Remote: "/charts"},
+		{Local: "app.yaml", Remote: "manifests/app.yaml"},
+	}))
+	require.Nil(t, sparseCheckoutPaths("", nil))
+	require.Nil(t, sparseCheckoutPaths("deploy", []Upload{{Local: "all", Remote: "."}}))
+}
+
+func TestGitOps_ShallowSparseReuse(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "mytoken")
+
+	g := &Generator{
+		TempDir:        "/tmp",
+		ToolsCommand:   []string{"kargo", "tools"},
+		ToolName:       "kargo",
+		WorktreeID:     "test",
+		ShallowClone:   true,
+		SparseCheckout: true,
+		ReuseClone:     true,
+	}
+
+	cmds, err := g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "", "kargo-head", "deploy", nil, nil, true, gitPushOptions{}, PullRequestOptions{})
+	require.NoError(t, err)
+
+	var got [][]string
+	for _, c := range cmds[:3] {
+		got = append(got, append([]string{c.Name}, c.Args.MustCollect(nil)...))
+	}
+
+	cache := "/tmp/kargo-gitops/cache/github.com/myorg/myrepo"
+	lock := "mkdir -p /tmp/kargo-gitops/cache/github.com/myorg && exec 9> " + cache + ".lock && flock 9 || exit 1; "
+
+	require.Equal(t, [][]string{
+		{"bash", "-vxc", lock + "if [ -d " + cache + "/.git ]; then git -C " + cache + " fetch --depth 1 origin +refs/heads/main:refs/remotes/origin/main ; " +
+			"else rm -rf " + cache + " && git clone --config 'credential.helper=!f() { echo username=kargo; echo \"password=$KARGO_GIT_TOKEN\"; }; f' " +
+			"--no-checkout --depth 1 --single-branch --branch main --filter=blob:none https://github.com/myorg/myrepo.git " + cache + " ; fi && " +
+			"git -C " + cache + " worktree prune && rm -rf /tmp/kargo-gitops/myapp-test && " +
+			"git -C " + cache + " worktree add --no-checkout --detach /tmp/kargo-gitops/myapp-test origin/main"},
+		{"git", "sparse-checkout", "set", "--no-cone", "/deploy"},
+		{"git", "checkout", "--detach", "origin/main"},
+	}, got)
+
+	last := cmds[len(cmds)-1]
+	require.True(t, last.Finally)
+	require.Equal(t, []string{"-vxc", lock + "git -C " + cache + " worktree remove --force /tmp/kargo-gitops/myapp-test || rm -rf /tmp/kargo-gitops/myapp-test ; git -C " + cache + " worktree prune"}, last.Args.MustCollect(nil))
+	require.Equal(t, []string{"-vxc", lock + "git diff --quiet origin/main HEAD || git push origin HEAD:refs/heads/kargo-head"}, cmds[len(cmds)-3].Args.MustCollect(nil))
+
+	for _, c := range cmds[:2] {
+		env, err := c.Env(nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{envGitToken: "mytoken"}, env)
+	}
+
+	g.GitBackend = "go-git"
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "", "kargo-head", "deploy", nil, nil, true, gitPushOptions{}, PullRequestOptions{})
+	require.EqualError(t, err, "unable to generate gitops commands: ShallowClone, SparseCheckout and ReuseClone require the shell git backend")
+}
+
+// commitFiles writes the files into the worktree at dir and commits them.
+func commitFiles(t *testing.T, dir, msg string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+	runGit(t, dir, "add", ".")
+	runGit(t, dir, "commit", "-m", msg)
+}
+
+// initRemote creates the bare repository with the main branch,
+// and returns the directory of the seed clone to push commits to it.
+func initRemote(t *testing.T, remote string, files map[string]string) string {
+	t.Helper()
+
+	runGit(t, "", "init", "--bare", "--initial-branch", "main", remote)
+	// Partial clones are served only if the server allows filters.
+	runGit(t, remote, "config", "uploadpack.allowFilter", "true")
+
+	seed := t.TempDir()
+	runGit(t, seed, "clone", remote, ".")
+	commitFiles(t, seed, "first", files)
+	runGit(t, seed, "push", "origin", "HEAD:main")
+
+	return seed
+}
+
+// runCmds runs cmds in order the way runners are supposed to:
+// it stops at the first failure, except for the commands whose Finally is set.
+func runCmds(t *testing.T, cmds []Cmd) error {
+	t.Helper()
+
+	var failed error
+	for _, c := range cmds {
+		if failed != nil && !c.Finally {
+			continue
+		}
+		if out, err := runCmd(t, c); err != nil && !c.AllowFailure && failed == nil {
+			failed = fmt.Errorf("%s: %w: %s", c.String(), err, out)
+		}
+	}
+	return failed
+}
+
+func requireGitAndFlock(t *testing.T) {
+	t.Helper()
+
+	for _, bin := range []string{"git", "bash", "flock"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+}
+
+func TestGitCloneCmds_Local(t *testing.T) {
+	requireGitAndFlock(t)
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	seed := initRemote(t, remote, map[string]string{"README.md": "seed\n", "deploy/myapp/app.yaml": "v1\n", "other/app.yaml": "other\n"})
+	commitFiles(t, seed, "second", map[string]string{"deploy/myapp/app.yaml": "v2\n"})
+	runGit(t, seed, "push", "origin", "HEAD:main")
+
+	// The file:// URL is required for --depth and --filter to take effect on local repositories.
+	url := "file://" + remote
+	tmp := t.TempDir()
+	cache := filepath.Join(tmp, "kargo-gitops", "cache", "myrepo")
+
+	run := func(dir string, opts gitCloneOptions) {
+		t.Helper()
+		for _, c := range gitCloneCmds(url, nil, dir, "origin", opts) {
+			out, err := runCmd(t, c)
+			require.NoError(t, err, out)
+		}
+		out, err := runCmd(t, Cmd{Name: "git", Args: NewArgs("checkout", "--detach", "origin/main"), Dir: dir})
+		require.NoError(t, err, out)
+	}
+
+	cleanup := func(dir string, opts gitCloneOptions) {
+		t.Helper()
+		out, err := runCmd(t, gitCleanupCmd(dir, opts))
+		require.NoError(t, err, out)
+		require.NoDirExists(t, dir)
+	}
+
+	opts := gitCloneOptions{branch: "main", shallow: true, sparsePaths: []string{"deploy/myapp"}, cacheDir: cache}
+
+	// The cache doesn't exist yet, so it's cloned.
+	dir1 := filepath.Join(tmp, "kargo-gitops", "myapp-1")
+	run(dir1, opts)
+
+	require.Equal(t, "true\n", runGit(t, dir1, "rev-parse", "--is-shallow-repository"))
+	require.Equal(t, "1\n", runGit(t, dir1, "rev-list", "--count", "HEAD"))
+	require.FileExists(t, filepath.Join(dir1, "deploy", "myapp", "app.yaml"))
+	require.NoFileExists(t, filepath.Join(dir1, "other", "app.yaml"))
+	require.NoFileExists(t, filepath.Join(dir1, "README.md"))
+	// Only the worktrees are checked out.
+	require.NoFileExists(t, filepath.Join(cache, "README.md"))
+
+	marker := filepath.Join(cache, ".git", "kargo-reused")
+	require.NoError(t, os.WriteFile(marker, nil, 0644))
+
+	// Someone pushes to main while the first worktree is still in use,
+	// and another worktree is added from the same cache.
+	require.NoError(t, os.WriteFile(filepath.Join(dir1, "deploy", "myapp", "app.yaml"), []byte("dirty\n"), 0644))
+	commitFiles(t, seed, "third", map[string]string{"deploy/myapp/app.yaml": "v3\n"})
+	runGit(t, seed, "push", "origin", "HEAD:main")
+
+	dir2 := filepath.Join(tmp, "kargo-gitops", "myapp-2")
+	run(dir2, gitCloneOptions{branch: "main", shallow: true, cacheDir: cache})
+
+	require.FileExists(t, marker, "the cache must be reused")
+	require.Equal(t, strings.TrimSpace(runGit(t, seed, "rev-parse", "HEAD")), strings.TrimSpace(runGit(t, dir2, "rev-parse", "HEAD")))
+	content, err := os.ReadFile(filepath.Join(dir2, "deploy", "myapp", "app.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "v3\n", string(content))
+	// The sparse checkout is configured per worktree.
+	require.FileExists(t, filepath.Join(dir2, "other", "app.yaml"))
+	require.NoFileExists(t, filepath.Join(dir1, "other", "app.yaml"))
+
+	content, err = os.ReadFile(filepath.Join(dir1, "deploy", "myapp", "app.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, "dirty\n", string(content), "other worktrees must be left untouched")
+
+	cleanup(dir1, opts)
+	// The worktree of a run killed before the cleanup is pruned by the next run.
+	require.NoError(t, os.RemoveAll(dir2))
+	run(filepath.Join(tmp, "kargo-gitops", "myapp-3"), opts)
+	require.Len(t, strings.Split(strings.TrimSpace(runGit(t, cache, "worktree", "list")), "\n"), 2)
+	cleanup(filepath.Join(tmp, "kargo-gitops", "myapp-3"), opts)
+	require.Len(t, strings.Split(strings.TrimSpace(runGit(t, cache, "worktree", "list")), "\n"), 1)
+
+	// Without the cache, the worktree is cloned from scratch, even if a stale one exists.
+	dir := filepath.Join(tmp, "kargo-gitops", "myapp-4")
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "stale"), 0755))
+	run(dir, gitCloneOptions{branch: "main"})
+
+	require.NoDirExists(t, filepath.Join(dir, "stale"))
+	require.Equal(t, "false\n", runGit(t, dir, "rev-parse", "--is-shallow-repository"))
+	require.FileExists(t, filepath.Join(dir, "other", "app.yaml"))
+	cleanup(dir, gitCloneOptions{branch: "main"})
+}
+
+func TestGitOps_Concurrent(t *testing.T) {
+	requireGitAndFlock(t)
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	seed := initRemote(t, remote, map[string]string{"deploy/README.md": "seed\n"})
+	runGit(t, seed, "push", "origin", "HEAD:dev")
+
+	// The generated commands push to the local repository instead of GitHub.
+	repo := "https://github.com/myorg/myrepo.git"
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "url.file://"+remote+".insteadOf")
+	t.Setenv("GIT_CONFIG_VALUE_0", repo)
+	t.Setenv("GITHUB_TOKEN", "mytoken")
+
+	prOpts := PullRequestOptions{GitUserName: "kargo", GitUserEmail: "kargo@example.com"}
+	push := gitPushOptions{mode: GitOpsModeDirect, retries: 20}
+
+	for _, reuse := range []bool{false, true} {
+		reuse := reuse
+		t.Run(fmt.Sprintf("reuse=%v", reuse), func(t *testing.T) {
+			tmp := t.TempDir()
+
+			// Deployments of the same app run concurrently,
+			// each of which generates and runs the gitops commands.
+			const n = 4
+			errs := make(chan error, n+1)
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				i := i
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					g := &Generator{TempDir: tmp, ToolsCommand: []string{"kargo", "tools"}, ToolName: "kargo", ReuseClone: reuse}
+					file := fmt.Sprintf("app-%d-%v.yaml", i, reuse)
+					write := Cmd{Name: "bash", Args: NewArgs("-c", "echo v1 > "+file)}
+					cmds, err := g.gitOps(Apply, "myapp", repo, "dev", "", "deploy", nil, []Cmd{write}, true, push, prOpts)
+					if err != nil {
+						errs <- err
+						return
+					}
+					errs <- runCmds(t, cmds)
+				}()
+			}
+
+			// A failing deployment cleans up its worktree too.
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				g := &Generator{TempDir: tmp, ToolsCommand: []string{"kargo", "tools"}, ToolName: "kargo", ReuseClone: reuse}
+				cmds, err := g.gitOps(Apply, "myapp", repo, "dev", "", "deploy", nil, []Cmd{{Name: "false"}}, true, push, prOpts)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if err := runCmds(t, cmds); err == nil {
+					errs <- errors.New("expected the deployment to fail")
+					return
+				}
+				errs <- nil
+			}()
+
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				require.NoError(t, err)
+			}
+
+			runGit(t, seed, "pull", "origin", "dev")
+			for i := 0; i < n; i++ {
+				require.FileExists(t, filepath.Join(seed, "deploy", fmt.Sprintf("app-%d-%v.yaml", i, reuse)))
+			}
+
+			// No worktree is left behind.
+			entries, err := os.ReadDir(filepath.Join(tmp, "kargo-gitops"))
+			require.NoError(t, err)
+			var left []string
+			for _, e := range entries {
+				left = append(left, e.Name())
+			}
+			if reuse {
+				require.Equal(t, []string{"cache"}, left)
+				cache := gitCacheDir(tmp, "github.com", "myorg/myrepo")
+				require.Len(t, strings.Split(strings.TrimSpace(runGit(t, cache, "worktree", "list")), "\n"), 1)
+			} else {
+				require.Empty(t, left)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package kargo
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+// GitOpsMode is how gitops changes are delivered to the repository.
+type GitOpsMode string
+
+const (
+	// GitOpsModePullRequest pushes the changes to a head branch
+	// and opens a pull request against the target branch.
+	// This is the default.
+	GitOpsModePullRequest GitOpsMode = "pullrequest"
+	// GitOpsModeDirect commits and pushes the changes straight to the target branch,
+	// without a pull request.
+	GitOpsModeDirect GitOpsMode = "direct"
+)
+
+// DefaultDirectPushRetries is the number of times the direct mode
+// rebases the commit onto the target branch and retries the push,
+// when the push is rejected because someone else pushed to the branch in the meantime.
+const DefaultDirectPushRetries = 3
+
+// protectedBranches are the branches the direct mode refuses to push to,
+// unless explicitly allowed.
+var protectedBranches = map[string]bool{
+	"main":   true,
+	"master": true,
+}
+
+// gitPushOptions is how gitOps pushes the changes.
+type gitPushOptions struct {
+	// mode defaults to GitOpsModePullRequest.
+	mode GitOpsMode
+	// allowProtectedBranch allows the direct mode to push to main and master.
+	allowProtectedBranch bool
+	// retries is the maximum number of rebase-and-retry attempts in the direct mode.
+	// Defaults to DefaultDirectPushRetries.
+	retries int
+}
+
+func (o gitPushOptions) validate(branch string, goGit bool) error {
+	switch o.mode {
+	case "", GitOpsModePullRequest:
+		return nil
+	case GitOpsModeDirect:
+	default:
+		return fmt.Errorf("unsupported gitops mode: %s", o.mode)
+	}
+
+	if goGit {
+		return fmt.Errorf("gitops mode %s requires the %s git backend, as the %s backend can't rebase", GitOpsModeDirect, tools.GitBackendShell, tools.GitBackendGoGit)
+	}
+
+	if protectedBranches[branch] && !o.allowProtectedBranch {
+		return fmt.Errorf("refusing to push directly to the protected branch %s: set allowProtectedBranch to allow it", branch)
+	}
+
+	if o.retries < 0 {
+		return fmt.Errorf("push retries must not be negative, but got %d", o.retries)
+	}
+
+	return nil
+}
+
+// directPushCmd returns the command to push HEAD to the branch of the remote.
+//
+// When the push is rejected as non-fast-forward, it fetches the branch,
+// rebases HEAD onto it, and retries the push up to retries times.
+// The rebased commits are signed again when signing is enabled.
+// Nothing is pushed when HEAD has no changes from the branch.
+// lock is the shell statement to run first, if any, to lock the repository.
+func directPushCmd(remote, branch string, retries int, signing tools.GitSigning, lock *Args) Cmd {
+	if retries == 0 {
+		retries = DefaultDirectPushRetries
+	}
+
+	upstream := remote + "/" + branch
+
+	rebase := NewArgs("git", signing.ShellArgs(), "rebase")
+	if signing.Enabled() {
+		rebase = rebase.AppendStrings("--gpg-sign")
+	}
+	rebase = rebase.AppendStrings(upstream)
+
+	giveUp := fmt.Sprintf("giving up pushing to %s after %d retries", branch, retries)
+
+	script := NewArgs(
+		lock,
+		"git", "diff", "--quiet", upstream, "HEAD", ShellRaw("||"), ShellRaw("{"),
+		ShellRaw("n=0;"),
+		ShellRaw("until"), "git", "push", remote, "HEAD:"+branch, ShellRaw(";"), ShellRaw("do"),
+		ShellRaw("n=$((n+1));"),
+		ShellRaw("if"), ShellRaw(`[ "$n" -gt`), strconv.Itoa(retries), ShellRaw("];"), ShellRaw("then"),
+		"echo", giveUp, ShellRaw(">&2;"), ShellRaw("exit 1;"),
+		ShellRaw("fi;"),
+		"git", "fetch", remote, branch, ShellRaw("&&"), rebase, ShellRaw("||"), ShellRaw("exit 1;"),
+		ShellRaw("done;"),
+		ShellRaw("}"),
+	)
+
+	return newBashCmd(script)
+}
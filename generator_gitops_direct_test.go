@@ -0,0 +1,149 @@
+package kargo
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo/tools"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitOps_Direct(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "mytoken")
+
+	g := &Generator{
+		TempDir:      "/tmp",
+		ToolsCommand: []string{"kargo", "tools"},
+		ToolName:     "kargo",
+		WorktreeID:   "test",
+	}
+
+	push := gitPushOptions{mode: GitOpsModeDirect, retries: 2}
+
+	cmds, err := g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "dev", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.NoError(t, err)
+
+	var got [][]string
+	for _, c := range cmds {
+		got = append(got, append([]string{c.Name}, c.Args.MustCollect(nil)...))
+	}
+
+	require.Equal(t, []string{"git", "checkout", "-B", "dev", "origin/dev"}, got[2])
+	require.Equal(t, []string{
+		"bash", "-vxc",
+		`git diff --quiet origin/dev HEAD || { n=0; until git push origin HEAD:dev ; do n=$((n+1)); if [ "$n" -gt 2 ]; then echo 'giving up pushing to dev after 2 retries' >&2; exit 1; fi; git fetch origin dev && git rebase origin/dev || exit 1; done; }`,
+	}, got[len(got)-2])
+
+	env, err := cmds[len(cmds)-2].Env(nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{envGitToken: "mytoken"}, env)
+
+	cmds, err = g.gitOps(Plan, "myapp", "https://github.com/myorg/myrepo.git", "dev", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.NoError(t, err)
+	last := cmds[len(cmds)-2]
+	require.Equal(t, []string{"diff", "--stat", "origin/dev", "HEAD"}, last.Args.MustCollect(nil))
+
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.EqualError(t, err, "unable to generate gitops commands: refusing to push directly to the protected branch main: set allowProtectedBranch to allow it")
+
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "master", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.EqualError(t, err, "unable to generate gitops commands: refusing to push directly to the protected branch master: set allowProtectedBranch to allow it")
+
+	push.allowProtectedBranch = true
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.NoError(t, err)
+
+	g.GitBackend = tools.GitBackendGoGit
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "dev", "", "deploy", nil, nil, true, push, PullRequestOptions{})
+	require.EqualError(t, err, "unable to generate gitops commands: gitops mode direct requires the shell git backend, as the go-git backend can't rebase")
+
+	g.GitBackend = ""
+	_, err = g.gitOps(Apply, "myapp", "https://github.com/myorg/myrepo.git", "dev", "", "deploy", nil, nil, true, gitPushOptions{mode: "merge"}, PullRequestOptions{})
+	require.EqualError(t, err, "unable to generate gitops commands: unsupported gitops mode: merge")
+}
+
+// runGit runs git with args in dir, failing the test on errors.
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	c := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+	c.Dir = dir
+	out, err := c.CombinedOutput()
+	require.NoError(t, err, "git %s: %s", strings.Join(args, " "), out)
+	return string(out)
+}
+
+// runCmd runs the generated command, returning the error if any.
+func runCmd(t *testing.T, c Cmd) (string, error) {
+	t.Helper()
+
+	args, err := c.Args.Collect(nil)
+	require.NoError(t, err)
+
+	env, err := c.Env(nil)
+	require.NoError(t, err)
+
+	x := exec.Command(c.Name, args...)
+	x.Dir = c.Dir
+	x.Env = os.Environ()
+	for k, v := range env {
+		x.Env = append(x.Env, k+"="+v)
+	}
+	out, err := x.CombinedOutput()
+	return string(out), err
+}
+
+func TestDirectPushCmd_RebaseAndRetry(t *testing.T) {
+	for _, bin := range []string{"git", "bash"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	runGit(t, "", "init", "--bare", "--initial-branch", "dev", remote)
+
+	seed := t.TempDir()
+	runGit(t, seed, "clone", remote, ".")
+	require.NoError(t, os.WriteFile(filepath.Join(seed, "README.md"), []byte("seed\n"), 0644))
+	runGit(t, seed, "add", ".")
+	runGit(t, seed, "commit", "-m", "seed")
+	runGit(t, seed, "push", "origin", "HEAD:dev")
+
+	work := t.TempDir()
+	runGit(t, work, "clone", remote, ".")
+	runGit(t, work, "config", "user.name", "kargo")
+	runGit(t, work, "config", "user.email", "kargo@example.com")
+	require.NoError(t, os.WriteFile(filepath.Join(work, "app.yaml"), []byte("image: myapp:v2\n"), 0644))
+	runGit(t, work, "add", ".")
+	runGit(t, work, "commit", "-m", "deploy myapp")
+
+	// Someone else pushes to the branch after our clone.
+	require.NoError(t, os.WriteFile(filepath.Join(seed, "other.yaml"), []byte("image: other:v1\n"), 0644))
+	runGit(t, seed, "add", ".")
+	runGit(t, seed, "commit", "-m", "deploy other")
+	runGit(t, seed, "push", "origin", "HEAD:dev")
+
+	push := directPushCmd("origin", "dev", 1, tools.GitSigning{}, nil)
+	push.Dir = work
+	out, err := runCmd(t, push)
+	require.NoError(t, err, out)
+
+	log := runGit(t, work, "log", "--format=%s", "origin/dev")
+	require.Equal(t, "deploy myapp\ndeploy other\nseed\n", log)
+
+	// A conflicting change makes the rebase fail instead of retrying forever.
+	require.NoError(t, os.WriteFile(filepath.Join(work, "other.yaml"), []byte("image: other:v2\n"), 0644))
+	runGit(t, work, "commit", "-am", "deploy other v2")
+	require.NoError(t, os.WriteFile(filepath.Join(seed, "other.yaml"), []byte("image: other:v3\n"), 0644))
+	runGit(t, seed, "commit", "-am", "deploy other v3")
+	runGit(t, seed, "pull", "--rebase", "origin", "dev")
+	runGit(t, seed, "push", "origin", "HEAD:dev")
+
+	out, err = runCmd(t, push)
+	require.Error(t, err, out)
+	require.Contains(t, out, "CONFLICT")
+}
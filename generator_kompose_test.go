@@ -65,7 +65,7 @@ func TestGenerate_Kompose(t *testing.T) {
 				Name: "bash",
 				Args: []string{
 					"-c",
-					"kompose convert --stdout -f docker-compose.yml | kubectl apply --server-side -f - && stern -l kompose.io.service!=",
+					"kompose convert --stdout -f docker-compose.yml | kubectl apply --server-side -f - && stern -l 'kompose.io.service!='",
 				},
 				Dir: "testdata/compose",
 			},
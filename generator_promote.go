@@ -0,0 +1,62 @@
+package kargo
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+// promoteCmd returns the command to promote the images and the chart versions
+// from the source environment to the kustomization of the target environment.
+// It runs in the kustomization directory of the target environment in the gitops worktree.
+func (g *Generator) promoteCmd(k *Kustomize) (Cmd, error) {
+	p := k.Promotion
+
+	if len(g.ToolsCommand) == 0 {
+		return Cmd{}, errors.New("ToolsCommand is required to promote images")
+	}
+
+	if k.Git.Repo == "" {
+		return Cmd{}, errors.New("kustomize.git.repo is required for kustomize.promotion")
+	}
+
+	if (p.FromPath == "") == (p.FromArgoCDApp == "") {
+		return Cmd{}, errors.New("either kustomize.promotion.fromPath or fromArgoCDApp must be set")
+	}
+
+	var args []string
+	args = append(args, g.ToolsCommand[1:]...)
+	args = append(args, tools.CommandPromote)
+
+	if p.FromPath != "" {
+		baseBranch := "main"
+		if k.Git.Branch != "" {
+			baseBranch = k.Git.Branch
+		}
+		args = append(args,
+			"--"+tools.FlagPromoteFromKustomization, p.FromPath,
+			"--"+tools.FlagPromoteFromRef, "origin/"+baseBranch,
+		)
+	} else {
+		args = append(args, "--"+tools.FlagPromoteFromArgoCDApp, p.FromArgoCDApp)
+	}
+
+	if len(p.Policy.Images) > 0 {
+		args = append(args, "--"+tools.FlagPromoteImages, strings.Join(p.Policy.Images, ","))
+	}
+	if p.Policy.TagPattern != "" {
+		args = append(args, "--"+tools.FlagPromoteTagPattern, p.Policy.TagPattern)
+	}
+	if p.Policy.RequireDigest {
+		args = append(args, "--"+tools.FlagPromoteRequireDigest, "true")
+	}
+	if len(p.Policy.Charts) > 0 {
+		args = append(args, "--"+tools.FlagPromoteCharts, strings.Join(p.Policy.Charts, ","))
+	}
+
+	return Cmd{
+		Name: g.ToolsCommand[0],
+		Args: NewArgs(args),
+	}, nil
+}
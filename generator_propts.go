@@ -1,7 +1,9 @@
 package kargo
 
 import (
+	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -16,10 +18,35 @@ import (
 // - <tool name>_PULLREQUEST_ASSIGNEE_IDS
 // - <tool name>_GIT_USER_NAME
 // - <tool name>_GIT_USER_EMAIL
+// - <tool name>_GIT_COMMIT_MESSAGE_TEMPLATE
+// - <tool name>_GIT_COMMIT_TRAILERS
+// - <tool name>_GIT_CO_AUTHORS
+// - <tool name>_GIT_SIGNING_FORMAT
+// - <tool name>_GIT_SIGNING_KEY
+// - <tool name>_PULLREQUEST_LABELS
+// - <tool name>_PULLREQUEST_REVIEWERS
+// - <tool name>_PULLREQUEST_TEAM_REVIEWERS
+// - <tool name>_PULLREQUEST_DRAFT
+// - <tool name>_PULLREQUEST_MILESTONE
+// - <tool name>_PULLREQUEST_TITLE_TEMPLATE
+// - <tool name>_PULLREQUEST_BODY_TEMPLATE
+// - <tool name>_PULLREQUEST_MERGE
+// - <tool name>_PULLREQUEST_MERGE_METHOD
+// - <tool name>_PULLREQUEST_MERGE_TIMEOUT
+// - <tool name>_PULLREQUEST_PROVIDER
+// - <tool name>_PULLREQUEST_API_URL
+// - <tool name>_GITHUB_APP_ID
+// - <tool name>_GITHUB_APP_INSTALLATION_ID
+// - <tool name>_GITHUB_APP_PRIVATE_KEY
 //
 // The value of <tool name>_PULLREQUEST_ASSIGNEE_IDS is a comma-separated list of GitHub user IDs.
 // Each ID can be either an integer or a string.
-func (g *Generator) prOptsFromEnv() PullRequestOptions {
+// The labels, reviewers and team reviewers are comma-separated lists too.
+// The commit trailers and co-authors are newline-separated lists,
+// as each of them may contain commas.
+// It fails if <tool name>_PULLREQUEST_DRAFT isn't a boolean,
+// or the GitHub App IDs aren't integers.
+func (g *Generator) prOptsFromEnv() (PullRequestOptions, error) {
 	var opts PullRequestOptions
 	env := strings.ToUpper(g.ToolName) + "_PULLREQUEST_ASSIGNEE_IDS"
 	if v := os.Getenv(env); v != "" {
@@ -36,11 +63,154 @@ func (g *Generator) prOptsFromEnv() PullRequestOptions {
 		opts.GitUserEmail = v
 	}
 
+	env = strings.ToUpper(g.ToolName) + "_GIT_COMMIT_MESSAGE_TEMPLATE"
+	if v := os.Getenv(env); v != "" {
+		opts.CommitMessageTemplate = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GIT_COMMIT_TRAILERS"
+	if v := os.Getenv(env); v != "" {
+		opts.CommitTrailers = splitLines(v)
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GIT_CO_AUTHORS"
+	if v := os.Getenv(env); v != "" {
+		opts.CoAuthors = splitLines(v)
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GIT_SIGNING_FORMAT"
+	if v := os.Getenv(env); v != "" {
+		opts.GitSigningFormat = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GIT_SIGNING_KEY"
+	if v := os.Getenv(env); v != "" {
+		opts.GitSigningKey = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_LABELS"
+	if v := os.Getenv(env); v != "" {
+		opts.Labels = strings.Split(v, ",")
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_REVIEWERS"
+	if v := os.Getenv(env); v != "" {
+		opts.Reviewers = strings.Split(v, ",")
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_TEAM_REVIEWERS"
+	if v := os.Getenv(env); v != "" {
+		opts.TeamReviewers = strings.Split(v, ",")
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_DRAFT"
+	if v := os.Getenv(env); v != "" {
+		draft, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", env, err)
+		}
+		opts.Draft = draft
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_MILESTONE"
+	if v := os.Getenv(env); v != "" {
+		opts.Milestone = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_TITLE_TEMPLATE"
+	if v := os.Getenv(env); v != "" {
+		opts.TitleTemplate = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_BODY_TEMPLATE"
+	if v := os.Getenv(env); v != "" {
+		opts.BodyTemplate = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_MERGE"
+	if v := os.Getenv(env); v != "" {
+		opts.Merge = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_MERGE_METHOD"
+	if v := os.Getenv(env); v != "" {
+		opts.MergeMethod = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_MERGE_TIMEOUT"
+	if v := os.Getenv(env); v != "" {
+		opts.MergeTimeout = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_PROVIDER"
+	if v := os.Getenv(env); v != "" {
+		opts.Provider = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_PULLREQUEST_API_URL"
+	if v := os.Getenv(env); v != "" {
+		opts.APIURL = v
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GITHUB_APP_ID"
+	if v := os.Getenv(env); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", env, err)
+		}
+		opts.GitHubAppID = id
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GITHUB_APP_INSTALLATION_ID"
+	if v := os.Getenv(env); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", env, err)
+		}
+		opts.GitHubAppInstallationID = id
+	}
+
+	env = strings.ToUpper(g.ToolName) + "_GITHUB_APP_PRIVATE_KEY"
+	if v := os.Getenv(env); v != "" {
+		opts.GitHubAppPrivateKey = v
+	}
+
 	if opts.OutputFile == "" {
 		opts.OutputFile = g.PullRequestOutputFile
 	}
 
-	return opts
+	return opts, nil
+}
+
+// prOpts returns the pull request options for the config c.
+// In addition to the options from the environment variables,
+// it sets the images and the chart version for the title and the body templates.
+func (g *Generator) prOpts(c *Config) (PullRequestOptions, error) {
+	opts, err := g.prOptsFromEnv()
+	if err != nil {
+		return opts, err
+	}
+
+	if c.Kustomize != nil {
+		opts.Images = c.Kustomize.Images
+	}
+
+	if c.Helm != nil {
+		opts.ChartVersion = c.Helm.Version
+	}
+
+	return opts, nil
+}
+
+// splitLines splits s into non-empty lines.
+func splitLines(s string) []string {
+	var lines []string
+	for _, l := range strings.Split(s, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines
 }
 
 func (g *Generator) prHeadFromEnv() string {
@@ -0,0 +1,425 @@
+package kargo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// Manifest is the multi-component manifest that deploys the components as a Graph.
+type Manifest struct {
+	Components []*Config `yaml:"components"`
+}
+
+// Graph is the set of the components to be deployed together,
+// where each component is a Config named uniquely within the graph.
+//
+// A component depends on another when any of its *From fields refers to
+// an output of the other as <component name>.<output name>,
+// like argocd.serverFrom: cluster.k8s_endpoint.
+// The other keys are resolved via Generator.GetValue as usual.
+type Graph struct {
+	components map[string]*Config
+	// deps maps the components to the sorted names of the components they depend on.
+	deps map[string][]string
+	// order is the deterministic topological order of the components.
+	order []string
+}
+
+// NewGraph builds the dependency graph of the components,
+// and fails if they have a dependency cycle.
+func NewGraph(components []*Config) (*Graph, error) {
+	g := &Graph{
+		components: map[string]*Config{},
+		deps:       map[string][]string{},
+	}
+
+	var names []string
+	for _, c := range components {
+		if c.Name == "" {
+			return nil, errors.New("component name is required")
+		}
+		if strings.Contains(c.Name, ".") {
+			return nil, fmt.Errorf("component name %q must not contain dots", c.Name)
+		}
+		if _, ok := g.components[c.Name]; ok {
+			return nil, fmt.Errorf("duplicate component %q", c.Name)
+		}
+
+		outputs := map[string]bool{}
+		for _, o := range c.Outputs {
+			if o.Name == "" {
+				return nil, fmt.Errorf("component %s: output name is required", c.Name)
+			}
+			if outputs[o.Name] {
+				return nil, fmt.Errorf("component %s: duplicate output %q", c.Name, o.Name)
+			}
+			outputs[o.Name] = true
+		}
+
+		g.components[c.Name] = c
+		names = append(names, c.Name)
+	}
+
+	for _, c := range components {
+		deps := map[string]bool{}
+		for _, r := range ValueRefs(c) {
+			dep, output, ok := g.outputRef(r.Key)
+			if !ok {
+				continue
+			}
+			if !g.hasOutput(dep, output) {
+				return nil, fmt.Errorf("component %s: %s refers to %s, which isn't an output of %s", c.Name, r.Field, r.Key, dep)
+			}
+			deps[dep] = true
+		}
+
+		for d := range deps {
+			g.deps[c.Name] = append(g.deps[c.Name], d)
+		}
+		sort.Strings(g.deps[c.Name])
+	}
+
+	order, err := g.topologicalOrder(names)
+	if err != nil {
+		return nil, err
+	}
+	g.order = order
+
+	return g, nil
+}
+
+// outputRef returns the component and the output name if the key refers to an output of a component.
+func (g *Graph) outputRef(key string) (string, string, bool) {
+	if strings.Contains(key, "://") {
+		return "", "", false
+	}
+
+	comp, output, ok := strings.Cut(key, ".")
+	if !ok {
+		return "", "", false
+	}
+
+	if _, ok := g.components[comp]; !ok {
+		return "", "", false
+	}
+
+	return comp, output, true
+}
+
+func (g *Graph) hasOutput(comp, output string) bool {
+	for _, o := range g.components[comp].Outputs {
+		if o.Name == output {
+			return true
+		}
+	}
+	return false
+}
+
+// topologicalOrder returns the components sorted so that every component comes after its dependencies.
+// Components that don't depend on each other keep the given order.
+func (g *Graph) topologicalOrder(names []string) ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	state := map[string]int{}
+	var (
+		order []string
+		path  []string
+		visit func(string) error
+	)
+
+	visit = func(n string) error {
+		switch state[n] {
+		case visited:
+			return nil
+		case visiting:
+			i := 0
+			for path[i] != n {
+				i++
+			}
+			return fmt.Errorf("dependency cycle: %s -> %s", strings.Join(path[i:], " -> "), n)
+		}
+
+		state[n] = visiting
+		path = append(path, n)
+		for _, d := range g.deps[n] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[n] = visited
+		order = append(order, n)
+
+		return nil
+	}
+
+	for _, n := range names {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// Order returns the names of the components in the order they can be deployed one by one.
+func (g *Graph) Order() []string {
+	return append([]string{}, g.order...)
+}
+
+// Dependencies returns the names of the components that the component depends on.
+func (g *Graph) Dependencies(name string) []string {
+	return append([]string{}, g.deps[name]...)
+}
+
+// RunGraphOptions is the options to plan or apply a Graph.
+type RunGraphOptions struct {
+	// Generator generates the commands of each component.
+	// Its GetValue resolves the keys that don't refer to outputs of the components.
+	Generator *Generator
+	// Target is one of Plan, Apply, PlanDestroy and Destroy.
+	Target Target
+	// Runner runs the commands of each component. Defaults to ExecRunner.
+	Runner Runner
+	// MaxParallel is the maximum number of the components deployed at once.
+	// Unlimited if zero.
+	MaxParallel int
+	// Stdout is where the notes like the components skipped on plan are written to.
+	// Defaults to os.Stdout.
+	Stdout io.Writer
+}
+
+// Run plans or applies the components in the topological order,
+// deploying the components that don't depend on each other in parallel.
+// It returns the outputs of the deployed components, keyed by the component and the output names.
+// On plan, the outputs captured after the deployment are set only if they can be captured from the current state.
+// The components referring to the outputs that aren't set are skipped with a note that they're known after apply,
+// and so are the components depending on them.
+//
+// On Destroy and PlanDestroy, the components are destroyed in the reverse order,
+// so that no component is destroyed before the ones depending on it.
+// The outputs of the components aren't captured then, and the references to them
+// are resolved via Generator.GetValue, e.g. from the outputs file of the last apply.
+//
+// No component is started after a failure, while the running ones are run to completion,
+// so that the returned error lists all the failed components.
+func (g *Graph) Run(ctx context.Context, opts RunGraphOptions) (Outputs, error) {
+	if opts.Generator == nil {
+		return nil, errors.New("Generator is required to run the graph")
+	}
+
+	runner := opts.Runner
+	if runner == nil {
+		runner = &ExecRunner{}
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+
+	var mu sync.Mutex
+	outputs := Outputs{}
+
+	destroying := opts.Target.destroying()
+
+	get := func(key string) (string, error) {
+		if comp, output, ok := g.outputRef(key); ok && !destroying {
+			mu.Lock()
+			defer mu.Unlock()
+			if _, ok := outputs[comp]; !ok {
+				return "", fmt.Errorf("unable to get %s: component %s has not been deployed", key, comp)
+			}
+			v, ok := outputs.Lookup(key)
+			if !ok {
+				return "", fmt.Errorf("unable to get %s: component %s has no output %s until it's applied", key, comp, output)
+			}
+			return v, nil
+		}
+
+		if opts.Generator.GetValue == nil {
+			return "", fmt.Errorf("unable to get %s: GetValue is not set", key)
+		}
+		return opts.Generator.GetValue(key)
+	}
+
+	type result struct {
+		name    string
+		outputs map[string]string
+		err     error
+	}
+
+	results := make(chan result)
+
+	order := g.order
+	// waits maps the components to the ones that must be done before them,
+	// and next maps the components to the ones waiting for them.
+	waits := g.deps
+	next := map[string][]string{}
+	for _, n := range g.order {
+		for _, d := range g.deps[n] {
+			next[d] = append(next[d], n)
+		}
+	}
+	if destroying {
+		order = make([]string, len(g.order))
+		for i, n := range g.order {
+			order[len(g.order)-1-i] = n
+		}
+		waits, next = next, waits
+	}
+
+	pending := map[string]int{}
+	for _, n := range order {
+		pending[n] = len(waits[n])
+	}
+
+	var ready []string
+	for _, n := range order {
+		if pending[n] == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	done := func(n string) {
+		for _, m := range next[n] {
+			pending[m]--
+			if pending[m] == 0 {
+				ready = append(ready, m)
+			}
+		}
+	}
+
+	var (
+		errs    error
+		running int
+	)
+	for {
+		for len(ready) > 0 && errs == nil && (opts.MaxParallel <= 0 || running < opts.MaxParallel) {
+			n := ready[0]
+			ready = ready[1:]
+
+			if opts.Target == Plan {
+				mu.Lock()
+				unknown := g.unknownOutputRefs(n, outputs)
+				mu.Unlock()
+				if len(unknown) > 0 {
+					fmt.Fprintf(stdout, "component %s is skipped on plan: %s is known after apply\n", n, strings.Join(unknown, ", "))
+					done(n)
+					continue
+				}
+			}
+
+			running++
+
+			go func() {
+				out, err := g.runComponent(ctx, g.components[n], opts, runner, get)
+				results <- result{name: n, outputs: out, err: err}
+			}()
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+
+		if r.err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("component %s: %w", r.name, r.err))
+			continue
+		}
+
+		if !destroying {
+			mu.Lock()
+			outputs[r.name] = r.outputs
+			mu.Unlock()
+		}
+
+		done(r.name)
+	}
+
+	if errs != nil {
+		return outputs, errs
+	}
+
+	return outputs, nil
+}
+
+// unknownOutputRefs returns the references of the component to the outputs of the other components
+// that aren't set, like the ones captured after the deployment on plan before the first apply.
+func (g *Graph) unknownOutputRefs(name string, outputs Outputs) []string {
+	var unknown []string
+	seen := map[string]bool{}
+	for _, r := range ValueRefs(g.components[name]) {
+		if _, _, ok := g.outputRef(r.Key); !ok || seen[r.Key] {
+			continue
+		}
+		seen[r.Key] = true
+		if _, ok := outputs.Lookup(r.Key); !ok {
+			unknown = append(unknown, r.Key)
+		}
+	}
+	return unknown
+}
+
+// runComponent runs the commands of the component for the target, and resolves its outputs.
+// No outputs are resolved on Destroy and PlanDestroy.
+func (g *Graph) runComponent(ctx context.Context, c *Config, opts RunGraphOptions, runner Runner, get GetValue) (map[string]string, error) {
+	gen := *opts.Generator
+	gen.GetValue = get
+	if gen.TempDir != "" {
+		// The components never share the temporary files like the kustomize build output.
+		gen.TempDir = filepath.Join(gen.TempDir, "components", c.Name)
+		if err := os.MkdirAll(gen.TempDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	cmds, err := gen.ExecCmds(c, opts.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	captured, err := runner.Run(ctx, cmds, get)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Target.destroying() {
+		return nil, nil
+	}
+
+	outputs := map[string]string{}
+	for _, o := range c.Outputs {
+		if o.captured() {
+			if v, ok := captured[outputCmdID][o.Name]; ok {
+				outputs[o.Name] = v
+			}
+			continue
+		}
+
+		v := o.Value
+		if o.ValueFrom != "" {
+			v, err = get(o.ValueFrom)
+			if err != nil {
+				return nil, fmt.Errorf("output %s: %w", o.Name, err)
+			}
+		}
+		outputs[o.Name] = v
+	}
+
+	return outputs, nil
+}
@@ -0,0 +1,268 @@
+package kargo_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingRunner records the collected args of the commands per component,
+// which is identified by the kustomize directory.
+type recordingRunner struct {
+	mu   sync.Mutex
+	cmds map[string][][]string
+	// barrier, if set, blocks the components in parallel until all of them are running at once.
+	barrier  *sync.WaitGroup
+	parallel map[string]bool
+	fail     string
+	// captured is returned as the outputs captured by the commands per component.
+	captured map[string]kargo.Outputs
+}
+
+func (r *recordingRunner) Run(ctx context.Context, cmds []kargo.Cmd, get kargo.GetValue) (kargo.Outputs, error) {
+	if r.parallel[cmds[0].Dir] {
+		r.barrier.Done()
+		done := make(chan struct{})
+		go func() {
+			r.barrier.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			return nil, errors.New("timed out waiting for the other components")
+		}
+	}
+
+	for _, c := range cmds {
+		args, err := c.Args.Collect(get)
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		if r.cmds == nil {
+			r.cmds = map[string][][]string{}
+		}
+		r.cmds[c.Dir] = append(r.cmds[c.Dir], append([]string{c.Name}, args...))
+		r.mu.Unlock()
+
+		if r.fail != "" && c.Dir == r.fail {
+			return nil, errors.New("failed")
+		}
+	}
+
+	return r.captured[cmds[0].Dir], nil
+}
+
+func kustomizeComponent(name string, img kargo.KustomizeImage, outputs ...kargo.Output) *kargo.Config {
+	return &kargo.Config{
+		Name:      name,
+		Path:      name,
+		Kustomize: &kargo.Kustomize{Images: kargo.KustomizeImages{img}},
+		Outputs:   outputs,
+	}
+}
+
+func TestGraph(t *testing.T) {
+	components := []*kargo.Config{
+		kustomizeComponent("app", kargo.KustomizeImage{Name: "app", NewTagFrom: "build.tag"},
+			kargo.Output{Name: "endpoint", ValueFrom: "cluster.endpoint"},
+		),
+		kustomizeComponent("build", kargo.KustomizeImage{Name: "builder", NewTagFrom: "ci.tag"},
+			kargo.Output{Name: "tag", ValueFrom: "ci.tag"},
+		),
+		kustomizeComponent("cluster", kargo.KustomizeImage{Name: "cluster", NewTag: "v1"},
+			kargo.Output{Name: "endpoint", Value: "https://k8s.example.com"},
+		),
+	}
+
+	g, err := kargo.NewGraph(components)
+	require.NoError(t, err)
+	require.Equal(t, []string{"build", "cluster", "app"}, g.Order())
+	require.Equal(t, []string{"build", "cluster"}, g.Dependencies("app"))
+
+	// build and cluster are deployed at once.
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	r := &recordingRunner{barrier: &barrier, parallel: map[string]bool{"build": true, "cluster": true}}
+
+	gen := &kargo.Generator{
+		TempDir:    t.TempDir(),
+		WorktreeID: "test",
+		GetValue: func(key string) (string, error) {
+			if key == "ci.tag" {
+				return "v2", nil
+			}
+			return "", errors.New("unexpected key " + key)
+		},
+	}
+
+	outputs, err := g.Run(context.Background(), kargo.RunGraphOptions{Generator: gen, Target: kargo.Apply, Runner: r})
+	require.NoError(t, err)
+	require.Equal(t, kargo.Outputs{
+		"app":     {"endpoint": "https://k8s.example.com"},
+		"build":   {"tag": "v2"},
+		"cluster": {"endpoint": "https://k8s.example.com"},
+	}, outputs)
+
+	require.Equal(t, []string{"kustomize", "edit", "set", "image", "app:v2"}, r.cmds["app"][0])
+	require.Equal(t, []string{"kustomize", "edit", "set", "image", "builder:v2"}, r.cmds["build"][0])
+
+	// Each component has its own temporary directory.
+	var built []string
+	for _, c := range r.cmds[""] {
+		if c[0] == "kustomize" {
+			built = append(built, c[2])
+		}
+	}
+	require.ElementsMatch(t, []string{
+		"--output=" + gen.TempDir + "/components/app/kustomize-built-app-test.yaml",
+		"--output=" + gen.TempDir + "/components/build/kustomize-built-build-test.yaml",
+		"--output=" + gen.TempDir + "/components/cluster/kustomize-built-cluster-test.yaml",
+	}, built)
+}
+
+func TestGraph_MaxParallelAndFailure(t *testing.T) {
+	components := []*kargo.Config{
+		kustomizeComponent("a", kargo.KustomizeImage{Name: "a", NewTag: "v1"}, kargo.Output{Name: "x", Value: "1"}),
+		kustomizeComponent("b", kargo.KustomizeImage{Name: "b", NewTag: "v1"}),
+		kustomizeComponent("c", kargo.KustomizeImage{Name: "c", NewTagFrom: "a.x"}),
+	}
+
+	g, err := kargo.NewGraph(components)
+	require.NoError(t, err)
+
+	r := &recordingRunner{fail: "a"}
+	outputs, err := g.Run(context.Background(), kargo.RunGraphOptions{Generator: &kargo.Generator{TempDir: t.TempDir()}, Target: kargo.Plan, Runner: r, MaxParallel: 1})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "component a: failed")
+	require.Empty(t, outputs)
+
+	// Neither the dependent nor the component waiting for its turn are deployed after the failure.
+	require.NotContains(t, r.cmds, "b")
+	require.NotContains(t, r.cmds, "c")
+}
+
+func TestGraph_CapturedOutputs(t *testing.T) {
+	components := []*kargo.Config{
+		kustomizeComponent("db", kargo.KustomizeImage{Name: "db", NewTag: "v1"},
+			kargo.Output{Name: "host", Kubectl: &kargo.KubectlOutput{Resource: "service/db", Namespace: "data"}, JSONPath: "{.spec.clusterIP}"},
+		),
+		kustomizeComponent("app", kargo.KustomizeImage{Name: "app", NewTagFrom: "db.host"},
+			kargo.Output{Name: "version", Value: "v1"},
+		),
+		kustomizeComponent("web", kargo.KustomizeImage{Name: "web", NewTagFrom: "app.version"}),
+	}
+
+	g, err := kargo.NewGraph(components)
+	require.NoError(t, err)
+
+	r := &recordingRunner{captured: map[string]kargo.Outputs{
+		"db": {"outputs": {"host": "10.0.0.1"}},
+	}}
+	outputs, err := g.Run(context.Background(), kargo.RunGraphOptions{Generator: &kargo.Generator{TempDir: t.TempDir()}, Target: kargo.Apply, Runner: r})
+	require.NoError(t, err)
+	require.Equal(t, kargo.Outputs{"db": {"host": "10.0.0.1"}, "app": {"version": "v1"}, "web": {}}, outputs)
+
+	require.Contains(t, r.cmds[""], []string{"kubectl", "get", "service/db", "--namespace", "data", "--output", "json"})
+	require.Equal(t, []string{"kustomize", "edit", "set", "image", "app:10.0.0.1"}, r.cmds["app"][0])
+
+	// The output isn't captured on plan before the first apply,
+	// and so the dependent and the ones depending on it are skipped.
+	r = &recordingRunner{}
+	var stdout bytes.Buffer
+	outputs, err = g.Run(context.Background(), kargo.RunGraphOptions{Generator: &kargo.Generator{TempDir: t.TempDir()}, Target: kargo.Plan, Runner: r, Stdout: &stdout})
+	require.NoError(t, err)
+	require.Equal(t, kargo.Outputs{"db": {}}, outputs)
+	require.Empty(t, r.cmds["app"])
+	require.Empty(t, r.cmds["web"])
+	require.Equal(t, "component app is skipped on plan: db.host is known after apply\n"+
+		"component web is skipped on plan: app.version is known after apply\n", stdout.String())
+}
+
+func TestGraph_Destroy(t *testing.T) {
+	components := []*kargo.Config{
+		kustomizeComponent("app", kargo.KustomizeImage{Name: "app", NewTagFrom: "db.host"}),
+		kustomizeComponent("db", kargo.KustomizeImage{Name: "db", NewTag: "v1"},
+			kargo.Output{Name: "host", Kubectl: &kargo.KubectlOutput{Resource: "service/db"}, JSONPath: "{.spec.clusterIP}"},
+		),
+		kustomizeComponent("cache", kargo.KustomizeImage{Name: "cache", NewTag: "v1"}),
+	}
+
+	g, err := kargo.NewGraph(components)
+	require.NoError(t, err)
+	require.Equal(t, []string{"db", "app", "cache"}, g.Order())
+
+	gen := &kargo.Generator{TempDir: t.TempDir(), WorktreeID: "test"}
+	r := &recordingRunner{}
+	outputs, err := g.Run(context.Background(), kargo.RunGraphOptions{Generator: gen, Target: kargo.Destroy, Runner: r, MaxParallel: 1})
+	require.NoError(t, err)
+	require.Empty(t, outputs)
+
+	// The dependent is destroyed before its dependency, and no outputs are captured.
+	var deleted []string
+	for _, c := range r.cmds[""] {
+		require.NotEqual(t, "get", c[1])
+		if c[0] == "kubectl" {
+			deleted = append(deleted, c[3])
+		}
+	}
+	require.Equal(t, []string{
+		gen.TempDir + "/components/cache/kustomize-built-cache-test.yaml",
+		gen.TempDir + "/components/app/kustomize-built-app-test.yaml",
+		gen.TempDir + "/components/db/kustomize-built-db-test.yaml",
+	}, deleted)
+}
+
+func TestNewGraph_Errors(t *testing.T) {
+	out := func(name string) kargo.Output { return kargo.Output{Name: name, Value: "v"} }
+
+	testcases := []struct {
+		components []*kargo.Config
+		err        string
+	}{
+		{
+			[]*kargo.Config{
+				kustomizeComponent("a", kargo.KustomizeImage{Name: "a", NewTagFrom: "c.x"}, out("x")),
+				kustomizeComponent("b", kargo.KustomizeImage{Name: "b", NewTagFrom: "a.x"}, out("x")),
+				kustomizeComponent("c", kargo.KustomizeImage{Name: "c", NewTagFrom: "b.x"}, out("x")),
+			},
+			"dependency cycle: a -> c -> b -> a",
+		},
+		{
+			[]*kargo.Config{kustomizeComponent("a", kargo.KustomizeImage{Name: "a", NewTagFrom: "a.x"}, out("x"))},
+			"dependency cycle: a -> a",
+		},
+		{
+			[]*kargo.Config{
+				kustomizeComponent("a", kargo.KustomizeImage{Name: "a", NewTag: "v1"}),
+				kustomizeComponent("b", kargo.KustomizeImage{Name: "b", NewTagFrom: "a.tag"}),
+			},
+			"component b: kustomize.images[0].newTagFrom refers to a.tag, which isn't an output of a",
+		},
+		{
+			[]*kargo.Config{kustomizeComponent("a", kargo.KustomizeImage{}), kustomizeComponent("a", kargo.KustomizeImage{})},
+			`duplicate component "a"`,
+		},
+		{
+			[]*kargo.Config{kustomizeComponent("a.b", kargo.KustomizeImage{})},
+			`component name "a.b" must not contain dots`,
+		},
+		{
+			[]*kargo.Config{kustomizeComponent("a", kargo.KustomizeImage{}, out("x"), out("x"))},
+			`component a: duplicate output "x"`,
+		},
+	}
+
+	for _, tc := range testcases {
+		_, err := kargo.NewGraph(tc.components)
+		require.EqualError(t, err, tc.err)
+	}
+}
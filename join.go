@@ -1,6 +1,9 @@
 package kargo
 
-import "strings"
+import (
+	"fmt"
+	"strings"
+)
 
 type Join struct {
 	Args *Args
@@ -19,3 +22,15 @@ func (b *Join) KargoValue(get GetValue) (string, error) {
 	}
 	return strings.Join(args, ""), nil
 }
+
+func (b *Join) String() string {
+	var args []string
+	b.Args.Visit(func(s string) {
+		args = append(args, s)
+	}, func(a DynArg) {
+		args = append(args, fmt.Sprintf("$(get %s with prefix %s)", a.FromOutput, a.Prefix))
+	}, func(fvp KargoValueProvider) {
+		args = append(args, fmt.Sprintf("%s", fvp))
+	})
+	return strings.Join(args, "")
+}
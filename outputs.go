@@ -0,0 +1,271 @@
+package kargo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// outputCmdID is the ID of the commands that capture the outputs of a Config.
+	outputCmdID = "outputs"
+	// pullRequestCmdID is the ID of the command that creates the gitops pull request.
+	pullRequestCmdID = "pullrequest"
+)
+
+// CmdOutput declares a value captured from a command after it succeeds.
+// The captured value is referred to as <Cmd.ID>.<Name> by the subsequent commands,
+// like DynArg.FromOutput.
+//
+// The whole stdout without the trailing newlines is captured unless JSONPath or Regexp is set.
+type CmdOutput struct {
+	Name string
+	// File, if set, makes the value captured from the file instead of the stdout,
+	// like the PullRequestOutputFile written by the command.
+	File string
+	// JSONPath selects the value in the JSON, like {.status.loadBalancer.ingress[0].ip}.
+	JSONPath string
+	// Regexp captures the first submatch, or the whole match if there's no group.
+	Regexp string
+	// Optional leaves the output unset instead of failing when JSONPath selects nothing.
+	Optional bool
+}
+
+// Outputs are the captured values, keyed by the IDs of the commands or the names of the components,
+// and then by the output names. It's encoded to JSON as is.
+type Outputs map[string]map[string]string
+
+// Set sets the output of the command or the component.
+func (o Outputs) Set(id, name, value string) {
+	if o[id] == nil {
+		o[id] = map[string]string{}
+	}
+	o[id][name] = value
+}
+
+// Lookup returns the value of the key in the form of <id>.<name>.
+func (o Outputs) Lookup(key string) (string, bool) {
+	id, name, ok := strings.Cut(key, ".")
+	if !ok {
+		return "", false
+	}
+	v, ok := o[id][name]
+	return v, ok
+}
+
+// GetValue returns the GetValue that resolves the keys of the outputs,
+// and passes the other keys to next.
+func (o Outputs) GetValue(next GetValue) GetValue {
+	return func(key string) (string, error) {
+		if v, ok := o.Lookup(key); ok {
+			return v, nil
+		}
+		if next == nil {
+			return "", fmt.Errorf("unable to get %s: no such output", key)
+		}
+		return next(key)
+	}
+}
+
+// CaptureOutputs captures the outputs declared by the command from its stdout.
+// Runners call it after the command succeeds.
+func CaptureOutputs(c Cmd, stdout []byte) (map[string]string, error) {
+	if len(c.Outputs) == 0 {
+		return nil, nil
+	}
+
+	if c.ID == "" {
+		return nil, fmt.Errorf("capturing outputs of %s: the command has no ID", c.String())
+	}
+
+	values := map[string]string{}
+	for _, o := range c.Outputs {
+		data := stdout
+		if o.File != "" {
+			var err error
+			data, err = os.ReadFile(o.File)
+			if err != nil {
+				return nil, fmt.Errorf("capturing output %s.%s: %w", c.ID, o.Name, err)
+			}
+		}
+
+		v, err := o.capture(data)
+		if o.Optional && errors.Is(err, errJSONPathNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("capturing output %s.%s: %w", c.ID, o.Name, err)
+		}
+		values[o.Name] = v
+	}
+
+	return values, nil
+}
+
+func (o CmdOutput) capture(data []byte) (string, error) {
+	v := strings.TrimRight(string(data), "\r\n")
+
+	if o.JSONPath != "" {
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return "", fmt.Errorf("parsing JSON: %w", err)
+		}
+
+		var err error
+		v, err = evalJSONPath(doc, o.JSONPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if o.Regexp != "" {
+		re, err := regexp.Compile(o.Regexp)
+		if err != nil {
+			return "", fmt.Errorf("parsing regexp: %w", err)
+		}
+
+		m := re.FindStringSubmatch(v)
+		if m == nil {
+			return "", fmt.Errorf("no match for %s", o.Regexp)
+		}
+		if len(m) > 1 {
+			return m[1], nil
+		}
+		return m[0], nil
+	}
+
+	return v, nil
+}
+
+// errJSONPathNotFound is returned by evalJSONPath when the path selects nothing.
+var errJSONPathNotFound = errors.New("not found")
+
+// evalJSONPath evaluates the kubectl-style JSONPath that consists of
+// fields and list indices, like {.items[0].metadata.name} or {.metadata.labels['app.kubernetes.io/name']}.
+// Maps and lists are rendered as JSON.
+func evalJSONPath(doc interface{}, path string) (string, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "{"), "}")
+	p = strings.TrimPrefix(p, "$")
+
+	n := doc
+	for p != "" {
+		var seg string
+		switch {
+		case p[0] == '.':
+			p = p[1:]
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			seg, p = p[:end], p[end:]
+		case p[0] == '[':
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return "", fmt.Errorf("invalid JSONPath %q", path)
+			}
+			seg, p = strings.Trim(p[1:end], `'"`), p[end+1:]
+		default:
+			return "", fmt.Errorf("invalid JSONPath %q", path)
+		}
+
+		if seg == "" {
+			return "", fmt.Errorf("invalid JSONPath %q", path)
+		}
+
+		switch v := n.(type) {
+		case map[string]interface{}:
+			var ok bool
+			n, ok = v[seg]
+			if !ok {
+				return "", fmt.Errorf("%s %w", path, errJSONPathNotFound)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil {
+				return "", fmt.Errorf("%s %w", path, errJSONPathNotFound)
+			}
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%s %w", path, errJSONPathNotFound)
+			}
+			n = v[i]
+		default:
+			return "", fmt.Errorf("%s %w", path, errJSONPathNotFound)
+		}
+	}
+
+	switch v := n.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// outputCmds returns the commands that capture the outputs of the config after the deployment.
+// On plan, they query the current state, and the outputs that don't exist yet are left unset.
+func (g *Generator) outputCmds(c *Config, t Target) ([]Cmd, error) {
+	var cmds []Cmd
+
+	for i, o := range c.Outputs {
+		sources := 0
+		for _, set := range []bool{o.Value != "" || o.ValueFrom != "", o.Kubectl != nil, o.File != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return nil, fmt.Errorf("outputs[%d]: only one of value, valueFrom, kubectl and file can be set", i)
+		}
+		if (o.JSONPath != "" || o.Regexp != "") && o.Kubectl == nil && o.File == "" {
+			return nil, fmt.Errorf("outputs[%d]: jsonPath and regexp require either kubectl or file", i)
+		}
+
+		capture := CmdOutput{Name: o.Name, JSONPath: o.JSONPath, Regexp: o.Regexp}
+
+		var cmd Cmd
+		switch {
+		case o.Kubectl != nil:
+			if o.Kubectl.Resource == "" {
+				return nil, fmt.Errorf("outputs[%d]: kubectl.resource is required", i)
+			}
+			args := NewArgs("get", o.Kubectl.Resource)
+			if o.Kubectl.Namespace != "" {
+				args = args.AppendStrings("--namespace", o.Kubectl.Namespace)
+			}
+			cmd = Cmd{Name: "kubectl", Args: args.AppendStrings("--output", "json")}
+		case o.File != "":
+			// The file is read by the runner, so the command does nothing but makes sure it exists.
+			capture.File = o.File
+			cmd = Cmd{Name: "test", Args: NewArgs("-f", o.File)}
+		default:
+			continue
+		}
+
+		cmd.ID = outputCmdID
+		cmd.Outputs = []CmdOutput{capture}
+		cmd.AllowFailure = t == Plan
+		cmds = append(cmds, cmd)
+	}
+
+	return cmds, nil
+}
+
+// captured reports whether the output is captured by the outputCmds.
+func (o Output) captured() bool {
+	return o.Kubectl != nil || o.File != ""
+}
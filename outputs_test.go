@@ -0,0 +1,146 @@
+package kargo_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCaptureOutputs(t *testing.T) {
+	svc := []byte(`{
+  "metadata": {"name": "myapp", "labels": {"app.kubernetes.io/name": "myapp"}},
+  "spec": {"ports": [{"port": 80}, {"port": 443}]},
+  "status": {"loadBalancer": {"ingress": [{"ip": "203.0.113.10"}]}}
+}`)
+
+	testcases := []struct {
+		output kargo.CmdOutput
+		want   string
+		err    string
+	}{
+		{output: kargo.CmdOutput{Name: "ip", JSONPath: "{.status.loadBalancer.ingress[0].ip}"}, want: "203.0.113.10"},
+		{output: kargo.CmdOutput{Name: "label", JSONPath: "{.metadata.labels['app.kubernetes.io/name']}"}, want: "myapp"},
+		{output: kargo.CmdOutput{Name: "port", JSONPath: "{.spec.ports[-1].port}"}, want: "443"},
+		{output: kargo.CmdOutput{Name: "ports", JSONPath: "{.spec.ports}"}, want: `[{"port":80},{"port":443}]`},
+		{output: kargo.CmdOutput{Name: "name", Regexp: `"name": "([^"]+)"`}, want: "myapp"},
+		{output: kargo.CmdOutput{Name: "ip", JSONPath: "{.status.loadBalancer.ingress[0].ip}", Regexp: `\d+$`}, want: "10"},
+		{output: kargo.CmdOutput{Name: "x", JSONPath: "{.spec.ports[2].port}"}, err: "capturing output svc.x: {.spec.ports[2].port} not found"},
+		{output: kargo.CmdOutput{Name: "x", JSONPath: "{.metadata[name}"}, err: `capturing output svc.x: invalid JSONPath "{.metadata[name}"`},
+		{output: kargo.CmdOutput{Name: "x", Regexp: "nothing"}, err: "capturing output svc.x: no match for nothing"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.output.JSONPath+tc.output.Regexp, func(t *testing.T) {
+			values, err := kargo.CaptureOutputs(kargo.Cmd{ID: "svc", Name: "kubectl", Outputs: []kargo.CmdOutput{tc.output}}, svc)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, map[string]string{tc.output.Name: tc.want}, values)
+		})
+	}
+
+	t.Run("file", func(t *testing.T) {
+		f := filepath.Join(t.TempDir(), "pr.json")
+		require.NoError(t, os.WriteFile(f, []byte(`{"number":12,"htmlURL":"https://github.com/myorg/myrepo/pull/12"}`+"\n"), 0644))
+
+		values, err := kargo.CaptureOutputs(kargo.Cmd{ID: "pullrequest", Outputs: []kargo.CmdOutput{
+			{Name: "number", File: f, JSONPath: "{.number}"},
+			{Name: "url", File: f, JSONPath: "{.htmlURL}"},
+		}}, []byte("ignored"))
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"number": "12", "url": "https://github.com/myorg/myrepo/pull/12"}, values)
+	})
+
+	t.Run("optional", func(t *testing.T) {
+		f := filepath.Join(t.TempDir(), "pr.json")
+		require.NoError(t, os.WriteFile(f, []byte(`{"head":"kargo-head","noChanges":true}`+"\n"), 0644))
+
+		values, err := kargo.CaptureOutputs(kargo.Cmd{ID: "pullrequest", Outputs: []kargo.CmdOutput{
+			{Name: "number", File: f, JSONPath: "{.number}", Optional: true},
+			{Name: "head", File: f, JSONPath: "{.head}", Optional: true},
+		}}, nil)
+		require.NoError(t, err)
+		require.Equal(t, map[string]string{"head": "kargo-head"}, values)
+
+		_, err = kargo.CaptureOutputs(kargo.Cmd{ID: "pullrequest", Outputs: []kargo.CmdOutput{
+			{Name: "number", File: f, JSONPath: "{.number}"},
+		}}, nil)
+		require.EqualError(t, err, "capturing output pullrequest.number: {.number} not found")
+	})
+
+	t.Run("no id", func(t *testing.T) {
+		_, err := kargo.CaptureOutputs(kargo.Cmd{Name: "echo", Args: kargo.NewArgs("hello"), Outputs: []kargo.CmdOutput{{Name: "x"}}}, nil)
+		require.EqualError(t, err, "capturing outputs of echo hello: the command has no ID")
+	})
+}
+
+func TestOutputs_GetValue(t *testing.T) {
+	o := kargo.Outputs{}
+	o.Set("build", "tag", "v1")
+
+	get := o.GetValue(func(key string) (string, error) { return "next-" + key, nil })
+
+	v, err := get("build.tag")
+	require.NoError(t, err)
+	require.Equal(t, "v1", v)
+
+	v, err = get("build.digest")
+	require.NoError(t, err)
+	require.Equal(t, "next-build.digest", v)
+
+	_, err = o.GetValue(nil)("build.digest")
+	require.EqualError(t, err, "unable to get build.digest: no such output")
+}
+
+func TestGenerator_OutputCmds(t *testing.T) {
+	c := &kargo.Config{
+		Name: "myapp",
+		Kustomize: &kargo.Kustomize{
+			Images: kargo.KustomizeImages{{Name: "myapp", NewTag: "v1"}},
+		},
+		Outputs: []kargo.Output{
+			{Name: "version", Value: "v1"},
+			{Name: "ip", Kubectl: &kargo.KubectlOutput{Resource: "svc/myapp", Namespace: "default"}, JSONPath: "{.status.loadBalancer.ingress[0].ip}"},
+			{Name: "pr", File: "/tmp/pr.json", JSONPath: "{.number}"},
+		},
+	}
+
+	g := &kargo.Generator{TempDir: t.TempDir()}
+
+	cmds, err := g.ExecCmds(c, kargo.Apply)
+	require.NoError(t, err)
+
+	outputCmds := cmds[len(cmds)-2:]
+	require.Equal(t, []kargo.Cmd{
+		{
+			ID:      "outputs",
+			Name:    "kubectl",
+			Args:    kargo.NewArgs("get", "svc/myapp", "--namespace", "default", "--output", "json"),
+			Outputs: []kargo.CmdOutput{{Name: "ip", JSONPath: "{.status.loadBalancer.ingress[0].ip}"}},
+		},
+		{
+			ID:      "outputs",
+			Name:    "test",
+			Args:    kargo.NewArgs("-f", "/tmp/pr.json"),
+			Outputs: []kargo.CmdOutput{{Name: "pr", File: "/tmp/pr.json", JSONPath: "{.number}"}},
+		},
+	}, outputCmds)
+
+	// The outputs are captured on plan as far as they exist.
+	cmds, err = g.ExecCmds(c, kargo.Plan)
+	require.NoError(t, err)
+	require.True(t, cmds[len(cmds)-1].AllowFailure)
+
+	c.Outputs = []kargo.Output{{Name: "x", Value: "v", File: "/tmp/x"}}
+	_, err = g.ExecCmds(c, kargo.Apply)
+	require.ErrorContains(t, err, "outputs[0]: only one of value, valueFrom, kubectl and file can be set")
+
+	c.Outputs = []kargo.Output{{Name: "x", ValueFrom: "y", JSONPath: "{.a}"}}
+	_, err = g.ExecCmds(c, kargo.Apply)
+	require.ErrorContains(t, err, "outputs[0]: jsonPath and regexp require either kubectl or file")
+}
@@ -0,0 +1,411 @@
+package kargo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+const (
+	defaultPreviewImageTagEnv       = "IMAGE_TAG"
+	defaultPreviewHelmImageTagValue = "image.tag"
+	// maxPreviewNameLen is the maximum length of Helm release names,
+	// which is shorter than the 63 characters allowed for namespaces.
+	maxPreviewNameLen = 53
+)
+
+// PreviewOptions identifies the preview environment of a pull request.
+type PreviewOptions struct {
+	// PullRequest is the number of the pull request.
+	PullRequest int
+	// Branch is the head branch of the pull request.
+	// The environment is named after it if PullRequest is zero.
+	Branch string
+	// ImageTag, if set, overrides the image tag of the app,
+	// like the tag of the image built for the head commit of the pull request.
+	ImageTag string
+	// Repo is the git remote URL of the repository of the pull request.
+	// It's recorded in Generator.PreviewStateFile so that PreviewGCCmds can check the state of the pull request.
+	Repo string
+}
+
+// PreviewPullRequests tells whether the pull requests of the preview environments are still open.
+// tools.GitHubPullRequests implements it via the GitHub API.
+type PreviewPullRequests interface {
+	Open(ctx context.Context, e tools.PreviewEnvironment) (bool, error)
+}
+
+var _ PreviewPullRequests = &tools.GitHubPullRequests{}
+
+var nonDNSLabelChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// PreviewName returns the unique name of the preview environment of the app,
+// like myapp-pr-12 for the pull request 12, or myapp-feature-foo for the branch feature/foo.
+//
+// It's a DNS-1123 label up to 53 characters, so that it can be used as
+// the Helm release, the namespace, the ArgoCD application and the compose project names.
+// Longer names are truncated and suffixed with the hash of the whole name to keep them unique.
+func PreviewName(app string, opts PreviewOptions) (string, error) {
+	var suffix string
+	switch {
+	case opts.PullRequest > 0:
+		suffix = "pr-" + strconv.Itoa(opts.PullRequest)
+	case opts.Branch != "":
+		suffix = opts.Branch
+	default:
+		return "", errors.New("either pull request or branch is required for the preview environment")
+	}
+
+	name := strings.Trim(nonDNSLabelChars.ReplaceAllString(strings.ToLower(app+"-"+suffix), "-"), "-")
+	if len(name) <= maxPreviewNameLen {
+		return name, nil
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:4])
+
+	return strings.TrimRight(name[:maxPreviewNameLen-len(hash)-1], "-") + "-" + hash, nil
+}
+
+// PreviewCmds returns the commands to plan or apply the preview environment of the pull request.
+//
+// The environment is the copy of the config named PreviewName,
+// deployed to the namespace of the same name, or as the compose project of the same name.
+// opts.ImageTag overrides the tags of the kustomize images, the helm values of Preview.HelmImageTagValues,
+// or the environment variable Preview.ImageTagEnv for compose and kompose.
+//
+// The environment is recorded in PreviewStateFile on apply.
+// The kustomization at Config.Path is left as is, and the images are set
+// on a new kustomization in TempDir that refers to it.
+// The kustomize and ArgoCD gitops modes aren't supported, because the preview environments
+// must not be pushed to the git repository shared with the other environments.
+func (g *Generator) PreviewCmds(c *Config, opts PreviewOptions, t Target) ([]Cmd, error) {
+	pc, env, err := previewConfig(c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlay, removeOverlay []Cmd
+	if pc.Kustomize != nil && pc.ArgoCD == nil {
+		overlay, removeOverlay, err = g.previewKustomizeOverlay(pc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cmds, err := g.ExecCmds(pc, t)
+	if err != nil {
+		return nil, err
+	}
+	cmds = append(append(overlay, cmds...), removeOverlay...)
+
+	if opts.ImageTag != "" && pc.ArgoCD == nil && (pc.Compose != nil || pc.Kompose != nil) {
+		tagEnv := previewImageTagEnv(pc)
+		for i := range cmds {
+			addEnv := map[string]string{}
+			for k, v := range cmds[i].AddEnv {
+				addEnv[k] = v
+			}
+			addEnv[tagEnv] = opts.ImageTag
+			cmds[i].AddEnv = addEnv
+		}
+	}
+
+	if t == Apply {
+		record, err := g.previewStateCmds(tools.PreviewStateActionAdd, env)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, record...)
+	}
+
+	return cmds, nil
+}
+
+// PreviewTeardownCmds returns the commands to remove the preview environment deployed by PreviewCmds,
+// and to remove it from PreviewStateFile.
+//
+// It uninstalls the Helm release, deletes the ArgoCD application with its resources,
+// or runs docker-compose-down, and deletes the namespace.
+// The namespace created by ArgoCD is left as is, as ArgoCD never deletes it.
+func (g *Generator) PreviewTeardownCmds(c *Config, opts PreviewOptions) ([]Cmd, error) {
+	pc, env, err := previewConfig(c, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []Cmd
+
+	// The environments are ephemeral, and so they are never protected by ProtectedNames.
+	if pc.ArgoCD != nil || pc.Compose != nil || pc.Helm != nil {
+		cmds, err = g.deleteCmds(pc, Destroy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Deleting the namespace deletes the resources applied by kustomize, kompose and kubectl too.
+	if pc.ArgoCD == nil && pc.Namespace != "" {
+		cmds = append(cmds, Cmd{
+			Name: "kubectl",
+			Args: NewArgs("delete", "namespace", pc.Namespace, "--ignore-not-found"),
+		})
+	}
+
+	forget, err := g.previewStateCmds(tools.PreviewStateActionRemove, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(cmds, forget...), nil
+}
+
+// PreviewGCCmds returns the commands to tear down the preview environments of the app
+// recorded in PreviewStateFile, whose pull requests are closed or merged.
+func (g *Generator) PreviewGCCmds(ctx context.Context, c *Config, prs PreviewPullRequests) ([]Cmd, error) {
+	if g.PreviewStateFile == "" {
+		return nil, errors.New("PreviewStateFile is required to garbage-collect preview environments")
+	}
+
+	s, err := tools.LoadPreviewState(g.PreviewStateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var cmds []Cmd
+	for _, e := range s.Environments {
+		if e.App != c.Name {
+			continue
+		}
+
+		open, err := prs.Open(ctx, e)
+		if err != nil {
+			return nil, fmt.Errorf("checking the pull request of preview environment %s: %w", e.Name, err)
+		}
+		if open {
+			continue
+		}
+
+		teardown, err := g.PreviewTeardownCmds(c, PreviewOptions{PullRequest: e.PullRequest, Branch: e.Branch, Repo: e.Repo})
+		if err != nil {
+			return nil, fmt.Errorf("preview environment %s: %w", e.Name, err)
+		}
+		cmds = append(cmds, teardown...)
+	}
+
+	return cmds, nil
+}
+
+// previewStateCmds returns the command to add or remove the environment to or from PreviewStateFile, if it's set.
+func (g *Generator) previewStateCmds(action string, e tools.PreviewEnvironment) ([]Cmd, error) {
+	if g.PreviewStateFile == "" {
+		return nil, nil
+	}
+
+	if len(g.ToolsCommand) == 0 {
+		return nil, errors.New("ToolsCommand is required to record preview environments")
+	}
+
+	var args []string
+	args = append(args, g.ToolsCommand[1:]...)
+	args = append(args, tools.CommandPreviewState,
+		"--"+tools.FlagPreviewStateFile, g.PreviewStateFile,
+		"--"+tools.FlagPreviewStateAction, action,
+		"--"+tools.FlagPreviewStateName, e.Name,
+	)
+
+	if action == tools.PreviewStateActionAdd {
+		args = append(args, "--"+tools.FlagPreviewStateApp, e.App)
+		if e.Namespace != "" {
+			args = append(args, "--"+tools.FlagPreviewStateNamespace, e.Namespace)
+		}
+		if e.PullRequest > 0 {
+			args = append(args, "--"+tools.FlagPreviewStatePullRequest, strconv.Itoa(e.PullRequest))
+		}
+		if e.Branch != "" {
+			args = append(args, "--"+tools.FlagPreviewStateBranch, e.Branch)
+		}
+		if e.Repo != "" {
+			args = append(args, "--"+tools.FlagPreviewStateRepo, e.Repo)
+		}
+	}
+
+	return []Cmd{{Name: g.ToolsCommand[0], Args: NewArgs(args)}}, nil
+}
+
+// previewKustomizeOverlay points the preview config to a new kustomization in TempDir,
+// which refers to the kustomization at Config.Path as its resource,
+// and returns the commands to create and to remove it.
+// kustomize-edit modifies the kustomization in place,
+// and so it must not run on the kustomization of the app in the caller's checkout.
+func (g *Generator) previewKustomizeOverlay(pc *Config) ([]Cmd, []Cmd, error) {
+	if g.TempDir == "" {
+		return nil, nil, fmt.Errorf("TempDir is required to run kustomize")
+	}
+
+	id, err := g.worktreeID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	src := pc.Path
+	if src == "" {
+		src = "."
+	}
+	src, err = filepath.Abs(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolving the path of the kustomization: %w", err)
+	}
+
+	dir := filepath.Join(g.TempDir, "kargo-previews", pc.Name+"-"+id)
+	pc.Path = dir
+
+	create := []Cmd{
+		{Name: "rm", Args: NewArgs("-rf", dir)},
+		{Name: "mkdir", Args: NewArgs("-p", dir)},
+		{Name: "kustomize", Args: NewArgs("create", "--resources", src), Dir: dir},
+	}
+	remove := []Cmd{{Name: "rm", Args: NewArgs("-rf", dir), Finally: true}}
+
+	return create, remove, nil
+}
+
+// previewConfig returns the copy of the config for the preview environment,
+// along with the environment to be recorded in the preview state file.
+func previewConfig(c *Config, opts PreviewOptions) (*Config, tools.PreviewEnvironment, error) {
+	var env tools.PreviewEnvironment
+
+	if c.Name == "" {
+		return nil, env, errors.New("name is required to derive the preview environment")
+	}
+
+	name, err := PreviewName(c.Name, opts)
+	if err != nil {
+		return nil, env, err
+	}
+
+	pc := *c
+	pc.Name = name
+
+	if c.Compose != nil {
+		compose := *c.Compose
+		compose.ProjectName = name
+		compose.RemoveVolumes = true
+		pc.Compose = &compose
+		pc.Namespace = ""
+	} else {
+		pc.Namespace = name
+	}
+
+	if c.Kustomize != nil {
+		if c.Kustomize.Git.Repo != "" || c.Kustomize.Strategy == KustomizeStrategySetImageAndCreatePR {
+			return nil, env, errors.New("preview environments don't support kustomize.git")
+		}
+		if c.Kustomize.Promotion != nil {
+			return nil, env, errors.New("preview environments don't support kustomize.promotion")
+		}
+
+		k := *c.Kustomize
+		k.Images = append(KustomizeImages{}, c.Kustomize.Images...)
+		if opts.ImageTag != "" {
+			var patterns []string
+			if c.Preview != nil {
+				patterns = c.Preview.Images
+			}
+			for i, img := range k.Images {
+				ok, err := matchAny(patterns, img.Name)
+				if err != nil {
+					return nil, env, fmt.Errorf("preview.images: %w", err)
+				}
+				if ok {
+					k.Images[i].NewTag = opts.ImageTag
+					k.Images[i].NewTagFrom = ""
+					k.Images[i].NewDigestFrom = ""
+				}
+			}
+		}
+		pc.Kustomize = &k
+	}
+
+	if c.Helm != nil {
+		h := *c.Helm
+		h.Set = append([]Set{}, c.Helm.Set...)
+		if opts.ImageTag != "" {
+			values := []string{defaultPreviewHelmImageTagValue}
+			if c.Preview != nil && len(c.Preview.HelmImageTagValues) > 0 {
+				values = c.Preview.HelmImageTagValues
+			}
+			for _, v := range values {
+				h.Set = append(h.Set, Set{Name: v, Value: opts.ImageTag})
+			}
+		}
+		pc.Helm = &h
+	}
+
+	if c.ArgoCD != nil {
+		if c.ArgoCD.Push || len(c.ArgoCD.Upload) > 0 {
+			return nil, env, errors.New("preview environments don't support argocd.push and argocd.upload")
+		}
+
+		a := *c.ArgoCD
+		// The preview environments share the project of the app.
+		if a.Project == "" {
+			a.Project = c.Name
+		}
+		// Config.Namespace takes effect only when argocd.namespace is empty.
+		a.DestNamespace = ""
+		pc.ArgoCD = &a
+
+		if opts.ImageTag != "" && c.Kompose != nil {
+			// The kompose plugin renders the compose file with the plugin env.
+			pc.Env = append(append([]Env{}, c.Env...), Env{Name: previewImageTagEnv(c), Value: opts.ImageTag})
+		}
+	}
+
+	env = tools.PreviewEnvironment{
+		App:         c.Name,
+		Name:        name,
+		Namespace:   pc.Namespace,
+		PullRequest: opts.PullRequest,
+		Branch:      opts.Branch,
+		Repo:        opts.Repo,
+	}
+
+	return &pc, env, nil
+}
+
+func previewImageTagEnv(c *Config) string {
+	if c.Preview != nil && c.Preview.ImageTagEnv != "" {
+		return c.Preview.ImageTagEnv
+	}
+	return defaultPreviewImageTagEnv
+}
+
+// matchAny reports whether the name matches any of the glob patterns,
+// or true if there are no patterns.
+func matchAny(patterns []string, name string) (bool, error) {
+	if len(patterns) == 0 {
+		return true, nil
+	}
+
+	for _, p := range patterns {
+		ok, err := path.Match(p, name)
+		if err != nil {
+			return false, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
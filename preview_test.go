@@ -0,0 +1,227 @@
+package kargo_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mumoshu/kargo"
+	"github.com/mumoshu/kargo/tools"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreviewName(t *testing.T) {
+	name, err := kargo.PreviewName("myapp", kargo.PreviewOptions{PullRequest: 12, Branch: "feature/foo"})
+	require.NoError(t, err)
+	require.Equal(t, "myapp-pr-12", name)
+
+	name, err = kargo.PreviewName("myapp", kargo.PreviewOptions{Branch: "Feature/Foo_bar"})
+	require.NoError(t, err)
+	require.Equal(t, "myapp-feature-foo-bar", name)
+
+	long := "my-very-long-application-name"
+	name, err = kargo.PreviewName(long, kargo.PreviewOptions{Branch: "feature/an-even-longer-branch-name"})
+	require.NoError(t, err)
+	require.Len(t, name, 53)
+	require.True(t, strings.HasPrefix(name, "my-very-long-application-name-feature-an-eve-"), name)
+
+	other, err := kargo.PreviewName(long, kargo.PreviewOptions{Branch: "feature/an-even-longer-branch-name-2"})
+	require.NoError(t, err)
+	require.NotEqual(t, name, other)
+
+	_, err = kargo.PreviewName("myapp", kargo.PreviewOptions{})
+	require.EqualError(t, err, "either pull request or branch is required for the preview environment")
+}
+
+func collectCmds(t *testing.T, cmds []kargo.Cmd) [][]string {
+	t.Helper()
+
+	var got [][]string
+	for _, c := range cmds {
+		got = append(got, append([]string{c.Name}, c.Args.MustCollect(nil)...))
+	}
+	return got
+}
+
+func TestPreviewCmds(t *testing.T) {
+	opts := kargo.PreviewOptions{PullRequest: 12, ImageTag: "sha-abc123", Repo: "https://github.com/myorg/myapp"}
+
+	t.Run("helm", func(t *testing.T) {
+		g := &kargo.Generator{ToolsCommand: []string{"kargo", "tools"}, PreviewStateFile: "/tmp/previews.json"}
+		c := &kargo.Config{
+			Name: "myapp",
+			Path: "charts/myapp",
+			Helm: &kargo.Helm{Set: []kargo.Set{{Name: "replicas", Value: "1"}}},
+		}
+
+		cmds, err := g.PreviewCmds(c, opts, kargo.Apply)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"helm", "upgrade", "--install", "myapp-pr-12", ".", "--set", "replicas=1", "--set", "image.tag=sha-abc123", "--namespace", "myapp-pr-12", "--create-namespace"},
+			{"kargo", "tools", "preview-state", "--file", "/tmp/previews.json", "--action", "add", "--name", "myapp-pr-12", "--app", "myapp", "--namespace", "myapp-pr-12", "--pull-request", "12", "--repo", "https://github.com/myorg/myapp"},
+		}, collectCmds(t, cmds))
+
+		// The config is left as is.
+		require.Equal(t, []kargo.Set{{Name: "replicas", Value: "1"}}, c.Helm.Set)
+
+		cmds, err = g.PreviewCmds(c, opts, kargo.Plan)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"helm", "diff", "upgrade", "--install", "myapp-pr-12", ".", "--set", "replicas=1", "--set", "image.tag=sha-abc123", "--namespace", "myapp-pr-12"},
+		}, collectCmds(t, cmds))
+
+		cmds, err = g.PreviewTeardownCmds(c, opts)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"helm", "uninstall", "myapp-pr-12", "--namespace", "myapp-pr-12"},
+			{"kubectl", "delete", "namespace", "myapp-pr-12", "--ignore-not-found"},
+			{"kargo", "tools", "preview-state", "--file", "/tmp/previews.json", "--action", "remove", "--name", "myapp-pr-12"},
+		}, collectCmds(t, cmds))
+	})
+
+	t.Run("kustomize", func(t *testing.T) {
+		g := &kargo.Generator{TempDir: "/tmp", WorktreeID: "test"}
+		c := &kargo.Config{
+			Name: "myapp",
+			Path: "deploy",
+			Kustomize: &kargo.Kustomize{Images: kargo.KustomizeImages{
+				{Name: "ghcr.io/myorg/myapp", NewTagFrom: "build.tag"},
+				{Name: "redis", NewTag: "7"},
+			}},
+			Preview: &kargo.Preview{Images: []string{"ghcr.io/myorg/*"}},
+		}
+
+		src, err := filepath.Abs("deploy")
+		require.NoError(t, err)
+		overlay := "/tmp/kargo-previews/myapp-pr-12-test"
+		ns := "/tmp/kustomize-namespace-myapp-pr-12-test"
+
+		cmds, err := g.PreviewCmds(c, opts, kargo.Apply)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"rm", "-rf", overlay},
+			{"mkdir", "-p", overlay},
+			{"kustomize", "create", "--resources", src},
+			{"kustomize", "edit", "set", "image", "ghcr.io/myorg/myapp:sha-abc123", "redis:7"},
+			{"bash", "-vxc", "rm -rf " + ns + " && mkdir -p " + ns + " && cd " + ns + ` && kustomize create --resources "$OLDPWD" && kustomize edit set namespace myapp-pr-12`},
+			{"kustomize", "build", "--output=/tmp/kustomize-built-myapp-pr-12-test.yaml", ns},
+			{"bash", "-vxc", "kubectl create namespace myapp-pr-12 --dry-run=client -o yaml | kubectl apply -f -"},
+			{"kubectl", "apply", "-f", "/tmp/kustomize-built-myapp-pr-12-test.yaml", "--server-side=true"},
+			{"rm", "-f", "/tmp/kustomize-built-myapp-pr-12-test.yaml"},
+			{"rm", "-rf", ns},
+			{"rm", "-rf", overlay},
+		}, collectCmds(t, cmds))
+		// The kustomization of the app is never edited, as it's the resource of the overlay.
+		for _, i := range []int{2, 3, 4} {
+			require.Equal(t, overlay, cmds[i].Dir)
+		}
+		require.True(t, cmds[len(cmds)-1].Finally)
+		require.Equal(t, "deploy", c.Path)
+
+		cmds, err = g.PreviewTeardownCmds(c, opts)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"kubectl", "delete", "namespace", "myapp-pr-12", "--ignore-not-found"},
+		}, collectCmds(t, cmds))
+
+		c.Kustomize.Git.Repo = "https://github.com/myorg/gitops"
+		_, err = g.PreviewCmds(c, opts, kargo.Apply)
+		require.EqualError(t, err, "preview environments don't support kustomize.git")
+	})
+
+	t.Run("compose", func(t *testing.T) {
+		g := &kargo.Generator{}
+		c := &kargo.Config{
+			Name:    "myapp",
+			Path:    "testdata/compose",
+			Compose: &kargo.Compose{},
+		}
+
+		cmds, err := g.PreviewCmds(c, kargo.PreviewOptions{Branch: "feature/foo", ImageTag: "sha-abc123"}, kargo.Apply)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"docker", "compose", "-f", "docker-compose.yml", "--project-name", "myapp-feature-foo", "up", "-d"},
+		}, collectCmds(t, cmds))
+		require.Equal(t, map[string]string{"IMAGE_TAG": "sha-abc123"}, cmds[0].AddEnv)
+
+		cmds, err = g.PreviewTeardownCmds(c, kargo.PreviewOptions{Branch: "feature/foo"})
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"docker", "compose", "-f", "docker-compose.yml", "--project-name", "myapp-feature-foo", "down", "--remove-orphans", "--volumes"},
+		}, collectCmds(t, cmds))
+		require.Equal(t, "testdata/compose", cmds[0].Dir)
+	})
+
+	t.Run("argocd", func(t *testing.T) {
+		g := &kargo.Generator{}
+		c := &kargo.Config{
+			Name: "myapp",
+			Kustomize: &kargo.Kustomize{Images: kargo.KustomizeImages{
+				{Name: "ghcr.io/myorg/myapp", NewTag: "v1"},
+			}},
+			ArgoCD: &kargo.ArgoCD{
+				Repo:          "https://github.com/myorg/myapp",
+				Path:          "deploy",
+				Server:        "argocd.example.com",
+				Username:      "admin",
+				DestName:      "mycluster",
+				DestNamespace: "myapp",
+			},
+		}
+
+		cmds, err := g.PreviewCmds(c, opts, kargo.Apply)
+		require.NoError(t, err)
+
+		got := collectCmds(t, cmds)
+		require.Contains(t, got, []string{"bash", "-vxc", "argocd proj create myapp --server argocd.example.com || true"})
+		appSet := got[len(got)-1]
+		require.Equal(t, []string{"argocd", "app", "set", "myapp-pr-12"}, appSet[:4])
+		require.Contains(t, appSet, "ghcr.io/myorg/myapp:sha-abc123")
+		require.Contains(t, strings.Join(appSet, " "), "--dest-namespace myapp-pr-12 --sync-option CreateNamespace=true")
+
+		cmds, err = g.PreviewTeardownCmds(c, opts)
+		require.NoError(t, err)
+		require.Equal(t, [][]string{
+			{"argocd", "login", "argocd.example.com", "--username", "admin"},
+			{"argocd", "app", "delete", "myapp-pr-12", "--yes", "--cascade=true", "--server", "argocd.example.com"},
+		}, collectCmds(t, cmds))
+
+		c.ArgoCD.Push = true
+		_, err = g.PreviewCmds(c, opts, kargo.Apply)
+		require.EqualError(t, err, "preview environments don't support argocd.push and argocd.upload")
+	})
+}
+
+type fakePullRequests map[string]bool
+
+func (p fakePullRequests) Open(ctx context.Context, e tools.PreviewEnvironment) (bool, error) {
+	return p[e.Name], nil
+}
+
+func TestPreviewGCCmds(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "previews.json")
+
+	s := &tools.PreviewState{}
+	now := time.Now()
+	s.Add(tools.PreviewEnvironment{App: "myapp", Name: "myapp-pr-1", Namespace: "myapp-pr-1", PullRequest: 1}, now)
+	s.Add(tools.PreviewEnvironment{App: "myapp", Name: "myapp-pr-2", Namespace: "myapp-pr-2", PullRequest: 2}, now)
+	s.Add(tools.PreviewEnvironment{App: "myapp", Name: "myapp-feature-foo", Namespace: "myapp-feature-foo", Branch: "feature/foo"}, now)
+	s.Add(tools.PreviewEnvironment{App: "other", Name: "other-pr-1", Namespace: "other-pr-1", PullRequest: 1}, now)
+	require.NoError(t, s.Save(file))
+
+	g := &kargo.Generator{ToolsCommand: []string{"kargo", "tools"}, PreviewStateFile: file}
+	c := &kargo.Config{Name: "myapp", Path: "charts/myapp", Helm: &kargo.Helm{}}
+
+	cmds, err := g.PreviewGCCmds(context.Background(), c, fakePullRequests{"myapp-pr-2": true})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"helm", "uninstall", "myapp-feature-foo", "--namespace", "myapp-feature-foo"},
+		{"kubectl", "delete", "namespace", "myapp-feature-foo", "--ignore-not-found"},
+		{"kargo", "tools", "preview-state", "--file", file, "--action", "remove", "--name", "myapp-feature-foo"},
+		{"helm", "uninstall", "myapp-pr-1", "--namespace", "myapp-pr-1"},
+		{"kubectl", "delete", "namespace", "myapp-pr-1", "--ignore-not-found"},
+		{"kargo", "tools", "preview-state", "--file", file, "--action", "remove", "--name", "myapp-pr-1"},
+	}, collectCmds(t, cmds))
+}
@@ -0,0 +1,123 @@
+package kargo
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mumoshu/kargo/tools"
+)
+
+// RegistryValueScheme is the scheme of the keys resolved by RegistryValues.
+const RegistryValueScheme = "registry"
+
+// RegistryValues is a GetValue that resolves the newest tag of an image from its registry,
+// so that e.g. kustomize.images[].newTagFrom can say "the latest 1.x" and
+// the deployment or the pull request is updated whenever a new tag is pushed.
+//
+// The keys are in the form of registry://<image>?<query>#<field>, like:
+//
+//	registry://ghcr.io/myorg/myapp?constraint=1.x
+//	registry://ghcr.io/myorg/myapp?constraint=1.x#digest
+//	registry://myorg/myapp?strategy=latest&tagPattern=^main-
+//
+// The query parameters are strategy, constraint, tagPattern and insecure,
+// which correspond to the fields of tools.ResolveImageOptions.
+// The field is either tag (default) or digest.
+// Keys with the same image and query resolve to the same tag within a RegistryValues,
+// so that the tag and the digest of an image never disagree.
+//
+// The other keys are passed to Next.
+// NewValues registers it for the registry scheme.
+type RegistryValues struct {
+	// Next gets the values of the keys not for the registry.
+	Next GetValue
+	// Client defaults to a tools.RegistryClient that accesses the registries anonymously.
+	Client *tools.RegistryClient
+
+	mu    sync.Mutex
+	cache map[string]*tools.ResolvedImage
+}
+
+// GetValue resolves the key. Pass it as Generator.GetValue.
+func (r *RegistryValues) GetValue(key string) (string, error) {
+	if !strings.HasPrefix(key, RegistryValueScheme+"://") {
+		if r.Next == nil {
+			return "", fmt.Errorf("unable to get %s: no value provider", key)
+		}
+		return r.Next(key)
+	}
+
+	query, field, _ := strings.Cut(key, "#")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.cache[query]
+	if !ok {
+		opts, err := parseRegistryValueKey(query)
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s: %w", key, err)
+		}
+
+		client := r.Client
+		if client == nil {
+			client = &tools.RegistryClient{}
+			r.Client = client
+		}
+
+		img, err = client.ResolveImage(context.Background(), opts)
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s: %w", key, err)
+		}
+
+		if r.cache == nil {
+			r.cache = map[string]*tools.ResolvedImage{}
+		}
+		r.cache[query] = img
+	}
+
+	switch field {
+	case "", "tag":
+		return img.Tag, nil
+	case "digest":
+		return img.Digest, nil
+	}
+
+	return "", fmt.Errorf("unable to get %s: unsupported field %q: it must be either tag or digest", key, field)
+}
+
+func parseRegistryValueKey(key string) (tools.ResolveImageOptions, error) {
+	u, err := url.Parse(key)
+	if err != nil {
+		return tools.ResolveImageOptions{}, err
+	}
+
+	opts := tools.ResolveImageOptions{
+		Image: strings.TrimSuffix(u.Host+u.Path, "/"),
+	}
+
+	for k, vs := range u.Query() {
+		v := vs[len(vs)-1]
+		switch k {
+		case "strategy":
+			opts.Strategy = v
+		case "constraint":
+			opts.Constraint = v
+		case "tagPattern":
+			opts.TagPattern = v
+		case "insecure":
+			opts.Insecure, err = strconv.ParseBool(v)
+			if err != nil {
+				return tools.ResolveImageOptions{}, fmt.Errorf("parsing insecure: %w", err)
+			}
+		default:
+			return tools.ResolveImageOptions{}, fmt.Errorf("unsupported query parameter %q", k)
+		}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,71 @@
+package kargo_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryValues(t *testing.T) {
+	var tagLists int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/myorg/myapp/tags/list":
+			tagLists++
+			fmt.Fprint(w, `{"tags":["1.0.0","1.1.0","2.0.0"]}`)
+		case "/v2/myorg/myapp/manifests/1.1.0":
+			w.Header().Set("Docker-Content-Digest", "sha256:abc")
+			fmt.Fprint(w, `{}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	image := strings.TrimPrefix(srv.URL, "http://") + "/myorg/myapp"
+
+	r := &kargo.RegistryValues{
+		Next: func(key string) (string, error) {
+			return "next:" + key, nil
+		},
+	}
+
+	c := &kargo.Config{
+		Name: "myapp",
+		Kustomize: &kargo.Kustomize{
+			Images: kargo.KustomizeImages{
+				{Name: "myapp", NewName: image, NewTagFrom: "registry://" + image + "?constraint=1.x"},
+				{Name: "pinned", NewDigestFrom: "registry://" + image + "?constraint=1.x#digest"},
+				{Name: "other", NewTagFrom: "build.tag"},
+			},
+		},
+	}
+
+	args, err := kargo.AppendArgs(nil, c.Kustomize.Images, kargo.FieldTagKustomize)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"myapp=" + image + ":1.1.0",
+		"pinned@sha256:abc",
+		"other:next:build.tag",
+	}, args.MustCollect(r.GetValue))
+
+	// The tag and the digest are resolved at once.
+	require.Equal(t, 1, tagLists)
+
+	_, err = r.GetValue("registry://" + image + "?constraint=1.x#name")
+	require.EqualError(t, err, "unable to get registry://"+image+"?constraint=1.x#name: unsupported field \"name\": it must be either tag or digest")
+
+	_, err = r.GetValue("registry://" + image + "?constraint=3.x")
+	require.EqualError(t, err, "unable to get registry://"+image+"?constraint=3.x: no tag of "+image+" matches strategy semver, constraint 3.x")
+
+	_, err = r.GetValue("registry://" + image + "?semver=1.x")
+	require.EqualError(t, err, "unable to get registry://"+image+"?semver=1.x: unsupported query parameter \"semver\"")
+
+	_, err = (&kargo.RegistryValues{}).GetValue("build.tag")
+	require.EqualError(t, err, "unable to get build.tag: no value provider")
+}
@@ -0,0 +1,98 @@
+package kargo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Runner runs the commands generated for a deployment.
+//
+// Implementations must run the cmds in order in cmd.Dir,
+// and stop at the first failure unless cmd.AllowFailure is set.
+// Commands whose cmd.Finally is set must be run even after a failure.
+// get resolves the values referred to by the args and the secrets of the cmds.
+// The cmds must be run with the environment variables returned by cmd.Env,
+// as cmd.AddEnv doesn't include the secrets.
+//
+// The outputs declared by the cmds are captured via CaptureOutputs and returned keyed by cmd.ID,
+// and the subsequent cmds can refer to them as <cmd.ID>.<output name>.
+type Runner interface {
+	Run(ctx context.Context, cmds []Cmd, get GetValue) (Outputs, error)
+}
+
+// ExecRunner is the Runner that runs the commands as child processes.
+type ExecRunner struct {
+	// Stdout and Stderr default to os.Stdout and os.Stderr.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+var _ Runner = &ExecRunner{}
+
+func (r *ExecRunner) Run(ctx context.Context, cmds []Cmd, get GetValue) (Outputs, error) {
+	outputs := Outputs{}
+	get = outputs.GetValue(get)
+
+	var failed error
+	for _, c := range cmds {
+		if failed != nil && !c.Finally {
+			continue
+		}
+
+		values, err := r.run(ctx, c, get)
+		if err != nil {
+			if !c.AllowFailure && failed == nil {
+				failed = err
+			}
+			continue
+		}
+
+		for k, v := range values {
+			outputs.Set(c.ID, k, v)
+		}
+	}
+
+	return outputs, failed
+}
+
+func (r *ExecRunner) run(ctx context.Context, c Cmd, get GetValue) (map[string]string, error) {
+	args, err := c.Args.Collect(get)
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w", c.String(), err)
+	}
+
+	env, err := c.Env(get)
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w", c.String(), err)
+	}
+
+	cmd := exec.CommandContext(ctx, c.Name, args...)
+	cmd.Dir = c.Dir
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+
+	var stdout bytes.Buffer
+	cmd.Stdout = r.Stdout
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if len(c.Outputs) > 0 {
+		cmd.Stdout = io.MultiWriter(cmd.Stdout, &stdout)
+	}
+	cmd.Stderr = r.Stderr
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w", c.String(), err)
+	}
+
+	return CaptureOutputs(c, stdout.Bytes())
+}
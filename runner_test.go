@@ -0,0 +1,128 @@
+package kargo_test
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecRunner(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	var stdout bytes.Buffer
+	r := &kargo.ExecRunner{Stdout: &stdout}
+
+	get := func(key string) (string, error) {
+		return "value-of-" + key, nil
+	}
+
+	dir := t.TempDir()
+	_, err := r.Run(context.Background(), []kargo.Cmd{
+		{Name: "sh", Args: kargo.NewArgs("-c", `echo "$1 $TOKEN"; pwd`, "sh").AppendValueFromOutput("foo"), Dir: dir, SecretEnv: map[string]kargo.Secret{"TOKEN": {FromOutput: "token"}}},
+		{Name: "sh", Args: kargo.NewArgs("-c", "exit 1"), AllowFailure: true},
+		{Name: "sh", Args: kargo.NewArgs("-c", "echo continued; exit 2")},
+		{Name: "sh", Args: kargo.NewArgs("-c", "echo skipped")},
+		{Name: "sh", Args: kargo.NewArgs("-c", "echo finally"), Finally: true},
+	}, get)
+	require.EqualError(t, err, "running sh -c echo continued; exit 2: exit status 2")
+	require.Equal(t, "value-of-foo value-of-token\n"+dir+"\ncontinued\nfinally\n", stdout.String())
+}
+
+// envRunner is a custom Runner that records the environment variables
+// each command would be run with, instead of running it.
+type envRunner struct {
+	env map[string]map[string]string
+}
+
+func (r *envRunner) Run(ctx context.Context, cmds []kargo.Cmd, get kargo.GetValue) (kargo.Outputs, error) {
+	r.env = map[string]map[string]string{}
+	for _, c := range cmds {
+		env, err := c.Env(get)
+		if err != nil {
+			return nil, err
+		}
+		r.env[c.String()] = env
+	}
+	return kargo.Outputs{}, nil
+}
+
+func TestRunner_SecretEnv(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "mytoken")
+
+	c := &kargo.Config{
+		Name: "myapp",
+		Kustomize: &kargo.Kustomize{
+			Strategy: kargo.KustomizeStrategySetImageAndCreatePR,
+			Images:   kargo.KustomizeImages{{Name: "myapp", NewTag: "v1"}},
+			Git:      kargo.KustomizeGit{Repo: "https://github.com/myorg/gitops", Path: "overlays/dev"},
+		},
+	}
+
+	g, err := kargo.NewGraph([]*kargo.Config{c})
+	require.NoError(t, err)
+
+	r := &envRunner{}
+	gen := &kargo.Generator{TempDir: t.TempDir(), ToolsCommand: []string{"kargo", "tools"}, ToolName: "kargo", WorktreeID: "test"}
+	_, err = g.Run(context.Background(), kargo.RunGraphOptions{Generator: gen, Target: kargo.Apply, Runner: r})
+	require.NoError(t, err)
+
+	// The tokens are available to custom runners only via Cmd.Env,
+	// and never appear in the commands.
+	var pr, push bool
+	for c, env := range r.env {
+		require.NotContains(t, c, "mytoken")
+		switch {
+		case strings.Contains(c, "create-pullrequest"):
+			pr = true
+			require.Equal(t, map[string]string{"KARGO_TOOLS_GITHUB_TOKEN": "mytoken"}, env)
+		case strings.Contains(c, "git push"):
+			push = true
+			require.Equal(t, map[string]string{"KARGO_GIT_TOKEN": "mytoken"}, env)
+		}
+	}
+	require.True(t, pr)
+	require.True(t, push)
+}
+
+func TestExecRunner_Outputs(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	var stdout bytes.Buffer
+	r := &kargo.ExecRunner{Stdout: &stdout}
+
+	outputs, err := r.Run(context.Background(), []kargo.Cmd{
+		{
+			ID:      "build",
+			Name:    "sh",
+			Args:    kargo.NewArgs("-c", `echo '{"image": {"tag": "v1.2.3"}}'`),
+			Outputs: []kargo.CmdOutput{{Name: "tag", JSONPath: "{.image.tag}"}, {Name: "raw"}},
+		},
+		{
+			ID:      "deploy",
+			Name:    "sh",
+			Args:    kargo.NewArgs("-c", `echo "deployed $1 to https://example.com/app"`, "sh").AppendValueFromOutput("build.tag"),
+			Outputs: []kargo.CmdOutput{{Name: "url", Regexp: `to (\S+)`}},
+		},
+	}, nil)
+	require.NoError(t, err)
+	require.Equal(t, kargo.Outputs{
+		"build":  {"tag": "v1.2.3", "raw": `{"image": {"tag": "v1.2.3"}}`},
+		"deploy": {"url": "https://example.com/app"},
+	}, outputs)
+	require.Equal(t, "{\"image\": {\"tag\": \"v1.2.3\"}}\ndeployed v1.2.3 to https://example.com/app\n", stdout.String())
+
+	// A failure to capture fails the command.
+	_, err = r.Run(context.Background(), []kargo.Cmd{
+		{ID: "x", Name: "sh", Args: kargo.NewArgs("-c", "echo nothing"), Outputs: []kargo.CmdOutput{{Name: "y", Regexp: "something"}}},
+	}, nil)
+	require.EqualError(t, err, "capturing output x.y: no match for something")
+}
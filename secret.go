@@ -0,0 +1,104 @@
+package kargo
+
+// Secret is a command-line argument whose value must not appear
+// in logs and plans, like passwords and tokens.
+//
+// Args.String renders it as ***.
+//
+// When a Secret is a part of a bash script, the script refers to it
+// via the environment variable named Env instead of embedding the value.
+// The runner is expected to run the command with the environment variables
+// returned by Cmd.Env, which includes the secret value.
+type Secret struct {
+	// Env is the name of the environment variable via which
+	// the secret is passed to scripts.
+	Env string
+
+	// Value is the secret value.
+	Value string
+
+	// FromOutput is a reference to an output of another kargo command
+	// that contains the secret value.
+	FromOutput string
+}
+
+const redacted = "***"
+
+// KargoValue returns the secret value.
+// Note that this reveals the secret, so it should be used only to run the command.
+func (s Secret) KargoValue(get GetValue) (string, error) {
+	if s.FromOutput != "" {
+		return get(s.FromOutput)
+	}
+	return s.Value, nil
+}
+
+func (s Secret) String() string {
+	return redacted
+}
+
+// GoString prevents the secret value from being printed via %#v.
+func (s Secret) GoString() string {
+	return "kargo.Secret{Env: " + s.Env + ", Value: " + redacted + "}"
+}
+
+var _ KargoValueProvider = Secret{}
+
+func (a *Args) AppendSecret(env, value string) *Args {
+	return a.Append(Secret{Env: env, Value: value})
+}
+
+func (a *Args) AppendSecretFromOutput(env, ref string) *Args {
+	return a.Append(Secret{Env: env, FromOutput: ref})
+}
+
+// Secrets returns all the secrets in the args,
+// including ones in the nested bash scripts and joins.
+func (a *Args) Secrets() []Secret {
+	var secrets []Secret
+
+	a.Visit(func(s string) {}, func(d DynArg) {}, func(fvp KargoValueProvider) {
+		switch v := fvp.(type) {
+		case Secret:
+			secrets = append(secrets, v)
+		case *BashScript:
+			secrets = append(secrets, v.Script.Secrets()...)
+		case *Join:
+			secrets = append(secrets, v.Args.Secrets()...)
+		}
+	})
+
+	return secrets
+}
+
+// Env returns the environment variables to add to the command.
+// That is, AddEnv, SecretEnv, and the secrets referenced by the scripts in Args.
+//
+// Runners should use this instead of AddEnv to run the command,
+// because it also includes the secret values.
+func (c Cmd) Env(get GetValue) (map[string]string, error) {
+	env := map[string]string{}
+
+	for k, v := range c.AddEnv {
+		env[k] = v
+	}
+
+	secrets := c.Args.Secrets()
+	for k, s := range c.SecretEnv {
+		s.Env = k
+		secrets = append(secrets, s)
+	}
+
+	for _, s := range secrets {
+		if s.Env == "" {
+			continue
+		}
+		v, err := s.KargoValue(get)
+		if err != nil {
+			return nil, errorf("resolving secret for %s: %w", s.Env, err)
+		}
+		env[s.Env] = v
+	}
+
+	return env, nil
+}
@@ -0,0 +1,147 @@
+package kargo_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testArgoCDPassword = "s3cr3t-argocd-password"
+	testGitHubToken    = "s3cr3t-github-token"
+)
+
+func TestSecrets_NotRendered(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", testGitHubToken)
+
+	get := func(key string) (string, error) {
+		if key == "argocd.password" {
+			return testArgoCDPassword, nil
+		}
+		return strings.ToUpper(key), nil
+	}
+
+	newGenerator := func() *kargo.Generator {
+		return &kargo.Generator{
+			GetValue:     get,
+			TempDir:      "/tmp",
+			ToolsCommand: []string{"kargo", "tools"},
+			ToolName:     "kargo",
+		}
+	}
+
+	argocd := func(f func(a *kargo.ArgoCD)) *kargo.Config {
+		c := &kargo.Config{
+			Name: "test",
+			ArgoCD: &kargo.ArgoCD{
+				Repo:     "https://github.com/myorg/myrepo.git",
+				Path:     "deploy",
+				Server:   "https://localhost:8080",
+				Username: "admin",
+				DestName: "myekscluster",
+			},
+		}
+		f(c.ArgoCD)
+		return c
+	}
+
+	configs := map[string]*kargo.Config{
+		"argocd password": argocd(func(a *kargo.ArgoCD) {
+			a.Password = testArgoCDPassword
+		}),
+		"argocd passwordFrom": argocd(func(a *kargo.ArgoCD) {
+			a.PasswordFrom = "argocd.password"
+		}),
+		"argocd push": argocd(func(a *kargo.ArgoCD) {
+			a.Password = testArgoCDPassword
+			a.Push = true
+		}),
+		"argocd projectSpec": argocd(func(a *kargo.ArgoCD) {
+			a.PasswordFrom = "argocd.password"
+			a.ProjectSpec = &kargo.ArgoCDProjectSpec{
+				SourceRepos: []string{a.Repo},
+			}
+		}),
+		"kustomize gitops": {
+			Name: "test",
+			Kustomize: &kargo.Kustomize{
+				Strategy: kargo.KustomizeStrategySetImageAndCreatePR,
+				Images: kargo.KustomizeImages{
+					{Name: "myapp", NewTag: "v1"},
+				},
+				Git: kargo.KustomizeGit{
+					Repo: "https://github.com/myorg/myrepo.git",
+					Path: "deploy",
+				},
+			},
+		},
+	}
+
+	for name, c := range configs {
+		for _, targ := range []kargo.Target{kargo.Plan, kargo.Apply} {
+			t.Run(fmt.Sprintf("%s/%d", name, targ), func(t *testing.T) {
+				g := newGenerator()
+
+				cmds, err := g.ExecCmds(c, targ)
+				require.NoError(t, err)
+
+				env := map[string]string{}
+				for _, cmd := range cmds {
+					collected, err := cmd.Args.Collect(get)
+					require.NoError(t, err)
+
+					for _, rendered := range []string{cmd.String(), cmd.Args.String(), strings.Join(collected, " "), fmt.Sprintf("%v %+v %#v", cmd, cmd, cmd)} {
+						require.NotContains(t, rendered, testArgoCDPassword)
+						require.NotContains(t, rendered, testGitHubToken)
+					}
+
+					for k, v := range cmd.AddEnv {
+						require.NotContains(t, v, testArgoCDPassword, k)
+						require.NotContains(t, v, testGitHubToken, k)
+					}
+
+					e, err := cmd.Env(get)
+					require.NoError(t, err)
+					for k, v := range e {
+						env[k] = v
+					}
+				}
+
+				// The secrets are still available to the runner via Cmd.Env.
+				var values []string
+				for _, v := range env {
+					values = append(values, v)
+				}
+				if strings.HasPrefix(name, "argocd") && targ == kargo.Apply {
+					require.Contains(t, values, testArgoCDPassword)
+				}
+				if name == "argocd push" || name == "kustomize gitops" {
+					require.Contains(t, values, testGitHubToken)
+				}
+			})
+		}
+	}
+}
+
+func TestSecret_Args(t *testing.T) {
+	args := kargo.NewArgs("login", "--password").AppendSecret("PASSWORD", "foo")
+	script := kargo.NewArgs("-vxc", kargo.NewBashScript(args))
+
+	require.Equal(t, "login --password ***", args.String())
+	require.Equal(t, "-vxc login --password ***", script.String())
+
+	collected, err := args.Collect(nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"login", "--password", "foo"}, collected)
+
+	collected, err = script.Collect(nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"-vxc", `login --password "$PASSWORD"`}, collected)
+
+	env, err := kargo.Cmd{Name: "bash", Args: script}.Env(nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"PASSWORD": "foo"}, env)
+}
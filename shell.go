@@ -0,0 +1,84 @@
+package kargo
+
+import "strings"
+
+// ShellRaw is a part of a shell script that is rendered as-is,
+// like operators (;, &&, ||, |, <<<, parentheses) and variable references.
+//
+// Any other string in a BashScript is quoted by ShellQuote,
+// so that values containing spaces, quotes and ; never break the script
+// nor inject commands.
+type ShellRaw string
+
+func (r ShellRaw) KargoValue(get GetValue) (string, error) {
+	return string(r), nil
+}
+
+func (r ShellRaw) String() string {
+	return string(r)
+}
+
+var _ KargoValueProvider = ShellRaw("")
+
+// ShellQuote quotes s so that a POSIX shell reads it as a single word
+// whose value is s.
+//
+// s is returned as-is if it consists only of characters that have no special meaning
+// to the shell. Otherwise it is enclosed in single quotes, in which every character
+// is literal. A single quote in s is rendered as '"'"', which closes the single-quoted
+// string, adds a double-quoted single quote, and opens another single-quoted string.
+func ShellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+
+	if isShellSafe(s) {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+// ShellJoin quotes each arg with ShellQuote and joins them with spaces.
+func ShellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = ShellQuote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+func isShellSafe(s string) bool {
+	if looksLikeAssignment(s) {
+		// FOO=bar at the command position is a variable assignment.
+		return false
+	}
+
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("-_./:,+=@%", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func looksLikeAssignment(s string) bool {
+	i := strings.IndexRune(s, '=')
+	if i <= 0 {
+		return false
+	}
+
+	for j, r := range s[:i] {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case j > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,124 @@
+package kargo_test
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: "''"},
+		{in: "foo", want: "foo"},
+		{in: "--output=/tmp/x.yaml", want: "--output=/tmp/x.yaml"},
+		{in: "myimage:v1.2.3@sha256:abc", want: "myimage:v1.2.3@sha256:abc"},
+		{in: "FOO=bar", want: "'FOO=bar'"},
+		{in: "automated commit", want: "'automated commit'"},
+		{in: "it's", want: `'it'"'"'s'`},
+		{in: "v1; rm -rf /", want: "'v1; rm -rf /'"},
+		{in: "$(id)", want: "'$(id)'"},
+		{in: "`id`", want: "'`id`'"},
+		{in: "a\nb", want: "'a\nb'"},
+		{in: "~", want: "'~'"},
+		{in: "*", want: "'*'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			require.Equal(t, tt.want, kargo.ShellQuote(tt.in))
+		})
+	}
+}
+
+func TestBashScript_Quoting(t *testing.T) {
+	tag := "v1; touch /tmp/pwned"
+	script := kargo.NewArgs("kustomize", "edit", "set", "image").
+		Append(kargo.NewJoin(kargo.NewArgs("myapp:").AppendValueFromOutput("tag"))).
+		Append(kargo.ShellRaw("&&"), "git", "commit", "-m", "deploy myapp's "+tag)
+
+	got, err := kargo.NewBashScript(script).KargoValue(func(string) (string, error) {
+		return tag, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, `kustomize edit set image 'myapp:v1; touch /tmp/pwned' && git commit -m 'deploy myapp'"'"'s v1; touch /tmp/pwned'`, got)
+}
+
+// shellRoundTrip runs the script rendered from args with sh,
+// and returns the arguments as the shell sees them.
+func shellRoundTrip(t *testing.T, args []string) []string {
+	t.Helper()
+
+	var script *kargo.Args
+	script = script.AppendStrings("printf", `%s\0`)
+	script = script.AppendStrings(args...)
+
+	rendered, err := kargo.NewBashScript(script).KargoValue(nil)
+	require.NoError(t, err)
+
+	var stdout, stderr bytes.Buffer
+	c := exec.Command("sh", "-c", rendered)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	require.NoError(t, c.Run(), "script: %s\nstderr: %s", rendered, stderr.String())
+
+	out := strings.Split(stdout.String(), "\x00")
+	return out[:len(out)-1]
+}
+
+func shellSafeArg(s string) bool {
+	// Arguments can't contain NUL bytes, and
+	// sh may reject invalid UTF-8 depending on the locale.
+	return utf8.ValidString(s) && !strings.ContainsRune(s, 0)
+}
+
+func FuzzShellQuote(f *testing.F) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		f.Skip("sh is not available")
+	}
+
+	for _, s := range []string{"", "foo", "a b", "it's", `"quoted"`, "$HOME", "$(id)", "`id`", "a;b", "a&&b", "a|b", "\\", "\n", "*", "~", "FOO=bar", "-n", "'", "''", `'"'"'`} {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, s string) {
+		if !shellSafeArg(s) {
+			t.Skip()
+		}
+
+		require.Equal(t, []string{s}, shellRoundTrip(t, []string{s}))
+	})
+}
+
+func FuzzShellJoin(f *testing.F) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		f.Skip("sh is not available")
+	}
+
+	f.Add("automated commit", "myapp:v1; rm -rf /", "")
+	f.Add("'", `"`, "$(id)")
+
+	f.Fuzz(func(t *testing.T, a, b, c string) {
+		args := []string{a, b, c}
+		for _, s := range args {
+			if !shellSafeArg(s) {
+				t.Skip()
+			}
+		}
+
+		require.Equal(t, args, shellRoundTrip(t, args))
+
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", "printf '%s\\0' "+kargo.ShellJoin(args))
+		cmd.Stdout = &stdout
+		require.NoError(t, cmd.Run())
+		require.Equal(t, strings.Join(args, "\x00")+"\x00", stdout.String())
+	})
+}
@@ -0,0 +1,308 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CommandArgoCDProjDiff        = "argocd-proj-diff"
+	FlagArgoCDProjDiffName       = "name"
+	FlagArgoCDProjDiffDesiredEnv = "desired-env"
+
+	CommandArgoCDProjApply        = "argocd-proj-apply"
+	FlagArgoCDProjApplyName       = "name"
+	FlagArgoCDProjApplyDesiredEnv = "desired-env"
+)
+
+type ArgoCDProjDiffOptions struct {
+	// Name is the name of the ArgoCD project.
+	Name string
+	// DesiredEnv is the name of the environment variable
+	// that contains the desired AppProject manifest in YAML.
+	DesiredEnv string
+	// ArgoCDArgs is the list of additional arguments passed to argocd-proj-get,
+	// like --server and --insecure.
+	ArgoCDArgs []string
+	// Out is where the differences are written to.
+	// Defaults to os.Stdout.
+	Out io.Writer
+}
+
+// ArgoCDProjDiff shows the differences between the current spec of the ArgoCD project
+// and the one ArgoCDProjApply would upsert.
+// It returns true if there are any differences.
+//
+// The desired spec is merged into the current one as ArgoCDProjApply does,
+// so that the fields that kargo does not manage don't show up as differences.
+func ArgoCDProjDiff(ctx context.Context, opts ArgoCDProjDiffOptions) (bool, error) {
+	if opts.Name == "" {
+		return false, fmt.Errorf("%s must be set", FlagArgoCDProjDiffName)
+	}
+
+	desired, err := desiredProject(opts.DesiredEnv, FlagArgoCDProjDiffDesiredEnv)
+	if err != nil {
+		return false, err
+	}
+
+	current, err := currentProject(ctx, opts.Name, opts.ArgoCDArgs)
+	if err != nil {
+		return false, err
+	}
+
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	currentSpec := projectSpec(current)
+	lines := diffProjectSpecs(mergeProjectSpecs(projectSpec(desired), currentSpec), currentSpec)
+	if len(lines) == 0 {
+		fmt.Fprintf(out, "argocd project %s is up to date\n", opts.Name)
+		return false, nil
+	}
+
+	fmt.Fprintf(out, "argocd project %s will be updated:\n", opts.Name)
+	for _, l := range lines {
+		fmt.Fprintln(out, l)
+	}
+
+	return true, nil
+}
+
+type ArgoCDProjApplyOptions struct {
+	// Name is the name of the ArgoCD project.
+	Name string
+	// DesiredEnv is the name of the environment variable
+	// that contains the desired AppProject manifest in YAML.
+	DesiredEnv string
+	// ArgoCDArgs is the list of additional arguments passed to argocd-proj-get and argocd-proj-create,
+	// like --server and --insecure.
+	ArgoCDArgs []string
+}
+
+// ArgoCDProjApply creates or updates the ArgoCD project.
+//
+// argocd-proj-create --upsert replaces the whole spec,
+// so the desired spec is merged into the current one before upserting.
+// The fields missing in the desired spec, like signatureKeys and namespaceResourceBlacklist,
+// and the JWT tokens issued for the roles are kept as they are.
+func ArgoCDProjApply(ctx context.Context, opts ArgoCDProjApplyOptions) error {
+	if opts.Name == "" {
+		return fmt.Errorf("%s must be set", FlagArgoCDProjApplyName)
+	}
+
+	desired, err := desiredProject(opts.DesiredEnv, FlagArgoCDProjApplyDesiredEnv)
+	if err != nil {
+		return err
+	}
+
+	current, err := currentProject(ctx, opts.Name, opts.ArgoCDArgs)
+	if err != nil {
+		return err
+	}
+
+	desired["spec"] = mergeProjectSpecs(projectSpec(desired), projectSpec(current))
+
+	manifest, err := json.Marshal(desired)
+	if err != nil {
+		return fmt.Errorf("marshaling project: %w", err)
+	}
+
+	// argocd-proj-create reads the manifest only from a file,
+	// so we pass it via stdin.
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "argocd", append([]string{"proj", "create", "--upsert", "--file", "/dev/stdin"}, opts.ArgoCDArgs...)...)
+	c.Stdin = bytes.NewReader(manifest)
+	c.Stdout = os.Stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("running argocd proj create: %w: %s", err, stderr.String())
+	}
+
+	return nil
+}
+
+func desiredProject(env, flag string) (map[string]interface{}, error) {
+	desiredYAML := os.Getenv(env)
+	if desiredYAML == "" {
+		return nil, fmt.Errorf("%s must be set", flag)
+	}
+
+	var desired map[string]interface{}
+	if err := yaml.Unmarshal([]byte(desiredYAML), &desired); err != nil {
+		return nil, fmt.Errorf("parsing desired project: %w", err)
+	}
+
+	return desired, nil
+}
+
+// currentProject returns the ArgoCD project, or nil if it doesn't exist yet.
+func currentProject(ctx context.Context, name string, argocdArgs []string) (map[string]interface{}, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "argocd", append([]string{"proj", "get", name, "-o", "json"}, argocdArgs...)...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if !strings.Contains(strings.ToLower(stderr.String()), "not found") {
+			return nil, fmt.Errorf("running argocd proj get: %w: %s", err, stderr.String())
+		}
+		// The project is going to be created.
+		return nil, nil
+	}
+
+	var current map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &current); err != nil {
+		return nil, fmt.Errorf("parsing current project: %w", err)
+	}
+
+	return current, nil
+}
+
+func projectSpec(p map[string]interface{}) map[string]interface{} {
+	spec, _ := p["spec"].(map[string]interface{})
+	return spec
+}
+
+// serverManagedRoleFields are the fields of the project roles
+// that are managed by ArgoCD rather than the project manifest.
+var serverManagedRoleFields = []string{"jwtTokens"}
+
+// mergeProjectSpecs returns the current spec with the fields in the desired spec replaced.
+// The roles are replaced as a whole, except for the server-managed fields of the roles of the same names.
+func mergeProjectSpecs(desired, current map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{}
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range desired {
+		merged[k] = v
+	}
+
+	desiredRoles, ok := desired["roles"].([]interface{})
+	if !ok {
+		return merged
+	}
+
+	currentRoles := map[string]map[string]interface{}{}
+	currentRoleList, _ := current["roles"].([]interface{})
+	for _, r := range currentRoleList {
+		if r, ok := r.(map[string]interface{}); ok {
+			currentRoles[fmt.Sprint(r["name"])] = r
+		}
+	}
+
+	var roles []interface{}
+	for _, r := range desiredRoles {
+		d, ok := r.(map[string]interface{})
+		if !ok {
+			roles = append(roles, r)
+			continue
+		}
+
+		role := map[string]interface{}{}
+		for k, v := range d {
+			role[k] = v
+		}
+		if c, ok := currentRoles[fmt.Sprint(d["name"])]; ok {
+			for _, f := range serverManagedRoleFields {
+				if v, ok := c[f]; ok {
+					role[f] = v
+				}
+			}
+		}
+		roles = append(roles, role)
+	}
+	merged["roles"] = roles
+
+	return merged
+}
+
+// diffProjectSpecs returns the differences between the desired and current project specs,
+// one item per line, prefixed with + for additions and - for removals.
+// The keys missing in the desired spec are shown as removals.
+func diffProjectSpecs(desired, current map[string]interface{}) []string {
+	var keys []string
+	for k := range desired {
+		keys = append(keys, k)
+	}
+	for k := range current {
+		if _, ok := desired[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		d, c := desired[k], current[k]
+
+		dl, dok := d.([]interface{})
+		cl, cok := c.([]interface{})
+		if dok || cok {
+			want, got := canonicalSet(dl), canonicalSet(cl)
+			for _, v := range want.keys {
+				if _, ok := got.items[v]; !ok {
+					lines = append(lines, fmt.Sprintf("+ %s: %s", k, v))
+				}
+			}
+			for _, v := range got.keys {
+				if _, ok := want.items[v]; !ok {
+					lines = append(lines, fmt.Sprintf("- %s: %s", k, v))
+				}
+			}
+			continue
+		}
+
+		if canonical(d) != canonical(c) {
+			if c != nil {
+				lines = append(lines, fmt.Sprintf("- %s: %s", k, canonical(c)))
+			}
+			if d != nil {
+				lines = append(lines, fmt.Sprintf("+ %s: %s", k, canonical(d)))
+			}
+		}
+	}
+
+	return lines
+}
+
+type stringSet struct {
+	keys  []string
+	items map[string]struct{}
+}
+
+func canonicalSet(l []interface{}) stringSet {
+	s := stringSet{items: map[string]struct{}{}}
+	for _, v := range l {
+		k := canonical(v)
+		if _, ok := s.items[k]; ok {
+			continue
+		}
+		s.items[k] = struct{}{}
+		s.keys = append(s.keys, k)
+	}
+	return s
+}
+
+// canonical returns the JSON representation of v,
+// so that values decoded from YAML and JSON can be compared.
+func canonical(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
@@ -0,0 +1,97 @@
+package tools
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDiffProjectSpecs(t *testing.T) {
+	var desired map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+description: my project
+sourceRepos:
+- https://github.com/myorg/a.git
+- https://github.com/myorg/b.git
+destinations:
+- server: https://kubernetes.default.svc
+  namespace: default
+`), &desired))
+
+	var current map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "sourceRepos": ["*", "https://github.com/myorg/a.git"],
+  "destinations": [{"server": "https://kubernetes.default.svc", "namespace": "default"}],
+  "roles": [{"name": "ci"}]
+}`), &current))
+
+	require.Equal(t, []string{
+		"+ description: my project",
+		"+ sourceRepos: https://github.com/myorg/b.git",
+		"- sourceRepos: *",
+	}, diffProjectSpecs(mergeProjectSpecs(desired, current), current))
+
+	require.Empty(t, diffProjectSpecs(desired, desired))
+}
+
+func TestDiffProjectSpecs_RemovedKeys(t *testing.T) {
+	require.Equal(t, []string{
+		"- description: my project",
+		"- sourceRepos: https://github.com/myorg/a.git",
+	}, diffProjectSpecs(map[string]interface{}{}, map[string]interface{}{
+		"description": "my project",
+		"sourceRepos": []interface{}{"https://github.com/myorg/a.git"},
+	}))
+}
+
+func TestMergeProjectSpecs(t *testing.T) {
+	var desired map[string]interface{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+sourceRepos:
+- https://github.com/myorg/a.git
+roles:
+- name: ci
+  policies:
+  - p, proj:testproj:ci, applications, sync, testproj/*, allow
+- name: deploy
+`), &desired))
+
+	var current map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "sourceRepos": ["*"],
+  "signatureKeys": [{"keyID": "4AEE18F83AFDEB23"}],
+  "namespaceResourceBlacklist": [{"group": "", "kind": "ResourceQuota"}],
+  "roles": [
+    {"name": "ci", "description": "old", "jwtTokens": [{"iat": 1700000000, "id": "token1"}]},
+    {"name": "old", "jwtTokens": [{"iat": 1700000001}]}
+  ]
+}`), &current))
+
+	merged := mergeProjectSpecs(desired, current)
+
+	var want map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "sourceRepos": ["https://github.com/myorg/a.git"],
+  "signatureKeys": [{"keyID": "4AEE18F83AFDEB23"}],
+  "namespaceResourceBlacklist": [{"group": "", "kind": "ResourceQuota"}],
+  "roles": [
+    {"name": "ci", "policies": ["p, proj:testproj:ci, applications, sync, testproj/*, allow"], "jwtTokens": [{"iat": 1700000000, "id": "token1"}]},
+    {"name": "deploy"}
+  ]
+}`), &want))
+	require.Equal(t, canonical(want), canonical(merged))
+
+	// The JWT tokens and the unmanaged fields don't show up as differences.
+	require.Equal(t, []string{
+		`+ roles: {"jwtTokens":[{"iat":1700000000,"id":"token1"}],"name":"ci","policies":["p, proj:testproj:ci, applications, sync, testproj/*, allow"]}`,
+		`+ roles: {"name":"deploy"}`,
+		`- roles: {"description":"old","jwtTokens":[{"iat":1700000000,"id":"token1"}],"name":"ci"}`,
+		`- roles: {"jwtTokens":[{"iat":1700000001}],"name":"old"}`,
+		"+ sourceRepos: https://github.com/myorg/a.git",
+		"- sourceRepos: *",
+	}, diffProjectSpecs(merged, current))
+
+	require.Empty(t, diffProjectSpecs(mergeProjectSpecs(desired, merged), merged))
+}
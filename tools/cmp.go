@@ -0,0 +1,331 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CommandCMP                 = "cmp"
+	FlagCMPAction              = "action"
+	FlagCMPNamespace           = "namespace"
+	FlagCMPDeployment          = "deployment"
+	FlagCMPName                = "name"
+	FlagCMPType                = "type"
+	FlagCMPImage               = "image"
+	FlagCMPFile                = "file"
+	FlagCMPOutputDir           = "output-dir"
+	CMPActionGenerate          = "generate"
+	CMPActionDiff              = "diff"
+	CMPActionApply             = "apply"
+	CMPTypeKompose             = "kompose"
+	CMPTypeKomposeVals         = "kompose_vals"
+	DefaultCMPNamespace        = "argocd"
+	DefaultCMPDeployment       = "argocd-repo-server"
+	DefaultCMPFile             = "docker-compose.yml"
+	CMPConfigMapFileName       = "configmap.yaml"
+	CMPRepoServerPatchFileName = "repo-server-patch.yaml"
+)
+
+// CMPOptions is the options for generating, diffing and applying
+// the ArgoCD Config Management Plugin that kargo uses to let
+// ArgoCD deploy kompose (and optionally vals) applications.
+type CMPOptions struct {
+	// Action is one of generate, diff, and apply.
+	Action string
+	// Namespace is the namespace where argocd-repo-server runs.
+	Namespace string
+	// Deployment is the name of the argocd-repo-server deployment
+	// to which the plugin sidecar is added.
+	Deployment string
+	// Name is the name of the plugin.
+	// It must match the --config-management-plugin flag of argocd-app-create.
+	Name string
+	// Type is either kompose or kompose_vals.
+	Type string
+	// Image is the container image of the plugin sidecar.
+	// It needs to contain kompose, and vals for kompose_vals.
+	// It's required, and is better pinned to a tag or a digest
+	// so that argocd-repo-server isn't changed without the manifests being changed.
+	Image string
+	// File is the name of the compose file in the path of the application.
+	// Defaults to docker-compose.yml.
+	File string
+	// KomposeArgs is the list of additional arguments passed to kompose-convert.
+	KomposeArgs []string
+	// OutputDir is the directory to write the generated files to.
+	// Used only by the generate action.
+	OutputDir string
+}
+
+// CMPManifests is the set of Kubernetes manifests required to
+// install the config management plugin.
+type CMPManifests struct {
+	// ConfigMap is the ConfigMap containing the plugin.yaml.
+	ConfigMap []byte
+	// RepoServerPatch is the strategic merge patch to
+	// add the plugin sidecar to argocd-repo-server.
+	RepoServerPatch []byte
+}
+
+// CMP generates, diffs, or applies the config management plugin.
+//
+// generate writes the ConfigMap and the argocd-repo-server patch to OutputDir,
+// so that you can git-commit them for your GitOps automation to deploy.
+//
+// diff and apply run kubectl against the current kubeconfig context.
+// diff compares the ConfigMap and argocd-repo-server patched with the sidecar
+// against the live ones, without changing them.
+func CMP(ctx context.Context, opts CMPOptions) error {
+	opts = opts.withDefaults()
+
+	m, err := GenerateCMPManifests(opts)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Action {
+	case CMPActionGenerate:
+		if opts.OutputDir == "" {
+			return fmt.Errorf("%s must be set", FlagCMPOutputDir)
+		}
+		return m.writeTo(opts.OutputDir)
+	case CMPActionDiff, CMPActionApply:
+	default:
+		return fmt.Errorf("unsupported %s: %q", FlagCMPAction, opts.Action)
+	}
+
+	dir, err := os.MkdirTemp("", "kargo-cmp")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := m.writeTo(dir); err != nil {
+		return err
+	}
+
+	cm := filepath.Join(dir, CMPConfigMapFileName)
+	patch := filepath.Join(dir, CMPRepoServerPatchFileName)
+
+	if opts.Action == CMPActionDiff {
+		// kubectl-diff exits with 1 when there are differences,
+		// which isn't an error for us.
+		if err := kubectl(ctx, "-n", opts.Namespace, "diff", "-f", cm); err != nil && exitCode(err) != 1 {
+			return fmt.Errorf("running kubectl diff: %w", err)
+		}
+
+		// The patched deployment is rendered by the server,
+		// so that it's diffed as a whole against the live one.
+		patched, err := kubectlOutput(ctx, "-n", opts.Namespace, "patch", "deployment", opts.Deployment, "--type=strategic", "--patch-file", patch, "--dry-run=server", "--output=yaml")
+		if err != nil {
+			return fmt.Errorf("running kubectl patch --dry-run=server: %w", err)
+		}
+
+		deploy := filepath.Join(dir, "repo-server.yaml")
+		if err := os.WriteFile(deploy, patched, 0644); err != nil {
+			return fmt.Errorf("writing patched deployment: %w", err)
+		}
+
+		if err := kubectl(ctx, "-n", opts.Namespace, "diff", "-f", deploy); err != nil && exitCode(err) != 1 {
+			return fmt.Errorf("running kubectl diff: %w", err)
+		}
+
+		return nil
+	}
+
+	if err := kubectl(ctx, "-n", opts.Namespace, "apply", "-f", cm); err != nil {
+		return fmt.Errorf("running kubectl apply: %w", err)
+	}
+
+	if err := kubectl(ctx, "-n", opts.Namespace, "patch", "deployment", opts.Deployment, "--type=strategic", "--patch-file", patch); err != nil {
+		return fmt.Errorf("running kubectl patch: %w", err)
+	}
+
+	return nil
+}
+
+func (opts CMPOptions) withDefaults() CMPOptions {
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultCMPNamespace
+	}
+	if opts.Deployment == "" {
+		opts.Deployment = DefaultCMPDeployment
+	}
+	if opts.File == "" {
+		opts.File = DefaultCMPFile
+	}
+	if opts.Type == "" {
+		opts.Type = CMPTypeKompose
+	}
+	return opts
+}
+
+func (m *CMPManifests) writeTo(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, CMPConfigMapFileName), m.ConfigMap, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", CMPConfigMapFileName, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, CMPRepoServerPatchFileName), m.RepoServerPatch, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", CMPRepoServerPatchFileName, err)
+	}
+
+	return nil
+}
+
+// GenerateCMPManifests generates the ConfigMap and the argocd-repo-server patch
+// for the config management plugin.
+func GenerateCMPManifests(opts CMPOptions) (*CMPManifests, error) {
+	opts = opts.withDefaults()
+
+	if opts.Name == "" {
+		return nil, fmt.Errorf("%s must be set", FlagCMPName)
+	}
+
+	if opts.Image == "" {
+		return nil, fmt.Errorf("%s must be set", FlagCMPImage)
+	}
+
+	file := shellQuote(opts.File)
+	komposeArgs := ""
+	for _, a := range opts.KomposeArgs {
+		komposeArgs += " " + shellQuote(a)
+	}
+
+	var generate string
+	switch opts.Type {
+	case CMPTypeKompose:
+		generate = "kompose convert --stdout -f " + file + komposeArgs
+	case CMPTypeKomposeVals:
+		generate = "vals exec --stream-yaml " + file + " -- kompose convert --stdout -f -" + komposeArgs
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q", FlagCMPType, opts.Type)
+	}
+
+	plugin, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ConfigManagementPlugin",
+		"metadata": map[string]interface{}{
+			"name": opts.Name,
+		},
+		"spec": map[string]interface{}{
+			"discover": map[string]interface{}{
+				"fileName": opts.File,
+			},
+			"generate": map[string]interface{}{
+				"command": []string{"sh", "-c"},
+				"args":    []string{generate},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling plugin.yaml: %w", err)
+	}
+
+	configMapName := cmpConfigMapName(opts.Name)
+
+	cm, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      configMapName,
+			"namespace": opts.Namespace,
+		},
+		"data": map[string]interface{}{
+			"plugin.yaml": string(plugin),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling configmap: %w", err)
+	}
+
+	sidecar := "cmp-" + opts.Name
+
+	patch, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      opts.Deployment,
+			"namespace": opts.Namespace,
+		},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":    sidecar,
+							"image":   opts.Image,
+							"command": []string{"/var/run/argocd/argocd-cmp-server"},
+							"securityContext": map[string]interface{}{
+								"runAsNonRoot": true,
+								"runAsUser":    999,
+							},
+							"volumeMounts": []interface{}{
+								map[string]interface{}{"name": "var-files", "mountPath": "/var/run/argocd"},
+								map[string]interface{}{"name": "plugins", "mountPath": "/home/argocd/cmp-server/plugins"},
+								map[string]interface{}{"name": sidecar, "mountPath": "/home/argocd/cmp-server/config/plugin.yaml", "subPath": "plugin.yaml"},
+								map[string]interface{}{"name": sidecar + "-tmp", "mountPath": "/tmp"},
+							},
+						},
+					},
+					"volumes": []interface{}{
+						map[string]interface{}{"name": sidecar, "configMap": map[string]interface{}{"name": configMapName}},
+						map[string]interface{}{"name": sidecar + "-tmp", "emptyDir": map[string]interface{}{}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling repo server patch: %w", err)
+	}
+
+	return &CMPManifests{
+		ConfigMap:       cm,
+		RepoServerPatch: patch,
+	}, nil
+}
+
+func cmpConfigMapName(plugin string) string {
+	return "argocd-cmp-" + plugin
+}
+
+// shellQuote quotes s for sh unless it consists only of safe characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:@,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
+
+func kubectl(ctx context.Context, args ...string) error {
+	c := exec.CommandContext(ctx, "kubectl", args...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+func kubectlOutput(ctx context.Context, args ...string) ([]byte, error) {
+	c := exec.CommandContext(ctx, "kubectl", args...)
+	c.Stderr = os.Stderr
+	return c.Output()
+}
+
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
@@ -0,0 +1,141 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestGenerateCMPManifests(t *testing.T) {
+	m, err := GenerateCMPManifests(CMPOptions{
+		Name:        "kargo",
+		Type:        CMPTypeKomposeVals,
+		Image:       "example.com/kargo-cmp:v1",
+		File:        "compose.yml",
+		KomposeArgs: []string{"--profile", "web app"},
+	})
+	require.NoError(t, err)
+
+	var cm struct {
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+		Data map[string]string `yaml:"data"`
+	}
+	require.NoError(t, yaml.Unmarshal(m.ConfigMap, &cm))
+	require.Equal(t, "argocd-cmp-kargo", cm.Metadata.Name)
+	require.Equal(t, "argocd", cm.Metadata.Namespace)
+
+	var plugin struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+		Spec struct {
+			Discover struct {
+				FileName string `yaml:"fileName"`
+			} `yaml:"discover"`
+			Generate struct {
+				Command []string `yaml:"command"`
+				Args    []string `yaml:"args"`
+			} `yaml:"generate"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(cm.Data["plugin.yaml"]), &plugin))
+	require.Equal(t, "kargo", plugin.Metadata.Name)
+	require.Equal(t, "compose.yml", plugin.Spec.Discover.FileName)
+	require.Equal(t, []string{"vals exec --stream-yaml compose.yml -- kompose convert --stdout -f - --profile 'web app'"}, plugin.Spec.Generate.Args)
+
+	var patch struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Containers []struct {
+						Name  string `yaml:"name"`
+						Image string `yaml:"image"`
+					} `yaml:"containers"`
+					Volumes []struct {
+						Name      string `yaml:"name"`
+						ConfigMap struct {
+							Name string `yaml:"name"`
+						} `yaml:"configMap"`
+					} `yaml:"volumes"`
+				} `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal(m.RepoServerPatch, &patch))
+	require.Equal(t, "argocd-repo-server", patch.Metadata.Name)
+	require.Len(t, patch.Spec.Template.Spec.Containers, 1)
+	require.Equal(t, "cmp-kargo", patch.Spec.Template.Spec.Containers[0].Name)
+	require.Equal(t, "example.com/kargo-cmp:v1", patch.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, "argocd-cmp-kargo", patch.Spec.Template.Spec.Volumes[0].ConfigMap.Name)
+}
+
+func TestGenerateCMPManifests_Errors(t *testing.T) {
+	_, err := GenerateCMPManifests(CMPOptions{})
+	require.EqualError(t, err, "name must be set")
+
+	_, err = GenerateCMPManifests(CMPOptions{Name: "kargo"})
+	require.EqualError(t, err, "image must be set")
+
+	_, err = GenerateCMPManifests(CMPOptions{Name: "kargo", Image: "example.com/kargo-cmp:v1", Type: "helm"})
+	require.EqualError(t, err, `unsupported type: "helm"`)
+}
+
+func TestCMP_Generate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cmp")
+
+	require.NoError(t, CMP(context.Background(), CMPOptions{
+		Action:    CMPActionGenerate,
+		Name:      "kargo",
+		Image:     "example.com/kargo-cmp:v1",
+		OutputDir: dir,
+	}))
+
+	for _, f := range []string{CMPConfigMapFileName, CMPRepoServerPatchFileName} {
+		_, err := os.Stat(filepath.Join(dir, f))
+		require.NoError(t, err)
+	}
+}
+
+func TestCMP_Diff(t *testing.T) {
+	// The fake kubectl records its arguments, renders the patched deployment,
+	// and exits with 1 on diff as there are differences.
+	bin := t.TempDir()
+	log := filepath.Join(t.TempDir(), "kubectl-args")
+	writeFiles(t, bin, map[string]string{
+		"kubectl": `#!/bin/sh
+printf "%s\\n" "$*" >> ` + log + `
+case "$3" in
+patch) echo "kind: Deployment" ;;
+diff) exit 1 ;;
+esac
+`,
+	})
+	require.NoError(t, os.Chmod(filepath.Join(bin, "kubectl"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.NoError(t, CMP(context.Background(), CMPOptions{
+		Action: CMPActionDiff,
+		Name:   "kargo",
+		Image:  "example.com/kargo-cmp:v1",
+	}))
+
+	data, err := os.ReadFile(log)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	require.Regexp(t, `^-n argocd diff -f .+/configmap\.yaml$`, lines[0])
+	require.Regexp(t, `^-n argocd patch deployment argocd-repo-server --type=strategic --patch-file .+/repo-server-patch\.yaml --dry-run=server --output=yaml$`, lines[1])
+	require.Regexp(t, `^-n argocd diff -f .+/repo-server\.yaml$`, lines[2])
+}
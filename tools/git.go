@@ -0,0 +1,585 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+const (
+	CommandGit             = "git"
+	FlagGitBackend         = "backend"
+	FlagGitAction          = "action"
+	FlagGitDir             = "dir"
+	FlagGitRepo            = "repo"
+	FlagGitBranch          = "branch"
+	FlagGitStartPoint      = "start-point"
+	FlagGitRemote          = "remote"
+	FlagGitMessage         = "message"
+	FlagGitUserName        = "user-name"
+	FlagGitUserEmail       = "user-email"
+	FlagGitTokenEnv        = "token-env"
+	FlagGitForce           = "force"
+	GitActionClone         = "clone"
+	GitActionCheckout      = "checkout"
+	GitActionAdd           = "add"
+	GitActionCommit        = "commit"
+	GitActionPush          = "push"
+	GitBackendShell        = "shell"
+	GitBackendGoGit        = "go-git"
+	DefaultGitRemote       = "origin"
+	DefaultGitUserName     = "kargo"
+	gitCredentialHelperEnv = "KARGO_GIT_TOKEN"
+)
+
+// ErrNothingToCommit is returned by GitBackend.Commit
+// when the worktree has no changes to commit.
+var ErrNothingToCommit = errors.New("nothing to commit")
+
+// GitError is the error returned by GitBackend,
+// which tells which operation failed on which repository.
+type GitError struct {
+	Op  string
+	Dir string
+	Err error
+}
+
+func (e *GitError) Error() string {
+	return fmt.Sprintf("git %s in %s: %v", e.Op, e.Dir, e.Err)
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// GitAuthor is the author and committer of commits.
+type GitAuthor struct {
+	Name  string
+	Email string
+}
+
+// GitBackend is the set of git operations required by the GitOps flow.
+//
+// There are two implementations.
+// ShellGit runs the git binary, whereas GoGit is implemented in pure Go
+// and doesn't require the git binary.
+type GitBackend interface {
+	// Clone clones the repository at url into dir.
+	Clone(ctx context.Context, url, dir string) error
+	// Checkout creates the branch from startPoint and checks it out.
+	// startPoint is a branch of the remote, like origin/main.
+	Checkout(ctx context.Context, dir, branch, startPoint string) error
+	// AddAll stages all the changes in the worktree, including deletions.
+	AddAll(ctx context.Context, dir string) error
+	// Commit commits the staged changes, signed according to signing.
+	// It returns ErrNothingToCommit if there's nothing to commit.
+	Commit(ctx context.Context, dir, message string, author GitAuthor, signing GitSigning) error
+	// Push pushes the branch to the remote.
+	// If force is true, the remote branch is overwritten even if it has diverged.
+	Push(ctx context.Context, dir, remote, branch string, force bool) error
+	// Changed returns true if the tree of HEAD differs from the tree of ref.
+	// ref can be a branch of the remote, like origin/main.
+	Changed(ctx context.Context, dir, ref string) (bool, error)
+	// Diff returns the diffstat of HEAD from ref, followed by the patch if patch is true.
+	Diff(ctx context.Context, dir, ref string, patch bool) (string, error)
+	// RemoteURL returns the URL of the remote.
+	RemoteURL(ctx context.Context, dir, remote string) (string, error)
+}
+
+// NewGitBackend returns the GitBackend named name.
+// token is used to authenticate against https remotes.
+func NewGitBackend(name, token string) (GitBackend, error) {
+	switch name {
+	case "", GitBackendShell:
+		return &ShellGit{Token: token}, nil
+	case GitBackendGoGit:
+		return &GoGit{Token: token}, nil
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q", FlagGitBackend, name)
+	}
+}
+
+type GitOptions struct {
+	// Backend is either shell or go-git. Defaults to shell.
+	Backend string
+	// Action is one of clone, checkout, add, commit, and push.
+	Action string
+	// Dir is the local repository.
+	Dir string
+	// Repo is the URL of the repository to clone.
+	Repo string
+	// Branch is the branch to checkout or push.
+	Branch string
+	// StartPoint is the remote branch to create Branch from.
+	// For push, the push is skipped if Branch has no changes from StartPoint.
+	StartPoint string
+	// Remote defaults to origin.
+	Remote string
+	// Message is the commit message.
+	Message string
+	// UserName and UserEmail are the author of the commit.
+	UserName  string
+	UserEmail string
+	// TokenEnv is the name of the environment variable that contains
+	// the token to authenticate against the remote.
+	TokenEnv string
+	// Force makes push overwrite the remote branch.
+	Force bool
+	// GitHubAppID, GitHubAppInstallationID, GitHubAppPrivateKeyEnv and APIURL make clone and push
+	// authenticate as the GitHub App when the token is not set in TokenEnv.
+	// Repo is required to get the installation token for the repository.
+	// See GitHubAppTokenOptions for details.
+	GitHubAppID             int64
+	GitHubAppInstallationID int64
+	GitHubAppPrivateKeyEnv  string
+	APIURL                  string
+	// SigningFormat and SigningKey configure commit signing.
+	// See GitSigning for details.
+	SigningFormat string
+	SigningKey    string
+}
+
+// Git runs the git operation specified by opts.Action
+// with the backend specified by opts.Backend.
+func Git(ctx context.Context, opts GitOptions) error {
+	var token string
+	if opts.TokenEnv != "" {
+		token = os.Getenv(opts.TokenEnv)
+	}
+
+	if token == "" && opts.GitHubAppID != 0 && (opts.Action == GitActionClone || opts.Action == GitActionPush) {
+		var err error
+		token, err = GitHubAppTokenOptions{
+			Repo:           opts.Repo,
+			AppID:          opts.GitHubAppID,
+			InstallationID: opts.GitHubAppInstallationID,
+			PrivateKeyEnv:  opts.GitHubAppPrivateKeyEnv,
+			APIURL:         opts.APIURL,
+		}.token(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := NewGitBackend(opts.Backend, token)
+	if err != nil {
+		return err
+	}
+
+	if opts.Dir == "" {
+		return fmt.Errorf("%s must be set", FlagGitDir)
+	}
+
+	remote := opts.Remote
+	if remote == "" {
+		remote = DefaultGitRemote
+	}
+
+	switch opts.Action {
+	case GitActionClone:
+		if opts.Repo == "" {
+			return fmt.Errorf("%s must be set", FlagGitRepo)
+		}
+		return b.Clone(ctx, opts.Repo, opts.Dir)
+	case GitActionCheckout:
+		if opts.Branch == "" || opts.StartPoint == "" {
+			return fmt.Errorf("%s and %s must be set", FlagGitBranch, FlagGitStartPoint)
+		}
+		return b.Checkout(ctx, opts.Dir, opts.Branch, opts.StartPoint)
+	case GitActionAdd:
+		return b.AddAll(ctx, opts.Dir)
+	case GitActionCommit:
+		if opts.Message == "" {
+			return fmt.Errorf("%s must be set", FlagGitMessage)
+		}
+		signing := GitSigning{Format: opts.SigningFormat, Key: opts.SigningKey}
+		if err := signing.Validate(); err != nil {
+			return err
+		}
+		err := b.Commit(ctx, opts.Dir, opts.Message, GitAuthor{Name: opts.UserName, Email: opts.UserEmail}, signing)
+		if errors.Is(err, ErrNothingToCommit) {
+			fmt.Printf("no changes to commit in %s\n", opts.Dir)
+			return nil
+		}
+		return err
+	case GitActionPush:
+		if opts.Branch == "" {
+			return fmt.Errorf("%s must be set", FlagGitBranch)
+		}
+		if opts.StartPoint != "" {
+			changed, err := b.Changed(ctx, opts.Dir, opts.StartPoint)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				fmt.Printf("no changes from %s, skipping push\n", opts.StartPoint)
+				return nil
+			}
+		}
+		return b.Push(ctx, opts.Dir, remote, opts.Branch, opts.Force)
+	default:
+		return fmt.Errorf("unsupported %s: %q", FlagGitAction, opts.Action)
+	}
+}
+
+// ShellGit is the GitBackend that runs the git binary.
+type ShellGit struct {
+	// Token is used to authenticate against https remotes
+	// via the git credential helper.
+	Token string
+}
+
+var _ GitBackend = &ShellGit{}
+
+func (g *ShellGit) Clone(ctx context.Context, url, dir string) error {
+	args := []string{"clone"}
+	if strings.HasPrefix(url, "https://") {
+		// The helper reads the token from the environment variable at runtime,
+		// so that the token is never written to the repository config.
+		args = append(args, "--config", `credential.helper=!f() { echo username=kargo; echo "password=$`+gitCredentialHelperEnv+`"; }; f`)
+	}
+	args = append(args, url, dir)
+
+	_, err := g.run(ctx, "", "clone", args...)
+	return err
+}
+
+func (g *ShellGit) Checkout(ctx context.Context, dir, branch, startPoint string) error {
+	_, err := g.run(ctx, dir, "checkout", "checkout", "-b", branch, startPoint)
+	return err
+}
+
+func (g *ShellGit) AddAll(ctx context.Context, dir string) error {
+	_, err := g.run(ctx, dir, "add", "add", "--all", ".")
+	return err
+}
+
+func (g *ShellGit) Commit(ctx context.Context, dir, message string, author GitAuthor, signing GitSigning) error {
+	status, err := g.run(ctx, dir, "status", "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(status) == "" {
+		return &GitError{Op: "commit", Dir: dir, Err: ErrNothingToCommit}
+	}
+
+	name := author.Name
+	if name == "" {
+		name = DefaultGitUserName
+	}
+
+	args := []string{"-c", "user.name=" + name, "-c", "user.email=" + author.Email}
+	args = append(args, signing.ShellArgs()...)
+	args = append(args, "commit", "-m", message)
+	if signing.Enabled() {
+		args = append(args, "--gpg-sign")
+	}
+
+	_, err = g.run(ctx, dir, "commit", args...)
+	return err
+}
+
+func (g *ShellGit) Push(ctx context.Context, dir, remote, branch string, force bool) error {
+	args := []string{"push"}
+	if force {
+		args = append(args, "--force")
+	}
+	args = append(args, remote, branch)
+
+	_, err := g.run(ctx, dir, "push", args...)
+	return err
+}
+
+func (g *ShellGit) Changed(ctx context.Context, dir, ref string) (bool, error) {
+	out, err := g.run(ctx, dir, "rev-parse", "rev-parse", ref+"^{tree}", "HEAD^{tree}")
+	if err != nil {
+		return false, err
+	}
+
+	trees := strings.Fields(out)
+	if len(trees) != 2 {
+		return false, &GitError{Op: "rev-parse", Dir: dir, Err: fmt.Errorf("unexpected output: %q", out)}
+	}
+
+	return trees[0] != trees[1], nil
+}
+
+func (g *ShellGit) Diff(ctx context.Context, dir, ref string, patch bool) (string, error) {
+	args := []string{"diff", "--stat"}
+	if patch {
+		args = append(args, "--patch-with-raw")
+	}
+	args = append(args, ref, "HEAD")
+
+	return g.run(ctx, dir, "diff", args...)
+}
+
+func (g *ShellGit) RemoteURL(ctx context.Context, dir, remote string) (string, error) {
+	out, err := g.run(ctx, dir, "remote", "remote", "get-url", remote)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// run runs git with args in dir.
+// op is the operation to be reported in the error.
+func (g *ShellGit) run(ctx context.Context, dir, op string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "git", args...)
+	c.Dir = dir
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	c.Env = append(os.Environ(), gitCredentialHelperEnv+"="+g.Token)
+	if err := c.Run(); err != nil {
+		return "", &GitError{Op: op, Dir: dir, Err: fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))}
+	}
+
+	return stdout.String(), nil
+}
+
+// GoGit is the GitBackend implemented in pure Go.
+// It doesn't require the git binary.
+type GoGit struct {
+	// Token is used to authenticate against https remotes.
+	Token string
+}
+
+var _ GitBackend = &GoGit{}
+
+func (g *GoGit) auth(url string) transport.AuthMethod {
+	if g.Token == "" || !strings.HasPrefix(url, "https://") {
+		// ssh remotes are authenticated via the ssh agent by default.
+		return nil
+	}
+
+	return &http.BasicAuth{
+		Username: "kargo",
+		Password: g.Token,
+	}
+}
+
+func (g *GoGit) Clone(ctx context.Context, url, dir string) error {
+	_, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:  url,
+		Auth: g.auth(url),
+	})
+	if err != nil {
+		return &GitError{Op: "clone", Dir: dir, Err: err}
+	}
+	return nil
+}
+
+func (g *GoGit) Checkout(ctx context.Context, dir, branch, startPoint string) error {
+	r, wt, err := g.open(dir)
+	if err != nil {
+		return &GitError{Op: "checkout", Dir: dir, Err: err}
+	}
+
+	remote, remoteBranch, ok := strings.Cut(startPoint, "/")
+	if !ok {
+		return &GitError{Op: "checkout", Dir: dir, Err: fmt.Errorf("start point must be <remote>/<branch>, but got %s", startPoint)}
+	}
+
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName(remote, remoteBranch), true)
+	if err != nil {
+		return &GitError{Op: "checkout", Dir: dir, Err: fmt.Errorf("resolving %s: %w", startPoint, err)}
+	}
+
+	if err := wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Hash:   ref.Hash(),
+		Create: true,
+	}); err != nil {
+		return &GitError{Op: "checkout", Dir: dir, Err: err}
+	}
+
+	return nil
+}
+
+func (g *GoGit) AddAll(ctx context.Context, dir string) error {
+	_, wt, err := g.open(dir)
+	if err != nil {
+		return &GitError{Op: "add", Dir: dir, Err: err}
+	}
+
+	if err := wt.AddWithOptions(&git.AddOptions{All: true}); err != nil {
+		return &GitError{Op: "add", Dir: dir, Err: err}
+	}
+
+	return nil
+}
+
+func (g *GoGit) Commit(ctx context.Context, dir, message string, author GitAuthor, signing GitSigning) error {
+	_, wt, err := g.open(dir)
+	if err != nil {
+		return &GitError{Op: "commit", Dir: dir, Err: err}
+	}
+
+	status, err := wt.Status()
+	if err != nil {
+		return &GitError{Op: "commit", Dir: dir, Err: err}
+	}
+	if status.IsClean() {
+		return &GitError{Op: "commit", Dir: dir, Err: ErrNothingToCommit}
+	}
+
+	name := author.Name
+	if name == "" {
+		name = DefaultGitUserName
+	}
+
+	sig := &object.Signature{
+		Name:  name,
+		Email: author.Email,
+		When:  time.Now(),
+	}
+
+	opts := &git.CommitOptions{Author: sig, Committer: sig}
+	if signing.Enabled() {
+		opts.Signer, err = signing.goGitSigner()
+		if err != nil {
+			return &GitError{Op: "commit", Dir: dir, Err: err}
+		}
+	}
+
+	if _, err := wt.Commit(message, opts); err != nil {
+		return &GitError{Op: "commit", Dir: dir, Err: err}
+	}
+
+	return nil
+}
+
+func (g *GoGit) Push(ctx context.Context, dir, remote, branch string, force bool) error {
+	r, _, err := g.open(dir)
+	if err != nil {
+		return &GitError{Op: "push", Dir: dir, Err: err}
+	}
+
+	rem, err := r.Remote(remote)
+	if err != nil {
+		return &GitError{Op: "push", Dir: dir, Err: err}
+	}
+
+	var url string
+	if urls := rem.Config().URLs; len(urls) > 0 {
+		url = urls[0]
+	}
+
+	ref := plumbing.NewBranchReferenceName(branch)
+	err = r.PushContext(ctx, &git.PushOptions{
+		RemoteName: remote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(ref + ":" + ref)},
+		Auth:       g.auth(url),
+		Force:      force,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return &GitError{Op: "push", Dir: dir, Err: err}
+	}
+
+	return nil
+}
+
+func (g *GoGit) Changed(ctx context.Context, dir, ref string) (bool, error) {
+	r, _, err := g.open(dir)
+	if err != nil {
+		return false, &GitError{Op: "rev-parse", Dir: dir, Err: err}
+	}
+
+	base, err := goGitCommit(r, ref)
+	if err != nil {
+		return false, &GitError{Op: "rev-parse", Dir: dir, Err: err}
+	}
+
+	head, err := goGitCommit(r, "HEAD")
+	if err != nil {
+		return false, &GitError{Op: "rev-parse", Dir: dir, Err: err}
+	}
+
+	return base.TreeHash != head.TreeHash, nil
+}
+
+func (g *GoGit) Diff(ctx context.Context, dir, ref string, patch bool) (string, error) {
+	r, _, err := g.open(dir)
+	if err != nil {
+		return "", &GitError{Op: "diff", Dir: dir, Err: err}
+	}
+
+	base, err := goGitCommit(r, ref)
+	if err != nil {
+		return "", &GitError{Op: "diff", Dir: dir, Err: err}
+	}
+
+	head, err := goGitCommit(r, "HEAD")
+	if err != nil {
+		return "", &GitError{Op: "diff", Dir: dir, Err: err}
+	}
+
+	p, err := base.PatchContext(ctx, head)
+	if err != nil {
+		return "", &GitError{Op: "diff", Dir: dir, Err: err}
+	}
+
+	out := p.Stats().String()
+	if patch {
+		out += "\n" + p.String()
+	}
+
+	return out, nil
+}
+
+func (g *GoGit) RemoteURL(ctx context.Context, dir, remote string) (string, error) {
+	r, _, err := g.open(dir)
+	if err != nil {
+		return "", &GitError{Op: "remote", Dir: dir, Err: err}
+	}
+
+	rem, err := r.Remote(remote)
+	if err != nil {
+		return "", &GitError{Op: "remote", Dir: dir, Err: err}
+	}
+
+	urls := rem.Config().URLs
+	if len(urls) == 0 {
+		return "", &GitError{Op: "remote", Dir: dir, Err: fmt.Errorf("remote %s has no url", remote)}
+	}
+
+	return urls[0], nil
+}
+
+// goGitCommit returns the commit that rev resolves to.
+func goGitCommit(r *git.Repository, rev string) (*object.Commit, error) {
+	h, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", rev, err)
+	}
+
+	return r.CommitObject(*h)
+}
+
+func (g *GoGit) open(dir string) (*git.Repository, *git.Worktree, error) {
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, wt, nil
+}
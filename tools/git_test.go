@@ -0,0 +1,220 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+// initBareRepo creates a bare repository whose main branch
+// contains a single commit that adds README.md.
+func initBareRepo(t *testing.T) string {
+	t.Helper()
+
+	bareDir := filepath.Join(t.TempDir(), "remote.git")
+	bare, err := git.PlainInit(bareDir, true)
+	require.NoError(t, err)
+	require.NoError(t, bare.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+
+	seedDir := t.TempDir()
+	seed, err := git.PlainInit(seedDir, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(seedDir, "README.md"), []byte("seed\n"), 0644))
+
+	wt, err := seed.Worktree()
+	require.NoError(t, err)
+	_, err = wt.Add("README.md")
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "seed", Email: "seed@example.com", When: time.Now()}
+	hash, err := wt.Commit("initial commit", &git.CommitOptions{Author: sig, Committer: sig})
+	require.NoError(t, err)
+	require.NoError(t, seed.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash)))
+
+	_, err = seed.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{bareDir}})
+	require.NoError(t, err)
+	require.NoError(t, seed.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"refs/heads/main:refs/heads/main"},
+	}))
+
+	return bareDir
+}
+
+func TestGitBackends(t *testing.T) {
+	backends := []string{GitBackendGoGit, GitBackendShell}
+
+	for _, name := range backends {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			if name == GitBackendShell {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git is not installed")
+				}
+			}
+
+			ctx := context.Background()
+			remote := initBareRepo(t)
+			dir := filepath.Join(t.TempDir(), "work")
+
+			b, err := NewGitBackend(name, "")
+			require.NoError(t, err)
+
+			require.NoError(t, b.Clone(ctx, remote, dir))
+			require.FileExists(t, filepath.Join(dir, "README.md"))
+
+			require.NoError(t, b.Checkout(ctx, dir, "kargo-head", "origin/main"))
+
+			err = b.Commit(ctx, dir, "empty", GitAuthor{}, GitSigning{})
+			require.ErrorIs(t, err, ErrNothingToCommit)
+
+			require.NoError(t, os.MkdirAll(filepath.Join(dir, "deploy"), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "deploy", "app.yaml"), []byte("kind: Deployment\n"), 0644))
+			require.NoError(t, os.Remove(filepath.Join(dir, "README.md")))
+
+			require.NoError(t, b.AddAll(ctx, dir))
+			require.NoError(t, b.Commit(ctx, dir, "automated commit", GitAuthor{Name: "kargo bot", Email: "bot@example.com"}, GitSigning{}))
+			require.NoError(t, b.Push(ctx, dir, "origin", "kargo-head", false))
+
+			r, err := git.PlainOpen(remote)
+			require.NoError(t, err)
+
+			ref, err := r.Reference(plumbing.NewBranchReferenceName("kargo-head"), true)
+			require.NoError(t, err)
+
+			c, err := r.CommitObject(ref.Hash())
+			require.NoError(t, err)
+			require.Equal(t, "automated commit", strings.TrimSpace(c.Message))
+			require.Equal(t, "kargo bot", c.Author.Name)
+			require.Equal(t, "bot@example.com", c.Author.Email)
+
+			tree, err := c.Tree()
+			require.NoError(t, err)
+			_, err = tree.File("deploy/app.yaml")
+			require.NoError(t, err)
+			_, err = tree.File("README.md")
+			require.ErrorIs(t, err, object.ErrFileNotFound)
+
+			main, err := r.Reference(plumbing.NewBranchReferenceName("main"), true)
+			require.NoError(t, err)
+			require.Equal(t, []plumbing.Hash{main.Hash()}, c.ParentHashes)
+		})
+	}
+}
+
+func TestGit_Validation(t *testing.T) {
+	ctx := context.Background()
+
+	err := Git(ctx, GitOptions{Backend: "svn", Action: GitActionAdd, Dir: "."})
+	require.EqualError(t, err, `unsupported backend: "svn"`)
+
+	err = Git(ctx, GitOptions{Backend: GitBackendGoGit, Action: GitActionClone, Dir: "."})
+	require.EqualError(t, err, "repo must be set")
+
+	err = Git(ctx, GitOptions{Backend: GitBackendGoGit, Action: "rebase", Dir: "."})
+	require.EqualError(t, err, `unsupported action: "rebase"`)
+}
+
+func TestGit_NoChanges(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{GitBackendGoGit, GitBackendShell} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			remote := initBareRepo(t)
+			dir := filepath.Join(t.TempDir(), "work")
+
+			run := func(action string, opts GitOptions) {
+				t.Helper()
+				opts.Backend = name
+				opts.Action = action
+				opts.Dir = dir
+				require.NoError(t, Git(ctx, opts))
+			}
+
+			run(GitActionClone, GitOptions{Repo: remote})
+			run(GitActionCheckout, GitOptions{Branch: "kargo-head", StartPoint: "origin/main"})
+			run(GitActionAdd, GitOptions{})
+			run(GitActionCommit, GitOptions{Message: "automated commit"})
+			run(GitActionPush, GitOptions{Branch: "kargo-head", StartPoint: "origin/main"})
+
+			r, err := git.PlainOpen(remote)
+			require.NoError(t, err)
+			_, err = r.Reference(plumbing.NewBranchReferenceName("kargo-head"), true)
+			require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+			b, err := NewGitBackend(name, "")
+			require.NoError(t, err)
+			changed, err := b.Changed(ctx, dir, "origin/main")
+			require.NoError(t, err)
+			require.False(t, changed)
+
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "app.yaml"), []byte("kind: Deployment\n"), 0644))
+			run(GitActionAdd, GitOptions{})
+			run(GitActionCommit, GitOptions{Message: "automated commit"})
+
+			changed, err = b.Changed(ctx, dir, "origin/main")
+			require.NoError(t, err)
+			require.True(t, changed)
+
+			stat, err := b.Diff(ctx, dir, "origin/main", false)
+			require.NoError(t, err)
+			require.Contains(t, stat, "app.yaml")
+			require.NotContains(t, stat, "+kind: Deployment")
+
+			diff, err := b.Diff(ctx, dir, "origin/main", true)
+			require.NoError(t, err)
+			require.Contains(t, diff, "+kind: Deployment")
+
+			url, err := b.RemoteURL(ctx, dir, "origin")
+			require.NoError(t, err)
+			require.Equal(t, remote, url)
+		})
+	}
+}
+
+func TestGitBackends_ForcePush(t *testing.T) {
+	for _, name := range []string{GitBackendGoGit, GitBackendShell} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			remote := initBareRepo(t)
+
+			b, err := NewGitBackend(name, "")
+			require.NoError(t, err)
+
+			// Each deployment recreates the stable head from the base branch,
+			// so the second push diverges from the first one.
+			for i, content := range []string{"v1", "v2"} {
+				dir := filepath.Join(t.TempDir(), "work")
+				require.NoError(t, b.Clone(ctx, remote, dir))
+				require.NoError(t, b.Checkout(ctx, dir, "kargo/myapp", "origin/main"))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "app.yaml"), []byte(content), 0644))
+				require.NoError(t, b.AddAll(ctx, dir))
+				require.NoError(t, b.Commit(ctx, dir, "deploy "+content, GitAuthor{}, GitSigning{}))
+
+				if i > 0 {
+					require.Error(t, b.Push(ctx, dir, "origin", "kargo/myapp", false))
+				}
+				require.NoError(t, b.Push(ctx, dir, "origin", "kargo/myapp", true))
+			}
+
+			r, err := git.PlainOpen(remote)
+			require.NoError(t, err)
+			ref, err := r.Reference(plumbing.NewBranchReferenceName("kargo/myapp"), true)
+			require.NoError(t, err)
+			c, err := r.CommitObject(ref.Hash())
+			require.NoError(t, err)
+			require.Equal(t, "deploy v2", strings.TrimSpace(c.Message))
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package tools
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	CommandGitHubAppToken       = "github-app-token"
+	FlagGitHubAppTokenRepo      = "repo"
+	FlagGitHubAppID             = "github-app-id"
+	FlagGitHubAppInstallationID = "github-app-installation-id"
+	FlagGitHubAppPrivateKeyEnv  = "github-app-private-key-env"
+	FlagGitHubAppAPIURL         = "api-url"
+
+	FlagCreatePullRequestGitHubAppID             = FlagGitHubAppID
+	FlagCreatePullRequestGitHubAppInstallationID = FlagGitHubAppInstallationID
+	FlagCreatePullRequestGitHubAppPrivateKeyEnv  = FlagGitHubAppPrivateKeyEnv
+)
+
+// GitHubAPIURL returns the base URL of the GitHub API for the git host.
+// It returns an empty string for github.com, which means the default https://api.github.com/,
+// and https://<host>/api/v3/ for GitHub Enterprise Server.
+func GitHubAPIURL(host string) string {
+	if host == "" || host == "github.com" || host == "www.github.com" {
+		return ""
+	}
+	return "https://" + host + "/api/v3/"
+}
+
+// newGitHubClient returns the GitHub client that sends requests via httpClient to apiURL.
+// apiURL defaults to https://api.github.com/.
+func newGitHubClient(httpClient *http.Client, apiURL string) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+
+	if apiURL != "" {
+		u, err := url.Parse(strings.TrimSuffix(apiURL, "/") + "/")
+		if err != nil {
+			return nil, fmt.Errorf("parsing GitHub API URL: %w", err)
+		}
+		client.BaseURL = u
+		client.UploadURL = u
+	}
+
+	return client, nil
+}
+
+// gitHubGraphQLURL returns the URL of the GraphQL API that corresponds to
+// the REST API at baseURL.
+// GitHub Enterprise Server serves the REST API at /api/v3/ and the GraphQL API at /api/graphql.
+func gitHubGraphQLURL(baseURL *url.URL) string {
+	u := *baseURL
+	if strings.HasSuffix(u.Path, "/api/v3/") {
+		u.Path = strings.TrimSuffix(u.Path, "v3/") + "graphql"
+	} else {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/graphql"
+	}
+	return u.String()
+}
+
+// GitHubApp is a GitHub App that kargo authenticates as,
+// instead of using a personal access token.
+type GitHubApp struct {
+	// AppID is the ID of the GitHub App.
+	AppID int64
+	// InstallationID is the ID of the installation of the app.
+	// It's looked up from the repository if zero.
+	InstallationID int64
+	// PrivateKey is the PEM-encoded private key of the app.
+	PrivateKey []byte
+	// APIURL is the base URL of the GitHub API. Defaults to https://api.github.com/.
+	APIURL string
+}
+
+// InstallationToken exchanges a JWT signed with the app's private key
+// for an installation access token that can access the repository.
+// The token can be used for both the GitHub API and git over https,
+// and expires in an hour.
+func (a GitHubApp) InstallationToken(ctx context.Context, repo *Repository) (string, error) {
+	if a.AppID == 0 {
+		return "", errors.New("github app ID is required")
+	}
+
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: jwt},
+	))
+	client, err := newGitHubClient(httpClient, a.APIURL)
+	if err != nil {
+		return "", err
+	}
+
+	installationID := a.InstallationID
+	if installationID == 0 {
+		inst, _, err := client.Apps.FindRepositoryInstallation(ctx, repo.Owner, repo.Name)
+		if err != nil {
+			return "", fmt.Errorf("calling find repository installation API for %s/%s: %w", repo.Owner, repo.Name, err)
+		}
+		installationID = inst.GetID()
+	}
+
+	tok, _, err := client.Apps.CreateInstallationToken(ctx, installationID, nil)
+	if err != nil {
+		return "", fmt.Errorf("calling create installation token API: %w", err)
+	}
+
+	if tok.GetToken() == "" {
+		return "", fmt.Errorf("assertion error: installation token for installation %d is empty", installationID)
+	}
+
+	return tok.GetToken(), nil
+}
+
+type GitHubAppTokenOptions struct {
+	// Repo is the git remote URL of the repository to access with the token.
+	Repo string
+	// AppID and InstallationID are the IDs of the GitHub App and its installation.
+	// The installation is looked up from the repository if InstallationID is zero.
+	AppID          int64
+	InstallationID int64
+	// PrivateKeyEnv is the name of the environment variable
+	// that contains the PEM-encoded private key of the app.
+	PrivateKeyEnv string
+	// APIURL defaults to the one for the host of the repository, like GitHubAPIURL.
+	APIURL string
+	// Out is where the token is written. Defaults to os.Stdout.
+	Out io.Writer
+}
+
+// WriteGitHubAppToken writes the installation token of the GitHub App for the repository.
+//
+// It's run by the git credential helper configured by kargo,
+// so that every git operation gets a fresh token, which expires in an hour,
+// no matter how long after the generation the commands are run.
+func WriteGitHubAppToken(ctx context.Context, opts GitHubAppTokenOptions) error {
+	token, err := opts.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	_, err = fmt.Fprintln(out, token)
+	return err
+}
+
+func (o GitHubAppTokenOptions) token(ctx context.Context) (string, error) {
+	if o.Repo == "" {
+		return "", fmt.Errorf("%s must be set", FlagGitHubAppTokenRepo)
+	}
+
+	if o.PrivateKeyEnv == "" {
+		return "", fmt.Errorf("%s must be set", FlagGitHubAppPrivateKeyEnv)
+	}
+
+	key := os.Getenv(o.PrivateKeyEnv)
+	if key == "" {
+		return "", fmt.Errorf("github app private key is not set in %s", o.PrivateKeyEnv)
+	}
+
+	repo, err := ParseRepository(o.Repo)
+	if err != nil {
+		return "", err
+	}
+
+	apiURL := o.APIURL
+	if apiURL == "" {
+		apiURL = GitHubAPIURL(repo.Host)
+	}
+
+	app := GitHubApp{
+		AppID:          o.AppID,
+		InstallationID: o.InstallationID,
+		PrivateKey:     []byte(key),
+		APIURL:         apiURL,
+	}
+
+	token, err := app.InstallationToken(ctx, repo)
+	if err != nil {
+		return "", fmt.Errorf("authenticating as github app %d: %w", o.AppID, err)
+	}
+
+	return token, nil
+}
+
+// jwt returns the JWT to authenticate as the app, signed with RS256.
+// See https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func (a GitHubApp) jwt(now time.Time) (string, error) {
+	key, err := parseRSAPrivateKey(a.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+
+	enc := func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return base64.RawURLEncoding.EncodeToString(data), nil
+	}
+
+	header, err := enc(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	// iat is set in the past to allow for clock drift,
+	// and exp must be no more than 10 minutes into the future.
+	claims, err := enc(map[string]interface{}{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.AppID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := header + "." + claims
+	digest := sha256.Sum256([]byte(signingInput))
+
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing github app JWT: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("github app private key must be PEM-encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing github app private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github app private key must be an RSA key, but got %T", key)
+	}
+
+	return rsaKey, nil
+}
@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func generateGitHubAppKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pemData := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	return key, pemData
+}
+
+func TestGitHubAPIURL(t *testing.T) {
+	for host, want := range map[string]string{
+		"":                  "",
+		"github.com":        "",
+		"ghe.example.com":   "https://ghe.example.com/api/v3/",
+		"git.example.co.jp": "https://git.example.co.jp/api/v3/",
+	} {
+		require.Equal(t, want, GitHubAPIURL(host), host)
+	}
+}
+
+func TestGitHubGraphQLURL(t *testing.T) {
+	for base, want := range map[string]string{
+		"https://api.github.com/":          "https://api.github.com/graphql",
+		"https://ghe.example.com/api/v3/":  "https://ghe.example.com/api/graphql",
+		"http://127.0.0.1:1234/":           "http://127.0.0.1:1234/graphql",
+		"http://127.0.0.1:1234/prefix/v3/": "http://127.0.0.1:1234/prefix/v3/graphql",
+	} {
+		u, err := url.Parse(base)
+		require.NoError(t, err)
+		require.Equal(t, want, gitHubGraphQLURL(u), base)
+	}
+}
+
+func TestGitHubApp_JWT(t *testing.T) {
+	key, pemData := generateGitHubAppKey(t)
+
+	now := time.Unix(1700000000, 0)
+	jwt, err := GitHubApp{AppID: 123, PrivateKey: pemData}.jwt(now)
+	require.NoError(t, err)
+
+	parts := strings.Split(jwt, ".")
+	require.Len(t, parts, 3)
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+	data, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &claims))
+	require.Equal(t, map[string]interface{}{
+		"iat": float64(now.Unix() - 60),
+		"exp": float64(now.Unix() + 540),
+		"iss": "123",
+	}, claims)
+
+	_, err = GitHubApp{AppID: 123, PrivateKey: []byte("not a key")}.jwt(now)
+	require.EqualError(t, err, "github app private key must be PEM-encoded")
+}
+
+func TestGitHubApp_InstallationToken(t *testing.T) {
+	_, pemData := generateGitHubAppKey(t)
+
+	var reqs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ey"), r.Header.Get("Authorization"))
+		reqs = append(reqs, r.Method+" "+r.URL.Path)
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /api/v3/repos/myorg/myrepo/installation":
+			fmt.Fprint(w, `{"id":5}`)
+		case "POST /api/v3/app/installations/5/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{"token":"ghs_installation"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	app := GitHubApp{
+		AppID:      123,
+		PrivateKey: pemData,
+		APIURL:     srv.URL + "/api/v3/",
+	}
+
+	token, err := app.InstallationToken(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"})
+	require.NoError(t, err)
+	require.Equal(t, "ghs_installation", token)
+	require.Equal(t, []string{
+		"GET /api/v3/repos/myorg/myrepo/installation",
+		"POST /api/v3/app/installations/5/access_tokens",
+	}, reqs)
+
+	// The installation lookup is skipped when the installation ID is given.
+	reqs = nil
+	app.InstallationID = 5
+	token, err = app.InstallationToken(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"})
+	require.NoError(t, err)
+	require.Equal(t, "ghs_installation", token)
+	require.Equal(t, []string{"POST /api/v3/app/installations/5/access_tokens"}, reqs)
+}
+
+func TestWriteGitHubAppToken(t *testing.T) {
+	_, pemData := generateGitHubAppKey(t)
+	t.Setenv("MY_APP_KEY", string(pemData))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST /api/v3/app/installations/5/access_tokens", r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"token":"ghs_installation"}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	opts := GitHubAppTokenOptions{
+		Repo:           "https://ghe.example.com/myorg/myrepo.git",
+		AppID:          123,
+		InstallationID: 5,
+		PrivateKeyEnv:  "MY_APP_KEY",
+		APIURL:         srv.URL + "/api/v3/",
+	}
+
+	var out bytes.Buffer
+	opts.Out = &out
+	require.NoError(t, WriteGitHubAppToken(context.Background(), opts))
+	require.Equal(t, "ghs_installation\n", out.String())
+
+	opts.PrivateKeyEnv = "MY_MISSING_KEY"
+	require.EqualError(t, WriteGitHubAppToken(context.Background(), opts), "github app private key is not set in MY_MISSING_KEY")
+
+	opts.Repo = ""
+	require.EqualError(t, WriteGitHubAppToken(context.Background(), opts), "repo must be set")
+}
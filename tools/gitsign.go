@@ -0,0 +1,191 @@
+package tools
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	FlagGitSigningFormat = "signing-format"
+	FlagGitSigningKey    = "signing-key"
+
+	GitSigningFormatSSH = "ssh"
+	GitSigningFormatGPG = "gpg"
+)
+
+// GitSigning is the configuration to sign commits.
+// The zero value means commits are not signed.
+type GitSigning struct {
+	// Format is either ssh or gpg.
+	Format string
+	// Key is the key to sign commits with.
+	//
+	// For ssh, it's the path to the private key.
+	// For gpg, it's the key ID for the shell backend,
+	// and the path to the ASCII-armored secret key for the go-git backend,
+	// which doesn't use the gpg keyring.
+	Key string
+}
+
+// Enabled returns true if commits are to be signed.
+func (s GitSigning) Enabled() bool {
+	return s.Format != ""
+}
+
+// Validate returns an error if the format is unsupported or the key is missing.
+func (s GitSigning) Validate() error {
+	switch s.Format {
+	case "":
+		return nil
+	case GitSigningFormatSSH, GitSigningFormatGPG:
+	default:
+		return fmt.Errorf("unsupported %s: %q", FlagGitSigningFormat, s.Format)
+	}
+
+	if s.Key == "" {
+		return fmt.Errorf("%s must be set to sign commits", FlagGitSigningKey)
+	}
+
+	return nil
+}
+
+// ShellArgs returns the git flags to sign the commit with the git binary.
+func (s GitSigning) ShellArgs() []string {
+	if !s.Enabled() {
+		return nil
+	}
+
+	format := "openpgp"
+	if s.Format == GitSigningFormatSSH {
+		format = "ssh"
+	}
+
+	return []string{"-c", "gpg.format=" + format, "-c", "user.signingkey=" + s.Key}
+}
+
+// goGitSigner returns the go-git signer that signs commits with the key.
+func (s GitSigning) goGitSigner() (git.Signer, error) {
+	data, err := os.ReadFile(s.Key)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+
+	switch s.Format {
+	case GitSigningFormatSSH:
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh signing key: %w", err)
+		}
+		return &sshSigner{signer: signer}, nil
+	case GitSigningFormatGPG:
+		keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("parsing gpg signing key: %w", err)
+		}
+		for _, e := range keyring {
+			if e.PrivateKey == nil {
+				continue
+			}
+			if e.PrivateKey.Encrypted {
+				return nil, errors.New("passphrase-protected gpg signing keys are not supported")
+			}
+			return &gpgSigner{entity: e}, nil
+		}
+		return nil, errors.New("no gpg secret key found in the signing key")
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q", FlagGitSigningFormat, s.Format)
+	}
+}
+
+// gpgSigner signs commits with an OpenPGP key.
+type gpgSigner struct {
+	entity *openpgp.Entity
+}
+
+func (s *gpgSigner) Sign(message io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sshSigner signs commits with an SSH key in the SSHSIG format,
+// like ssh-keygen -Y sign -n git does.
+// See https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.sshsig
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigNamespace = "git"
+	sshSigHash      = "sha512"
+)
+
+func (s *sshSigner) Sign(message io.Reader) ([]byte, error) {
+	h := sha512.New()
+	if _, err := io.Copy(h, message); err != nil {
+		return nil, err
+	}
+
+	var signed bytes.Buffer
+	signed.WriteString(sshSigMagic)
+	writeSSHString(&signed, []byte(sshSigNamespace))
+	writeSSHString(&signed, nil)
+	writeSSHString(&signed, []byte(sshSigHash))
+	writeSSHString(&signed, h.Sum(nil))
+
+	var (
+		sig *ssh.Signature
+		err error
+	)
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// ssh-rsa signatures use SHA-1, which is rejected by ssh-keygen -Y verify.
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed.Bytes(), ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signed.Bytes())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("signing with ssh key: %w", err)
+	}
+
+	var blob bytes.Buffer
+	blob.WriteString(sshSigMagic)
+	_ = binary.Write(&blob, binary.BigEndian, uint32(1))
+	writeSSHString(&blob, s.signer.PublicKey().Marshal())
+	writeSSHString(&blob, []byte(sshSigNamespace))
+	writeSSHString(&blob, nil)
+	writeSSHString(&blob, []byte(sshSigHash))
+	writeSSHString(&blob, ssh.Marshal(sig))
+
+	enc := base64.StdEncoding.EncodeToString(blob.Bytes())
+
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(enc) > 70 {
+		armored.WriteString(enc[:70] + "\n")
+		enc = enc[70:]
+	}
+	armored.WriteString(enc + "\n")
+	armored.WriteString("-----END SSH SIGNATURE-----\n")
+
+	return []byte(armored.String()), nil
+}
+
+func writeSSHString(buf *bytes.Buffer, b []byte) {
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
@@ -0,0 +1,113 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// commitFile clones remote into a new worktree and commits a file with b.
+func commitFile(t *testing.T, b GitBackend, remote string, signing GitSigning) string {
+	t.Helper()
+
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "work")
+
+	require.NoError(t, b.Clone(ctx, remote, dir))
+	require.NoError(t, b.Checkout(ctx, dir, "kargo-head", "origin/main"))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.yaml"), []byte("kind: Deployment\n"), 0644))
+	require.NoError(t, b.AddAll(ctx, dir))
+	require.NoError(t, b.Commit(ctx, dir, "signed commit", GitAuthor{Name: "kargo bot", Email: "bot@example.com"}, signing))
+
+	return dir
+}
+
+func TestGitBackends_SSHSigning(t *testing.T) {
+	for _, bin := range []string{"git", "ssh-keygen"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not installed", bin)
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keyDir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	keyFile := filepath.Join(keyDir, "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	allowedSigners := filepath.Join(keyDir, "allowed_signers")
+	require.NoError(t, os.WriteFile(allowedSigners, append([]byte("bot@example.com "), ssh.MarshalAuthorizedKey(sshPub)...), 0644))
+
+	for _, name := range []string{GitBackendGoGit, GitBackendShell} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			b, err := NewGitBackend(name, "")
+			require.NoError(t, err)
+
+			dir := commitFile(t, b, initBareRepo(t), GitSigning{Format: GitSigningFormatSSH, Key: keyFile})
+
+			out, err := exec.Command("git", "-C", dir, "-c", "gpg.ssh.allowedSignersFile="+allowedSigners, "verify-commit", "HEAD").CombinedOutput()
+			require.NoError(t, err, string(out))
+			require.Contains(t, string(out), `Good "git" signature for bot@example.com`)
+		})
+	}
+}
+
+func TestGoGit_GPGSigning(t *testing.T) {
+	entity, err := openpgp.NewEntity("kargo bot", "", "bot@example.com", nil)
+	require.NoError(t, err)
+
+	var secret bytes.Buffer
+	w, err := armor.Encode(&secret, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+
+	var public bytes.Buffer
+	w, err = armor.Encode(&public, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+
+	keyFile := filepath.Join(t.TempDir(), "key.asc")
+	require.NoError(t, os.WriteFile(keyFile, secret.Bytes(), 0600))
+
+	dir := commitFile(t, &GoGit{}, initBareRepo(t), GitSigning{Format: GitSigningFormatGPG, Key: keyFile})
+
+	r, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	head, err := r.Head()
+	require.NoError(t, err)
+	c, err := r.CommitObject(head.Hash())
+	require.NoError(t, err)
+
+	_, err = c.Verify(public.String())
+	require.NoError(t, err)
+}
+
+func TestGit_SigningValidation(t *testing.T) {
+	ctx := context.Background()
+
+	err := Git(ctx, GitOptions{Action: GitActionCommit, Dir: t.TempDir(), Message: "m", SigningFormat: "x509", SigningKey: "key"})
+	require.EqualError(t, err, `unsupported signing-format: "x509"`)
+
+	err = Git(ctx, GitOptions{Action: GitActionCommit, Dir: t.TempDir(), Message: "m", SigningFormat: GitSigningFormatSSH})
+	require.EqualError(t, err, "signing-key must be set to sign commits")
+}
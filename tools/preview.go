@@ -0,0 +1,262 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+	"golang.org/x/oauth2"
+)
+
+const (
+	CommandPreviewState         = "preview-state"
+	FlagPreviewStateFile        = "file"
+	FlagPreviewStateAction      = "action"
+	FlagPreviewStateApp         = "app"
+	FlagPreviewStateName        = "name"
+	FlagPreviewStateNamespace   = "namespace"
+	FlagPreviewStatePullRequest = "pull-request"
+	FlagPreviewStateBranch      = "branch"
+	FlagPreviewStateRepo        = "repo"
+	PreviewStateActionAdd       = "add"
+	PreviewStateActionRemove    = "remove"
+)
+
+// PreviewEnvironment is a preview environment of a pull request,
+// recorded in the preview state file when it's deployed.
+type PreviewEnvironment struct {
+	// App is the name of the Config that the environment is derived from.
+	App string `json:"app"`
+	// Name is the unique name of the environment, which is the name of the app, release or project.
+	Name string `json:"name"`
+	// Namespace is the Kubernetes namespace of the environment. It's empty for compose.
+	Namespace string `json:"namespace,omitempty"`
+	// PullRequest is the number of the pull request. It's zero when the environment is for Branch.
+	PullRequest int    `json:"pullRequest,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	// Repo is the git remote URL of the repository of the pull request, like https://github.com/myorg/myapp.
+	Repo      string    `json:"repo,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// PreviewState is the content of the preview state file,
+// which lists the deployed preview environments.
+type PreviewState struct {
+	Environments []PreviewEnvironment `json:"environments"`
+}
+
+// LoadPreviewState reads the preview state file.
+// It returns the empty state if the file doesn't exist yet.
+func LoadPreviewState(path string) (*PreviewState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &PreviewState{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading preview state: %w", err)
+	}
+
+	var s PreviewState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing preview state %s: %w", path, err)
+	}
+
+	return &s, nil
+}
+
+// Save writes the state to the file.
+// The file is replaced atomically, so that it's never left half-written.
+func (s *PreviewState) Save(path string) error {
+	sort.Slice(s.Environments, func(i, j int) bool {
+		return s.Environments[i].Name < s.Environments[j].Name
+	})
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+
+	f, err := os.CreateTemp(dir, ".preview-state-*")
+	if err != nil {
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("writing preview state: %w", err)
+	}
+
+	return nil
+}
+
+// Lookup returns the environment named name.
+func (s *PreviewState) Lookup(name string) (PreviewEnvironment, bool) {
+	for _, e := range s.Environments {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return PreviewEnvironment{}, false
+}
+
+// Add records the environment, or updates the one of the same name while keeping its CreatedAt.
+func (s *PreviewState) Add(e PreviewEnvironment, now time.Time) {
+	e.CreatedAt = now
+	e.UpdatedAt = now
+
+	for i, existing := range s.Environments {
+		if existing.Name == e.Name {
+			e.CreatedAt = existing.CreatedAt
+			s.Environments[i] = e
+			return
+		}
+	}
+
+	s.Environments = append(s.Environments, e)
+}
+
+// Remove removes the environment named name, and reports whether it was recorded.
+func (s *PreviewState) Remove(name string) bool {
+	for i, e := range s.Environments {
+		if e.Name == name {
+			s.Environments = append(s.Environments[:i], s.Environments[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type PreviewStateOptions struct {
+	// File is the path to the preview state file.
+	File string
+	// Action is either PreviewStateActionAdd or PreviewStateActionRemove.
+	Action string
+	// Environment is the environment to add. Only its Name is used to remove it.
+	Environment PreviewEnvironment
+}
+
+// UpdatePreviewState adds or removes the environment to or from the preview state file.
+// It's run by kargo after deploying or tearing down a preview environment.
+func UpdatePreviewState(opts PreviewStateOptions) error {
+	if opts.File == "" {
+		return errors.New("preview state file is required")
+	}
+	if opts.Environment.Name == "" {
+		return errors.New("preview environment name is required")
+	}
+
+	s, err := LoadPreviewState(opts.File)
+	if err != nil {
+		return err
+	}
+
+	switch opts.Action {
+	case PreviewStateActionAdd:
+		s.Add(opts.Environment, time.Now().UTC())
+	case PreviewStateActionRemove:
+		if !s.Remove(opts.Environment.Name) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported preview-state action %q", opts.Action)
+	}
+
+	return s.Save(opts.File)
+}
+
+// GitHubPullRequests checks the states of the pull requests of preview environments via the GitHub API.
+type GitHubPullRequests struct {
+	// Token is the GitHub token. The API is called anonymously if empty,
+	// which works for public repositories only.
+	Token string
+	// APIURL defaults to the one for the host of the repository, like GitHubAPIURL.
+	APIURL string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Open reports whether the pull request of the environment is still open.
+// For the environment of a branch without the pull request number,
+// it reports whether the branch has any open pull request.
+func (p *GitHubPullRequests) Open(ctx context.Context, e PreviewEnvironment) (bool, error) {
+	if e.Repo == "" {
+		return false, fmt.Errorf("preview environment %s: repo is required to check the pull request", e.Name)
+	}
+
+	repo, err := ParseRepository(e.Repo)
+	if err != nil {
+		return false, err
+	}
+
+	client, err := p.client(ctx, repo)
+	if err != nil {
+		return false, err
+	}
+
+	if e.PullRequest != 0 {
+		pr, _, err := client.PullRequests.Get(ctx, repo.Owner, repo.Name, e.PullRequest)
+		if err != nil {
+			return false, fmt.Errorf("calling pull request get API: %w", err)
+		}
+		return pr.GetState() == "open", nil
+	}
+
+	if e.Branch == "" {
+		return false, fmt.Errorf("preview environment %s: either pull request or branch is required", e.Name)
+	}
+
+	prs, _, err := client.PullRequests.List(ctx, repo.Owner, repo.Name, &github.PullRequestListOptions{
+		State: "open",
+		Head:  repo.Owner + ":" + e.Branch,
+	})
+	if err != nil {
+		return false, fmt.Errorf("calling pull request list API: %w", err)
+	}
+
+	return len(prs) > 0, nil
+}
+
+func (p *GitHubPullRequests) client(ctx context.Context, repo *Repository) (*github.Client, error) {
+	httpClient := p.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	if p.Token != "" {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+			&oauth2.Token{AccessToken: p.Token},
+		))
+	}
+
+	apiURL := p.APIURL
+	if apiURL == "" {
+		apiURL = GitHubAPIURL(repo.Host)
+	}
+
+	return newGitHubClient(httpClient, apiURL)
+}
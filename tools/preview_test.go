@@ -0,0 +1,117 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdatePreviewState(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state", "previews.json")
+
+	s, err := LoadPreviewState(file)
+	require.NoError(t, err)
+	require.Empty(t, s.Environments)
+
+	add := func(name string, pr int) {
+		t.Helper()
+		require.NoError(t, UpdatePreviewState(PreviewStateOptions{
+			File:   file,
+			Action: PreviewStateActionAdd,
+			Environment: PreviewEnvironment{
+				App:         "myapp",
+				Name:        name,
+				Namespace:   name,
+				PullRequest: pr,
+				Repo:        "https://github.com/myorg/myapp",
+			},
+		}))
+	}
+
+	add("myapp-pr-2", 2)
+	add("myapp-pr-1", 1)
+
+	s, err = LoadPreviewState(file)
+	require.NoError(t, err)
+	require.Len(t, s.Environments, 2)
+	require.Equal(t, "myapp-pr-1", s.Environments[0].Name)
+	require.Equal(t, 1, s.Environments[0].PullRequest)
+	created := s.Environments[0].CreatedAt
+	require.False(t, created.IsZero())
+
+	// Redeploying keeps the creation time.
+	time.Sleep(10 * time.Millisecond)
+	add("myapp-pr-1", 1)
+	s, err = LoadPreviewState(file)
+	require.NoError(t, err)
+	e, ok := s.Lookup("myapp-pr-1")
+	require.True(t, ok)
+	require.Equal(t, created, e.CreatedAt)
+	require.True(t, e.UpdatedAt.After(created))
+
+	require.NoError(t, UpdatePreviewState(PreviewStateOptions{File: file, Action: PreviewStateActionRemove, Environment: PreviewEnvironment{Name: "myapp-pr-1"}}))
+	// Removing the environment that doesn't exist is a no-op, so that the teardown can be retried.
+	require.NoError(t, UpdatePreviewState(PreviewStateOptions{File: file, Action: PreviewStateActionRemove, Environment: PreviewEnvironment{Name: "myapp-pr-1"}}))
+
+	s, err = LoadPreviewState(file)
+	require.NoError(t, err)
+	require.Len(t, s.Environments, 1)
+	require.Equal(t, "myapp-pr-2", s.Environments[0].Name)
+
+	err = UpdatePreviewState(PreviewStateOptions{File: file, Action: "list", Environment: PreviewEnvironment{Name: "x"}})
+	require.EqualError(t, err, `unsupported preview-state action "list"`)
+}
+
+func TestGitHubPullRequests_Open(t *testing.T) {
+	var auth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+
+		switch r.URL.Path {
+		case "/repos/myorg/myapp/pulls/1":
+			fmt.Fprint(w, `{"number":1,"state":"open"}`)
+		case "/repos/myorg/myapp/pulls/2":
+			fmt.Fprint(w, `{"number":2,"state":"closed"}`)
+		case "/repos/myorg/myapp/pulls":
+			require.Equal(t, "open", r.URL.Query().Get("state"))
+			if r.URL.Query().Get("head") == "myorg:feature/open" {
+				fmt.Fprint(w, `[{"number":3,"state":"open"}]`)
+				return
+			}
+			fmt.Fprint(w, `[]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := &GitHubPullRequests{Token: "mytoken", APIURL: srv.URL}
+
+	testcases := []struct {
+		env  PreviewEnvironment
+		open bool
+	}{
+		{PreviewEnvironment{Name: "a", PullRequest: 1}, true},
+		{PreviewEnvironment{Name: "b", PullRequest: 2}, false},
+		{PreviewEnvironment{Name: "c", Branch: "feature/open"}, true},
+		{PreviewEnvironment{Name: "d", Branch: "feature/merged"}, false},
+	}
+
+	for _, tc := range testcases {
+		tc.env.Repo = "https://github.com/myorg/myapp.git"
+		open, err := p.Open(context.Background(), tc.env)
+		require.NoError(t, err)
+		require.Equal(t, tc.open, open, tc.env.Name)
+	}
+
+	require.Equal(t, "Bearer mytoken", auth[0])
+
+	_, err := p.Open(context.Background(), PreviewEnvironment{Name: "e", PullRequest: 1})
+	require.EqualError(t, err, "preview environment e: repo is required to check the pull request")
+}
@@ -0,0 +1,472 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	CommandPromote               = "promote"
+	FlagPromoteFromKustomization = "from-kustomization"
+	FlagPromoteFromRef           = "from-ref"
+	FlagPromoteFromArgoCDApp     = "from-argocd-app"
+	FlagPromoteImages            = "images"
+	FlagPromoteTagPattern        = "tag-pattern"
+	FlagPromoteRequireDigest     = "require-digest"
+	FlagPromoteCharts            = "charts"
+	DefaultPromoteFromRef        = "origin/main"
+
+	kustomizationNotFound = "no kustomization.yaml, kustomization.yml or Kustomization found"
+)
+
+// kustomizationFileNames are the file names that kustomize recognizes, in the order of precedence.
+var kustomizationFileNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+type PromoteOptions struct {
+	// Dir is the directory of the kustomization of the target environment.
+	// Defaults to the current directory.
+	Dir string
+	// FromKustomization is the path to the directory of the kustomization
+	// of the source environment, relative to the root of the git repository at Dir.
+	// It's read from FromRef, so that the promotion is based on what's merged
+	// rather than the working tree.
+	FromKustomization string
+	// FromRef is the git revision to read FromKustomization from.
+	// Defaults to DefaultPromoteFromRef.
+	FromRef string
+	// FromArgoCDApp is the name of the ArgoCD application of the source environment.
+	// It's read via argocd-app-get, which is configured via ARGOCD_SERVER, ARGOCD_AUTH_TOKEN
+	// and the other environment variables supported by the argocd CLI.
+	// Either FromKustomization or FromArgoCDApp must be set.
+	FromArgoCDApp string
+	// ArgoCDArgs is the list of additional arguments passed to argocd-app-get.
+	ArgoCDArgs []string
+	// Policy decides which images and charts are promoted.
+	Policy PromotionPolicy
+	// Out is where the promoted and skipped images and charts are listed.
+	// Defaults to os.Stdout.
+	Out io.Writer
+}
+
+// PromotionPolicy decides which images and Helm charts are allowed to be promoted.
+// Everything is promoted if it's empty.
+type PromotionPolicy struct {
+	// Images is the list of glob patterns of the names of the images to promote,
+	// like ghcr.io/myorg/*. All the images are promoted if empty.
+	Images []string
+	// TagPattern is the regular expression that the tags of the images must match,
+	// like ^v[0-9]+\.[0-9]+\.[0-9]+$ to promote release versions only.
+	TagPattern string
+	// RequireDigest makes only the images pinned by digest promoted.
+	RequireDigest bool
+	// Charts is the list of glob patterns of the names of the Helm charts to promote.
+	// All the charts are promoted if empty.
+	Charts []string
+}
+
+// PromotedImage is an image deployed to an environment,
+// in the form of the images field of kustomization.yaml.
+type PromotedImage struct {
+	Name    string `yaml:"name"`
+	NewName string `yaml:"newName"`
+	NewTag  string `yaml:"newTag"`
+	Digest  string `yaml:"digest"`
+}
+
+// String returns the image in the form of the argument of kustomize-edit-set-image,
+// that is name[=newName][:tag][@digest].
+func (i PromotedImage) String() string {
+	s := i.Name
+	if i.NewName != "" {
+		s += "=" + i.NewName
+	}
+	if i.NewTag != "" {
+		s += ":" + i.NewTag
+	}
+	if i.Digest != "" {
+		s += "@" + i.Digest
+	}
+	return s
+}
+
+// PromotedChart is a Helm chart deployed to an environment.
+type PromotedChart struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// PromotionResult is the list of the images and the charts promoted by Promote.
+type PromotionResult struct {
+	Images []PromotedImage
+	Charts []PromotedChart
+}
+
+// Promote reads the images and the Helm chart versions deployed to the source environment,
+// and sets the ones allowed by the policy to the kustomization of the target environment.
+//
+// The images are set via kustomize-edit-set-image,
+// and the chart versions are set to the helmCharts field of the kustomization.
+// Images and charts denied by the policy are listed as skipped.
+func Promote(ctx context.Context, opts PromoteOptions) (*PromotionResult, error) {
+	if (opts.FromKustomization == "") == (opts.FromArgoCDApp == "") {
+		return nil, fmt.Errorf("either %s or %s must be set", FlagPromoteFromKustomization, FlagPromoteFromArgoCDApp)
+	}
+
+	for _, p := range append(append([]string{}, opts.Policy.Images...), opts.Policy.Charts...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	var tagPattern *regexp.Regexp
+	if opts.Policy.TagPattern != "" {
+		var err error
+		tagPattern, err = regexp.Compile(opts.Policy.TagPattern)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", FlagPromoteTagPattern, err)
+		}
+	}
+
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	var (
+		images []PromotedImage
+		charts []PromotedChart
+		err    error
+	)
+	if opts.FromKustomization != "" {
+		ref := opts.FromRef
+		if ref == "" {
+			ref = DefaultPromoteFromRef
+		}
+		images, charts, err = readKustomizationAtRef(ctx, dir, ref, opts.FromKustomization)
+	} else {
+		images, charts, err = readArgoCDApp(ctx, opts.FromArgoCDApp, opts.ArgoCDArgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r PromotionResult
+
+	for _, img := range images {
+		if reason := opts.Policy.denyImage(img, tagPattern); reason != "" {
+			fmt.Fprintf(out, "skipping image %s: %s\n", img.Name, reason)
+			continue
+		}
+		fmt.Fprintf(out, "promoting image %s\n", img)
+		r.Images = append(r.Images, img)
+	}
+
+	for _, c := range charts {
+		if len(opts.Policy.Charts) > 0 && !matchAnyGlob(opts.Policy.Charts, c.Name) {
+			fmt.Fprintf(out, "skipping chart %s: not allowed by %s\n", c.Name, FlagPromoteCharts)
+			continue
+		}
+		fmt.Fprintf(out, "promoting chart %s to %s\n", c.Name, c.Version)
+		r.Charts = append(r.Charts, c)
+	}
+
+	if len(r.Charts) > 0 {
+		if err := setHelmChartVersions(dir, r.Charts, out); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(r.Images) > 0 {
+		args := []string{"edit", "set", "image"}
+		for _, img := range r.Images {
+			args = append(args, img.String())
+		}
+
+		c := exec.CommandContext(ctx, "kustomize", args...)
+		c.Dir = dir
+		c.Stdout = out
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return nil, fmt.Errorf("running kustomize edit set image: %w", err)
+		}
+	}
+
+	if len(r.Images)+len(r.Charts) == 0 {
+		fmt.Fprintln(out, "nothing to promote")
+	}
+
+	return &r, nil
+}
+
+// denyImage returns the reason why the policy denies the image,
+// or an empty string if the image is allowed.
+func (p PromotionPolicy) denyImage(img PromotedImage, tagPattern *regexp.Regexp) string {
+	if len(p.Images) > 0 && !matchAnyGlob(p.Images, img.Name) {
+		return "not allowed by " + FlagPromoteImages
+	}
+
+	if img.NewTag == "" && img.Digest == "" {
+		return "neither tag nor digest is set"
+	}
+
+	if tagPattern != nil && !tagPattern.MatchString(img.NewTag) {
+		return fmt.Sprintf("tag %q doesn't match %s", img.NewTag, FlagPromoteTagPattern)
+	}
+
+	if p.RequireDigest && img.Digest == "" {
+		return "not pinned by digest"
+	}
+
+	return ""
+}
+
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// kustomization is the part of kustomization.yaml that Promote reads.
+type kustomization struct {
+	Images     []PromotedImage `yaml:"images"`
+	HelmCharts []PromotedChart `yaml:"helmCharts"`
+}
+
+// readKustomizationAtRef reads the images and the charts of the kustomization at p
+// in the git revision ref, via git-show run in dir.
+func readKustomizationAtRef(ctx context.Context, dir, ref, p string) ([]PromotedImage, []PromotedChart, error) {
+	for _, name := range kustomizationFileNames {
+		// The path is relative to the root of the repository.
+		obj := ref + ":" + path.Join(strings.Trim(filepath.ToSlash(p), "/"), name)
+
+		var stdout, stderr bytes.Buffer
+		c := exec.CommandContext(ctx, "git", "show", obj)
+		c.Dir = dir
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+		if err := c.Run(); err != nil {
+			if strings.Contains(stderr.String(), "does not exist") || strings.Contains(stderr.String(), "exists on disk, but not in") {
+				continue
+			}
+			return nil, nil, fmt.Errorf("running git show %s: %w: %s", obj, err, stderr.String())
+		}
+
+		var k kustomization
+		if err := yaml.Unmarshal(stdout.Bytes(), &k); err != nil {
+			return nil, nil, fmt.Errorf("parsing %s: %w", obj, err)
+		}
+
+		return k.Images, k.HelmCharts, nil
+	}
+
+	return nil, nil, fmt.Errorf("reading %s at %s: %s", p, ref, kustomizationNotFound)
+}
+
+// argoCDAppSource is the part of the source of the ArgoCD application that Promote reads.
+type argoCDAppSource struct {
+	Chart          string `json:"chart"`
+	TargetRevision string `json:"targetRevision"`
+	Kustomize      *struct {
+		Images []string `json:"images"`
+	} `json:"kustomize"`
+}
+
+// readArgoCDApp reads the images and the charts of the ArgoCD application via argocd-app-get.
+//
+// The images are read from the kustomize images of the sources,
+// which retain the original image names that the target kustomization refers to.
+// The images in the status of the application are used if there are none.
+func readArgoCDApp(ctx context.Context, app string, argocdArgs []string) ([]PromotedImage, []PromotedChart, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "argocd", append([]string{"app", "get", app, "-o", "json"}, argocdArgs...)...)
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return nil, nil, fmt.Errorf("running argocd app get: %w: %s", err, stderr.String())
+	}
+
+	return parseArgoCDApp(stdout.Bytes())
+}
+
+func parseArgoCDApp(data []byte) ([]PromotedImage, []PromotedChart, error) {
+	var a struct {
+		Spec struct {
+			Source  *argoCDAppSource  `json:"source"`
+			Sources []argoCDAppSource `json:"sources"`
+		} `json:"spec"`
+		Status struct {
+			Summary struct {
+				Images []string `json:"images"`
+			} `json:"summary"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, nil, fmt.Errorf("parsing argocd application: %w", err)
+	}
+
+	sources := a.Spec.Sources
+	if a.Spec.Source != nil {
+		sources = append([]argoCDAppSource{*a.Spec.Source}, sources...)
+	}
+
+	var (
+		images []PromotedImage
+		charts []PromotedChart
+	)
+	for _, s := range sources {
+		if s.Chart != "" {
+			charts = append(charts, PromotedChart{Name: s.Chart, Version: s.TargetRevision})
+		}
+		if s.Kustomize != nil {
+			for _, img := range s.Kustomize.Images {
+				images = append(images, parseKustomizeImage(img))
+			}
+		}
+	}
+
+	if len(images) == 0 {
+		for _, img := range a.Status.Summary.Images {
+			images = append(images, parseKustomizeImage(img))
+		}
+	}
+
+	return images, charts, nil
+}
+
+// parseKustomizeImage parses the image in the form of name[=newName][:tag][@digest].
+func parseKustomizeImage(s string) PromotedImage {
+	var img PromotedImage
+
+	name, ref, renamed := strings.Cut(s, "=")
+	if !renamed {
+		ref = s
+	}
+
+	if i := strings.Index(ref, "@"); i >= 0 {
+		img.Digest = ref[i+1:]
+		ref = ref[:i]
+	}
+
+	// The colon after the last slash separates the tag,
+	// while the one before it separates the port of the registry.
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		img.NewTag = ref[i+1:]
+		ref = ref[:i]
+	}
+
+	if renamed {
+		img.Name = name
+		img.NewName = ref
+	} else {
+		img.Name = ref
+	}
+
+	return img
+}
+
+// setHelmChartVersions sets the versions of the charts in the helmCharts field
+// of the kustomization in dir, preserving the rest of the file.
+func setHelmChartVersions(dir string, charts []PromotedChart, out io.Writer) error {
+	var file string
+	for _, name := range kustomizationFileNames {
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err == nil {
+			file = p
+			break
+		}
+	}
+	if file == "" {
+		return fmt.Errorf("reading %s: %s", dir, kustomizationNotFound)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("parsing %s: the kustomization must be a mapping", file)
+	}
+
+	helmCharts := mappingValue(doc.Content[0], "helmCharts")
+
+	var changed bool
+	for _, c := range charts {
+		var found bool
+		if helmCharts != nil {
+			for _, item := range helmCharts.Content {
+				if n := mappingValue(item, "name"); n == nil || n.Value != c.Name {
+					continue
+				}
+				found = true
+
+				if v := mappingValue(item, "version"); v != nil {
+					changed = changed || v.Value != c.Version
+					v.Value = c.Version
+				} else {
+					changed = true
+					item.Content = append(item.Content,
+						&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+						&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: c.Version},
+					)
+				}
+			}
+		}
+		if !found {
+			fmt.Fprintf(out, "skipping chart %s: not in the helmCharts of %s\n", c.Name, file)
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("encoding %s: %w", file, err)
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, buf.Bytes(), 0644)
+}
+
+// mappingValue returns the value of the key in the mapping node,
+// or nil if the node isn't a mapping or has no such key.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKustomizeImage(t *testing.T) {
+	testcases := []struct {
+		in   string
+		want PromotedImage
+	}{
+		{"myapp", PromotedImage{Name: "myapp"}},
+		{"myapp:v1", PromotedImage{Name: "myapp", NewTag: "v1"}},
+		{"myapp=ghcr.io/myorg/myapp:v1", PromotedImage{Name: "myapp", NewName: "ghcr.io/myorg/myapp", NewTag: "v1"}},
+		{"localhost:5000/myapp", PromotedImage{Name: "localhost:5000/myapp"}},
+		{"localhost:5000/myapp:v1@sha256:abc", PromotedImage{Name: "localhost:5000/myapp", NewTag: "v1", Digest: "sha256:abc"}},
+		{"myapp@sha256:abc", PromotedImage{Name: "myapp", Digest: "sha256:abc"}},
+	}
+
+	for _, tc := range testcases {
+		got := parseKustomizeImage(tc.in)
+		require.Equal(t, tc.want, got, tc.in)
+		require.Equal(t, tc.in, got.String())
+	}
+}
+
+func TestParseArgoCDApp(t *testing.T) {
+	images, charts, err := parseArgoCDApp([]byte(`{
+  "spec": {
+    "sources": [
+      {"chart": "mychart", "targetRevision": "1.2.3"},
+      {"kustomize": {"images": ["myapp=ghcr.io/myorg/myapp:v2"]}}
+    ]
+  },
+  "status": {"summary": {"images": ["ghcr.io/myorg/myapp:v2"]}}
+}`))
+	require.NoError(t, err)
+	require.Equal(t, []PromotedImage{{Name: "myapp", NewName: "ghcr.io/myorg/myapp", NewTag: "v2"}}, images)
+	require.Equal(t, []PromotedChart{{Name: "mychart", Version: "1.2.3"}}, charts)
+
+	// The deployed images are used when the application doesn't override any.
+	images, charts, err = parseArgoCDApp([]byte(`{
+  "spec": {"source": {"path": "deploy"}},
+  "status": {"summary": {"images": ["ghcr.io/myorg/myapp:v2"]}}
+}`))
+	require.NoError(t, err)
+	require.Equal(t, []PromotedImage{{Name: "ghcr.io/myorg/myapp", NewTag: "v2"}}, images)
+	require.Empty(t, charts)
+}
+
+func TestPromote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	ctx := context.Background()
+
+	// The fake kustomize records its arguments.
+	bin := t.TempDir()
+	writeFiles(t, bin, map[string]string{
+		"kustomize": "#!/bin/sh\necho \"$@\" > kustomize-args\n",
+	})
+	require.NoError(t, os.Chmod(filepath.Join(bin, "kustomize"), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		c := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		c.Dir = dir
+		out, err := c.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	writeFiles(t, dir, map[string]string{
+		"envs/staging/kustomization.yaml": `images:
+- name: myapp
+  newName: ghcr.io/myorg/myapp
+  newTag: v2
+  digest: sha256:abc
+- name: sidecar
+  newTag: latest
+- name: other.example.com/tool
+  newTag: v1
+helmCharts:
+- name: mychart
+  version: 1.3.0
+- name: unknown
+  version: 0.1.0
+`,
+		"envs/prod/kustomization.yaml": `# prod
+helmCharts:
+- name: mychart
+  repo: https://charts.example.com
+  version: 1.2.0
+`,
+	})
+	git("init", "--initial-branch", "main")
+	git("add", ".")
+	git("commit", "-m", "initial")
+
+	// Uncommitted changes to the source environment are never promoted.
+	writeFiles(t, dir, map[string]string{
+		"envs/staging/kustomization.yaml": "images:\n- name: myapp\n  newTag: v3\n",
+	})
+
+	var out bytes.Buffer
+	r, err := Promote(ctx, PromoteOptions{
+		Dir:               filepath.Join(dir, "envs/prod"),
+		FromKustomization: "envs/staging",
+		FromRef:           "main",
+		Policy: PromotionPolicy{
+			Images:     []string{"myapp", "sidecar"},
+			TagPattern: `^v[0-9]+$`,
+		},
+		Out: &out,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &PromotionResult{
+		Images: []PromotedImage{{Name: "myapp", NewName: "ghcr.io/myorg/myapp", NewTag: "v2", Digest: "sha256:abc"}},
+		Charts: []PromotedChart{{Name: "mychart", Version: "1.3.0"}, {Name: "unknown", Version: "0.1.0"}},
+	}, r)
+	require.Equal(t, `promoting image myapp=ghcr.io/myorg/myapp:v2@sha256:abc
+skipping image sidecar: tag "latest" doesn't match tag-pattern
+skipping image other.example.com/tool: not allowed by images
+promoting chart mychart to 1.3.0
+promoting chart unknown to 0.1.0
+skipping chart unknown: not in the helmCharts of `+filepath.Join(dir, "envs/prod/kustomization.yaml")+"\n", out.String())
+
+	args, err := os.ReadFile(filepath.Join(dir, "envs/prod/kustomize-args"))
+	require.NoError(t, err)
+	require.Equal(t, "edit set image myapp=ghcr.io/myorg/myapp:v2@sha256:abc\n", string(args))
+
+	prod, err := os.ReadFile(filepath.Join(dir, "envs/prod/kustomization.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, `# prod
+helmCharts:
+  - name: mychart
+    repo: https://charts.example.com
+    version: 1.3.0
+`, string(prod))
+
+	out.Reset()
+	r, err = Promote(ctx, PromoteOptions{
+		Dir:               filepath.Join(dir, "envs/prod"),
+		FromKustomization: "envs/staging",
+		FromRef:           "main",
+		Policy:            PromotionPolicy{RequireDigest: true, Images: []string{"sidecar"}, Charts: []string{"other"}},
+		Out:               &out,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &PromotionResult{}, r)
+	require.Equal(t, `skipping image myapp: not allowed by images
+skipping image sidecar: not pinned by digest
+skipping image other.example.com/tool: not allowed by images
+skipping chart mychart: not allowed by charts
+skipping chart unknown: not allowed by charts
+nothing to promote
+`, out.String())
+
+	_, err = Promote(ctx, PromoteOptions{Dir: dir, FromKustomization: "envs/dev", FromRef: "main", Out: &out})
+	require.EqualError(t, err, "reading envs/dev at main: "+kustomizationNotFound)
+
+	_, err = Promote(ctx, PromoteOptions{Dir: dir, Out: &out})
+	require.EqualError(t, err, "either from-kustomization or from-argocd-app must be set")
+
+	_, err = Promote(ctx, PromoteOptions{Dir: dir, FromArgoCDApp: "myapp", Policy: PromotionPolicy{Images: []string{"["}}})
+	require.EqualError(t, err, `invalid pattern "[": syntax error in pattern`)
+}
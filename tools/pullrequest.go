@@ -4,13 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/url"
 	"os"
-	"os/exec"
-	"strings"
-
-	"github.com/google/go-github/v56/github"
-	"golang.org/x/oauth2"
+	"time"
 )
 
 const (
@@ -24,6 +19,8 @@ const (
 	FlagCreatePullRequestTokenEnv    = "token-env"
 	FlagCreatePullRequestDryRun      = "dry-run"
 	FlagCreatePullRequestOutputFile  = "output-file"
+	FlagCreatePullRequestUpdate      = "update-existing"
+	FlagCreatePullRequestGitBackend  = "git-backend"
 )
 
 type CreatePullRequestOptions struct {
@@ -39,19 +36,92 @@ type CreatePullRequestOptions struct {
 	AssigneeIDs []string
 	TokenEnv    string
 	DryRun      bool
+	// UpdateExisting makes CreatePullRequest update the open pull request
+	// from Head to Base, if any, instead of creating another one.
+	// This is meant to be used with a stable Head that is force-pushed on every deployment.
+	UpdateExisting bool
+	// Labels is the list of labels to add to the pull request.
+	Labels []string
+	// Reviewers is the list of GitHub users to request reviews from.
+	Reviewers []string
+	// TeamReviewers is the list of GitHub team slugs to request reviews from.
+	TeamReviewers []string
+	// Draft makes the new pull request a draft.
+	Draft bool
+	// Milestone is either the number or the title of the milestone.
+	Milestone string
+	// TitleTemplate and BodyTemplate are Go templates to render the title and the body.
+	// They take precedence over Title and Body.
+	// See PullRequestTemplateData for the available data.
+	TitleTemplate string
+	BodyTemplate  string
+	// TemplateData is the data for TitleTemplate and BodyTemplate.
+	// Head, Base and DiffStat are set by CreatePullRequest.
+	TemplateData PullRequestTemplateData
+	// Merge is either auto or wait.
+	// auto enables GitHub auto-merge on the pull request.
+	// wait waits for the required checks to pass and merges the pull request
+	// within MergeTimeout.
+	// The pull request is left open if this is empty.
+	Merge string
+	// MergeMethod is one of merge, squash and rebase. Defaults to merge.
+	MergeMethod string
+	// MergeTimeout defaults to DefaultMergeTimeout.
+	MergeTimeout time.Duration
+	// MergePollInterval defaults to DefaultMergePollInterval.
+	MergePollInterval time.Duration
+	// Provider is one of github, gitlab and gitea.
+	// It's detected from the host of the remote if empty.
+	Provider string
+	// APIURL is the base URL of the provider's API.
+	// It defaults to https://api.github.com/ for github.com,
+	// https://<host>/api/v3/ for GitHub Enterprise Server,
+	// https://<host>/api/v4 for GitLab, and https://<host>/api/v1 for Gitea.
+	APIURL string
+	// GitHubAppID, GitHubAppInstallationID and GitHubAppPrivateKeyEnv
+	// make CreatePullRequest authenticate as the GitHub App
+	// when the token is not set in TokenEnv.
+	// The installation is looked up from the repository if GitHubAppInstallationID is zero.
+	// GitHubAppPrivateKeyEnv is the name of the environment variable
+	// that contains the PEM-encoded private key of the app.
+	GitHubAppID             int64
+	GitHubAppInstallationID int64
+	GitHubAppPrivateKeyEnv  string
+	// GitBackend is either shell or go-git. Defaults to shell.
+	// It's used to compare the head with the base, and to show the diff on DryRun.
+	GitBackend string
 }
 
 // PullRequest is a pull request on GitHub that
 // is created by kargo / CreatePullRequest function.
+//
+// ID, NodeID, Number and HTMLURL are omitted from the output file when NoChanges is true,
+// as there's no pull request, unless Closed is true.
 type PullRequest struct {
-	ID      int64  `json:"id" yaml:"id"`
-	NodeID  string `json:"nodeID" yaml:"nodeID"`
-	Number  int    `json:"number" yaml:"number"`
+	ID      int64  `json:"id,omitempty" yaml:"id,omitempty"`
+	NodeID  string `json:"nodeID,omitempty" yaml:"nodeID,omitempty"`
+	Number  int    `json:"number,omitempty" yaml:"number,omitempty"`
 	Head    string `json:"head" yaml:"head"`
-	HTMLURL string `json:"htmlURL" yaml:"htmlURL"`
+	HTMLURL string `json:"htmlURL,omitempty" yaml:"htmlURL,omitempty"`
+	// Updated is true when the existing pull request was updated
+	// instead of creating a new one.
+	Updated bool `json:"updated,omitempty" yaml:"updated,omitempty"`
+	// AutoMerge is true when auto-merge was enabled on the pull request.
+	AutoMerge bool `json:"autoMerge,omitempty" yaml:"autoMerge,omitempty"`
+	// Merged is true when the pull request was merged by kargo.
+	Merged bool `json:"merged,omitempty" yaml:"merged,omitempty"`
+	// MergeCommitSHA is the SHA of the merge commit when Merged is true.
+	MergeCommitSHA string `json:"mergeCommitSHA,omitempty" yaml:"mergeCommitSHA,omitempty"`
+	// NoChanges is true when head has no changes from base.
+	// No pull request is created in that case.
+	NoChanges bool `json:"noChanges,omitempty" yaml:"noChanges,omitempty"`
+	// Closed is true when the open pull request from head was closed
+	// because head has no changes from base with UpdateExisting.
+	Closed bool `json:"closed,omitempty" yaml:"closed,omitempty"`
 }
 
-// CreatePullRequest creates a pull request on GitHub.
+// CreatePullRequest creates a pull request on GitHub,
+// or on GitLab or Gitea depending on opts.Provider.
 // dir is the directory of the repository.
 // title is the title of the pull request.
 // body is the body of the pull request.
@@ -59,17 +129,21 @@ type PullRequest struct {
 // base is the branch to merge to.
 // token is the GitHub token.
 // It returns the PullRequest object on success.
+// If head has no changes from base, it creates no pull request
+// and returns the PullRequest object whose NoChanges is true.
+// If opts.UpdateExisting is true, the open pull request from head to base
+// is updated instead, and the PullRequest object whose Updated is true is returned.
+// If head has no changes then, the open pull request is closed,
+// and the PullRequest object whose NoChanges and Closed are true is returned.
 func CreatePullRequest(ctx context.Context, opts CreatePullRequestOptions) (*PullRequest, error) {
 	dir := opts.Dir
-	title := opts.Title
-	body := opts.Body
 	head := opts.Head
 	base := opts.Base
 	tokenEnv := opts.TokenEnv
 	token := os.Getenv(tokenEnv)
 
-	if token == "" {
-		return nil, fmt.Errorf("%s must be set", FlagCreatePullRequestTokenEnv)
+	if token == "" && opts.GitHubAppID == 0 {
+		return nil, fmt.Errorf("either %s or %s must be set", FlagCreatePullRequestTokenEnv, FlagCreatePullRequestGitHubAppID)
 	}
 
 	if head == "" {
@@ -80,6 +154,10 @@ func CreatePullRequest(ctx context.Context, opts CreatePullRequestOptions) (*Pul
 		return nil, fmt.Errorf("base must be set")
 	}
 
+	if err := validateMergeOptions(opts); err != nil {
+		return nil, err
+	}
+
 	if head == "main" || head == "master" {
 		return nil, fmt.Errorf("head must not be %s", head)
 	}
@@ -88,132 +166,144 @@ func CreatePullRequest(ctx context.Context, opts CreatePullRequestOptions) (*Pul
 		return nil, fmt.Errorf("dir must be set")
 	}
 
-	repo, err := getRepository(ctx, dir)
+	git, err := NewGitBackend(opts.GitBackend, "")
 	if err != nil {
 		return nil, err
 	}
-	if opts.DryRun {
-		fmt.Printf("dry-run: create pull request on %s/%s from %s to %s\n", repo.Owner, repo.Name, head, base)
 
-		fmt.Printf("dry-run: showing git-diff between %s and %s\n", base, head)
-		c := exec.CommandContext(ctx, "git", "diff", "--stat", "--patch-with-raw", base+".."+head)
-		c.Dir = dir
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
-		if err := c.Run(); err != nil {
-			return nil, fmt.Errorf("running git diff: %w", err)
+	repo, err := getRepository(ctx, git, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := git.Changed(ctx, dir, "origin/"+base)
+	if err != nil {
+		return nil, err
+	}
+	if !changed {
+		fmt.Printf("no changes between %s and %s, skipping pull request\n", base, head)
+
+		r := &PullRequest{Head: head, NoChanges: true}
+
+		// The stable head isn't pushed without changes,
+		// so the open pull request would be left with the changes of the previous deployment.
+		if opts.UpdateExisting {
+			if opts.DryRun {
+				fmt.Printf("dry-run: close the open pull request on %s/%s from %s to %s, if any\n", repo.Owner, repo.Name, head, base)
+			} else {
+				provider, err := newPullRequestProvider(ctx, repo, token, opts)
+				if err != nil {
+					return nil, err
+				}
+
+				closed, err := closeStalePullRequest(ctx, provider, repo, opts)
+				if err != nil {
+					return nil, err
+				}
+				if closed != nil {
+					fmt.Printf("closed pull request %s\n", closed.HTMLURL)
+					closed.NoChanges = true
+					r = closed
+				}
+			}
 		}
 
-		return nil, nil
+		if err := writePullRequest(opts.OutputFile, r); err != nil {
+			return nil, err
+		}
+
+		return r, nil
 	}
 
-	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	))
-	client := github.NewClient(httpClient)
-	pr, _, err := client.PullRequests.Create(ctx, repo.Owner, repo.Name, &github.NewPullRequest{
-		Title: &title,
-		Body:  &body,
-		Head:  &head,
-		Base:  &base,
-	})
+	opts.Title, opts.Body, err = renderPullRequestTitleAndBody(ctx, git, opts)
 	if err != nil {
-		return nil, fmt.Errorf("calling pull request creation API: %w", err)
+		return nil, err
 	}
 
-	if pr == nil {
-		return nil, fmt.Errorf("assertion error: pull request is nil: %v", opts)
-	}
+	if opts.DryRun {
+		fmt.Printf("dry-run: create pull request on %s/%s from %s to %s\n", repo.Owner, repo.Name, head, base)
+		fmt.Printf("dry-run: title: %s\n", opts.Title)
+		fmt.Printf("dry-run: body:\n%s\n", opts.Body)
 
-	if len(opts.AssigneeIDs) > 0 {
-		_, _, err = client.Issues.AddAssignees(ctx, repo.Owner, repo.Name, pr.GetNumber(), opts.AssigneeIDs)
+		// HEAD is compared instead of the head branch,
+		// which doesn't exist locally when the worktree has the detached HEAD.
+		fmt.Printf("dry-run: showing git-diff between %s and %s\n", base, head)
+		diff, err := git.Diff(ctx, dir, "origin/"+base, true)
 		if err != nil {
-			return nil, fmt.Errorf("calling add assignees API: %w", err)
+			return nil, err
+		}
+		fmt.Print(diff)
+
+		// The output file of the previous run must not be mistaken for the result of this one.
+		if err := removePullRequest(opts.OutputFile); err != nil {
+			return nil, err
 		}
+
+		return nil, nil
 	}
 
-	r := &PullRequest{
-		ID:      pr.GetID(),
-		NodeID:  pr.GetNodeID(),
-		Number:  pr.GetNumber(),
-		HTMLURL: pr.GetHTMLURL(),
+	provider, err := newPullRequestProvider(ctx, repo, token, opts)
+	if err != nil {
+		return nil, err
 	}
-	if h := pr.GetHead(); h != nil {
-		r.Head = h.GetRef()
+
+	r, err := upsertPullRequest(ctx, provider, repo, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	if opts.OutputFile != "" {
-		f, err := os.Create(opts.OutputFile)
-		if err != nil {
-			return nil, fmt.Errorf("creating output file: %w", err)
-		}
-		defer f.Close()
+	if err := provider.UpdatePullRequestMetadata(ctx, repo, r.Number, opts); err != nil {
+		return nil, err
+	}
 
-		if err := json.NewEncoder(f).Encode(r); err != nil {
-			return nil, fmt.Errorf("writing output file: %w", err)
-		}
+	// The pull request is written to the output file even if the merge fails,
+	// so that the caller can tell which pull request is left open.
+	mergeErr := provider.MergePullRequest(ctx, repo, r, opts)
 
-		if err := f.Close(); err != nil {
-			return nil, fmt.Errorf("closing output file: %w", err)
-		}
+	if err := writePullRequest(opts.OutputFile, r); err != nil {
+		return nil, err
+	}
+
+	if mergeErr != nil {
+		return r, mergeErr
 	}
 
 	return r, nil
 }
 
-type Repository struct {
-	Owner string
-	Name  string
-}
+// removePullRequest removes the output file written by writePullRequest, if any.
+func removePullRequest(path string) error {
+	if path == "" {
+		return nil
+	}
 
-// GetRepository returns the GitHub repository of the remote of the
-// local repository in dir.
-func getRepository(ctx context.Context, dir string) (*Repository, error) {
-	c := exec.CommandContext(ctx, "git", "remote", "get-url", "origin")
-	c.Dir = dir
-	out, err := c.Output()
-	if err != nil {
-		return nil, err
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing output file: %w", err)
 	}
-	return parseRepository(out)
+
+	return nil
 }
 
-// parseRepository parses the GitHub repository from the output of
-// git remote get-url.
-func parseRepository(gitRemoteGetURLOut []byte) (*Repository, error) {
-	o := strings.TrimSpace(string(gitRemoteGetURLOut))
+// writePullRequest writes r to the file at path in JSON.
+// It does nothing if path is empty.
+func writePullRequest(path string, r *PullRequest) error {
+	if path == "" {
+		return nil
+	}
 
-	u, err := url.Parse(o)
+	f, err := os.Create(path)
 	if err != nil {
-		s := strings.Split(string(gitRemoteGetURLOut), ":")
-		if len(s) != 2 {
-			return nil, fmt.Errorf("expected 2 parts but got %d", len(s))
-		}
-
-		s = strings.Split(strings.TrimSuffix(s[1], "\n"), "/")
-		if len(s) != 2 {
-			return nil, fmt.Errorf("expected 2 parts but got %d", len(s))
-		}
-
-		return &Repository{
-			Owner: s[0],
-			Name:  strings.TrimSuffix(s[1], ".git"),
-		}, nil
+		return fmt.Errorf("creating output file: %w", err)
 	}
+	defer f.Close()
 
-	if u.Scheme != "https" {
-		return nil, fmt.Errorf("expected https scheme but got %s", u.Scheme)
+	if err := json.NewEncoder(f).Encode(r); err != nil {
+		return fmt.Errorf("writing output file: %w", err)
 	}
 
-	p := strings.TrimLeft(u.Path, "/")
-
-	s := strings.Split(p, "/")
-	if len(s) != 2 {
-		return nil, fmt.Errorf("expected 2 parts in url path but got %d: %s", len(s), p)
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing output file: %w", err)
 	}
 
-	return &Repository{
-		Owner: s[0],
-		Name:  strings.TrimSuffix(s[1], ".git"),
-	}, nil
+	return nil
 }
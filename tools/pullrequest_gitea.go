@@ -0,0 +1,342 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// giteaProvider is the PullRequestProvider for Gitea,
+// which creates pull requests via the Gitea REST API v1.
+type giteaProvider struct {
+	api *restClient
+}
+
+var _ PullRequestProvider = &giteaProvider{}
+
+func newGiteaProvider(apiURL, token string) *giteaProvider {
+	return &giteaProvider{
+		api: &restClient{
+			baseURL: apiURL,
+			header:  http.Header{"Authorization": []string{"token " + token}},
+		},
+	}
+}
+
+type giteaPullRequest struct {
+	ID             int64  `json:"id"`
+	Number         int    `json:"number"`
+	HTMLURL        string `json:"html_url"`
+	Mergeable      bool   `json:"mergeable"`
+	Merged         bool   `json:"merged"`
+	MergeCommitSHA string `json:"merge_commit_sha"`
+	Head           struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (pr giteaPullRequest) pullRequest() *PullRequest {
+	return &PullRequest{
+		ID:      pr.ID,
+		Number:  pr.Number,
+		Head:    pr.Head.Ref,
+		HTMLURL: pr.HTMLURL,
+	}
+}
+
+func (p *giteaProvider) repoPath(repo *Repository) string {
+	return "/repos/" + url.PathEscape(repo.Owner) + "/" + url.PathEscape(repo.Name)
+}
+
+func (p *giteaProvider) pullPath(repo *Repository, number int) string {
+	return p.repoPath(repo) + "/pulls/" + strconv.Itoa(number)
+}
+
+func (p *giteaProvider) FindOpenPullRequest(ctx context.Context, repo *Repository, head, base string) (*PullRequest, error) {
+	query := url.Values{"state": {"open"}, "limit": {"50"}}
+
+	for page := 1; ; page++ {
+		query.Set("page", strconv.Itoa(page))
+
+		var prs []giteaPullRequest
+		if err := p.api.do(ctx, http.MethodGet, p.repoPath(repo)+"/pulls", query, nil, &prs); err != nil {
+			return nil, fmt.Errorf("calling pull request list API: %w", err)
+		}
+
+		for _, pr := range prs {
+			if pr.Head.Ref == head && pr.Base.Ref == base {
+				return pr.pullRequest(), nil
+			}
+		}
+
+		if len(prs) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+func (p *giteaProvider) CreatePullRequest(ctx context.Context, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error) {
+	title := opts.Title
+	if opts.Draft {
+		// Gitea treats pull requests whose title starts with WIP: as drafts.
+		title = "WIP: " + title
+	}
+
+	var pr giteaPullRequest
+	err := p.api.do(ctx, http.MethodPost, p.repoPath(repo)+"/pulls", nil, map[string]interface{}{
+		"head":  opts.Head,
+		"base":  opts.Base,
+		"title": title,
+		"body":  opts.Body,
+	}, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request creation API: %w", err)
+	}
+
+	return pr.pullRequest(), nil
+}
+
+func (p *giteaProvider) UpdatePullRequest(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) (*PullRequest, error) {
+	var pr giteaPullRequest
+	err := p.api.do(ctx, http.MethodPatch, p.pullPath(repo, number), nil, map[string]interface{}{
+		"title": opts.Title,
+		"body":  opts.Body,
+	}, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request edit API: %w", err)
+	}
+
+	return pr.pullRequest(), nil
+}
+
+func (p *giteaProvider) ClosePullRequest(ctx context.Context, repo *Repository, number int) (*PullRequest, error) {
+	var pr giteaPullRequest
+	err := p.api.do(ctx, http.MethodPatch, p.pullPath(repo, number), nil, map[string]interface{}{
+		"state": "closed",
+	}, &pr)
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request edit API: %w", err)
+	}
+
+	return pr.pullRequest(), nil
+}
+
+func (p *giteaProvider) UpdatePullRequestMetadata(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) error {
+	edit := map[string]interface{}{}
+
+	if len(opts.AssigneeIDs) > 0 {
+		edit["assignees"] = opts.AssigneeIDs
+	}
+
+	if opts.Milestone != "" {
+		id, err := p.milestoneID(ctx, repo, opts.Milestone)
+		if err != nil {
+			return err
+		}
+		edit["milestone"] = id
+	}
+
+	if len(edit) > 0 {
+		if err := p.api.do(ctx, http.MethodPatch, p.pullPath(repo, number), nil, edit, nil); err != nil {
+			return fmt.Errorf("calling pull request edit API: %w", err)
+		}
+	}
+
+	if len(opts.Labels) > 0 {
+		ids, err := p.labelIDs(ctx, repo, opts.Labels)
+		if err != nil {
+			return err
+		}
+
+		path := p.repoPath(repo) + "/issues/" + strconv.Itoa(number) + "/labels"
+		if err := p.api.do(ctx, http.MethodPost, path, nil, map[string]interface{}{"labels": ids}, nil); err != nil {
+			return fmt.Errorf("calling add labels API: %w", err)
+		}
+	}
+
+	if len(opts.Reviewers) > 0 || len(opts.TeamReviewers) > 0 {
+		err := p.api.do(ctx, http.MethodPost, p.pullPath(repo, number)+"/requested_reviewers", nil, map[string]interface{}{
+			"reviewers":      opts.Reviewers,
+			"team_reviewers": opts.TeamReviewers,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("calling request reviewers API: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// labelIDs returns the IDs of the labels, as Gitea refers to labels by ID.
+func (p *giteaProvider) labelIDs(ctx context.Context, repo *Repository, labels []string) ([]int64, error) {
+	var all []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := p.api.do(ctx, http.MethodGet, p.repoPath(repo)+"/labels", url.Values{"limit": {"50"}}, nil, &all); err != nil {
+		return nil, fmt.Errorf("calling label list API: %w", err)
+	}
+
+	var ids []int64
+	for _, l := range labels {
+		var found bool
+		for _, a := range all {
+			if a.Name == l {
+				ids = append(ids, a.ID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("label %q not found in %s/%s", l, repo.Owner, repo.Name)
+		}
+	}
+	return ids, nil
+}
+
+// milestoneID returns the ID of the milestone.
+// milestone can be either the ID or the title of a milestone.
+func (p *giteaProvider) milestoneID(ctx context.Context, repo *Repository, milestone string) (int64, error) {
+	if id, err := strconv.ParseInt(milestone, 10, 64); err == nil {
+		return id, nil
+	}
+
+	var found []struct {
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := p.api.do(ctx, http.MethodGet, p.repoPath(repo)+"/milestones", url.Values{"name": {milestone}}, nil, &found); err != nil {
+		return 0, fmt.Errorf("calling milestone list API: %w", err)
+	}
+
+	for _, m := range found {
+		if m.Title == milestone {
+			return m.ID, nil
+		}
+	}
+
+	return 0, fmt.Errorf("milestone %q not found in %s/%s", milestone, repo.Owner, repo.Name)
+}
+
+// MergePullRequest merges the pull request according to opts.Merge.
+//
+// For MergeAuto, it schedules the merge for when all the checks succeed.
+// For MergeWait, it waits for the checks to succeed and merges the pull request.
+func (p *giteaProvider) MergePullRequest(ctx context.Context, repo *Repository, r *PullRequest, opts CreatePullRequestOptions) error {
+	method := opts.MergeMethod
+	if method == "" {
+		method = MergeMethodMerge
+	}
+
+	switch opts.Merge {
+	case MergeAuto:
+		err := p.api.do(ctx, http.MethodPost, p.pullPath(repo, r.Number)+"/merge", nil, map[string]interface{}{
+			"Do":                        method,
+			"merge_when_checks_succeed": true,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("calling pull request merge API: %w", err)
+		}
+		r.AutoMerge = true
+	case MergeWait:
+		sha, err := p.waitAndMerge(ctx, repo, r.Number, method, opts)
+		if err != nil {
+			return err
+		}
+		r.Merged = true
+		r.MergeCommitSHA = sha
+	}
+
+	return nil
+}
+
+func (p *giteaProvider) waitAndMerge(ctx context.Context, repo *Repository, number int, method string, opts CreatePullRequestOptions) (string, error) {
+	timeout := opts.MergeTimeout
+	if timeout == 0 {
+		timeout = DefaultMergeTimeout
+	}
+
+	interval := opts.MergePollInterval
+	if interval == 0 {
+		interval = DefaultMergePollInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lastState := "unknown"
+	timedOut := func() error {
+		return fmt.Errorf("timed out waiting for pull request #%d to become mergeable: last status was %s", number, lastState)
+	}
+
+	get := func() (*giteaPullRequest, error) {
+		var pr giteaPullRequest
+		if err := p.api.do(ctx, http.MethodGet, p.pullPath(repo, number), nil, nil, &pr); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, timedOut()
+			}
+			return nil, fmt.Errorf("calling get pull request API: %w", err)
+		}
+		return &pr, nil
+	}
+
+	for {
+		pr, err := get()
+		if err != nil {
+			return "", err
+		}
+
+		if pr.Merged {
+			return pr.MergeCommitSHA, nil
+		}
+
+		var status struct {
+			State string `json:"state"`
+		}
+		path := p.repoPath(repo) + "/commits/" + url.PathEscape(pr.Head.SHA) + "/status"
+		if err := p.api.do(ctx, http.MethodGet, path, nil, nil, &status); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", timedOut()
+			}
+			return "", fmt.Errorf("calling combined status API: %w", err)
+		}
+		lastState = status.State
+
+		switch status.State {
+		case "failure", "error":
+			return "", fmt.Errorf("checks on pull request #%d concluded with %s", number, status.State)
+		case "", "success", "warning":
+			// The empty state means there are no checks.
+			if pr.Mergeable {
+				err := p.api.do(ctx, http.MethodPost, p.pullPath(repo, number)+"/merge", nil, map[string]interface{}{
+					"Do":             method,
+					"head_commit_id": pr.Head.SHA,
+				}, nil)
+				if err != nil {
+					return "", fmt.Errorf("calling pull request merge API: %w", err)
+				}
+
+				merged, err := get()
+				if err != nil {
+					return "", err
+				}
+				return merged.MergeCommitSHA, nil
+			}
+			lastState = "unmergeable"
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", timedOut()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+	"golang.org/x/oauth2"
+)
+
+// gitHubProvider is the PullRequestProvider for GitHub.
+type gitHubProvider struct {
+	client *github.Client
+}
+
+var _ PullRequestProvider = &gitHubProvider{}
+
+// newGitHubProvider returns the provider that authenticates with token,
+// or as the GitHub App specified by opts if token is empty.
+//
+// The API URL defaults to the one for the host of repo,
+// so that GitHub Enterprise Server works without configuring opts.APIURL.
+func newGitHubProvider(ctx context.Context, repo *Repository, token string, opts CreatePullRequestOptions) (*gitHubProvider, error) {
+	apiURL := opts.APIURL
+	if apiURL == "" {
+		apiURL = GitHubAPIURL(repo.Host)
+	}
+
+	if token == "" {
+		app := GitHubApp{
+			AppID:          opts.GitHubAppID,
+			InstallationID: opts.GitHubAppInstallationID,
+			PrivateKey:     []byte(os.Getenv(opts.GitHubAppPrivateKeyEnv)),
+			APIURL:         apiURL,
+		}
+
+		var err error
+		token, err = app.InstallationToken(ctx, repo)
+		if err != nil {
+			return nil, fmt.Errorf("authenticating as github app: %w", err)
+		}
+	}
+
+	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	))
+
+	client, err := newGitHubClient(httpClient, apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitHubProvider{client: client}, nil
+}
+
+func (p *gitHubProvider) FindOpenPullRequest(ctx context.Context, repo *Repository, head, base string) (*PullRequest, error) {
+	prs, _, err := p.client.PullRequests.List(ctx, repo.Owner, repo.Name, &github.PullRequestListOptions{
+		State: "open",
+		Head:  repo.Owner + ":" + head,
+		Base:  base,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request list API: %w", err)
+	}
+
+	if len(prs) == 0 {
+		return nil, nil
+	}
+
+	return gitHubPullRequest(prs[0]), nil
+}
+
+func (p *gitHubProvider) CreatePullRequest(ctx context.Context, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error) {
+	pr, _, err := p.client.PullRequests.Create(ctx, repo.Owner, repo.Name, &github.NewPullRequest{
+		Title: &opts.Title,
+		Body:  &opts.Body,
+		Head:  &opts.Head,
+		Base:  &opts.Base,
+		Draft: &opts.Draft,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request creation API: %w", err)
+	}
+
+	if pr == nil {
+		return nil, fmt.Errorf("assertion error: pull request is nil: %v", opts)
+	}
+
+	return gitHubPullRequest(pr), nil
+}
+
+func (p *gitHubProvider) UpdatePullRequest(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) (*PullRequest, error) {
+	pr, _, err := p.client.PullRequests.Edit(ctx, repo.Owner, repo.Name, number, &github.PullRequest{
+		Title: &opts.Title,
+		Body:  &opts.Body,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request edit API: %w", err)
+	}
+
+	if pr == nil {
+		return nil, fmt.Errorf("assertion error: pull request is nil: %v", opts)
+	}
+
+	return gitHubPullRequest(pr), nil
+}
+
+func (p *gitHubProvider) ClosePullRequest(ctx context.Context, repo *Repository, number int) (*PullRequest, error) {
+	pr, _, err := p.client.PullRequests.Edit(ctx, repo.Owner, repo.Name, number, &github.PullRequest{
+		State: github.String("closed"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("calling pull request edit API: %w", err)
+	}
+
+	if pr == nil {
+		return nil, fmt.Errorf("assertion error: pull request is nil: %d", number)
+	}
+
+	return gitHubPullRequest(pr), nil
+}
+
+func gitHubPullRequest(pr *github.PullRequest) *PullRequest {
+	r := &PullRequest{
+		ID:      pr.GetID(),
+		NodeID:  pr.GetNodeID(),
+		Number:  pr.GetNumber(),
+		HTMLURL: pr.GetHTMLURL(),
+	}
+	if h := pr.GetHead(); h != nil {
+		r.Head = h.GetRef()
+	}
+	return r
+}
+
+func (p *gitHubProvider) UpdatePullRequestMetadata(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) error {
+	if len(opts.AssigneeIDs) > 0 {
+		_, _, err := p.client.Issues.AddAssignees(ctx, repo.Owner, repo.Name, number, opts.AssigneeIDs)
+		if err != nil {
+			return fmt.Errorf("calling add assignees API: %w", err)
+		}
+	}
+
+	if len(opts.Labels) > 0 {
+		_, _, err := p.client.Issues.AddLabelsToIssue(ctx, repo.Owner, repo.Name, number, opts.Labels)
+		if err != nil {
+			return fmt.Errorf("calling add labels API: %w", err)
+		}
+	}
+
+	if len(opts.Reviewers) > 0 || len(opts.TeamReviewers) > 0 {
+		_, _, err := p.client.PullRequests.RequestReviewers(ctx, repo.Owner, repo.Name, number, github.ReviewersRequest{
+			Reviewers:     opts.Reviewers,
+			TeamReviewers: opts.TeamReviewers,
+		})
+		if err != nil {
+			return fmt.Errorf("calling request reviewers API: %w", err)
+		}
+	}
+
+	if opts.Milestone != "" {
+		milestone, err := p.resolveMilestone(ctx, repo, opts.Milestone)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = p.client.Issues.Edit(ctx, repo.Owner, repo.Name, number, &github.IssueRequest{
+			Milestone: &milestone,
+		})
+		if err != nil {
+			return fmt.Errorf("calling issue edit API to set milestone: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resolveMilestone returns the number of the milestone.
+// milestone can be either the number or the title of an open milestone.
+func (p *gitHubProvider) resolveMilestone(ctx context.Context, repo *Repository, milestone string) (int, error) {
+	if n, err := strconv.Atoi(milestone); err == nil {
+		return n, nil
+	}
+
+	opts := &github.MilestoneListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		ms, res, err := p.client.Issues.ListMilestones(ctx, repo.Owner, repo.Name, opts)
+		if err != nil {
+			return 0, fmt.Errorf("calling list milestones API: %w", err)
+		}
+
+		for _, m := range ms {
+			if m.GetTitle() == milestone {
+				return m.GetNumber(), nil
+			}
+		}
+
+		if res == nil || res.NextPage == 0 {
+			break
+		}
+		opts.Page = res.NextPage
+	}
+
+	return 0, fmt.Errorf("milestone %q not found in %s/%s", milestone, repo.Owner, repo.Name)
+}
+
+// MergePullRequest merges the pull request according to opts.Merge.
+//
+// For MergeAuto, it enables auto-merge and sets r.AutoMerge.
+// For MergeWait, it waits for the pull request to become mergeable and merges it,
+// and sets r.Merged and r.MergeCommitSHA.
+func (p *gitHubProvider) MergePullRequest(ctx context.Context, repo *Repository, r *PullRequest, opts CreatePullRequestOptions) error {
+	method := opts.MergeMethod
+	if method == "" {
+		method = MergeMethodMerge
+	}
+
+	switch opts.Merge {
+	case MergeAuto:
+		if err := p.enableAutoMerge(ctx, r.NodeID, method); err != nil {
+			return err
+		}
+		r.AutoMerge = true
+	case MergeWait:
+		sha, err := p.waitAndMerge(ctx, repo, r.Number, method, opts)
+		if err != nil {
+			return err
+		}
+		r.Merged = true
+		r.MergeCommitSHA = sha
+	}
+
+	return nil
+}
+
+// enableAutoMerge enables auto-merge on the pull request.
+// There's no REST API for that, so we use the GraphQL API.
+func (p *gitHubProvider) enableAutoMerge(ctx context.Context, nodeID, method string) error {
+	if nodeID == "" {
+		return errors.New("assertion error: node ID of the pull request is required to enable auto-merge")
+	}
+
+	body := map[string]interface{}{
+		"query": `mutation($id: ID!, $method: PullRequestMergeMethod!) {
+  enablePullRequestAutoMerge(input: {pullRequestId: $id, mergeMethod: $method}) {
+    pullRequest { number }
+  }
+}`,
+		"variables": map[string]interface{}{
+			"id":     nodeID,
+			"method": strings.ToUpper(method),
+		},
+	}
+
+	req, err := p.client.NewRequest("POST", gitHubGraphQLURL(p.client.BaseURL), body)
+	if err != nil {
+		return fmt.Errorf("creating auto-merge request: %w", err)
+	}
+
+	var res struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := p.client.Do(ctx, req, &res); err != nil {
+		return fmt.Errorf("calling enable auto-merge API: %w", err)
+	}
+
+	if len(res.Errors) > 0 {
+		var msgs []string
+		for _, e := range res.Errors {
+			msgs = append(msgs, e.Message)
+		}
+		return fmt.Errorf("enabling auto-merge: %s", strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+// waitAndMerge polls the pull request until it becomes mergeable, and merges it.
+// It returns the SHA of the merge commit.
+//
+// It fails early when the pull request has conflicts or any check run on the head fails,
+// and fails after opts.MergeTimeout otherwise.
+func (p *gitHubProvider) waitAndMerge(ctx context.Context, repo *Repository, number int, method string, opts CreatePullRequestOptions) (string, error) {
+	timeout := opts.MergeTimeout
+	if timeout == 0 {
+		timeout = DefaultMergeTimeout
+	}
+
+	interval := opts.MergePollInterval
+	if interval == 0 {
+		interval = DefaultMergePollInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lastState := "unknown"
+	timedOut := func() error {
+		return fmt.Errorf("timed out waiting for pull request #%d to become mergeable: last mergeable state was %s", number, lastState)
+	}
+
+	for {
+		pr, _, err := p.client.PullRequests.Get(ctx, repo.Owner, repo.Name, number)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", timedOut()
+			}
+			return "", fmt.Errorf("calling get pull request API: %w", err)
+		}
+		lastState = pr.GetMergeableState()
+
+		if pr.GetMerged() {
+			return pr.GetMergeCommitSHA(), nil
+		}
+
+		headSHA := pr.GetHead().GetSHA()
+
+		// See https://docs.github.com/en/graphql/reference/enums#mergestatestatus
+		switch lastState {
+		case "clean", "unstable", "has_hooks":
+			res, _, err := p.client.PullRequests.Merge(ctx, repo.Owner, repo.Name, number, "", &github.PullRequestOptions{
+				MergeMethod: method,
+				SHA:         headSHA,
+			})
+			if err != nil {
+				return "", fmt.Errorf("calling merge pull request API: %w", err)
+			}
+			return res.GetSHA(), nil
+		case "dirty":
+			return "", fmt.Errorf("pull request #%d has conflicts with the base branch", number)
+		}
+
+		if headSHA != "" {
+			runs, _, err := p.client.Checks.ListCheckRunsForRef(ctx, repo.Owner, repo.Name, headSHA, nil)
+			if err != nil {
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+					return "", timedOut()
+				}
+				return "", fmt.Errorf("calling list check runs API: %w", err)
+			}
+
+			for _, run := range runs.CheckRuns {
+				switch run.GetConclusion() {
+				case "failure", "cancelled", "timed_out", "action_required":
+					return "", fmt.Errorf("check %s on pull request #%d concluded with %s", run.GetName(), number, run.GetConclusion())
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", timedOut()
+		case <-time.After(interval):
+		}
+	}
+}
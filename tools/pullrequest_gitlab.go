@@ -0,0 +1,324 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitLabProvider is the PullRequestProvider for GitLab,
+// which creates merge requests via the GitLab REST API v4.
+type gitLabProvider struct {
+	api *restClient
+}
+
+var _ PullRequestProvider = &gitLabProvider{}
+
+func newGitLabProvider(apiURL, token string) *gitLabProvider {
+	return &gitLabProvider{
+		api: &restClient{
+			baseURL: apiURL,
+			header:  http.Header{"Private-Token": []string{token}},
+		},
+	}
+}
+
+type gitLabMergeRequest struct {
+	ID                  int64  `json:"id"`
+	IID                 int    `json:"iid"`
+	SourceBranch        string `json:"source_branch"`
+	WebURL              string `json:"web_url"`
+	State               string `json:"state"`
+	DetailedMergeStatus string `json:"detailed_merge_status"`
+	SHA                 string `json:"sha"`
+	MergeCommitSHA      string `json:"merge_commit_sha"`
+	SquashCommitSHA     string `json:"squash_commit_sha"`
+	HeadPipeline        *struct {
+		Status string `json:"status"`
+	} `json:"head_pipeline"`
+}
+
+func (mr gitLabMergeRequest) pullRequest() *PullRequest {
+	return &PullRequest{
+		ID:      mr.ID,
+		Number:  mr.IID,
+		Head:    mr.SourceBranch,
+		HTMLURL: mr.WebURL,
+	}
+}
+
+// projectPath returns the API path of the project, whose ID is the URL-encoded path.
+func (p *gitLabProvider) projectPath(repo *Repository) string {
+	return "/projects/" + url.PathEscape(repo.FullName())
+}
+
+func (p *gitLabProvider) mergeRequestPath(repo *Repository, iid int) string {
+	return p.projectPath(repo) + "/merge_requests/" + strconv.Itoa(iid)
+}
+
+func (p *gitLabProvider) FindOpenPullRequest(ctx context.Context, repo *Repository, head, base string) (*PullRequest, error) {
+	var mrs []gitLabMergeRequest
+	err := p.api.do(ctx, http.MethodGet, p.projectPath(repo)+"/merge_requests", url.Values{
+		"state":         {"opened"},
+		"source_branch": {head},
+		"target_branch": {base},
+	}, nil, &mrs)
+	if err != nil {
+		return nil, fmt.Errorf("calling merge request list API: %w", err)
+	}
+
+	if len(mrs) == 0 {
+		return nil, nil
+	}
+
+	return mrs[0].pullRequest(), nil
+}
+
+func (p *gitLabProvider) CreatePullRequest(ctx context.Context, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error) {
+	title := opts.Title
+	if opts.Draft {
+		title = "Draft: " + title
+	}
+
+	var mr gitLabMergeRequest
+	err := p.api.do(ctx, http.MethodPost, p.projectPath(repo)+"/merge_requests", nil, map[string]interface{}{
+		"source_branch": opts.Head,
+		"target_branch": opts.Base,
+		"title":         title,
+		"description":   opts.Body,
+	}, &mr)
+	if err != nil {
+		return nil, fmt.Errorf("calling merge request creation API: %w", err)
+	}
+
+	return mr.pullRequest(), nil
+}
+
+func (p *gitLabProvider) UpdatePullRequest(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) (*PullRequest, error) {
+	var mr gitLabMergeRequest
+	err := p.api.do(ctx, http.MethodPut, p.mergeRequestPath(repo, number), nil, map[string]interface{}{
+		"title":       opts.Title,
+		"description": opts.Body,
+	}, &mr)
+	if err != nil {
+		return nil, fmt.Errorf("calling merge request update API: %w", err)
+	}
+
+	return mr.pullRequest(), nil
+}
+
+func (p *gitLabProvider) ClosePullRequest(ctx context.Context, repo *Repository, number int) (*PullRequest, error) {
+	var mr gitLabMergeRequest
+	err := p.api.do(ctx, http.MethodPut, p.mergeRequestPath(repo, number), nil, map[string]interface{}{
+		"state_event": "close",
+	}, &mr)
+	if err != nil {
+		return nil, fmt.Errorf("calling merge request update API: %w", err)
+	}
+
+	return mr.pullRequest(), nil
+}
+
+func (p *gitLabProvider) UpdatePullRequestMetadata(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) error {
+	if len(opts.TeamReviewers) > 0 {
+		return errors.New("team reviewers are not supported by gitlab")
+	}
+
+	update := map[string]interface{}{}
+
+	if len(opts.AssigneeIDs) > 0 {
+		ids, err := p.userIDs(ctx, opts.AssigneeIDs)
+		if err != nil {
+			return err
+		}
+		update["assignee_ids"] = ids
+	}
+
+	if len(opts.Reviewers) > 0 {
+		ids, err := p.userIDs(ctx, opts.Reviewers)
+		if err != nil {
+			return err
+		}
+		update["reviewer_ids"] = ids
+	}
+
+	if len(opts.Labels) > 0 {
+		update["add_labels"] = strings.Join(opts.Labels, ",")
+	}
+
+	if opts.Milestone != "" {
+		id, err := p.milestoneID(ctx, repo, opts.Milestone)
+		if err != nil {
+			return err
+		}
+		update["milestone_id"] = id
+	}
+
+	if len(update) == 0 {
+		return nil
+	}
+
+	if err := p.api.do(ctx, http.MethodPut, p.mergeRequestPath(repo, number), nil, update, nil); err != nil {
+		return fmt.Errorf("calling merge request update API: %w", err)
+	}
+
+	return nil
+}
+
+// userIDs returns the IDs of the users.
+// Each user can be either the ID or the username.
+func (p *gitLabProvider) userIDs(ctx context.Context, users []string) ([]int, error) {
+	var ids []int
+	for _, u := range users {
+		if id, err := strconv.Atoi(u); err == nil {
+			ids = append(ids, id)
+			continue
+		}
+
+		var found []struct {
+			ID int `json:"id"`
+		}
+		if err := p.api.do(ctx, http.MethodGet, "/users", url.Values{"username": {u}}, nil, &found); err != nil {
+			return nil, fmt.Errorf("calling user list API: %w", err)
+		}
+
+		if len(found) == 0 {
+			return nil, fmt.Errorf("gitlab user %q not found", u)
+		}
+		ids = append(ids, found[0].ID)
+	}
+	return ids, nil
+}
+
+// milestoneID returns the ID of the milestone.
+// milestone can be either the ID or the title of a milestone.
+func (p *gitLabProvider) milestoneID(ctx context.Context, repo *Repository, milestone string) (int, error) {
+	if id, err := strconv.Atoi(milestone); err == nil {
+		return id, nil
+	}
+
+	var found []struct {
+		ID int `json:"id"`
+	}
+	if err := p.api.do(ctx, http.MethodGet, p.projectPath(repo)+"/milestones", url.Values{"title": {milestone}}, nil, &found); err != nil {
+		return 0, fmt.Errorf("calling milestone list API: %w", err)
+	}
+
+	if len(found) == 0 {
+		return 0, fmt.Errorf("milestone %q not found in %s/%s", milestone, repo.Owner, repo.Name)
+	}
+
+	return found[0].ID, nil
+}
+
+// MergePullRequest merges the merge request according to opts.Merge.
+//
+// For MergeAuto, it sets the merge request to be merged when the pipeline succeeds.
+// For MergeWait, it waits for the merge request to become mergeable and merges it.
+// GitLab decides between merge commits and fast-forward merges per project,
+// so MergeMethodRebase is not supported.
+func (p *gitLabProvider) MergePullRequest(ctx context.Context, repo *Repository, r *PullRequest, opts CreatePullRequestOptions) error {
+	if opts.Merge == "" {
+		return nil
+	}
+
+	if opts.MergeMethod == MergeMethodRebase {
+		return fmt.Errorf("%s %s is not supported by gitlab", FlagCreatePullRequestMergeMethod, MergeMethodRebase)
+	}
+
+	squash := opts.MergeMethod == MergeMethodSquash
+
+	switch opts.Merge {
+	case MergeAuto:
+		err := p.api.do(ctx, http.MethodPut, p.mergeRequestPath(repo, r.Number)+"/merge", nil, map[string]interface{}{
+			"merge_when_pipeline_succeeds": true,
+			"squash":                       squash,
+		}, nil)
+		if err != nil {
+			return fmt.Errorf("calling merge request merge API: %w", err)
+		}
+		r.AutoMerge = true
+	case MergeWait:
+		sha, err := p.waitAndMerge(ctx, repo, r.Number, squash, opts)
+		if err != nil {
+			return err
+		}
+		r.Merged = true
+		r.MergeCommitSHA = sha
+	}
+
+	return nil
+}
+
+func (p *gitLabProvider) waitAndMerge(ctx context.Context, repo *Repository, iid int, squash bool, opts CreatePullRequestOptions) (string, error) {
+	timeout := opts.MergeTimeout
+	if timeout == 0 {
+		timeout = DefaultMergeTimeout
+	}
+
+	interval := opts.MergePollInterval
+	if interval == 0 {
+		interval = DefaultMergePollInterval
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	lastStatus := "unknown"
+	timedOut := func() error {
+		return fmt.Errorf("timed out waiting for merge request !%d to become mergeable: last merge status was %s", iid, lastStatus)
+	}
+
+	for {
+		var mr gitLabMergeRequest
+		if err := p.api.do(ctx, http.MethodGet, p.mergeRequestPath(repo, iid), nil, nil, &mr); err != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", timedOut()
+			}
+			return "", fmt.Errorf("calling get merge request API: %w", err)
+		}
+		lastStatus = mr.DetailedMergeStatus
+
+		if mr.State == "merged" {
+			return mr.mergeCommitSHA(), nil
+		}
+
+		// See https://docs.gitlab.com/ee/api/merge_requests.html#merge-status
+		switch lastStatus {
+		case "mergeable":
+			var merged gitLabMergeRequest
+			err := p.api.do(ctx, http.MethodPut, p.mergeRequestPath(repo, iid)+"/merge", nil, map[string]interface{}{
+				"sha":    mr.SHA,
+				"squash": squash,
+			}, &merged)
+			if err != nil {
+				return "", fmt.Errorf("calling merge request merge API: %w", err)
+			}
+			return merged.mergeCommitSHA(), nil
+		case "conflict", "broken_status":
+			return "", fmt.Errorf("merge request !%d has conflicts with the target branch", iid)
+		}
+
+		if pl := mr.HeadPipeline; pl != nil && (pl.Status == "failed" || pl.Status == "canceled") {
+			return "", fmt.Errorf("pipeline on merge request !%d %s", iid, pl.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", timedOut()
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (mr gitLabMergeRequest) mergeCommitSHA() string {
+	if mr.MergeCommitSHA != "" {
+		return mr.MergeCommitSHA
+	}
+	return mr.SquashCommitSHA
+}
@@ -0,0 +1,41 @@
+package tools
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	FlagCreatePullRequestMerge        = "merge"
+	FlagCreatePullRequestMergeMethod  = "merge-method"
+	FlagCreatePullRequestMergeTimeout = "merge-timeout"
+
+	// MergeAuto enables GitHub auto-merge on the pull request,
+	// so that GitHub merges it once the branch protection requirements are met.
+	MergeAuto = "auto"
+	// MergeWait waits for the required checks to pass and merges the pull request.
+	MergeWait = "wait"
+
+	MergeMethodMerge  = "merge"
+	MergeMethodSquash = "squash"
+	MergeMethodRebase = "rebase"
+
+	DefaultMergeTimeout      = 30 * time.Minute
+	DefaultMergePollInterval = 10 * time.Second
+)
+
+func validateMergeOptions(opts CreatePullRequestOptions) error {
+	switch opts.Merge {
+	case "", MergeAuto, MergeWait:
+	default:
+		return fmt.Errorf("%s must be either %s or %s, but got %s", FlagCreatePullRequestMerge, MergeAuto, MergeWait, opts.Merge)
+	}
+
+	switch opts.MergeMethod {
+	case "", MergeMethodMerge, MergeMethodSquash, MergeMethodRebase:
+	default:
+		return fmt.Errorf("%s must be one of %s, %s and %s, but got %s", FlagCreatePullRequestMergeMethod, MergeMethodMerge, MergeMethodSquash, MergeMethodRebase, opts.MergeMethod)
+	}
+
+	return nil
+}
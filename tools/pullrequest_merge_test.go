@@ -0,0 +1,155 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v56/github"
+	"github.com/stretchr/testify/require"
+)
+
+// newGitHubStub returns a GitHub client whose requests are served by handler.
+func newGitHubStub(t *testing.T, handler http.HandlerFunc) *github.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	u, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = u
+
+	return client
+}
+
+func TestMergePullRequest_Auto(t *testing.T) {
+	var query map[string]interface{}
+
+	client := newGitHubStub(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "POST /graphql", r.Method+" "+r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&query))
+		fmt.Fprint(w, `{"data":{"enablePullRequestAutoMerge":{"pullRequest":{"number":1}}}}`)
+	})
+
+	r := &PullRequest{Number: 1, NodeID: "PR_1"}
+	err := (&gitHubProvider{client: client}).MergePullRequest(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"}, r, CreatePullRequestOptions{
+		Merge:       MergeAuto,
+		MergeMethod: MergeMethodSquash,
+	})
+	require.NoError(t, err)
+	require.Equal(t, &PullRequest{Number: 1, NodeID: "PR_1", AutoMerge: true}, r)
+	require.Equal(t, map[string]interface{}{"id": "PR_1", "method": "SQUASH"}, query["variables"])
+}
+
+func TestMergePullRequest_AutoError(t *testing.T) {
+	client := newGitHubStub(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"errors":[{"message":"Pull request is not in the correct state to enable auto-merge"}]}`)
+	})
+
+	err := (&gitHubProvider{client: client}).MergePullRequest(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"}, &PullRequest{Number: 1, NodeID: "PR_1"}, CreatePullRequestOptions{
+		Merge: MergeAuto,
+	})
+	require.EqualError(t, err, "enabling auto-merge: Pull request is not in the correct state to enable auto-merge")
+}
+
+func TestMergePullRequest_Wait(t *testing.T) {
+	tests := []struct {
+		name       string
+		states     []string
+		conclusion string
+		timeout    time.Duration
+		wantSHA    string
+		wantErr    string
+	}{
+		{
+			name:    "merged after checks pass",
+			states:  []string{"blocked", "blocked", "clean"},
+			wantSHA: "mergesha",
+		},
+		{
+			name:    "merged with non-required checks failing",
+			states:  []string{"unstable"},
+			wantSHA: "mergesha",
+		},
+		{
+			name:    "conflicts",
+			states:  []string{"dirty"},
+			wantErr: "pull request #1 has conflicts with the base branch",
+		},
+		{
+			name:       "failed check",
+			states:     []string{"blocked"},
+			conclusion: "failure",
+			wantErr:    "check test on pull request #1 concluded with failure",
+		},
+		{
+			name:    "timeout",
+			states:  []string{"blocked"},
+			timeout: 50 * time.Millisecond,
+			wantErr: "timed out waiting for pull request #1 to become mergeable: last mergeable state was blocked",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gets   int
+				merges []map[string]interface{}
+			)
+
+			client := newGitHubStub(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method + " " + r.URL.Path {
+				case "GET /repos/myorg/myrepo/pulls/1":
+					state := tt.states[len(tt.states)-1]
+					if gets < len(tt.states) {
+						state = tt.states[gets]
+					}
+					gets++
+					fmt.Fprintf(w, `{"number":1,"mergeable_state":%q,"head":{"sha":"headsha"}}`, state)
+				case "GET /repos/myorg/myrepo/commits/headsha/check-runs":
+					fmt.Fprintf(w, `{"total_count":1,"check_runs":[{"name":"test","status":"completed","conclusion":%q}]}`, tt.conclusion)
+				case "PUT /repos/myorg/myrepo/pulls/1/merge":
+					var body map[string]interface{}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					merges = append(merges, body)
+					fmt.Fprint(w, `{"sha":"mergesha","merged":true}`)
+				default:
+					t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+
+			r := &PullRequest{Number: 1}
+			err := (&gitHubProvider{client: client}).MergePullRequest(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"}, r, CreatePullRequestOptions{
+				Merge:             MergeWait,
+				MergeMethod:       MergeMethodRebase,
+				MergeTimeout:      tt.timeout,
+				MergePollInterval: time.Millisecond,
+			})
+
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				require.Empty(t, merges)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, &PullRequest{Number: 1, Merged: true, MergeCommitSHA: tt.wantSHA}, r)
+			require.Equal(t, []map[string]interface{}{{"merge_method": "rebase", "sha": "headsha"}}, merges)
+		})
+	}
+}
+
+func TestValidateMergeOptions(t *testing.T) {
+	require.NoError(t, validateMergeOptions(CreatePullRequestOptions{Merge: MergeWait, MergeMethod: MergeMethodSquash}))
+	require.EqualError(t, validateMergeOptions(CreatePullRequestOptions{Merge: "now"}), "merge must be either auto or wait, but got now")
+	require.EqualError(t, validateMergeOptions(CreatePullRequestOptions{MergeMethod: "ff"}), "merge-method must be one of merge, squash and rebase, but got ff")
+}
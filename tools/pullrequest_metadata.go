@@ -0,0 +1,100 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+const (
+	FlagCreatePullRequestLabels        = "labels"
+	FlagCreatePullRequestReviewers     = "reviewers"
+	FlagCreatePullRequestTeamReviewers = "team-reviewers"
+	FlagCreatePullRequestDraft         = "draft"
+	FlagCreatePullRequestMilestone     = "milestone"
+	FlagCreatePullRequestTitleTemplate = "title-template"
+	FlagCreatePullRequestBodyTemplate  = "body-template"
+	FlagCreatePullRequestName          = "name"
+	FlagCreatePullRequestImages        = "images"
+	FlagCreatePullRequestChartVersion  = "chart-version"
+)
+
+// PullRequestTemplateData is the data available to
+// the title and the body templates of the pull request.
+//
+// For example, the title template can be:
+//
+//	Deploy {{ .Name }} {{ join .Images ", " }}
+type PullRequestTemplateData struct {
+	// Name is the name of the application being deployed.
+	Name string
+	// Images is the list of container images being deployed,
+	// each in the form of name[=newName][:tag|@digest].
+	Images []string
+	// ChartVersion is the version of the Helm chart being deployed.
+	ChartVersion string
+	// Head and Base are the branches of the pull request.
+	Head string
+	Base string
+	// DiffStat is the output of git diff --stat between Base and Head.
+	DiffStat string
+}
+
+// RenderPullRequestTemplate renders the Go template text with data.
+// It's also used to render the commit messages of gitops commits.
+func RenderPullRequestTemplate(name, text string, data PullRequestTemplateData) (string, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"join": strings.Join}).
+		Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("rendering %s: %w", name, err)
+	}
+
+	return buf.String(), nil
+}
+
+// renderPullRequestTitleAndBody returns the title and the body of the pull request.
+// The templates take precedence over opts.Title and opts.Body if set.
+func renderPullRequestTitleAndBody(ctx context.Context, git GitBackend, opts CreatePullRequestOptions) (string, string, error) {
+	title, body := opts.Title, opts.Body
+
+	if opts.TitleTemplate == "" && opts.BodyTemplate == "" {
+		return title, body, nil
+	}
+
+	data := opts.TemplateData
+	data.Head = opts.Head
+	data.Base = opts.Base
+
+	out, err := git.Diff(ctx, opts.Dir, "origin/"+opts.Base, false)
+	if err != nil {
+		return "", "", err
+	}
+	data.DiffStat = strings.TrimRight(out, "\n")
+
+	if opts.TitleTemplate != "" {
+		title, err = RenderPullRequestTemplate(FlagCreatePullRequestTitleTemplate, opts.TitleTemplate, data)
+		if err != nil {
+			return "", "", err
+		}
+		// GitHub rejects multi-line titles.
+		title = strings.TrimSpace(title)
+	}
+
+	if opts.BodyTemplate != "" {
+		body, err = RenderPullRequestTemplate(FlagCreatePullRequestBodyTemplate, opts.BodyTemplate, data)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return title, body, nil
+}
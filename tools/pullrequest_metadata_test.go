@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v56/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPullRequestTemplate(t *testing.T) {
+	data := PullRequestTemplateData{
+		Name:         "myapp",
+		Images:       []string{"myapp=example.com/myapp:v2", "sidecar:v1"},
+		ChartVersion: "1.2.3",
+		Head:         "kargo/myapp",
+		Base:         "main",
+		DiffStat:     " deploy/kustomization.yaml | 2 +-",
+	}
+
+	got, err := RenderPullRequestTemplate("title", `Deploy {{ .Name }} {{ join .Images ", " }} (chart {{ .ChartVersion }})`, data)
+	require.NoError(t, err)
+	require.Equal(t, "Deploy myapp myapp=example.com/myapp:v2, sidecar:v1 (chart 1.2.3)", got)
+
+	got, err = RenderPullRequestTemplate("body", "{{ .Head }} -> {{ .Base }}\n{{ .DiffStat }}", data)
+	require.NoError(t, err)
+	require.Equal(t, "kargo/myapp -> main\n deploy/kustomization.yaml | 2 +-", got)
+
+	_, err = RenderPullRequestTemplate("title", "{{ .Nme }}", data)
+	require.ErrorContains(t, err, "rendering title")
+}
+
+func TestUpdatePullRequestMetadata(t *testing.T) {
+	var requests []string
+	bodies := map[string]interface{}{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := r.Method + " " + r.URL.Path
+		requests = append(requests, req)
+
+		if r.Method == http.MethodGet {
+			require.Equal(t, "/repos/myorg/myrepo/milestones", r.URL.Path)
+			fmt.Fprint(w, `[{"number":3,"title":"v1.0"},{"number":4,"title":"v1.1"}]`)
+			return
+		}
+
+		var body interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		bodies[req] = body
+		if _, ok := body.([]interface{}); ok {
+			fmt.Fprint(w, `[]`)
+		} else {
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	defer srv.Close()
+
+	client := github.NewClient(nil)
+	u, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = u
+
+	err = (&gitHubProvider{client: client}).UpdatePullRequestMetadata(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"}, 1, CreatePullRequestOptions{
+		AssigneeIDs:   []string{"alice"},
+		Labels:        []string{"deploy", "myapp"},
+		Reviewers:     []string{"bob"},
+		TeamReviewers: []string{"sre"},
+		Milestone:     "v1.1",
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{
+		"POST /repos/myorg/myrepo/issues/1/assignees",
+		"POST /repos/myorg/myrepo/issues/1/labels",
+		"POST /repos/myorg/myrepo/pulls/1/requested_reviewers",
+		"GET /repos/myorg/myrepo/milestones",
+		"PATCH /repos/myorg/myrepo/issues/1",
+	}, requests)
+
+	require.Equal(t, []interface{}{"deploy", "myapp"}, bodies["POST /repos/myorg/myrepo/issues/1/labels"])
+	require.Equal(t, map[string]interface{}{"reviewers": []interface{}{"bob"}, "team_reviewers": []interface{}{"sre"}}, bodies["POST /repos/myorg/myrepo/pulls/1/requested_reviewers"])
+	require.Equal(t, map[string]interface{}{"milestone": float64(4)}, bodies["PATCH /repos/myorg/myrepo/issues/1"])
+
+	requests = nil
+	err = (&gitHubProvider{client: client}).UpdatePullRequestMetadata(context.Background(), &Repository{Owner: "myorg", Name: "myrepo"}, 1, CreatePullRequestOptions{
+		Milestone: "v2.0",
+	})
+	require.EqualError(t, err, `milestone "v2.0" not found in myorg/myrepo`)
+}
@@ -0,0 +1,193 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	FlagCreatePullRequestProvider = "provider"
+	FlagCreatePullRequestAPIURL   = "api-url"
+
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+	ProviderGitea  = "gitea"
+)
+
+// PullRequestProvider is a git hosting service on which
+// CreatePullRequest creates pull requests (or merge requests on GitLab).
+type PullRequestProvider interface {
+	// FindOpenPullRequest returns the open pull request from head to base.
+	// It returns nil if there's none.
+	FindOpenPullRequest(ctx context.Context, repo *Repository, head, base string) (*PullRequest, error)
+	// CreatePullRequest creates the pull request from opts.Head to opts.Base.
+	CreatePullRequest(ctx context.Context, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error)
+	// UpdatePullRequest updates the title and the body of the pull request.
+	UpdatePullRequest(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) (*PullRequest, error)
+	// ClosePullRequest closes the pull request without merging it.
+	ClosePullRequest(ctx context.Context, repo *Repository, number int) (*PullRequest, error)
+	// UpdatePullRequestMetadata sets the assignees, labels, reviewers and milestone.
+	// It's called for both new and updated pull requests,
+	// so it needs to be idempotent.
+	UpdatePullRequestMetadata(ctx context.Context, repo *Repository, number int, opts CreatePullRequestOptions) error
+	// MergePullRequest merges the pull request according to opts.Merge,
+	// and sets the merge result to r.
+	MergePullRequest(ctx context.Context, repo *Repository, r *PullRequest, opts CreatePullRequestOptions) error
+}
+
+// DetectPullRequestProvider returns the provider for the git host.
+// It returns ProviderGitHub unless the host looks like GitLab or Gitea.
+func DetectPullRequestProvider(host string) string {
+	h := strings.ToLower(host)
+	switch {
+	case strings.Contains(h, "gitlab"):
+		return ProviderGitLab
+	case strings.Contains(h, "gitea"):
+		return ProviderGitea
+	default:
+		return ProviderGitHub
+	}
+}
+
+// newPullRequestProvider returns the provider specified by opts.Provider,
+// or the one detected from the host of repo.
+func newPullRequestProvider(ctx context.Context, repo *Repository, token string, opts CreatePullRequestOptions) (PullRequestProvider, error) {
+	provider := opts.Provider
+	if provider == "" {
+		provider = DetectPullRequestProvider(repo.Host)
+	}
+
+	switch provider {
+	case ProviderGitHub:
+		return newGitHubProvider(ctx, repo, token, opts)
+	case ProviderGitLab:
+		apiURL := opts.APIURL
+		if apiURL == "" {
+			apiURL = "https://" + repo.Host + "/api/v4"
+		}
+		return newGitLabProvider(apiURL, token), nil
+	case ProviderGitea:
+		apiURL := opts.APIURL
+		if apiURL == "" {
+			apiURL = "https://" + repo.Host + "/api/v1"
+		}
+		return newGiteaProvider(apiURL, token), nil
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q", FlagCreatePullRequestProvider, provider)
+	}
+}
+
+// upsertPullRequest creates the pull request from opts.Head to opts.Base.
+// If opts.UpdateExisting is true and there's already an open pull request
+// from opts.Head to opts.Base, it updates the pull request instead
+// and marks it as Updated.
+func upsertPullRequest(ctx context.Context, p PullRequestProvider, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error) {
+	if opts.UpdateExisting {
+		existing, err := p.FindOpenPullRequest(ctx, repo, opts.Head, opts.Base)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			r, err := p.UpdatePullRequest(ctx, repo, existing.Number, opts)
+			if err != nil {
+				return nil, err
+			}
+			r.Updated = true
+			return r, nil
+		}
+	}
+
+	return p.CreatePullRequest(ctx, repo, opts)
+}
+
+// closeStalePullRequest closes the open pull request from opts.Head to opts.Base, if any,
+// and returns it marked as Closed. It returns nil if there's none.
+func closeStalePullRequest(ctx context.Context, p PullRequestProvider, repo *Repository, opts CreatePullRequestOptions) (*PullRequest, error) {
+	existing, err := p.FindOpenPullRequest(ctx, repo, opts.Head, opts.Base)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	r, err := p.ClosePullRequest(ctx, repo, existing.Number)
+	if err != nil {
+		return nil, err
+	}
+	r.Closed = true
+
+	return r, nil
+}
+
+// restClient is a minimal JSON REST API client
+// for the providers that have no dedicated client library.
+type restClient struct {
+	baseURL string
+	header  http.Header
+	client  *http.Client
+}
+
+// do sends the request to baseURL+path, with in encoded as the JSON body if non-nil,
+// and decodes the JSON response into out if non-nil.
+// path must already be escaped.
+func (c *restClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	u := strings.TrimSuffix(c.baseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+
+	for k, vs := range c.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response of %s %s: %w", method, path, err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("decoding response of %s %s: %w", method, path, err)
+		}
+	}
+
+	return nil
+}
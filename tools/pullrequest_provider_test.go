@@ -0,0 +1,257 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectPullRequestProvider(t *testing.T) {
+	require.Equal(t, ProviderGitHub, DetectPullRequestProvider("github.com"))
+	require.Equal(t, ProviderGitHub, DetectPullRequestProvider("github.example.com"))
+	require.Equal(t, ProviderGitLab, DetectPullRequestProvider("gitlab.com"))
+	require.Equal(t, ProviderGitLab, DetectPullRequestProvider("GitLab.example.com"))
+	require.Equal(t, ProviderGitea, DetectPullRequestProvider("gitea.example.com"))
+}
+
+func TestNewPullRequestProvider(t *testing.T) {
+	ctx := context.Background()
+
+	p, err := newPullRequestProvider(ctx, &Repository{Host: "gitlab.example.com"}, "token", CreatePullRequestOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "https://gitlab.example.com/api/v4", p.(*gitLabProvider).api.baseURL)
+
+	p, err = newPullRequestProvider(ctx, &Repository{Host: "git.example.com"}, "token", CreatePullRequestOptions{Provider: ProviderGitea})
+	require.NoError(t, err)
+	require.Equal(t, "https://git.example.com/api/v1", p.(*giteaProvider).api.baseURL)
+
+	_, err = newPullRequestProvider(ctx, &Repository{Host: "git.example.com"}, "token", CreatePullRequestOptions{Provider: "bitbucket"})
+	require.EqualError(t, err, `unsupported provider: "bitbucket"`)
+}
+
+// stubRequest is a request received by the provider API stub.
+type stubRequest struct {
+	Method string
+	Path   string
+	Body   interface{}
+}
+
+// newProviderStub starts an API stub that records the requests,
+// and responds with the result of respond for each request.
+func newProviderStub(t *testing.T, respond func(r stubRequest) (int, string)) (string, *[]stubRequest) {
+	t.Helper()
+
+	var requests []stubRequest
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := stubRequest{Method: r.Method, Path: r.URL.EscapedPath()}
+		if r.URL.RawQuery != "" {
+			req.Path += "?" + r.URL.RawQuery
+		}
+		if r.Body != nil && r.ContentLength != 0 {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req.Body))
+		}
+		requests = append(requests, req)
+
+		status, body := respond(req)
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.URL, &requests
+}
+
+func TestGitLabProvider(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repository{Host: "gitlab.example.com", Owner: "mygroup", Name: "myrepo"}
+
+	var gets int
+	apiURL, requests := newProviderStub(t, func(r stubRequest) (int, string) {
+		switch r.Method + " " + r.Path {
+		case "GET /projects/mygroup%2Fmyrepo/merge_requests?source_branch=kargo%2Fmyapp&state=opened&target_branch=main":
+			return 200, `[{"id":10,"iid":1,"source_branch":"kargo/myapp"}]`
+		case "PUT /projects/mygroup%2Fmyrepo/merge_requests/1":
+			return 200, `{"id":10,"iid":1,"source_branch":"kargo/myapp","web_url":"https://gitlab.example.com/mygroup/myrepo/-/merge_requests/1"}`
+		case "GET /users?username=alice":
+			return 200, `[{"id":100}]`
+		case "GET /projects/mygroup%2Fmyrepo/milestones?title=v1.1":
+			return 200, `[{"id":7}]`
+		case "GET /projects/mygroup%2Fmyrepo/merge_requests/1":
+			gets++
+			if gets == 1 {
+				return 200, `{"iid":1,"state":"opened","detailed_merge_status":"ci_still_running","sha":"headsha","head_pipeline":{"status":"running"}}`
+			}
+			return 200, `{"iid":1,"state":"opened","detailed_merge_status":"mergeable","sha":"headsha"}`
+		case "PUT /projects/mygroup%2Fmyrepo/merge_requests/1/merge":
+			return 200, `{"iid":1,"state":"merged","squash_commit_sha":"squashsha"}`
+		}
+		t.Errorf("unexpected request: %s %s", r.Method, r.Path)
+		return 404, `{}`
+	})
+
+	p := newGitLabProvider(apiURL, "token")
+
+	opts := CreatePullRequestOptions{
+		Title:             "Deploy myapp",
+		Body:              "Deploy myapp",
+		Head:              "kargo/myapp",
+		Base:              "main",
+		UpdateExisting:    true,
+		AssigneeIDs:       []string{"alice", "200"},
+		Labels:            []string{"deploy", "myapp"},
+		Milestone:         "v1.1",
+		Merge:             MergeWait,
+		MergeMethod:       MergeMethodSquash,
+		MergePollInterval: time.Millisecond,
+	}
+
+	r, err := upsertPullRequest(ctx, p, repo, opts)
+	require.NoError(t, err)
+	require.Equal(t, &PullRequest{ID: 10, Number: 1, Head: "kargo/myapp", HTMLURL: "https://gitlab.example.com/mygroup/myrepo/-/merge_requests/1", Updated: true}, r)
+
+	require.NoError(t, p.UpdatePullRequestMetadata(ctx, repo, r.Number, opts))
+	require.NoError(t, p.MergePullRequest(ctx, repo, r, opts))
+	require.True(t, r.Merged)
+	require.Equal(t, "squashsha", r.MergeCommitSHA)
+
+	require.Equal(t, []stubRequest{
+		{Method: "GET", Path: "/projects/mygroup%2Fmyrepo/merge_requests?source_branch=kargo%2Fmyapp&state=opened&target_branch=main"},
+		{Method: "PUT", Path: "/projects/mygroup%2Fmyrepo/merge_requests/1", Body: map[string]interface{}{"title": "Deploy myapp", "description": "Deploy myapp"}},
+		{Method: "GET", Path: "/users?username=alice"},
+		{Method: "GET", Path: "/projects/mygroup%2Fmyrepo/milestones?title=v1.1"},
+		{Method: "PUT", Path: "/projects/mygroup%2Fmyrepo/merge_requests/1", Body: map[string]interface{}{"assignee_ids": []interface{}{float64(100), float64(200)}, "add_labels": "deploy,myapp", "milestone_id": float64(7)}},
+		{Method: "GET", Path: "/projects/mygroup%2Fmyrepo/merge_requests/1"},
+		{Method: "GET", Path: "/projects/mygroup%2Fmyrepo/merge_requests/1"},
+		{Method: "PUT", Path: "/projects/mygroup%2Fmyrepo/merge_requests/1/merge", Body: map[string]interface{}{"sha": "headsha", "squash": true}},
+	}, *requests)
+
+	require.EqualError(t, p.UpdatePullRequestMetadata(ctx, repo, 1, CreatePullRequestOptions{TeamReviewers: []string{"sre"}}), "team reviewers are not supported by gitlab")
+}
+
+func TestGitLabProvider_CreateDraftAndAutoMerge(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repository{Host: "gitlab.example.com", Owner: "mygroup", Name: "myrepo"}
+
+	apiURL, requests := newProviderStub(t, func(r stubRequest) (int, string) {
+		if r.Method == "POST" {
+			return 201, `{"id":11,"iid":2,"source_branch":"kargo-head"}`
+		}
+		return 200, `{}`
+	})
+
+	p := newGitLabProvider(apiURL, "token")
+
+	opts := CreatePullRequestOptions{Title: "Deploy myapp", Body: "body", Head: "kargo-head", Base: "main", Draft: true, Merge: MergeAuto}
+	r, err := upsertPullRequest(ctx, p, repo, opts)
+	require.NoError(t, err)
+	require.Equal(t, 2, r.Number)
+
+	require.NoError(t, p.MergePullRequest(ctx, repo, r, opts))
+	require.True(t, r.AutoMerge)
+
+	require.Equal(t, []stubRequest{
+		{Method: "POST", Path: "/projects/mygroup%2Fmyrepo/merge_requests", Body: map[string]interface{}{"source_branch": "kargo-head", "target_branch": "main", "title": "Draft: Deploy myapp", "description": "body"}},
+		{Method: "PUT", Path: "/projects/mygroup%2Fmyrepo/merge_requests/2/merge", Body: map[string]interface{}{"merge_when_pipeline_succeeds": true, "squash": false}},
+	}, *requests)
+
+	opts.MergeMethod = MergeMethodRebase
+	require.EqualError(t, p.MergePullRequest(ctx, repo, r, opts), "merge-method rebase is not supported by gitlab")
+}
+
+func TestGiteaProvider(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repository{Host: "gitea.example.com", Owner: "myorg", Name: "myrepo"}
+
+	var merged bool
+	apiURL, requests := newProviderStub(t, func(r stubRequest) (int, string) {
+		switch r.Method + " " + r.Path {
+		case "GET /repos/myorg/myrepo/pulls?limit=50&page=1&state=open":
+			return 200, `[{"number":3,"head":{"ref":"other"},"base":{"ref":"main"}}]`
+		case "GET /repos/myorg/myrepo/pulls?limit=50&page=2&state=open":
+			return 200, `[]`
+		case "POST /repos/myorg/myrepo/pulls":
+			return 201, `{"id":20,"number":4,"html_url":"https://gitea.example.com/myorg/myrepo/pulls/4","head":{"ref":"kargo/myapp"}}`
+		case "PATCH /repos/myorg/myrepo/pulls/4":
+			return 201, `{}`
+		case "GET /repos/myorg/myrepo/labels?limit=50":
+			return 200, `[{"id":1,"name":"deploy"},{"id":2,"name":"myapp"}]`
+		case "POST /repos/myorg/myrepo/issues/4/labels", "POST /repos/myorg/myrepo/pulls/4/requested_reviewers":
+			return 200, `[]`
+		case "GET /repos/myorg/myrepo/pulls/4":
+			if merged {
+				return 200, `{"number":4,"merged":true,"merge_commit_sha":"mergesha"}`
+			}
+			return 200, `{"number":4,"mergeable":true,"head":{"sha":"headsha"}}`
+		case "GET /repos/myorg/myrepo/commits/headsha/status":
+			return 200, `{"state":"success"}`
+		case "POST /repos/myorg/myrepo/pulls/4/merge":
+			merged = true
+			return 200, ``
+		}
+		t.Errorf("unexpected request: %s %s", r.Method, r.Path)
+		return 404, `{}`
+	})
+
+	p := newGiteaProvider(apiURL, "token")
+
+	opts := CreatePullRequestOptions{
+		Title:          "Deploy myapp",
+		Body:           "Deploy myapp",
+		Head:           "kargo/myapp",
+		Base:           "main",
+		UpdateExisting: true,
+		AssigneeIDs:    []string{"alice"},
+		Labels:         []string{"deploy", "myapp"},
+		Reviewers:      []string{"bob"},
+		Milestone:      "5",
+		Merge:          MergeWait,
+		MergeMethod:    MergeMethodRebase,
+	}
+
+	r, err := upsertPullRequest(ctx, p, repo, opts)
+	require.NoError(t, err)
+	require.Equal(t, &PullRequest{ID: 20, Number: 4, Head: "kargo/myapp", HTMLURL: "https://gitea.example.com/myorg/myrepo/pulls/4"}, r)
+
+	require.NoError(t, p.UpdatePullRequestMetadata(ctx, repo, r.Number, opts))
+	require.NoError(t, p.MergePullRequest(ctx, repo, r, opts))
+	require.True(t, r.Merged)
+	require.Equal(t, "mergesha", r.MergeCommitSHA)
+
+	require.Equal(t, []stubRequest{
+		{Method: "GET", Path: "/repos/myorg/myrepo/pulls?limit=50&page=1&state=open"},
+		{Method: "GET", Path: "/repos/myorg/myrepo/pulls?limit=50&page=2&state=open"},
+		{Method: "POST", Path: "/repos/myorg/myrepo/pulls", Body: map[string]interface{}{"head": "kargo/myapp", "base": "main", "title": "Deploy myapp", "body": "Deploy myapp"}},
+		{Method: "PATCH", Path: "/repos/myorg/myrepo/pulls/4", Body: map[string]interface{}{"assignees": []interface{}{"alice"}, "milestone": float64(5)}},
+		{Method: "GET", Path: "/repos/myorg/myrepo/labels?limit=50"},
+		{Method: "POST", Path: "/repos/myorg/myrepo/issues/4/labels", Body: map[string]interface{}{"labels": []interface{}{float64(1), float64(2)}}},
+		{Method: "POST", Path: "/repos/myorg/myrepo/pulls/4/requested_reviewers", Body: map[string]interface{}{"reviewers": []interface{}{"bob"}, "team_reviewers": nil}},
+		{Method: "GET", Path: "/repos/myorg/myrepo/pulls/4"},
+		{Method: "GET", Path: "/repos/myorg/myrepo/commits/headsha/status"},
+		{Method: "POST", Path: "/repos/myorg/myrepo/pulls/4/merge", Body: map[string]interface{}{"Do": "rebase", "head_commit_id": "headsha"}},
+		{Method: "GET", Path: "/repos/myorg/myrepo/pulls/4"},
+	}, *requests)
+}
+
+func TestGiteaProvider_FailedChecks(t *testing.T) {
+	ctx := context.Background()
+	repo := &Repository{Host: "gitea.example.com", Owner: "myorg", Name: "myrepo"}
+
+	apiURL, _ := newProviderStub(t, func(r stubRequest) (int, string) {
+		if r.Path == "/repos/myorg/myrepo/commits/headsha/status" {
+			return 200, `{"state":"failure"}`
+		}
+		return 200, `{"number":4,"mergeable":true,"head":{"sha":"headsha"}}`
+	})
+
+	p := newGiteaProvider(apiURL, "token")
+
+	err := p.MergePullRequest(ctx, repo, &PullRequest{Number: 4}, CreatePullRequestOptions{Merge: MergeWait})
+	require.EqualError(t, err, "checks on pull request #4 concluded with failure")
+}
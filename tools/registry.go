@@ -0,0 +1,526 @@
+package tools
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TagStrategySemver picks the highest semantic version among the tags.
+	TagStrategySemver = "semver"
+	// TagStrategyLatest picks the tag of the most recently created image,
+	// according to the created field of the image config.
+	TagStrategyLatest = "latest"
+	// TagStrategyAlphabetical picks the last tag in the lexical order,
+	// which is useful for tags like dates and build numbers of the same width.
+	TagStrategyAlphabetical = "alphabetical"
+
+	dockerHubRegistry = "registry-1.docker.io"
+
+	mediaTypeOCIIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList        = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest    = "application/vnd.docker.distribution.manifest.v2+json"
+	registryManifestMediaTypes = mediaTypeOCIIndex + ", " + mediaTypeOCIManifest + ", " + mediaTypeDockerList + ", " + mediaTypeDockerManifest
+)
+
+// ResolveImageOptions is the query to pick the newest tag of an image.
+type ResolveImageOptions struct {
+	// Image is the image name without the tag, like ghcr.io/myorg/myapp or nginx.
+	// Images without the registry host are on Docker Hub.
+	Image string
+	// Strategy is how the newest tag is picked.
+	// Either TagStrategySemver, TagStrategyLatest or TagStrategyAlphabetical.
+	// Defaults to TagStrategySemver.
+	Strategy string
+	// Constraint is the version constraint that the tags must satisfy, like 1.x or ^1.2.
+	// See VersionConstraint for the syntax. Only for TagStrategySemver.
+	Constraint string
+	// TagPattern is the regular expression that the tags must match.
+	// For TagStrategySemver, the first submatch is parsed as the version if any,
+	// so that tags like myapp-v1.2.3 can be compared.
+	TagPattern string
+	// Insecure makes the registry accessed via plain HTTP.
+	// It's implied for localhost and 127.0.0.1.
+	Insecure bool
+}
+
+// ResolvedImage is the tag picked by ResolveImage and the digest of its manifest.
+type ResolvedImage struct {
+	Tag    string
+	Digest string
+}
+
+// RegistryClient queries OCI distribution registries, like Docker Hub, GHCR, ECR and Harbor.
+// It supports anonymous access, basic authentication and the bearer token authentication.
+type RegistryClient struct {
+	// Credentials returns the username and the password for the registry host.
+	// Empty ones make the client access the registry anonymously.
+	Credentials func(host string) (username, password string, err error)
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+// ResolveImage picks the newest tag of the image that matches opts, and resolves its digest.
+func (c *RegistryClient) ResolveImage(ctx context.Context, opts ResolveImageOptions) (*ResolvedImage, error) {
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = TagStrategySemver
+	}
+
+	var constraint *VersionConstraint
+	switch strategy {
+	case TagStrategySemver:
+		if opts.Constraint != "" {
+			var err error
+			constraint, err = ParseVersionConstraint(opts.Constraint)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case TagStrategyLatest, TagStrategyAlphabetical:
+		if opts.Constraint != "" {
+			return nil, fmt.Errorf("version constraint is supported by the %s strategy only", TagStrategySemver)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported tag strategy %q", strategy)
+	}
+
+	var pattern *regexp.Regexp
+	if opts.TagPattern != "" {
+		var err error
+		pattern, err = regexp.Compile(opts.TagPattern)
+		if err != nil {
+			return nil, fmt.Errorf("parsing tag pattern: %w", err)
+		}
+	}
+
+	repo, err := parseRegistryImage(opts.Image, opts.Insecure)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := c.listTags(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+	versions := map[string]Version{}
+	for _, t := range tags {
+		s := t
+		if pattern != nil {
+			m := pattern.FindStringSubmatch(t)
+			if m == nil {
+				continue
+			}
+			if len(m) > 1 {
+				s = m[1]
+			}
+		}
+
+		if strategy == TagStrategySemver {
+			v, err := ParseVersion(s)
+			if err != nil {
+				continue
+			}
+			if constraint != nil {
+				if !constraint.Check(v) {
+					continue
+				}
+			} else if v.Prerelease != "" {
+				continue
+			}
+			versions[t] = v
+		}
+
+		candidates = append(candidates, t)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no tag of %s matches %s", opts.Image, describeResolveQuery(strategy, opts))
+	}
+
+	var tag, digest string
+	switch strategy {
+	case TagStrategySemver:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return versions[candidates[i]].Compare(versions[candidates[j]]) < 0
+		})
+		tag = candidates[len(candidates)-1]
+	case TagStrategyAlphabetical:
+		sort.Strings(candidates)
+		tag = candidates[len(candidates)-1]
+	case TagStrategyLatest:
+		var newest time.Time
+		for _, t := range candidates {
+			d, created, err := c.imageCreated(ctx, repo, t)
+			if err != nil {
+				return nil, err
+			}
+			if tag == "" || created.After(newest) {
+				tag, digest, newest = t, d, created
+			}
+		}
+	}
+
+	if digest == "" {
+		digest, _, _, err = c.manifest(ctx, repo, tag, registryManifestMediaTypes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &ResolvedImage{Tag: tag, Digest: digest}, nil
+}
+
+func describeResolveQuery(strategy string, opts ResolveImageOptions) string {
+	q := "strategy " + strategy
+	if opts.Constraint != "" {
+		q += ", constraint " + opts.Constraint
+	}
+	if opts.TagPattern != "" {
+		q += ", tag pattern " + opts.TagPattern
+	}
+	return q
+}
+
+// registryRepository is the repository of an image in a registry.
+type registryRepository struct {
+	// baseURL is like https://ghcr.io.
+	baseURL string
+	host    string
+	// name is like myorg/myapp.
+	name string
+}
+
+// parseRegistryImage parses the image name into the registry and the repository,
+// the same way as docker does.
+func parseRegistryImage(image string, insecure bool) (registryRepository, error) {
+	if image == "" {
+		return registryRepository{}, errors.New("image is required")
+	}
+	if strings.ContainsAny(image, "@") || strings.LastIndex(image, ":") > strings.LastIndex(image, "/") {
+		return registryRepository{}, fmt.Errorf("image %q must not have a tag or a digest", image)
+	}
+
+	host, name, ok := strings.Cut(image, "/")
+	if !ok || !(strings.ContainsAny(host, ".:") || host == "localhost") {
+		host, name = dockerHubRegistry, image
+	}
+	if host == "docker.io" || host == "index.docker.io" {
+		host = dockerHubRegistry
+	}
+	if host == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	scheme := "https"
+	hostname := strings.Split(host, ":")[0]
+	if insecure || hostname == "localhost" || hostname == "127.0.0.1" {
+		scheme = "http"
+	}
+
+	return registryRepository{baseURL: scheme + "://" + host, host: host, name: name}, nil
+}
+
+// listTags lists all the tags of the repository, following the pagination.
+func (c *RegistryClient) listTags(ctx context.Context, repo registryRepository) ([]string, error) {
+	var tags []string
+
+	next := "/v2/" + repo.name + "/tags/list?n=1000"
+	for next != "" {
+		res, err := c.get(ctx, repo, next, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("listing tags of %s/%s: %w", repo.host, repo.name, err)
+		}
+
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(res.Body).Decode(&page)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding tags of %s/%s: %w", repo.host, repo.name, err)
+		}
+		tags = append(tags, page.Tags...)
+
+		next = nextLink(res.Header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+// nextLink returns the path of the next page in the Link header,
+// like </v2/myorg/myapp/tags/list?n=1000&last=v1>; rel="next".
+func nextLink(link string) string {
+	for _, l := range strings.Split(link, ",") {
+		target, params, _ := strings.Cut(l, ";")
+		if !strings.Contains(params, `rel="next"`) {
+			continue
+		}
+		u, err := url.Parse(strings.Trim(strings.TrimSpace(target), "<>"))
+		if err != nil {
+			return ""
+		}
+		return u.RequestURI()
+	}
+	return ""
+}
+
+// manifest returns the digest, the media type and the content of the manifest of the reference.
+func (c *RegistryClient) manifest(ctx context.Context, repo registryRepository, ref, accept string) (string, string, []byte, error) {
+	res, err := c.get(ctx, repo, "/v2/"+repo.name+"/manifests/"+ref, accept)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("getting manifest %s of %s/%s: %w", ref, repo.host, repo.name, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", "", nil, fmt.Errorf("getting manifest %s of %s/%s: no Docker-Content-Digest header", ref, repo.host, repo.name)
+	}
+
+	return digest, res.Header.Get("Content-Type"), data, nil
+}
+
+// imageCreated returns the digest of the tag and the creation time of its image.
+// For multi-platform images, the first image in the index is used,
+// as all the platforms are usually built at once.
+func (c *RegistryClient) imageCreated(ctx context.Context, repo registryRepository, tag string) (string, time.Time, error) {
+	digest, mediaType, data, err := c.manifest(ctx, repo, tag, registryManifestMediaTypes)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var m struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding manifest %s of %s/%s: %w", tag, repo.host, repo.name, err)
+	}
+
+	if mediaType == mediaTypeOCIIndex || mediaType == mediaTypeDockerList || m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerList {
+		if len(m.Manifests) == 0 {
+			return "", time.Time{}, fmt.Errorf("manifest %s of %s/%s has no images", tag, repo.host, repo.name)
+		}
+		_, _, data, err = c.manifest(ctx, repo, m.Manifests[0].Digest, mediaTypeOCIManifest+", "+mediaTypeDockerManifest)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return "", time.Time{}, fmt.Errorf("decoding manifest %s of %s/%s: %w", m.Manifests[0].Digest, repo.host, repo.name, err)
+		}
+	}
+
+	res, err := c.get(ctx, repo, "/v2/"+repo.name+"/blobs/"+m.Config.Digest, "")
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("getting image config of %s:%s: %w", repo.name, tag, err)
+	}
+	defer res.Body.Close()
+
+	var config struct {
+		Created time.Time `json:"created"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&config); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding image config of %s:%s: %w", repo.name, tag, err)
+	}
+
+	return digest, config.Created, nil
+}
+
+// get sends the GET request to the registry, authenticating as the registry requests.
+// The caller must close the body of the returned response.
+func (c *RegistryClient) get(ctx context.Context, repo registryRepository, path, accept string) (*http.Response, error) {
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	do := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, repo.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return client.Do(req)
+	}
+
+	scope := "repository:" + repo.name + ":pull"
+
+	c.mu.Lock()
+	auth := c.tokens[repo.host+" "+scope]
+	c.mu.Unlock()
+
+	res, err := do(auth)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		auth, err = c.authorize(ctx, client, repo.host, scope, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		if c.tokens == nil {
+			c.tokens = map[string]string{}
+		}
+		c.tokens[repo.host+" "+scope] = auth
+		c.mu.Unlock()
+
+		res, err = do(auth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s: %s", path, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	return res, nil
+}
+
+// authorize returns the Authorization header value that satisfies the WWW-Authenticate challenge.
+// See https://distribution.github.io/distribution/spec/auth/token/
+func (c *RegistryClient) authorize(ctx context.Context, client *http.Client, host, scope, challenge string) (string, error) {
+	var username, password string
+	if c.Credentials != nil {
+		var err error
+		username, password, err = c.Credentials(host)
+		if err != nil {
+			return "", fmt.Errorf("getting credentials for %s: %w", host, err)
+		}
+	}
+
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("%s requires credentials", host)
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge from %s: %q", host, challenge)
+	}
+
+	realm := params["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("no realm in the authentication challenge from %s: %q", host, challenge)
+	}
+
+	q := url.Values{}
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	if s := params["scope"]; s != "" {
+		scope = s
+	}
+	q.Set("scope", scope)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("getting token for %s: %w", host, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(res.Body)
+		return "", fmt.Errorf("getting token for %s: %s: %s", host, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding token for %s: %w", host, err)
+	}
+
+	t := token.Token
+	if t == "" {
+		t = token.AccessToken
+	}
+	if t == "" {
+		return "", fmt.Errorf("getting token for %s: empty token", host)
+	}
+
+	return "Bearer " + t, nil
+}
+
+// parseAuthChallenge parses the WWW-Authenticate header value,
+// like Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+func parseAuthChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(rest, "=")
+		key = strings.ToLower(strings.Trim(strings.TrimSpace(key), ","))
+
+		rest = strings.TrimSpace(rest)
+		if strings.HasPrefix(rest, `"`) {
+			var ok bool
+			value, rest, ok = strings.Cut(rest[1:], `"`)
+			if !ok {
+				break
+			}
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+		if key != "" {
+			params[key] = value
+		}
+	}
+
+	return scheme, params
+}
@@ -0,0 +1,190 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// registryStub is a local OCI distribution registry that serves the tags of myorg/myapp.
+// It requires the bearer token obtained with the credentials alice:secret.
+type registryStub struct {
+	*httptest.Server
+
+	// created maps the tags to the creation time of their images.
+	created map[string]string
+	// tokenRequests counts the token requests.
+	tokenRequests int
+}
+
+func newRegistryStub(t *testing.T, created map[string]string) *registryStub {
+	t.Helper()
+
+	s := &registryStub{created: created}
+
+	var tags []string
+	for tag := range created {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.tokenRequests++
+
+		user, pass, _ := r.BasicAuth()
+		if user != "alice" || pass != "secret" || r.URL.Query().Get("scope") != "repository:myorg/myapp:pull" || r.URL.Query().Get("service") != "stub" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token":"mytoken"}`)
+	})
+	mux.HandleFunc("/v2/myorg/myapp/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer mytoken" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="stub",scope="repository:myorg/myapp:pull"`, s.URL))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		rest := strings.TrimPrefix(r.URL.Path, "/v2/myorg/myapp/")
+		switch {
+		case rest == "tags/list":
+			// Two tags per page, to test the pagination.
+			page := tags
+			if last := r.URL.Query().Get("last"); last != "" {
+				i := sort.SearchStrings(tags, last)
+				page = tags[i+1:]
+			}
+			if len(page) > 2 {
+				w.Header().Set("Link", fmt.Sprintf(`</v2/myorg/myapp/tags/list?n=2&last=%s>; rel="next"`, page[1]))
+				page = page[:2]
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"name": "myorg/myapp", "tags": page})
+		case strings.HasPrefix(rest, "manifests/sha256:"):
+			// The platform-specific image of the index.
+			tag := strings.TrimPrefix(rest, "manifests/sha256:amd64-")
+			w.Header().Set("Content-Type", mediaTypeOCIManifest)
+			w.Header().Set("Docker-Content-Digest", "sha256:amd64-"+tag)
+			fmt.Fprintf(w, `{"mediaType":%q,"config":{"digest":"sha256:config-%s"}}`, mediaTypeOCIManifest, tag)
+		case strings.HasPrefix(rest, "manifests/"):
+			tag := strings.TrimPrefix(rest, "manifests/")
+			if _, ok := s.created[tag]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", mediaTypeOCIIndex)
+			w.Header().Set("Docker-Content-Digest", "sha256:"+tag)
+			fmt.Fprintf(w, `{"mediaType":%q,"manifests":[{"digest":"sha256:amd64-%s"}]}`, mediaTypeOCIIndex, tag)
+		case strings.HasPrefix(rest, "blobs/sha256:config-"):
+			tag := strings.TrimPrefix(rest, "blobs/sha256:config-")
+			fmt.Fprintf(w, `{"created":%q}`, s.created[tag])
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func TestRegistryClient_ResolveImage(t *testing.T) {
+	stub := newRegistryStub(t, map[string]string{
+		"1.0.0":         "2024-01-01T00:00:00Z",
+		"v1.2.0":        "2024-02-01T00:00:00Z",
+		"1.10.0-rc.1":   "2024-05-01T00:00:00Z",
+		"1.9.0":         "2024-03-01T00:00:00Z",
+		"2.0.0":         "2024-04-01T00:00:00Z",
+		"main-20240601": "2024-06-01T00:00:00Z",
+		"main-20240501": "2024-06-02T00:00:00Z",
+		"latest":        "2024-06-01T00:00:00Z",
+	})
+	image := strings.TrimPrefix(stub.URL, "http://") + "/myorg/myapp"
+
+	c := &RegistryClient{
+		Credentials: func(host string) (string, string, error) {
+			require.Equal(t, strings.TrimPrefix(stub.URL, "http://"), host)
+			return "alice", "secret", nil
+		},
+	}
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		opts ResolveImageOptions
+		want ResolvedImage
+	}{
+		{ResolveImageOptions{}, ResolvedImage{Tag: "2.0.0", Digest: "sha256:2.0.0"}},
+		{ResolveImageOptions{Constraint: "1.x"}, ResolvedImage{Tag: "1.9.0", Digest: "sha256:1.9.0"}},
+		{ResolveImageOptions{Constraint: ">=1.10.0-rc.0"}, ResolvedImage{Tag: "2.0.0", Digest: "sha256:2.0.0"}},
+		{ResolveImageOptions{Constraint: "~1.10.0-rc.0"}, ResolvedImage{Tag: "1.10.0-rc.1", Digest: "sha256:1.10.0-rc.1"}},
+		{ResolveImageOptions{Constraint: "<1.5", TagPattern: "^v"}, ResolvedImage{Tag: "v1.2.0", Digest: "sha256:v1.2.0"}},
+		{ResolveImageOptions{Strategy: TagStrategyAlphabetical, TagPattern: "^main-"}, ResolvedImage{Tag: "main-20240601", Digest: "sha256:main-20240601"}},
+		// The image config is newer despite the tag.
+		{ResolveImageOptions{Strategy: TagStrategyLatest, TagPattern: "^main-"}, ResolvedImage{Tag: "main-20240501", Digest: "sha256:main-20240501"}},
+		{ResolveImageOptions{Strategy: TagStrategyLatest}, ResolvedImage{Tag: "main-20240501", Digest: "sha256:main-20240501"}},
+	}
+
+	for _, tc := range testcases {
+		tc.opts.Image = image
+		got, err := c.ResolveImage(ctx, tc.opts)
+		require.NoError(t, err, "%+v", tc.opts)
+		require.Equal(t, &tc.want, got, "%+v", tc.opts)
+	}
+
+	// The token is reused across requests.
+	require.Equal(t, 1, stub.tokenRequests)
+
+	_, err := c.ResolveImage(ctx, ResolveImageOptions{Image: image, Constraint: "3.x"})
+	require.EqualError(t, err, "no tag of "+image+" matches strategy semver, constraint 3.x")
+
+	_, err = c.ResolveImage(ctx, ResolveImageOptions{Image: image + ":1.0.0"})
+	require.EqualError(t, err, `image "`+image+`:1.0.0" must not have a tag or a digest`)
+
+	_, err = c.ResolveImage(ctx, ResolveImageOptions{Image: image, Strategy: TagStrategyLatest, Constraint: "1.x"})
+	require.EqualError(t, err, "version constraint is supported by the semver strategy only")
+
+	_, err = (&RegistryClient{}).ResolveImage(ctx, ResolveImageOptions{Image: image})
+	require.ErrorContains(t, err, "getting token for "+strings.TrimPrefix(stub.URL, "http://")+": 401 Unauthorized")
+}
+
+func TestParseRegistryImage(t *testing.T) {
+	testcases := []struct {
+		image string
+		want  registryRepository
+	}{
+		{"nginx", registryRepository{baseURL: "https://registry-1.docker.io", host: "registry-1.docker.io", name: "library/nginx"}},
+		{"myorg/myapp", registryRepository{baseURL: "https://registry-1.docker.io", host: "registry-1.docker.io", name: "myorg/myapp"}},
+		{"docker.io/nginx", registryRepository{baseURL: "https://registry-1.docker.io", host: "registry-1.docker.io", name: "library/nginx"}},
+		{"ghcr.io/myorg/myapp", registryRepository{baseURL: "https://ghcr.io", host: "ghcr.io", name: "myorg/myapp"}},
+		{"localhost:5000/myapp", registryRepository{baseURL: "http://localhost:5000", host: "localhost:5000", name: "myapp"}},
+	}
+
+	for _, tc := range testcases {
+		got, err := parseRegistryImage(tc.image, false)
+		require.NoError(t, err, tc.image)
+		require.Equal(t, tc.want, got, tc.image)
+	}
+}
+
+func TestParseAuthChallenge(t *testing.T) {
+	scheme, params := parseAuthChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull,push"`)
+	require.Equal(t, "Bearer", scheme)
+	require.Equal(t, map[string]string{
+		"realm":   "https://auth.docker.io/token",
+		"service": "registry.docker.io",
+		"scope":   "repository:library/nginx:pull,push",
+	}, params)
+
+	scheme, params = parseAuthChallenge(`Basic realm=registry`)
+	require.Equal(t, "Basic", scheme)
+	require.Equal(t, map[string]string{"realm": "registry"}, params)
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Repository is a git repository hosted on GitHub, GitLab, Gitea and so on.
+type Repository struct {
+	// Scheme is one of https, http, ssh and git.
+	// It's ssh for scp-like addresses like git@github.com:owner/repo.git.
+	Scheme string
+	// Host is the hostname of the git host, without the port.
+	Host string
+	// Port is the port of the git host. It's empty for the default port.
+	Port string
+	// Owner is the namespace path of the repository.
+	// It's the user or the organization on GitHub and Gitea,
+	// and can contain slashes on GitLab, like group/subgroup.
+	Owner string
+	// Name is the name of the repository, without the .git suffix.
+	Name string
+}
+
+// FullName returns the path of the repository, like owner/name.
+func (r *Repository) FullName() string {
+	return r.Owner + "/" + r.Name
+}
+
+var repositoryHostRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+// ParseRepository parses the git remote URL of the repository.
+// The URL is either:
+//
+//   - scheme://[user[:password]@]host[:port]/owner/name[.git], where the scheme is one of https, http, ssh and git
+//   - [user@]host:owner/name[.git], which is the scp-like syntax for ssh
+//
+// The owner can consist of multiple path segments, like GitLab subgroups.
+func ParseRepository(remote string) (*Repository, error) {
+	remote = strings.TrimSpace(remote)
+
+	var (
+		r    Repository
+		path string
+	)
+
+	if strings.Contains(remote, "://") {
+		u, err := url.Parse(remote)
+		if err != nil {
+			return nil, fmt.Errorf("parsing repository URL %q: %w", remote, err)
+		}
+
+		switch u.Scheme {
+		case "https", "http", "ssh", "git":
+		default:
+			return nil, fmt.Errorf("unsupported scheme %q in repository URL %q: it must be one of https, http, ssh and git", u.Scheme, remote)
+		}
+
+		if u.RawQuery != "" || u.Fragment != "" {
+			return nil, fmt.Errorf("repository URL %q must not have a query or a fragment", remote)
+		}
+
+		r.Scheme = u.Scheme
+		r.Host = u.Hostname()
+		r.Port = u.Port()
+		path = u.Path
+	} else {
+		hostPart, p, ok := strings.Cut(remote, ":")
+		// git treats the address as a local path if there's a slash before the first colon.
+		if !ok || strings.Contains(hostPart, "/") {
+			return nil, fmt.Errorf("either scheme://host/owner/name.git or host:owner/name.git format is required for repository, but got %q", remote)
+		}
+
+		if i := strings.LastIndex(hostPart, "@"); i >= 0 {
+			hostPart = hostPart[i+1:]
+		}
+
+		r.Scheme = "ssh"
+		r.Host = hostPart
+		path = p
+	}
+
+	if !validRepositoryHost(r.Host) {
+		return nil, fmt.Errorf("invalid host %q in repository URL %q", r.Host, remote)
+	}
+
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+
+	segments := strings.Split(path, "/")
+	if len(segments) < 2 {
+		return nil, fmt.Errorf("repository URL %q must have both the owner and the name in its path", remote)
+	}
+
+	for _, s := range segments {
+		if s == "" || s == "." || s == ".." || strings.IndexFunc(s, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("invalid path segment %q in repository URL %q", s, remote)
+		}
+	}
+
+	r.Owner = strings.Join(segments[:len(segments)-1], "/")
+	r.Name = segments[len(segments)-1]
+
+	return &r, nil
+}
+
+func validRepositoryHost(host string) bool {
+	return repositoryHostRegexp.MatchString(host) || net.ParseIP(host) != nil
+}
+
+// getRepository returns the repository of the origin remote of the
+// local repository in dir.
+func getRepository(ctx context.Context, git GitBackend, dir string) (*Repository, error) {
+	url, err := git.RemoteURL(ctx, dir, DefaultGitRemote)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRepository(url)
+}
@@ -0,0 +1,298 @@
+package tools
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, like v1.2.3 or 1.2.3-rc.1.
+// Missing minor and patch numbers are treated as zero, so that tags like v1.2 are versions too.
+type Version struct {
+	Major, Minor, Patch int
+	Prerelease          string
+}
+
+// ParseVersion parses the semantic version with an optional v prefix.
+// Build metadata after + is ignored.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+
+	rest := strings.TrimPrefix(s, "v")
+	rest, _, _ = strings.Cut(rest, "+")
+	rest, v.Prerelease, _ = strings.Cut(rest, "-")
+
+	nums := strings.Split(rest, ".")
+	if len(nums) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	for i, n := range nums {
+		x, err := strconv.Atoi(n)
+		if err != nil || x < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", s)
+		}
+		switch i {
+		case 0:
+			v.Major = x
+		case 1:
+			v.Minor = x
+		case 2:
+			v.Patch = x
+		}
+	}
+
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 depending on whether v is older than, the same as, or newer than o.
+// A prerelease is older than its release, and prereleases are compared per dot-separated identifier.
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseIdentifier(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(a), len(b))
+}
+
+func comparePrereleaseIdentifier(a, b string) int {
+	x, errA := strconv.Atoi(a)
+	y, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInt(x, y)
+	case errA == nil:
+		// Numeric identifiers have lower precedence than alphanumeric ones.
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// VersionConstraint is a set of version ranges, like ">= 1.2, < 2 || 3.x".
+//
+// Each range is a list of comparisons separated by commas or spaces, and all of them must be met.
+// The supported comparisons are =, !=, >, >=, <, <=, ~ (patch updates, like ~1.2.3),
+// ^ (minor and patch updates, like ^1.2.3), and wildcards like 1.x, 1.2.* and *.
+// Prereleases are allowed only when the constraint mentions a prerelease.
+type VersionConstraint struct {
+	ranges     [][]versionComparison
+	prerelease bool
+}
+
+type versionComparison struct {
+	op string
+	v  Version
+}
+
+// ParseVersionConstraint parses the version constraint.
+// See VersionConstraint for the syntax.
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	c := &VersionConstraint{prerelease: strings.Contains(s, "-")}
+
+	for _, r := range strings.Split(s, "||") {
+		var comparisons []versionComparison
+
+		fields := strings.FieldsFunc(r, func(c rune) bool { return c == ',' || c == ' ' })
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			// Allow a space between the operator and the version, like ">= 1.2".
+			if strings.Trim(f, "=!<>~^") == "" && i+1 < len(fields) {
+				i++
+				f += fields[i]
+			}
+
+			cs, err := parseVersionComparison(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+			}
+			comparisons = append(comparisons, cs...)
+		}
+
+		if len(comparisons) == 0 {
+			return nil, fmt.Errorf("invalid version constraint %q: empty range", s)
+		}
+
+		c.ranges = append(c.ranges, comparisons)
+	}
+
+	return c, nil
+}
+
+// parseVersionComparison parses the comparison into the equivalent list of comparisons
+// with the =, !=, >, >=, < and <= operators.
+func parseVersionComparison(s string) ([]versionComparison, error) {
+	ver := strings.TrimLeft(s, "=!<>~^")
+	op := s[:len(s)-len(ver)]
+
+	switch op {
+	case "", "=", "!=", ">", ">=", "<", "<=", "~", "^":
+	default:
+		return nil, fmt.Errorf("unsupported operator %q", op)
+	}
+
+	core, pre, _ := strings.Cut(strings.TrimPrefix(ver, "v"), "-")
+	parts := strings.Split(core, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid version %q", ver)
+	}
+
+	// fixed is the number of the leading version numbers that aren't wildcards,
+	// like 2 for 1.2.x and 1.2.
+	var (
+		nums  [3]int
+		fixed int
+	)
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			continue
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || fixed != i {
+			return nil, fmt.Errorf("invalid version %q", ver)
+		}
+		nums[i] = n
+		fixed++
+	}
+
+	if pre != "" && fixed < 3 {
+		return nil, fmt.Errorf("invalid version %q", ver)
+	}
+
+	v := Version{Major: nums[0], Minor: nums[1], Patch: nums[2], Prerelease: pre}
+
+	// next returns the lowest version above the first n version numbers, like 1.3.0 for 1.2.x and n=2.
+	next := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+
+	if fixed == 0 {
+		switch op {
+		case "", "=", ">=", "<=", "~", "^":
+			return []versionComparison{{op: ">=", v: Version{}}}, nil
+		}
+		return nil, fmt.Errorf("unsupported operator %q for %q", op, ver)
+	}
+
+	partial := fixed < 3
+
+	switch op {
+	case "", "=":
+		if !partial {
+			return []versionComparison{{op: "=", v: v}}, nil
+		}
+		return []versionComparison{{op: ">=", v: v}, {op: "<", v: next(fixed)}}, nil
+	case "!=":
+		if partial {
+			// Not in [v, next), which isn't a single range.
+			return nil, fmt.Errorf("unsupported partial version %q for !=", ver)
+		}
+		return []versionComparison{{op: "!=", v: v}}, nil
+	case ">":
+		if !partial {
+			return []versionComparison{{op: ">", v: v}}, nil
+		}
+		return []versionComparison{{op: ">=", v: next(fixed)}}, nil
+	case "<=":
+		if !partial {
+			return []versionComparison{{op: "<=", v: v}}, nil
+		}
+		return []versionComparison{{op: "<", v: next(fixed)}}, nil
+	case ">=", "<":
+		return []versionComparison{{op: op, v: v}}, nil
+	case "~":
+		// ~1 allows minor updates, while ~1.2 and ~1.2.3 allow patch updates only.
+		n := fixed
+		if n > 2 {
+			n = 2
+		}
+		return []versionComparison{{op: ">=", v: v}, {op: "<", v: next(n)}}, nil
+	}
+
+	// ^ allows the updates that don't modify the left-most non-zero version number.
+	n := 1
+	switch {
+	case v.Major == 0 && v.Minor == 0 && fixed == 3:
+		n = 3
+	case v.Major == 0 && fixed >= 2:
+		n = 2
+	}
+	return []versionComparison{{op: ">=", v: v}, {op: "<", v: next(n)}}, nil
+}
+
+// Check reports whether the version satisfies the constraint.
+func (c *VersionConstraint) Check(v Version) bool {
+	if v.Prerelease != "" && !c.prerelease {
+		return false
+	}
+
+	for _, r := range c.ranges {
+		ok := true
+		for _, cmp := range r {
+			if !cmp.check(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c versionComparison) check(v Version) bool {
+	d := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return d == 0
+	case "!=":
+		return d != 0
+	case ">":
+		return d > 0
+	case ">=":
+		return d >= 0
+	case "<":
+		return d < 0
+	case "<=":
+		return d <= 0
+	}
+	return false
+}
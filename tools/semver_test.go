@@ -0,0 +1,72 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion_Compare(t *testing.T) {
+	ordered := []string{"0.1.0", "v1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "v1.0.1", "1.2", "1.10.0", "2.0.0+build.1"}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, err := ParseVersion(ordered[i])
+			require.NoError(t, err)
+			b, err := ParseVersion(ordered[j])
+			require.NoError(t, err)
+			require.Equal(t, compareInt(i, j), a.Compare(b), "%s vs %s", ordered[i], ordered[j])
+		}
+	}
+
+	for _, s := range []string{"latest", "1.2.3.4", "v", "1.x"} {
+		_, err := ParseVersion(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	testcases := []struct {
+		constraint string
+		ok         []string
+		ng         []string
+	}{
+		{"1.x", []string{"1.0.0", "1.9.9"}, []string{"0.9.0", "2.0.0", "1.1.0-rc.1"}},
+		{"1.2.*", []string{"1.2.0", "1.2.9"}, []string{"1.3.0", "1.1.9"}},
+		{"*", []string{"0.0.1", "9.9.9"}, []string{"1.0.0-rc.1"}},
+		{"1.2.3", []string{"1.2.3", "v1.2.3"}, []string{"1.2.4"}},
+		{"1.2", []string{"1.2.0", "1.2.5"}, []string{"1.3.0"}},
+		{">= 1.2, < 2", []string{"1.2.0", "1.9.0"}, []string{"1.1.9", "2.0.0"}},
+		{">1.2 <=1.4", []string{"1.3.0", "1.4.9"}, []string{"1.2.9", "1.5.0"}},
+		{">1.2.3", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.3.0", "1.2.2"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"^1.2.3", []string{"1.2.3", "1.9.0"}, []string{"2.0.0", "1.2.2"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4"}},
+		{"!=1.2.3, 1.x", []string{"1.2.4"}, []string{"1.2.3"}},
+		{"1.x || >=3", []string{"1.5.0", "3.0.0"}, []string{"2.0.0"}},
+		{">=1.0.0-rc.1", []string{"1.0.0-rc.2", "1.0.0"}, []string{"1.0.0-beta.1"}},
+	}
+
+	for _, tc := range testcases {
+		c, err := ParseVersionConstraint(tc.constraint)
+		require.NoError(t, err, tc.constraint)
+
+		for _, s := range tc.ok {
+			v, err := ParseVersion(s)
+			require.NoError(t, err)
+			require.True(t, c.Check(v), "%s should satisfy %s", s, tc.constraint)
+		}
+		for _, s := range tc.ng {
+			v, err := ParseVersion(s)
+			require.NoError(t, err)
+			require.False(t, c.Check(v), "%s should not satisfy %s", s, tc.constraint)
+		}
+	}
+
+	for _, s := range []string{"", "1.x ||", "=>1", "1.x.3", "!=1.x", "1.2-rc.1", "<*"} {
+		_, err := ParseVersionConstraint(s)
+		require.Error(t, err, s)
+	}
+}
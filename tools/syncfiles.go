@@ -0,0 +1,354 @@
+package tools
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	CommandSyncFiles     = "sync-files"
+	FlagSyncFilesSrc     = "src"
+	FlagSyncFilesDst     = "dst"
+	FlagSyncFilesMode    = "mode"
+	FlagSyncFilesInclude = "include"
+	FlagSyncFilesExclude = "exclude"
+
+	// SyncModeCopy copies the source files over the destination,
+	// leaving the other destination files as-is.
+	SyncModeCopy = "copy"
+	// SyncModeMirror makes the destination an exact copy of the source,
+	// removing the destination files that don't exist in the source.
+	SyncModeMirror = "mirror"
+)
+
+type SyncFilesOptions struct {
+	// Src is the directory to sync the files from.
+	Src string
+	// Dst is the directory to sync the files to.
+	// It's created if it doesn't exist.
+	Dst string
+	// Mode is either SyncModeCopy or SyncModeMirror. Defaults to SyncModeCopy.
+	Mode string
+	// Include is the list of glob patterns of the files to sync.
+	// All the files are synced if empty.
+	// See MatchSyncPattern for the syntax.
+	Include []string
+	// Exclude is the list of glob patterns of the files not to sync.
+	// Excluded destination files are never removed by SyncModeMirror.
+	Exclude []string
+	// Out is where the synced files are listed.
+	// Defaults to os.Stdout.
+	Out io.Writer
+}
+
+// SyncFilesResult is the list of the files changed by SyncFiles.
+// Each path is slash-separated and relative to the destination.
+type SyncFilesResult struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+// SyncFiles syncs the files in opts.Src to opts.Dst,
+// and lists the added, changed and removed files one per line,
+// prefixed with "+ ", "~ " and "- " respectively.
+// .git directories are never synced nor removed.
+func SyncFiles(opts SyncFilesOptions) (*SyncFilesResult, error) {
+	if opts.Src == "" {
+		return nil, fmt.Errorf("%s must be set", FlagSyncFilesSrc)
+	}
+
+	if opts.Dst == "" {
+		return nil, fmt.Errorf("%s must be set", FlagSyncFilesDst)
+	}
+
+	switch opts.Mode {
+	case "", SyncModeCopy, SyncModeMirror:
+	default:
+		return nil, fmt.Errorf("unsupported %s: %q", FlagSyncFilesMode, opts.Mode)
+	}
+
+	for _, p := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+
+	info, err := os.Stat(opts.Src)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s %s is not a directory", FlagSyncFilesSrc, opts.Src)
+	}
+
+	selected := func(rel string) bool {
+		if len(opts.Include) > 0 && !matchAnySyncPattern(opts.Include, rel) {
+			return false
+		}
+		return !matchAnySyncPattern(opts.Exclude, rel)
+	}
+
+	src, err := listSyncFiles(opts.Src, selected)
+	if err != nil {
+		return nil, err
+	}
+
+	var dst map[string]fs.FileMode
+	if _, err := os.Stat(opts.Dst); err == nil {
+		dst, err = listSyncFiles(opts.Dst, selected)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	var r SyncFilesResult
+
+	for _, rel := range sortedKeys(src) {
+		from := filepath.Join(opts.Src, filepath.FromSlash(rel))
+		to := filepath.Join(opts.Dst, filepath.FromSlash(rel))
+
+		if _, ok := dst[rel]; ok {
+			same, err := sameSyncFile(from, to, src[rel], dst[rel])
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+			r.Changed = append(r.Changed, rel)
+		} else {
+			r.Added = append(r.Added, rel)
+		}
+
+		if err := copySyncFile(from, to, src[rel]); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Mode == SyncModeMirror {
+		for _, rel := range sortedKeys(dst) {
+			if _, ok := src[rel]; ok {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(opts.Dst, filepath.FromSlash(rel))); err != nil {
+				return nil, err
+			}
+			removeEmptyParents(opts.Dst, rel)
+			r.Removed = append(r.Removed, rel)
+		}
+	}
+
+	out := opts.Out
+	if out == nil {
+		out = os.Stdout
+	}
+
+	if len(r.Added)+len(r.Changed)+len(r.Removed) == 0 {
+		fmt.Fprintf(out, "no changes to %s\n", opts.Dst)
+	}
+	for _, f := range r.Added {
+		fmt.Fprintf(out, "+ %s\n", f)
+	}
+	for _, f := range r.Changed {
+		fmt.Fprintf(out, "~ %s\n", f)
+	}
+	for _, f := range r.Removed {
+		fmt.Fprintf(out, "- %s\n", f)
+	}
+
+	return &r, nil
+}
+
+// MatchSyncPattern reports whether the slash-separated path rel matches the glob pattern.
+//
+// A pattern without a slash matches the name of the file or any of its parent directories,
+// like *.yaml or testdata.
+// Otherwise, the pattern matches the path from the root of the directory being synced,
+// where ** matches any number of directories, like charts/**/values.yaml.
+// A pattern that matches a directory matches all the files in it.
+// Each path segment is matched with path.Match.
+func MatchSyncPattern(pattern, rel string) bool {
+	pattern = strings.Trim(pattern, "/")
+	segs := strings.Split(rel, "/")
+
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		for _, s := range segs {
+			if ok, _ := path.Match(pattern, s); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return matchSyncSegments(strings.Split(pattern, "/"), segs)
+}
+
+func matchSyncSegments(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		// The pattern matched a parent directory.
+		return true
+	}
+
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSyncSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(segs) == 0 {
+		return false
+	}
+
+	ok, _ := path.Match(pattern[0], segs[0])
+	return ok && matchSyncSegments(pattern[1:], segs[1:])
+}
+
+func matchAnySyncPattern(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if MatchSyncPattern(p, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// listSyncFiles returns the modes of the selected regular files and symlinks in dir,
+// keyed by their slash-separated paths relative to dir.
+func listSyncFiles(dir string, selected func(string) bool) (map[string]fs.FileMode, error) {
+	files := map[string]fs.FileMode{}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" && p != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return fmt.Errorf("unable to sync %s: unsupported file type %s", p, d.Type())
+		}
+
+		if !selected(rel) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = info.Mode()
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// sameSyncFile reports whether the destination file is the same as the source one,
+// comparing the contents, the executable bits, and the targets of symlinks.
+func sameSyncFile(from, to string, fromMode, toMode fs.FileMode) (bool, error) {
+	if fromMode.Type() != toMode.Type() {
+		return false, nil
+	}
+
+	if fromMode&fs.ModeSymlink != 0 {
+		a, err := os.Readlink(from)
+		if err != nil {
+			return false, err
+		}
+		b, err := os.Readlink(to)
+		if err != nil {
+			return false, err
+		}
+		return a == b, nil
+	}
+
+	if fromMode.Perm()&0111 != toMode.Perm()&0111 {
+		return false, nil
+	}
+
+	a, err := os.ReadFile(from)
+	if err != nil {
+		return false, err
+	}
+	b, err := os.ReadFile(to)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(a, b), nil
+}
+
+// copySyncFile copies the regular file or the symlink from to to,
+// replacing the existing file if any.
+func copySyncFile(from, to string, mode fs.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+
+	if err := os.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if mode&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, to)
+	}
+
+	data, err := os.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(to, data, mode.Perm())
+}
+
+// removeEmptyParents removes the parent directories of rel in dir
+// that have become empty, up to dir.
+func removeEmptyParents(dir, rel string) {
+	for p := path.Dir(rel); p != "."; p = path.Dir(p) {
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(p))); err != nil {
+			// The directory isn't empty.
+			return
+		}
+	}
+}
+
+func sortedKeys(m map[string]fs.FileMode) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,158 @@
+package tools
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchSyncPattern(t *testing.T) {
+	testcases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.yaml", "app.yaml", true},
+		{"*.yaml", "charts/app/values.yaml", true},
+		{"*.yaml", "app.json", false},
+		{"testdata", "charts/testdata/values.yaml", true},
+		{"charts/app", "charts/app/values.yaml", true},
+		{"/charts/app/", "charts/app/values.yaml", true},
+		{"charts/app", "charts/application/values.yaml", false},
+		{"charts/*.yaml", "charts/values.yaml", true},
+		{"charts/*.yaml", "charts/app/values.yaml", false},
+		{"charts/**/values.yaml", "charts/values.yaml", true},
+		{"charts/**/values.yaml", "charts/app/sub/values.yaml", true},
+		{"charts/**/values.yaml", "other/app/values.yaml", false},
+		{"**", "any/file", true},
+		{"**/*.md", "docs/README.md", true},
+	}
+
+	for _, tc := range testcases {
+		require.Equal(t, tc.want, MatchSyncPattern(tc.pattern, tc.path), "pattern %q, path %q", tc.pattern, tc.path)
+	}
+}
+
+// writeFiles writes the files into dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+}
+
+func TestSyncFiles(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"app.yaml":           "v2\n",
+		"new.yaml":           "new\n",
+		"same.yaml":          "same\n",
+		"charts/values.yaml": "chart\n",
+		"README.md":          "readme\n",
+		".git/config":        "[core]\n",
+	})
+
+	newDst := func() string {
+		dst := t.TempDir()
+		writeFiles(t, dst, map[string]string{
+			"app.yaml":         "v1\n",
+			"same.yaml":        "same\n",
+			"old/removed.yaml": "old\n",
+			"notes.md":         "notes\n",
+			".git/HEAD":        "ref: refs/heads/main\n",
+		})
+		return dst
+	}
+
+	t.Run("copy", func(t *testing.T) {
+		dst := newDst()
+
+		var out bytes.Buffer
+		r, err := SyncFiles(SyncFilesOptions{Src: src, Dst: dst, Out: &out})
+		require.NoError(t, err)
+		require.Equal(t, &SyncFilesResult{
+			Added:   []string{"README.md", "charts/values.yaml", "new.yaml"},
+			Changed: []string{"app.yaml"},
+		}, r)
+		require.Equal(t, "+ README.md\n+ charts/values.yaml\n+ new.yaml\n~ app.yaml\n", out.String())
+
+		require.FileExists(t, filepath.Join(dst, "old", "removed.yaml"))
+		require.NoFileExists(t, filepath.Join(dst, ".git", "config"))
+
+		// Syncing again is a no-op.
+		out.Reset()
+		r, err = SyncFiles(SyncFilesOptions{Src: src, Dst: dst, Out: &out})
+		require.NoError(t, err)
+		require.Equal(t, &SyncFilesResult{}, r)
+		require.Equal(t, "no changes to "+dst+"\n", out.String())
+	})
+
+	t.Run("mirror", func(t *testing.T) {
+		dst := newDst()
+
+		r, err := SyncFiles(SyncFilesOptions{Src: src, Dst: dst, Mode: SyncModeMirror, Exclude: []string{"*.md"}, Out: &bytes.Buffer{}})
+		require.NoError(t, err)
+		require.Equal(t, &SyncFilesResult{
+			Added:   []string{"charts/values.yaml", "new.yaml"},
+			Changed: []string{"app.yaml"},
+			Removed: []string{"old/removed.yaml"},
+		}, r)
+
+		require.NoDirExists(t, filepath.Join(dst, "old"))
+		// Excluded files are neither uploaded nor removed.
+		require.NoFileExists(t, filepath.Join(dst, "README.md"))
+		require.FileExists(t, filepath.Join(dst, "notes.md"))
+		require.FileExists(t, filepath.Join(dst, ".git", "HEAD"))
+	})
+
+	t.Run("include", func(t *testing.T) {
+		dst := newDst()
+
+		r, err := SyncFiles(SyncFilesOptions{Src: src, Dst: dst, Mode: SyncModeMirror, Include: []string{"charts/**", "app.yaml"}, Out: &bytes.Buffer{}})
+		require.NoError(t, err)
+		require.Equal(t, &SyncFilesResult{
+			Added:   []string{"charts/values.yaml"},
+			Changed: []string{"app.yaml"},
+		}, r)
+		require.FileExists(t, filepath.Join(dst, "old", "removed.yaml"))
+	})
+
+	t.Run("mode and symlink", func(t *testing.T) {
+		src := t.TempDir()
+		writeFiles(t, src, map[string]string{"run.sh": "echo\n", "target.yaml": "x\n"})
+		require.NoError(t, os.Chmod(filepath.Join(src, "run.sh"), 0755))
+		require.NoError(t, os.Symlink("target.yaml", filepath.Join(src, "link.yaml")))
+
+		dst := t.TempDir()
+		writeFiles(t, dst, map[string]string{"run.sh": "echo\n", "target.yaml": "x\n"})
+		require.NoError(t, os.Symlink("run.sh", filepath.Join(dst, "link.yaml")))
+
+		r, err := SyncFiles(SyncFilesOptions{Src: src, Dst: dst, Out: &bytes.Buffer{}})
+		require.NoError(t, err)
+		require.Equal(t, &SyncFilesResult{Changed: []string{"link.yaml", "run.sh"}}, r)
+
+		info, err := os.Stat(filepath.Join(dst, "run.sh"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0111), info.Mode().Perm()&0111)
+		target, err := os.Readlink(filepath.Join(dst, "link.yaml"))
+		require.NoError(t, err)
+		require.Equal(t, "target.yaml", target)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := SyncFiles(SyncFilesOptions{Src: src, Dst: t.TempDir(), Mode: "merge"})
+		require.EqualError(t, err, `unsupported mode: "merge"`)
+
+		_, err = SyncFiles(SyncFilesOptions{Src: src, Dst: t.TempDir(), Include: []string{"["}})
+		require.EqualError(t, err, `invalid pattern "[": syntax error in pattern`)
+
+		_, err = SyncFiles(SyncFilesOptions{Src: filepath.Join(src, "app.yaml"), Dst: t.TempDir()})
+		require.EqualError(t, err, "src "+filepath.Join(src, "app.yaml")+" is not a directory")
+	})
+}
@@ -0,0 +1,425 @@
+package kargo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Values is a GetValue that selects the provider of each key by the scheme of the key,
+// like env://FOO or ref+vault://secret/data/myapp#/password,
+// and caches the values so that each key is resolved only once.
+//
+// NewValues returns the Values with the built-in providers:
+//
+//	env://NAME                       the environment variable
+//	file://path/to/file[#/a/b]       the file content, or the value at /a/b of the JSON or YAML file
+//	sops://path/to/file[#/a/b]       the same as file:// but the file is decrypted via sops
+//	ref+vault://path/to/secret#/key  the key of the Vault secret, like vals does
+//	registry://image?query[#field]   the newest tag or digest of the image. See RegistryValues
+//
+// Keys without a scheme, like component_name.foo, are passed to Default.
+type Values struct {
+	// Providers maps the schemes to the providers of the values.
+	Providers map[string]GetValue
+	// Default gets the values of the keys without a scheme.
+	// OutputsFile is handy to read the outputs of the other components from a file.
+	Default GetValue
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewValues returns the Values with the built-in providers.
+// Vault is configured via VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE.
+func NewValues(def GetValue) *Values {
+	return &Values{
+		Providers: map[string]GetValue{
+			"env":       EnvValue,
+			"file":      FileValue,
+			"sops":      (&SOPSValues{}).GetValue,
+			"ref+vault": (&VaultValues{}).GetValue,
+			"registry":  (&RegistryValues{}).GetValue,
+		},
+		Default: def,
+	}
+}
+
+// GetValue resolves the key via the provider for its scheme.
+func (v *Values) GetValue(key string) (string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if value, ok := v.cache[key]; ok {
+		return value, nil
+	}
+
+	get := v.Default
+	if scheme, _, ok := strings.Cut(key, "://"); ok {
+		get, ok = v.Providers[scheme]
+		if !ok {
+			return "", fmt.Errorf("unable to get %s: unsupported scheme %q", key, scheme)
+		}
+	} else if get == nil {
+		return "", fmt.Errorf("unable to get %s: no default value provider", key)
+	}
+
+	value, err := get(key)
+	if err != nil {
+		return "", err
+	}
+
+	if v.cache == nil {
+		v.cache = map[string]string{}
+	}
+	v.cache[key] = value
+
+	return value, nil
+}
+
+// GetValueFor returns the GetValue for the config,
+// whose errors name the config fields that refer to the key, like:
+//
+//	kustomize.images[0].newTagFrom: unable to get env://TAG: environment variable TAG is not set
+//
+// Use it as Generator.GetValue when the generator deploys a single config.
+func (v *Values) GetValueFor(c *Config) GetValue {
+	fields := map[string][]string{}
+	for _, r := range ValueRefs(c) {
+		fields[r.Key] = append(fields[r.Key], r.Field)
+	}
+
+	return func(key string) (string, error) {
+		value, err := v.GetValue(key)
+		if err != nil && len(fields[key]) > 0 {
+			return "", fmt.Errorf("%s: %w", strings.Join(fields[key], ", "), err)
+		}
+		return value, err
+	}
+}
+
+// ValueRef is a reference from a config field to the key of a value.
+type ValueRef struct {
+	// Field is the path to the field in the config, like kustomize.images[0].newTagFrom.
+	Field string
+	// Key is the key of the value.
+	Key string
+}
+
+// ValueRefs returns all the references to values in the config,
+// which are the non-empty *From fields, like env[0].valueFrom.
+func ValueRefs(c *Config) []ValueRef {
+	var refs []ValueRef
+	collectValueRefs(reflect.ValueOf(c), "", &refs)
+	return refs
+}
+
+func collectValueRefs(v reflect.Value, path string, refs *[]ValueRef) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			collectValueRefs(v.Elem(), path, refs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectValueRefs(v.Index(i), path+"["+strconv.Itoa(i)+"]", refs)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if name == "" || name == "-" {
+				name = f.Name
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+
+			fv := v.Field(i)
+			if fv.Kind() == reflect.String {
+				if strings.HasSuffix(f.Name, "From") && fv.String() != "" {
+					*refs = append(*refs, ValueRef{Field: name, Key: fv.String()})
+				}
+				continue
+			}
+
+			collectValueRefs(fv, name, refs)
+		}
+	}
+}
+
+// EnvValue gets the value of the environment variable, like env://FOO.
+// It fails if the variable isn't set, so that a typo never results in an empty value.
+func EnvValue(key string) (string, error) {
+	name := strings.TrimPrefix(key, "env://")
+
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("unable to get %s: environment variable %s is not set", key, name)
+	}
+
+	return v, nil
+}
+
+// FileValue gets the content of the file, like file://path/to/file,
+// or the value at the path in the JSON or YAML file, like file://outputs.json#/myapp/tag.
+// The trailing newlines of the content are removed.
+func FileValue(key string) (string, error) {
+	file, fragment, _ := strings.Cut(strings.TrimPrefix(key, "file://"), "#")
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	v, err := selectValue(data, fragment)
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	return v, nil
+}
+
+// OutputsFile returns the GetValue that reads the outputs of the other components
+// from the JSON or YAML file, in which the key component_name.foo refers to:
+//
+//	component_name:
+//	  foo: value
+func OutputsFile(path string) GetValue {
+	return func(key string) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s: %w", key, err)
+		}
+
+		v, err := selectValue(data, "/"+strings.ReplaceAll(key, ".", "/"))
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s from %s: %w", key, path, err)
+		}
+
+		return v, nil
+	}
+}
+
+// SOPSValues gets the values from the files encrypted with sops,
+// like sops://secrets.enc.yaml#/db/password.
+// Each file is decrypted once.
+type SOPSValues struct {
+	// Command is the sops command. Defaults to sops.
+	Command string
+
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *SOPSValues) GetValue(key string) (string, error) {
+	file, fragment, _ := strings.Cut(strings.TrimPrefix(key, "sops://"), "#")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.files[file]
+	if !ok {
+		command := s.Command
+		if command == "" {
+			command = "sops"
+		}
+
+		var stdout, stderr bytes.Buffer
+		c := exec.Command(command, "--decrypt", file)
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+		if err := c.Run(); err != nil {
+			return "", fmt.Errorf("unable to get %s: running sops --decrypt %s: %w: %s", key, file, err, strings.TrimSpace(stderr.String()))
+		}
+		data = stdout.Bytes()
+
+		if s.files == nil {
+			s.files = map[string][]byte{}
+		}
+		s.files[file] = data
+	}
+
+	v, err := selectValue(data, fragment)
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	return v, nil
+}
+
+// VaultValues gets the values of the keys in the Vault secrets,
+// like ref+vault://secret/data/myapp#/password for the KV v2 secret engine
+// and ref+vault://secret/myapp#/password for KV v1.
+// Each secret is read once.
+type VaultValues struct {
+	// Addr is the address of the Vault server. Defaults to $VAULT_ADDR.
+	Addr string
+	// Token is the Vault token. Defaults to $VAULT_TOKEN.
+	Token string
+	// Namespace is the Vault Enterprise namespace. Defaults to $VAULT_NAMESPACE.
+	Namespace string
+	// HTTPClient defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu      sync.Mutex
+	secrets map[string]map[string]interface{}
+}
+
+func (s *VaultValues) GetValue(key string) (string, error) {
+	p, fragment, _ := strings.Cut(strings.TrimPrefix(key, "ref+vault://"), "#")
+	if fragment == "" {
+		return "", fmt.Errorf("unable to get %s: specify the key in the secret like #/password", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secret, ok := s.secrets[p]
+	if !ok {
+		var err error
+		secret, err = s.read(p)
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s: %w", key, err)
+		}
+
+		if s.secrets == nil {
+			s.secrets = map[string]map[string]interface{}{}
+		}
+		s.secrets[p] = secret
+	}
+
+	v, err := selectNode(secret, fragment)
+	if err != nil {
+		return "", fmt.Errorf("unable to get %s: %w", key, err)
+	}
+
+	return v, nil
+}
+
+// read reads the data of the secret at the path.
+func (s *VaultValues) read(p string) (map[string]interface{}, error) {
+	addr, token, namespace := s.Addr, s.Token, s.Namespace
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("VAULT_ADDR is required")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(p, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading vault secret %s: %s: %s", p, res.Status, strings.TrimSpace(string(data)))
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("decoding vault secret %s: %w", p, err)
+	}
+
+	// KV v2 nests the secret and its metadata in the data.
+	if d, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, ok := body.Data["metadata"]; ok {
+			return d, nil
+		}
+	}
+
+	return body.Data, nil
+}
+
+// selectValue returns the value at the slash-separated path in the JSON or YAML document,
+// or the whole document without the trailing newlines if the path is empty.
+func selectValue(data []byte, path string) (string, error) {
+	if path == "" {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("parsing the document: %w", err)
+	}
+
+	return selectNode(doc, path)
+}
+
+// selectNode returns the scalar value at the slash-separated path,
+// where the numeric segments select the items of lists.
+func selectNode(doc interface{}, path string) (string, error) {
+	n := doc
+	for _, seg := range strings.Split(strings.Trim(path, "/"), "/") {
+		switch v := n.(type) {
+		case map[string]interface{}:
+			var ok bool
+			n, ok = v[seg]
+			if !ok {
+				return "", fmt.Errorf("%s not found", path)
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", fmt.Errorf("%s not found", path)
+			}
+			n = v[i]
+		default:
+			return "", fmt.Errorf("%s not found", path)
+		}
+	}
+
+	switch v := n.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("%s is not a scalar value", path)
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,139 @@
+package kargo_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mumoshu/kargo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValues(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(content), 0755))
+		return p
+	}
+
+	tagFile := write("tag", "v1.2.3\n")
+	outputs := write("outputs.json", `{"cluster":{"endpoint":"https://k8s.example.com","port":6443}}`)
+	config := write("config.yaml", "db:\n  hosts:\n  - db1\n  - db2\n")
+
+	// The fake sops prints the file as if it were decrypted, and counts the runs.
+	secrets := write("secrets.enc.yaml", "db:\n  password: s3cr3t\n  user: app\n")
+	sops := write("sops", "#!/bin/sh\necho run >> "+filepath.Join(dir, "sops-runs")+"\n[ \"$1\" = --decrypt ] && cat \"$2\"\n")
+
+	var vaultReads int
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vaultReads++
+		if r.Header.Get("X-Vault-Token") != "mytoken" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/myapp":
+			fmt.Fprint(w, `{"data":{"data":{"password":"v2pass","port":5432},"metadata":{"version":3}}}`)
+		case "/v1/kv/myapp":
+			fmt.Fprint(w, `{"data":{"password":"v1pass"}}`)
+		default:
+			http.Error(w, `{"errors":[]}`, http.StatusNotFound)
+		}
+	}))
+	defer vault.Close()
+
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "mytoken")
+	t.Setenv("KARGO_TEST_STAGE", "prod")
+
+	v := kargo.NewValues(kargo.OutputsFile(outputs))
+	v.Providers["sops"] = (&kargo.SOPSValues{Command: sops}).GetValue
+
+	testcases := map[string]string{
+		"env://KARGO_TEST_STAGE":                  "prod",
+		"file://" + tagFile:                       "v1.2.3",
+		"file://" + config + "#/db/hosts/1":       "db2",
+		"cluster.endpoint":                        "https://k8s.example.com",
+		"cluster.port":                            "6443",
+		"sops://" + secrets + "#/db/password":     "s3cr3t",
+		"sops://" + secrets + "#/db/user":         "app",
+		"ref+vault://secret/data/myapp#/password": "v2pass",
+		"ref+vault://secret/data/myapp#/port":     "5432",
+		"ref+vault://kv/myapp#password":           "v1pass",
+	}
+
+	for key, want := range testcases {
+		got, err := v.GetValue(key)
+		require.NoError(t, err, key)
+		require.Equal(t, want, got, key)
+
+		// The value is cached.
+		got, err = v.GetValue(key)
+		require.NoError(t, err, key)
+		require.Equal(t, want, got, key)
+	}
+
+	// Each file and secret is read once.
+	runs, err := os.ReadFile(filepath.Join(dir, "sops-runs"))
+	require.NoError(t, err)
+	require.Equal(t, "run\n", string(runs))
+	require.Equal(t, 2, vaultReads)
+
+	errors := map[string]string{
+		"env://KARGO_TEST_UNDEFINED":       "unable to get env://KARGO_TEST_UNDEFINED: environment variable KARGO_TEST_UNDEFINED is not set",
+		"file://" + config + "#/db/hosts":  "unable to get file://" + config + "#/db/hosts: /db/hosts is not a scalar value",
+		"file://" + config + "#/db/port":   "unable to get file://" + config + "#/db/port: /db/port not found",
+		"cluster.name":                     "unable to get cluster.name from " + outputs + ": /cluster/name not found",
+		"ref+vault://secret/data/myapp":    "unable to get ref+vault://secret/data/myapp: specify the key in the secret like #/password",
+		"ref+vault://secret/data/other#/a": `unable to get ref+vault://secret/data/other#/a: reading vault secret secret/data/other: 404 Not Found: {"errors":[]}`,
+		"s3://bucket/key":                  `unable to get s3://bucket/key: unsupported scheme "s3"`,
+	}
+
+	for key, want := range errors {
+		_, err := v.GetValue(key)
+		require.EqualError(t, err, want, key)
+	}
+
+	_, err = kargo.NewValues(nil).GetValue("cluster.endpoint")
+	require.EqualError(t, err, "unable to get cluster.endpoint: no default value provider")
+}
+
+func TestValues_GetValueFor(t *testing.T) {
+	c := &kargo.Config{
+		Name: "myapp",
+		Env: []kargo.Env{
+			{Name: "STAGE", Value: "prod"},
+			{Name: "TAG", ValueFrom: "env://KARGO_TEST_UNDEFINED"},
+		},
+		Kustomize: &kargo.Kustomize{
+			Images: kargo.KustomizeImages{
+				{Name: "myapp", NewTagFrom: "env://KARGO_TEST_UNDEFINED"},
+			},
+		},
+		ArgoCD: &kargo.ArgoCD{ServerFrom: "cluster.endpoint"},
+	}
+
+	require.Equal(t, []kargo.ValueRef{
+		{Field: "env[1].valueFrom", Key: "env://KARGO_TEST_UNDEFINED"},
+		{Field: "kustomize.images[0].newTagFrom", Key: "env://KARGO_TEST_UNDEFINED"},
+		{Field: "argocd.serverFrom", Key: "cluster.endpoint"},
+	}, kargo.ValueRefs(c))
+
+	get := kargo.NewValues(nil).GetValueFor(c)
+
+	_, err := get("env://KARGO_TEST_UNDEFINED")
+	require.EqualError(t, err, "env[1].valueFrom, kustomize.images[0].newTagFrom: unable to get env://KARGO_TEST_UNDEFINED: environment variable KARGO_TEST_UNDEFINED is not set")
+
+	_, err = get("cluster.endpoint")
+	require.EqualError(t, err, "argocd.serverFrom: unable to get cluster.endpoint: no default value provider")
+
+	args, err := kargo.AppendArgs(nil, c.Kustomize.Images, kargo.FieldTagKustomize)
+	require.NoError(t, err)
+	_, err = args.Collect(get)
+	require.ErrorContains(t, err, "env[1].valueFrom, kustomize.images[0].newTagFrom: unable to get env://KARGO_TEST_UNDEFINED: environment variable KARGO_TEST_UNDEFINED is not set")
+}